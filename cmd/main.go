@@ -1,15 +1,172 @@
 package main
 
 import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"time"
+
 	"github.com/Dwipasca/contact-management/internal/handler"
 	"github.com/Dwipasca/contact-management/internal/repository"
 	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
 )
 
 func main() {
-	repo := repository.NewContactRepository()
-	service := usecase.NewContactService(repo)
-	handler := handler.NewContactHandler(service)
+	// run returns instead of exiting, so the deferred closes flush the storage first
+	os.Exit(run())
+}
+
+// run starts the interactive menu, or runs the subcommand given after the flags
+func run() int {
+	storage := flag.String("storage", "file", "storage backend: file, journal, kv, sql or memory")
+	dataFile := flag.String("data", "data/contacts_store.json", "path of the data file used by the file storage")
+	journalDir := flag.String("journal-dir", "data/journal", "folder of the snapshot and journal used by the journal storage")
+	kvFile := flag.String("kv-file", "data/contacts.kv", "path of the store file used by the kv storage")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "deleted contacts older than this are purged from the trash on startup, 0 keeps them forever")
+	groupsFile := flag.String("groups-file", "data/groups.json", "path of the groups file, the sql storage keeps the groups in the database instead")
+	profilesDir := flag.String("profiles-dir", "data/csv_profiles", "folder the saved CSV mapping profiles are kept in")
+	auditFile := flag.String("audit-file", "data/audit.log", "path of the audit log, the sql storage keeps it in the database instead")
+	actor := flag.String("actor", currentUser(), "name recorded in the audit log for the changes made in this session")
+	compactSize := flag.Int64("compact-size", repository.DefaultCompactThreshold, "journal size in bytes after which it is compacted into a snapshot")
+	dbDriver := flag.String("db-driver", "", "database/sql driver name used by the sql storage, e.g. postgres or sqlite")
+	dbDSN := flag.String("db-dsn", "", "data source name used by the sql storage")
+	schemaVersion := flag.Int("db-schema-version", repository.LatestSchemaVersion, "migrate the sql schema up or down to this version, -1 means latest")
+	outputName := flag.String("output", "text", "format of the command results and errors: text, table, json, ndjson or tsv")
+	flag.Parse()
+
+	output, err := ui.ParseOutput(*outputName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ERROR:", err)
+		return 2
+	}
+
+	var repo repository.ContactRepository
+	if *storage == "sql" {
+		repo, err = newSQLRepository(*dbDriver, *dbDSN, *schemaVersion)
+	} else {
+		repo, err = newRepository(*storage, *dataFile, *journalDir, *kvFile, *compactSize)
+	}
+	if err != nil {
+		return fail(output, err)
+	}
+	if closer, ok := repo.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	groups, err := newGroupRepository(*storage, *groupsFile, repo)
+	if err != nil {
+		return fail(output, err)
+	}
+
+	audit, err := newAuditRepository(*storage, *auditFile, repo)
+	if err != nil {
+		return fail(output, err)
+	}
+	if closer, ok := audit.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	service := usecase.NewContactService(repo, groups, audit, repository.NewCSVProfileStore(*profilesDir))
+	service.SetActor(*actor)
+	groupService := usecase.NewGroupService(groups, repo)
+
+	if _, err := service.PurgeExpiredTrash(*trashRetention); err != nil {
+		// not fatal, the commands still work with the expired contacts in the trash
+		fail(output, fmt.Errorf("failed to purge the trash: %w", err))
+	}
+
+	if flag.NArg() > 0 {
+		return handler.NewCommandHandler(service, output).Run(flag.Args())
+	}
+
+	handler.NewContactHandler(service, groupService).ShowMainMenu()
+	return 0
+}
+
+// fail reports an error of the startup in the format of the command output and returns exit code 1
+func fail(output ui.Output, err error) int {
+	ui.PrintError(output, ui.ErrorInfo{Code: 1, Name: "failure", Message: err.Error()})
+	return 1
+}
+
+func newRepository(storage, dataFile, journalDir, kvFile string, compactSize int64) (repository.ContactRepository, error) {
+	switch storage {
+	case "file":
+		return repository.NewFileContactRepository(dataFile)
+	case "journal":
+		return repository.NewJournalContactRepository(journalDir, compactSize)
+	case "kv":
+		return repository.NewKVContactRepository(kvFile)
+	case "memory":
+		return repository.NewContactRepository(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q, please use file, journal, kv, sql or memory", storage)
+	}
+}
+
+// newGroupRepository keeps the groups next to the contacts: in the database for the sql storage,
+// in memory for the memory storage and in the groups file for the others
+func newGroupRepository(storage, groupsFile string, contacts repository.ContactRepository) (repository.GroupRepository, error) {
+	switch storage {
+	case "sql":
+		return contacts.(*repository.SQLContactRepository).Groups(), nil
+	case "memory":
+		return repository.NewGroupRepository(), nil
+	default:
+		return repository.NewFileGroupRepository(groupsFile)
+	}
+}
+
+// newAuditRepository keeps the audit log next to the contacts, like newGroupRepository
+func newAuditRepository(storage, auditFile string, contacts repository.ContactRepository) (repository.AuditRepository, error) {
+	switch storage {
+	case "sql":
+		return contacts.(*repository.SQLContactRepository).Audit(), nil
+	case "memory":
+		return repository.NewAuditRepository(), nil
+	default:
+		return repository.NewFileAuditRepository(auditFile)
+	}
+}
+
+// currentUser is the login name of the user running the application
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// newSQLRepository opens the database with a driver linked into the binary.
+// database/sql drivers register themselves when imported,
+// add the blank import of the driver you need to this file, e.g.
+//
+//	import _ "github.com/lib/pq"
+func newSQLRepository(driver, dsn string, schemaVersion int) (repository.ContactRepository, error) {
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("database driver %q is not linked into this binary, available drivers: %v", driver, sql.Drivers())
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	repo, err := repository.NewSQLContactRepository(db, driver, schemaVersion)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-	handler.ShowMainMenu()
-}
\ No newline at end of file
+	return repo, nil
+}
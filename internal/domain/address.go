@@ -0,0 +1,184 @@
+package domain
+
+import "strings"
+
+// Address is a postal address of a contact, labelled e.g. "home" or "work".
+// CountryCode is the ISO 3166-1 alpha-2 code, e.g. "ID" or "US".
+type Address struct {
+	Label       string
+	Street      string
+	Locality    string
+	Region      string
+	PostalCode  string
+	CountryCode string
+}
+
+// IsEmpty reports whether no field besides the label is filled in
+func (a Address) IsEmpty() bool {
+	return a.Street == "" && a.Locality == "" && a.Region == "" && a.PostalCode == "" && a.CountryCode == ""
+}
+
+// MatchesLocation reports whether the address is in the city or the country,
+// an empty argument never matches
+func (a Address) MatchesLocation(locality, countryCode string) bool {
+	if locality != "" && strings.EqualFold(a.Locality, locality) {
+		return true
+	}
+	return countryCode != "" && strings.EqualFold(a.CountryCode, countryCode)
+}
+
+// addressLayouts lists the lines of an address in the customary order of a country,
+// each line is made of fields separated by spaces:
+// S street, L locality, R region, P postal code
+var addressLayouts = map[string][]string{
+	// street / city, region postal code
+	"US": {"S", "L, R P"},
+	"CA": {"S", "L R P"},
+	"AU": {"S", "L R P"},
+	// street / city / postal code
+	"GB": {"S", "L", "P"},
+	// street / postal code city
+	"DE": {"S", "P L"},
+	"FR": {"S", "P L"},
+	"ES": {"S", "P L R"},
+	"IT": {"S", "P L R"},
+	"NL": {"S", "P L"},
+	// street / city / region postal code
+	"ID": {"S", "L", "R P"},
+	"MY": {"S", "P L", "R"},
+	"SG": {"S", "P"},
+	"IN": {"S", "L P", "R"},
+	// from the largest area to the street
+	"JP": {"P", "R L", "S"},
+	"CN": {"P", "R L", "S"},
+	"KR": {"R L S", "P"},
+}
+
+var defaultAddressLayout = []string{"S", "L R P"}
+
+// Lines renders the address in the line order customary for its country,
+// ending with the country name. Empty fields and lines are left out.
+func (a Address) Lines() []string {
+	layout, ok := addressLayouts[strings.ToUpper(a.CountryCode)]
+	if !ok {
+		layout = defaultAddressLayout
+	}
+
+	fields := map[byte]string{
+		'S': a.Street,
+		'L': a.Locality,
+		'R': a.Region,
+		'P': a.PostalCode,
+	}
+
+	var lines []string
+	for _, pattern := range layout {
+		var parts []string
+		for _, token := range strings.Fields(pattern) {
+			// a token may carry punctuation, e.g. "L," for "Springfield, IL"
+			value := fields[token[0]]
+			if value == "" {
+				continue
+			}
+			parts = append(parts, value+token[1:])
+		}
+		line := strings.TrimSuffix(strings.Join(parts, " "), ",")
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if a.CountryCode != "" {
+		lines = append(lines, CountryName(a.CountryCode))
+	}
+
+	return lines
+}
+
+// Format renders the address on one line, e.g. for a table or a list
+func (a Address) Format() string {
+	return strings.Join(a.Lines(), ", ")
+}
+
+// addressEscaper escapes the separators of the address list inside a label or field
+var addressEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, "|", `\|`, ":", `\:`)
+
+var addressUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\|`, "|", `\:`, ":")
+
+// indexUnescaped is the index of the first sep of s that is not escaped with a backslash, or -1
+func indexUnescaped(s string, sep byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			// the escaped byte is never a separator
+			i++
+		case sep:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits s at every sep that is not escaped, the parts keep their escapes
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep)
+		if i == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// ParseAddresses reads the list written by FormatAddresses:
+// addresses separated by ";" and fields by "|" in the order
+// street|locality|region|postal code|country code, with an optional "label:" in front.
+// A ";", "|", ":" or "\" inside a label or field is escaped with a backslash
+func ParseAddresses(text string) []Address {
+	var addresses []Address
+	for _, item := range splitUnescaped(text, ';') {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var addr Address
+		fields := splitUnescaped(item, '|')
+		// the label is only what comes before the first ":" of the street part,
+		// so a colon further on is kept
+		if i := indexUnescaped(fields[0], ':'); i != -1 {
+			addr.Label = addressUnescaper.Replace(strings.TrimSpace(fields[0][:i]))
+			fields[0] = fields[0][i+1:]
+		}
+
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		for i := range fields {
+			fields[i] = addressUnescaper.Replace(strings.TrimSpace(fields[i]))
+		}
+		addr.Street = fields[0]
+		addr.Locality = fields[1]
+		addr.Region = fields[2]
+		addr.PostalCode = fields[3]
+		addr.CountryCode = strings.ToUpper(fields[4])
+
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+// FormatAddresses writes addresses in the format read by ParseAddresses
+func FormatAddresses(addresses []Address) string {
+	items := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		fields := []string{addr.Street, addr.Locality, addr.Region, addr.PostalCode, addr.CountryCode}
+		for i := range fields {
+			fields[i] = addressEscaper.Replace(fields[i])
+		}
+		items = append(items, joinLabel(addressEscaper.Replace(addr.Label), strings.Join(fields, "|")))
+	}
+	return strings.Join(items, "; ")
+}
@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddressesRoundTrip(t *testing.T) {
+	addresses := []Address{
+		{Street: "Apt: 5 Main St", Locality: "Springfield", CountryCode: "US"},
+		{Label: "work", Street: "Unit 3; Block B", Locality: "Jakarta", PostalCode: "10110", CountryCode: "ID"},
+		{Label: "a|b:c", Street: `C:\Mail | Room 2`, Region: "West; North"},
+		{Label: "home", Street: "Hauptstr. 1", Locality: "Berlin", CountryCode: "DE"},
+	}
+
+	text := FormatAddresses(addresses)
+	if got := ParseAddresses(text); !reflect.DeepEqual(got, addresses) {
+		t.Errorf("FormatAddresses wrote %q, read back as\n%+v\nwant\n%+v", text, got, addresses)
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Address
+	}{
+		{
+			name: "typed by hand",
+			text: "work: Main St 1 | Jakarta | | 10110 | id; Side St 2|Bandung",
+			want: []Address{
+				{Label: "work", Street: "Main St 1", Locality: "Jakarta", PostalCode: "10110", CountryCode: "ID"},
+				{Street: "Side St 2", Locality: "Bandung"},
+			},
+		},
+		{
+			name: "colon after the street is kept",
+			text: "Main St 1|Jakarta|Region: West",
+			want: []Address{{Street: "Main St 1", Locality: "Jakarta", Region: "Region: West"}},
+		},
+		{
+			name: "escaped separators",
+			text: `Apt\: 5\; rear\|left|Springfield`,
+			want: []Address{{Street: "Apt: 5; rear|left", Locality: "Springfield"}},
+		},
+		{name: "empty", text: " ; ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseAddresses(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package domain
+
+import "time"
+
+// AuditOp is the kind of change an audit entry records
+type AuditOp string
+
+const (
+	AuditCreate  AuditOp = "create"
+	AuditUpdate  AuditOp = "update"
+	AuditDelete  AuditOp = "delete"
+	AuditRestore AuditOp = "restore"
+	AuditPurge   AuditOp = "purge"
+	AuditImport  AuditOp = "import"
+	AuditRevert  AuditOp = "revert"
+)
+
+// AuditEntry records one change of a contact. Its ID is the revision number
+// the contact can be reverted to.
+type AuditEntry struct {
+	ID        int
+	ContactID int
+	Actor     string
+	At        time.Time
+	Op        AuditOp
+	Changes   []FieldChange
+	// Snapshot is the contact after the change,
+	// for a delete or a purge it is the contact that was removed
+	Snapshot Contact
+	// RevertedTo is the revision a revert went back to
+	RevertedTo int
+}
+
+// FieldChange is the value of one field before and after a change,
+// lists are written in the format of FormatEmails, FormatPhones and so on
+type FieldChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// DiffContacts lists the fields that differ between before and after,
+// a created contact is diffed against an empty one and a deleted one the other way round
+func DiffContacts(before, after Contact) []FieldChange {
+	fields := []struct {
+		name   string
+		format func(c Contact) string
+	}{
+		{"Name", func(c Contact) string { return c.Name }},
+		{"Emails", func(c Contact) string { return FormatEmails(c.EmailList()) }},
+		{"Phones", func(c Contact) string { return FormatPhones(c.PhoneList()) }},
+		{"Addresses", func(c Contact) string { return FormatAddresses(c.Addresses) }},
+		{"Tags", func(c Contact) string { return FormatTags(c.Tags) }},
+	}
+
+	var changes []FieldChange
+	for _, field := range fields {
+		b, a := field.format(before), field.format(after)
+		if b != a {
+			changes = append(changes, FieldChange{Field: field.name, Before: b, After: a})
+		}
+	}
+	return changes
+}
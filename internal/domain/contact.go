@@ -1,8 +1,274 @@
 package domain
 
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
 type Contact struct {
-	ID		int
-	Name	string
-	Email	string
-	Phone	string
+	ID   int
+	Name string
+	// Email and Phone hold the primary email and phone,
+	// they are kept so files written before Emails and Phones existed still import
+	Email     string
+	Phone     string
+	Emails    []Email
+	Phones    []Phone
+	Addresses []Address
+	// Tags group contacts, e.g. "customer" or "family", they are lowercase and sorted
+	Tags      []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Version grows by one with every update, an update must carry
+	// the version it was read at, see MarkUpdated
+	Version int
+	// DeletedAt is set while the contact is in the trash
+	DeletedAt time.Time
+	// UID and CardName are the vCard UID and the file name a CardDAV client saved the
+	// contact under, the client finds its card by them. Other contacts have neither
+	UID      string `json:",omitempty"`
+	CardName string `json:",omitempty"`
+}
+
+// InTrash reports whether the contact was deleted and not yet restored or purged
+func (c Contact) InTrash() bool {
+	return !c.DeletedAt.IsZero()
+}
+
+// Email is one email address of a contact, labelled e.g. "work" or "home"
+type Email struct {
+	Label   string
+	Address string
+	Primary bool
+}
+
+// Phone is one phone number of a contact, labelled e.g. "work", "home" or "mobile"
+type Phone struct {
+	Label   string
+	Number  string
+	Primary bool
+}
+
+// MarkCreated stamps a contact that is saved for the first time.
+// Timestamps that are already set, e.g. by an import, are kept.
+func (c *Contact) MarkCreated(now time.Time) {
+	c.Version = 1
+	c.DeletedAt = time.Time{}
+	if c.CreatedAt.IsZero() {
+		c.CreatedAt = now
+	}
+	if c.UpdatedAt.IsZero() {
+		c.UpdatedAt = c.CreatedAt
+	}
+}
+
+// MarkUpdated stamps c as the next version of stored,
+// or returns ErrConflict when c was read at another version than stored
+func (c *Contact) MarkUpdated(stored Contact, now time.Time) error {
+	if c.Version != stored.Version {
+		return ErrConflict
+	}
+	c.Version = stored.Version + 1
+	c.CreatedAt = stored.CreatedAt
+	c.UpdatedAt = now
+	return nil
+}
+
+// EmailList returns every email of the contact, primary first.
+// A contact saved before emails had labels only has the flat Email field.
+func (c Contact) EmailList() []Email {
+	if len(c.Emails) == 0 && c.Email != "" {
+		return []Email{{Address: c.Email, Primary: true}}
+	}
+	return c.Emails
+}
+
+// PhoneList returns every phone of the contact, primary first
+func (c Contact) PhoneList() []Phone {
+	if len(c.Phones) == 0 && c.Phone != "" {
+		return []Phone{{Number: c.Phone, Primary: true}}
+	}
+	return c.Phones
+}
+
+// HasEmail reports whether address is one of the emails of the contact
+func (c Contact) HasEmail(address string) bool {
+	for _, em := range c.EmailList() {
+		if em.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalize makes the lists and the flat fields agree: the flat fields of
+// an old contact become its primary entries, empty entries are dropped,
+// exactly one email and phone is primary and it is moved to the front,
+// and the tags are normalized.
+func (c *Contact) Normalize() {
+	var emails []Email
+	for _, em := range c.EmailList() {
+		em.Label = strings.TrimSpace(em.Label)
+		em.Address = strings.TrimSpace(em.Address)
+		if em.Address != "" {
+			emails = append(emails, em)
+		}
+	}
+	primary := 0
+	for i := range emails {
+		if emails[i].Primary {
+			primary = i
+			break
+		}
+	}
+	for i := range emails {
+		emails[i].Primary = i == primary
+	}
+	if len(emails) > 0 {
+		emails[0], emails[primary] = emails[primary], emails[0]
+	}
+
+	var phones []Phone
+	for _, ph := range c.PhoneList() {
+		ph.Label = strings.TrimSpace(ph.Label)
+		ph.Number = strings.TrimSpace(ph.Number)
+		if ph.Number != "" {
+			phones = append(phones, ph)
+		}
+	}
+	primary = 0
+	for i := range phones {
+		if phones[i].Primary {
+			primary = i
+			break
+		}
+	}
+	for i := range phones {
+		phones[i].Primary = i == primary
+	}
+	if len(phones) > 0 {
+		phones[0], phones[primary] = phones[primary], phones[0]
+	}
+
+	var addresses []Address
+	for _, addr := range c.Addresses {
+		addr.Label = strings.TrimSpace(addr.Label)
+		addr.Street = strings.TrimSpace(addr.Street)
+		addr.Locality = strings.TrimSpace(addr.Locality)
+		addr.Region = strings.TrimSpace(addr.Region)
+		addr.PostalCode = strings.TrimSpace(addr.PostalCode)
+		addr.CountryCode = strings.ToUpper(strings.TrimSpace(addr.CountryCode))
+		if !addr.IsEmpty() {
+			addresses = append(addresses, addr)
+		}
+	}
+	c.Addresses = addresses
+
+	c.Tags = NormalizeTags(c.Tags)
+
+	c.Emails, c.Email = emails, ""
+	if len(emails) > 0 {
+		c.Email = emails[0].Address
+	}
+	c.Phones, c.Phone = phones, ""
+	if len(phones) > 0 {
+		c.Phone = phones[0].Number
+	}
+}
+
+// HasTag reports whether the contact has the tag, tag must be normalized
+func (c Contact) HasTag(tag string) bool {
+	return slices.Contains(c.Tags, tag)
+}
+
+// NormalizeTag makes tags compare case-insensitively
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// NormalizeTags normalizes, sorts and deduplicates tags
+func NormalizeTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if tag = NormalizeTag(tag); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// ParseTags reads a list written as "customer, vip"
+func ParseTags(text string) []string {
+	return NormalizeTags(splitList(text))
+}
+
+// FormatTags writes tags in the format read by ParseTags
+func FormatTags(tags []string) string {
+	return strings.Join(tags, ", ")
+}
+
+// ParseEmails reads a list written as "work:a@mail.com, home:b@mail.com",
+// the label is optional and the first email is the primary one
+func ParseEmails(text string) []Email {
+	var emails []Email
+	for _, item := range splitList(text) {
+		label, value := splitLabel(item)
+		emails = append(emails, Email{Label: label, Address: value, Primary: len(emails) == 0})
+	}
+	return emails
+}
+
+// ParsePhones reads a list written as "mobile:0812, work:021", see ParseEmails
+func ParsePhones(text string) []Phone {
+	var phones []Phone
+	for _, item := range splitList(text) {
+		label, value := splitLabel(item)
+		phones = append(phones, Phone{Label: label, Number: value, Primary: len(phones) == 0})
+	}
+	return phones
+}
+
+// FormatEmails writes emails in the format read by ParseEmails
+func FormatEmails(emails []Email) string {
+	items := make([]string, 0, len(emails))
+	for _, em := range emails {
+		items = append(items, joinLabel(em.Label, em.Address))
+	}
+	return strings.Join(items, ", ")
+}
+
+// FormatPhones writes phones in the format read by ParsePhones
+func FormatPhones(phones []Phone) string {
+	items := make([]string, 0, len(phones))
+	for _, ph := range phones {
+		items = append(items, joinLabel(ph.Label, ph.Number))
+	}
+	return strings.Join(items, ", ")
+}
+
+func splitList(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func splitLabel(item string) (string, string) {
+	label, value, found := strings.Cut(item, ":")
+	if !found {
+		return "", strings.TrimSpace(item)
+	}
+	return strings.TrimSpace(label), strings.TrimSpace(value)
+}
+
+func joinLabel(label, value string) string {
+	if label == "" {
+		return value
+	}
+	return label + ":" + value
 }
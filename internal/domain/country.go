@@ -0,0 +1,375 @@
+package domain
+
+import "strings"
+
+// countries maps every ISO 3166-1 alpha-2 code to the English short name of the country
+var countries = map[string]string{
+	"AD": "Andorra",
+	"AE": "United Arab Emirates",
+	"AF": "Afghanistan",
+	"AG": "Antigua and Barbuda",
+	"AI": "Anguilla",
+	"AL": "Albania",
+	"AM": "Armenia",
+	"AO": "Angola",
+	"AQ": "Antarctica",
+	"AR": "Argentina",
+	"AS": "American Samoa",
+	"AT": "Austria",
+	"AU": "Australia",
+	"AW": "Aruba",
+	"AX": "Åland Islands",
+	"AZ": "Azerbaijan",
+	"BA": "Bosnia and Herzegovina",
+	"BB": "Barbados",
+	"BD": "Bangladesh",
+	"BE": "Belgium",
+	"BF": "Burkina Faso",
+	"BG": "Bulgaria",
+	"BH": "Bahrain",
+	"BI": "Burundi",
+	"BJ": "Benin",
+	"BL": "Saint Barthélemy",
+	"BM": "Bermuda",
+	"BN": "Brunei",
+	"BO": "Bolivia",
+	"BQ": "Caribbean Netherlands",
+	"BR": "Brazil",
+	"BS": "Bahamas",
+	"BT": "Bhutan",
+	"BV": "Bouvet Island",
+	"BW": "Botswana",
+	"BY": "Belarus",
+	"BZ": "Belize",
+	"CA": "Canada",
+	"CC": "Cocos (Keeling) Islands",
+	"CD": "Democratic Republic of the Congo",
+	"CF": "Central African Republic",
+	"CG": "Republic of the Congo",
+	"CH": "Switzerland",
+	"CI": "Côte d'Ivoire",
+	"CK": "Cook Islands",
+	"CL": "Chile",
+	"CM": "Cameroon",
+	"CN": "China",
+	"CO": "Colombia",
+	"CR": "Costa Rica",
+	"CU": "Cuba",
+	"CV": "Cape Verde",
+	"CW": "Curaçao",
+	"CX": "Christmas Island",
+	"CY": "Cyprus",
+	"CZ": "Czechia",
+	"DE": "Germany",
+	"DJ": "Djibouti",
+	"DK": "Denmark",
+	"DM": "Dominica",
+	"DO": "Dominican Republic",
+	"DZ": "Algeria",
+	"EC": "Ecuador",
+	"EE": "Estonia",
+	"EG": "Egypt",
+	"EH": "Western Sahara",
+	"ER": "Eritrea",
+	"ES": "Spain",
+	"ET": "Ethiopia",
+	"FI": "Finland",
+	"FJ": "Fiji",
+	"FK": "Falkland Islands",
+	"FM": "Micronesia",
+	"FO": "Faroe Islands",
+	"FR": "France",
+	"GA": "Gabon",
+	"GB": "United Kingdom",
+	"GD": "Grenada",
+	"GE": "Georgia",
+	"GF": "French Guiana",
+	"GG": "Guernsey",
+	"GH": "Ghana",
+	"GI": "Gibraltar",
+	"GL": "Greenland",
+	"GM": "Gambia",
+	"GN": "Guinea",
+	"GP": "Guadeloupe",
+	"GQ": "Equatorial Guinea",
+	"GR": "Greece",
+	"GS": "South Georgia and the South Sandwich Islands",
+	"GT": "Guatemala",
+	"GU": "Guam",
+	"GW": "Guinea-Bissau",
+	"GY": "Guyana",
+	"HK": "Hong Kong",
+	"HM": "Heard Island and McDonald Islands",
+	"HN": "Honduras",
+	"HR": "Croatia",
+	"HT": "Haiti",
+	"HU": "Hungary",
+	"ID": "Indonesia",
+	"IE": "Ireland",
+	"IL": "Israel",
+	"IM": "Isle of Man",
+	"IN": "India",
+	"IO": "British Indian Ocean Territory",
+	"IQ": "Iraq",
+	"IR": "Iran",
+	"IS": "Iceland",
+	"IT": "Italy",
+	"JE": "Jersey",
+	"JM": "Jamaica",
+	"JO": "Jordan",
+	"JP": "Japan",
+	"KE": "Kenya",
+	"KG": "Kyrgyzstan",
+	"KH": "Cambodia",
+	"KI": "Kiribati",
+	"KM": "Comoros",
+	"KN": "Saint Kitts and Nevis",
+	"KP": "North Korea",
+	"KR": "South Korea",
+	"KW": "Kuwait",
+	"KY": "Cayman Islands",
+	"KZ": "Kazakhstan",
+	"LA": "Laos",
+	"LB": "Lebanon",
+	"LC": "Saint Lucia",
+	"LI": "Liechtenstein",
+	"LK": "Sri Lanka",
+	"LR": "Liberia",
+	"LS": "Lesotho",
+	"LT": "Lithuania",
+	"LU": "Luxembourg",
+	"LV": "Latvia",
+	"LY": "Libya",
+	"MA": "Morocco",
+	"MC": "Monaco",
+	"MD": "Moldova",
+	"ME": "Montenegro",
+	"MF": "Saint Martin",
+	"MG": "Madagascar",
+	"MH": "Marshall Islands",
+	"MK": "North Macedonia",
+	"ML": "Mali",
+	"MM": "Myanmar",
+	"MN": "Mongolia",
+	"MO": "Macao",
+	"MP": "Northern Mariana Islands",
+	"MQ": "Martinique",
+	"MR": "Mauritania",
+	"MS": "Montserrat",
+	"MT": "Malta",
+	"MU": "Mauritius",
+	"MV": "Maldives",
+	"MW": "Malawi",
+	"MX": "Mexico",
+	"MY": "Malaysia",
+	"MZ": "Mozambique",
+	"NA": "Namibia",
+	"NC": "New Caledonia",
+	"NE": "Niger",
+	"NF": "Norfolk Island",
+	"NG": "Nigeria",
+	"NI": "Nicaragua",
+	"NL": "Netherlands",
+	"NO": "Norway",
+	"NP": "Nepal",
+	"NR": "Nauru",
+	"NU": "Niue",
+	"NZ": "New Zealand",
+	"OM": "Oman",
+	"PA": "Panama",
+	"PE": "Peru",
+	"PF": "French Polynesia",
+	"PG": "Papua New Guinea",
+	"PH": "Philippines",
+	"PK": "Pakistan",
+	"PL": "Poland",
+	"PM": "Saint Pierre and Miquelon",
+	"PN": "Pitcairn Islands",
+	"PR": "Puerto Rico",
+	"PS": "Palestine",
+	"PT": "Portugal",
+	"PW": "Palau",
+	"PY": "Paraguay",
+	"QA": "Qatar",
+	"RE": "Réunion",
+	"RO": "Romania",
+	"RS": "Serbia",
+	"RU": "Russia",
+	"RW": "Rwanda",
+	"SA": "Saudi Arabia",
+	"SB": "Solomon Islands",
+	"SC": "Seychelles",
+	"SD": "Sudan",
+	"SE": "Sweden",
+	"SG": "Singapore",
+	"SH": "Saint Helena, Ascension and Tristan da Cunha",
+	"SI": "Slovenia",
+	"SJ": "Svalbard and Jan Mayen",
+	"SK": "Slovakia",
+	"SL": "Sierra Leone",
+	"SM": "San Marino",
+	"SN": "Senegal",
+	"SO": "Somalia",
+	"SR": "Suriname",
+	"SS": "South Sudan",
+	"ST": "São Tomé and Príncipe",
+	"SV": "El Salvador",
+	"SX": "Sint Maarten",
+	"SY": "Syria",
+	"SZ": "Eswatini",
+	"TC": "Turks and Caicos Islands",
+	"TD": "Chad",
+	"TF": "French Southern Territories",
+	"TG": "Togo",
+	"TH": "Thailand",
+	"TJ": "Tajikistan",
+	"TK": "Tokelau",
+	"TL": "Timor-Leste",
+	"TM": "Turkmenistan",
+	"TN": "Tunisia",
+	"TO": "Tonga",
+	"TR": "Türkiye",
+	"TT": "Trinidad and Tobago",
+	"TV": "Tuvalu",
+	"TW": "Taiwan",
+	"TZ": "Tanzania",
+	"UA": "Ukraine",
+	"UG": "Uganda",
+	"UM": "United States Minor Outlying Islands",
+	"US": "United States",
+	"UY": "Uruguay",
+	"UZ": "Uzbekistan",
+	"VA": "Vatican City",
+	"VC": "Saint Vincent and the Grenadines",
+	"VE": "Venezuela",
+	"VG": "British Virgin Islands",
+	"VI": "U.S. Virgin Islands",
+	"VN": "Vietnam",
+	"VU": "Vanuatu",
+	"WF": "Wallis and Futuna",
+	"WS": "Samoa",
+	"YE": "Yemen",
+	"YT": "Mayotte",
+	"ZA": "South Africa",
+	"ZM": "Zambia",
+	"ZW": "Zimbabwe",
+}
+
+// countryAliases are other names a country is written as, e.g. by Outlook or by hand,
+// normalized like normalizeCountryName does
+var countryAliases = map[string]string{
+	"usa":                                   "US",
+	"united states of america":              "US",
+	"america":                               "US",
+	"uk":                                    "GB",
+	"great britain":                         "GB",
+	"britain":                               "GB",
+	"england":                               "GB",
+	"scotland":                              "GB",
+	"wales":                                 "GB",
+	"northern ireland":                      "GB",
+	"russian federation":                    "RU",
+	"korea":                                 "KR",
+	"republic of korea":                     "KR",
+	"korea republic of":                     "KR",
+	"democratic people's republic of korea": "KP",
+	"korea democratic people's republic of": "KP",
+	"viet nam":                              "VN",
+	"czech republic":                        "CZ",
+	"holland":                               "NL",
+	"turkey":                                "TR",
+	"turkiye":                               "TR",
+	"ivory coast":                           "CI",
+	"cote d'ivoire":                         "CI",
+	"swaziland":                             "SZ",
+	"macedonia":                             "MK",
+	"burma":                                 "MM",
+	"east timor":                            "TL",
+	"cabo verde":                            "CV",
+	"vatican":                               "VA",
+	"holy see":                              "VA",
+	"congo":                                 "CG",
+	"congo-brazzaville":                     "CG",
+	"congo-kinshasa":                        "CD",
+	"dr congo":                              "CD",
+	"drc":                                   "CD",
+	"iran islamic republic of":              "IR",
+	"syrian arab republic":                  "SY",
+	"lao people's democratic republic":      "LA",
+	"tanzania united republic of":           "TZ",
+	"bolivia plurinational state of":        "BO",
+	"venezuela bolivarian republic of":      "VE",
+	"moldova republic of":                   "MD",
+	"brunei darussalam":                     "BN",
+	"macau":                                 "MO",
+	"hong kong sar":                         "HK",
+	"uae":                                   "AE",
+	"micronesia federated states of":        "FM",
+	"palestinian territories":               "PS",
+	"state of palestine":                    "PS",
+	"curacao":                               "CW",
+	"reunion":                               "RE",
+	"sao tome and principe":                 "ST",
+	"aland islands":                         "AX",
+	"saint barthelemy":                      "BL",
+	"deutschland":                           "DE",
+	"espana":                                "ES",
+	"españa":                                "ES",
+	"brasil":                                "BR",
+	"méxico":                                "MX",
+	"italia":                                "IT",
+	"schweiz":                               "CH",
+	"suisse":                                "CH",
+	"österreich":                            "AT",
+	"nederland":                             "NL",
+	"polska":                                "PL",
+	"sverige":                               "SE",
+	"norge":                                 "NO",
+	"danmark":                               "DK",
+}
+
+// countryCodes finds the code of a normalized country name or alias
+var countryCodes = func() map[string]string {
+	codes := make(map[string]string, len(countries)+len(countryAliases))
+	for code, name := range countries {
+		codes[normalizeCountryName(name)] = code
+	}
+	for alias, code := range countryAliases {
+		codes[normalizeCountryName(alias)] = code
+	}
+	return codes
+}()
+
+// normalizeCountryName lowercases a country name and drops what varies between the ways
+// it is written: dots, commas, "&" for "and" and a leading "the"
+func normalizeCountryName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.NewReplacer(".", "", ",", " ", "&", " and ").Replace(name)
+	name = strings.Join(strings.Fields(name), " ")
+	return strings.TrimPrefix(name, "the ")
+}
+
+// CountryName returns the English name of a country code, or the code itself when unknown
+func CountryName(code string) string {
+	if name, ok := countries[strings.ToUpper(code)]; ok {
+		return name
+	}
+	return code
+}
+
+// CountryCodeFor accepts a country code or a country name and returns the code,
+// or an empty string when text is neither. Other two letters are taken for a code
+// that is not in the table yet, e.g. XK.
+func CountryCodeFor(text string) string {
+	text = strings.TrimSpace(text)
+	if _, ok := countries[strings.ToUpper(text)]; ok {
+		return strings.ToUpper(text)
+	}
+	if code, ok := countryCodes[normalizeCountryName(text)]; ok {
+		return code
+	}
+	if len(text) == 2 {
+		return strings.ToUpper(text)
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package domain
+
+import "errors"
+
+// errors shared by the storage and the service layer,
+// a storage that enforces a rule itself reports it with the same sentinel
+var (
+	ErrEmailAlreadyExist = errors.New("email already exists")
+	// ErrConflict means the contact was changed by someone else since it was read
+	ErrConflict = errors.New("contact was changed by someone else")
+	// ErrIDAlreadyExist means an imported contact keeps an ID another contact has
+	ErrIDAlreadyExist = errors.New("id already exists")
+)
@@ -0,0 +1,22 @@
+package domain
+
+import "strings"
+
+// Group is a curated distribution list. Members keep the order they were added in,
+// and a group can contain other groups which are expanded after its own members.
+type Group struct {
+	ID          int
+	Name        string
+	MemberIDs   []int
+	SubgroupIDs []int
+}
+
+// Recipient renders the primary email of the contact as `Name <email>`,
+// the name is quoted when it contains characters with a meaning in an address
+func (c Contact) Recipient() string {
+	name := c.Name
+	if strings.ContainsAny(name, `,;:<>@"()[]\`) {
+		name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+	return name + " <" + c.Email + ">"
+}
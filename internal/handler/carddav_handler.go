@@ -0,0 +1,975 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/repository"
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/internal/vcard"
+)
+
+// the address book is served over CardDAV (RFC 6352) under /dav/. It has one principal
+// with one address book, every contact is a vCard 3.0 and its ETag is its version, like
+// the ETag of the REST API. A card keeps the name and UID its client saved it with,
+// a contact added another way is named <id>.vcf
+const (
+	davRootPath        = "/dav/"
+	davPrincipalPath   = "/dav/principal/"
+	davHomePath        = "/dav/addressbooks/"
+	davAddressBookPath = "/dav/addressbooks/contacts/"
+
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	// nsCalendarServer has the getctag most clients poll to see whether anything changed
+	nsCalendarServer = "http://calendarserver.org/ns/"
+
+	vcardContentType = "text/vcard; charset=utf-8"
+)
+
+// davPrefixes are the prefixes declared on every multistatus
+var davPrefixes = map[string]string{nsDAV: "d", nsCardDAV: "card", nsCalendarServer: "cs"}
+
+// CardDAVHandler serves the contacts to address book apps. It goes through the
+// ContactService like the REST API, so a vCard is validated like any other contact
+// and its changes are in the audit log. It is not safe for concurrent use on its own,
+// HTTPHandler serves it one request at a time
+type CardDAVHandler struct {
+	service *usecase.ContactService
+}
+
+func NewCardDAVHandler(service *usecase.ContactService) *CardDAVHandler {
+	return &CardDAVHandler{service: service}
+}
+
+// davKind is the kind of resource a path names
+type davKind int
+
+const (
+	davRoot davKind = iota
+	davPrincipal
+	davHome
+	davAddressBook
+	davCard
+)
+
+// davResource is a resource of the tree, contact is set for a card that exists
+type davResource struct {
+	kind    davKind
+	href    string
+	contact domain.Contact
+}
+
+// cardName is the file name of the card of a contact
+func cardName(ctc domain.Contact) string {
+	if ctc.CardName != "" {
+		return ctc.CardName
+	}
+	return fmt.Sprintf("%d.vcf", ctc.ID)
+}
+
+func cardHref(ctc domain.Contact) string {
+	return davAddressBookPath + url.PathEscape(cardName(ctc))
+}
+
+func (h *CardDAVHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/.well-known/carddav" {
+		// RFC 6764, clients given only the host start here
+		http.Redirect(w, r, davRootPath, http.StatusMovedPermanently)
+		return
+	}
+
+	kind, name, ok := resolveDAVPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("DAV", "1, 3, addressbook")
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Allow", davAllow(kind))
+		w.WriteHeader(http.StatusOK)
+	case "PROPFIND":
+		h.propfind(w, r, kind, name)
+	case "REPORT":
+		if kind != davAddressBook {
+			writeDAVStatus(w, http.StatusMethodNotAllowed, davAllow(kind))
+			return
+		}
+		h.report(w, r)
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		if kind != davCard {
+			writeDAVStatus(w, http.StatusMethodNotAllowed, davAllow(kind))
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			h.putCard(w, r, name)
+		case http.MethodDelete:
+			h.deleteCard(w, r, name)
+		default:
+			h.getCard(w, r, name)
+		}
+	default:
+		writeDAVStatus(w, http.StatusMethodNotAllowed, davAllow(kind))
+	}
+}
+
+// resolveDAVPath finds the resource of a path, name is the file name of a card
+func resolveDAVPath(path string) (kind davKind, name string, ok bool) {
+	if !strings.HasSuffix(path, "/") && !strings.HasSuffix(path, ".vcf") {
+		// collections are also found without their trailing slash
+		path += "/"
+	}
+
+	switch path {
+	case davRootPath:
+		return davRoot, "", true
+	case davPrincipalPath:
+		return davPrincipal, "", true
+	case davHomePath:
+		return davHome, "", true
+	case davAddressBookPath:
+		return davAddressBook, "", true
+	}
+
+	name, found := strings.CutPrefix(path, davAddressBookPath)
+	if !found || !strings.HasSuffix(name, ".vcf") || strings.Contains(name, "/") {
+		return 0, "", false
+	}
+	return davCard, name, true
+}
+
+func davAllow(kind davKind) string {
+	switch kind {
+	case davCard:
+		return "OPTIONS, PROPFIND, GET, HEAD, PUT, DELETE"
+	case davAddressBook:
+		return "OPTIONS, PROPFIND, REPORT"
+	default:
+		return "OPTIONS, PROPFIND"
+	}
+}
+
+// findCard reads the contact of a card name, ok is false when there is none.
+// The names are not indexed, an address book is small enough to look through
+func (h *CardDAVHandler) findCard(name string) (domain.Contact, bool, error) {
+	return h.findContact(func(ctc domain.Contact) bool { return cardName(ctc) == name })
+}
+
+// findUID reads the contact whose card has the UID, ok is false when there is none
+func (h *CardDAVHandler) findUID(uid string) (domain.Contact, bool, error) {
+	return h.findContact(func(ctc domain.Contact) bool { return repository.VCardUID(ctc) == uid })
+}
+
+func (h *CardDAVHandler) findContact(match func(domain.Contact) bool) (domain.Contact, bool, error) {
+	contacts, err := h.contacts()
+	if err != nil {
+		return domain.Contact{}, false, err
+	}
+	for _, ctc := range contacts {
+		if match(ctc) {
+			return ctc, true, nil
+		}
+	}
+	return domain.Contact{}, false, nil
+}
+
+// contacts is every contact of the address book, none is not an error here
+func (h *CardDAVHandler) contacts() ([]domain.Contact, error) {
+	contacts, err := h.service.GetAllContacts()
+	if errors.Is(err, usecase.ErrNoContacts) {
+		return nil, nil
+	}
+	return contacts, err
+}
+
+func (h *CardDAVHandler) getCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctc, ok, err := h.findCard(name)
+	if err != nil {
+		writeDAVError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := vcard.Write(&buf, "3.0", repository.ContactToVCard(ctc, "3.0")); err != nil {
+		writeDAVError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", vcardContentType)
+	w.Header().Set("ETag", contactETag(ctc))
+	w.Header().Set("Last-Modified", ctc.UpdatedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(buf.Bytes())
+	}
+}
+
+// putCard adds or replaces a card. If-Match must be the ETag of the stored card and
+// If-None-Match: * only adds. A new card keeps the name of the request and its UID,
+// a UID names one card only and does not change
+func (h *CardDAVHandler) putCard(w http.ResponseWriter, r *http.Request, name string) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil &&
+		mediaType != "text/vcard" && mediaType != "text/x-vcard" && mediaType != "text/directory" {
+		writePrecondition(w, http.StatusUnsupportedMediaType, xml.Name{Space: nsCardDAV, Local: "supported-address-data"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writePrecondition(w, http.StatusRequestEntityTooLarge, xml.Name{Space: nsCardDAV, Local: "max-resource-size"})
+		return
+	}
+	cards, err := vcard.ReadAll(bytes.NewReader(body))
+	if err != nil || len(cards) != 1 {
+		writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "valid-address-data"})
+		return
+	}
+
+	current, exists, err := h.findCard(name)
+	if err != nil {
+		writeDAVError(w, err)
+		return
+	}
+
+	match := r.Header.Get("If-Match")
+	if (match != "" && (!exists || (match != "*" && match != contactETag(current)))) ||
+		(exists && r.Header.Get("If-None-Match") == "*") {
+		writeDAVStatus(w, http.StatusPreconditionFailed, "")
+		return
+	}
+
+	uid := strings.TrimSpace(cards[0].Value("UID"))
+	if exists && uid != "" && uid != repository.VCardUID(current) {
+		writeUIDConflict(w, cardHref(current))
+		return
+	}
+	if !exists && uid != "" {
+		owner, taken, err := h.findUID(uid)
+		if err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		if taken {
+			writeUIDConflict(w, cardHref(owner))
+			return
+		}
+	}
+
+	ctc := repository.ContactFromVCard(cards[0])
+	if !exists {
+		ctc.UID, ctc.CardName = uid, name
+		if err := h.service.CreateContact(ctc); err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		// no ETag, the stored card is not byte for byte what was sent so the client reads it back
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	ctc.ID, ctc.Version = current.ID, current.Version
+	if err := h.service.UpdateContact(ctc); err != nil {
+		writeDAVError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCard moves the contact to the trash
+func (h *CardDAVHandler) deleteCard(w http.ResponseWriter, r *http.Request, name string) {
+	ctc, ok, err := h.findCard(name)
+	if err != nil {
+		writeDAVError(w, err)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != "*" && match != contactETag(ctc) {
+		writeDAVStatus(w, http.StatusPreconditionFailed, "")
+		return
+	}
+
+	if err := h.service.DeleteContact(ctc.ID); err != nil {
+		writeDAVError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// contactETag is the version of the contact, the same ETag as the REST API
+func contactETag(ctc domain.Contact) string {
+	return fmt.Sprintf(`"%d"`, ctc.Version)
+}
+
+// addressBookCTag changes whenever a contact is added, changed or deleted
+func addressBookCTag(contacts []domain.Contact) string {
+	hash := fnv.New64a()
+	for _, ctc := range contacts {
+		fmt.Fprintf(hash, "%d:%d;", ctc.ID, ctc.Version)
+	}
+	return fmt.Sprintf(`"%x"`, hash.Sum64())
+}
+
+// writeDAVError answers with the status of the sentinel error in err. An invalid contact
+// fails the valid-address-data precondition, an email of another contact is a conflict
+func writeDAVError(w http.ResponseWriter, err error) {
+	se, ok := findSentinel(err)
+	switch {
+	case ok && se.status == http.StatusUnprocessableEntity:
+		writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "valid-address-data"})
+	case errors.Is(err, usecase.ErrConflict):
+		writeDAVStatus(w, http.StatusPreconditionFailed, "")
+	case ok:
+		http.Error(w, err.Error(), se.status)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeDAVStatus(w http.ResponseWriter, status int, allow string) {
+	if allow != "" {
+		w.Header().Set("Allow", allow)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// writePrecondition answers with the DAV:error body naming the failed precondition, RFC 4918 section 16
+func writePrecondition(w http.ResponseWriter, status int, condition xml.Name) {
+	writeDAVErrorBody(w, status, propXML(condition, ""))
+}
+
+// writeUIDConflict answers a PUT whose UID is not the UID of the card at href, RFC 6352 section 5.3.2.1
+func writeUIDConflict(w http.ResponseWriter, href string) {
+	writeDAVErrorBody(w, http.StatusConflict, propXML(xml.Name{Space: nsCardDAV, Local: "no-uid-conflict"}, "<d:href>"+escapeXML(href)+"</d:href>"))
+}
+
+func writeDAVErrorBody(w http.ResponseWriter, status int, condition string) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<d:error %s>%s</d:error>`, xml.Header, davNamespaces(), condition)
+}
+
+// propfind answers with the properties of the resource, and of its members with Depth: 1
+func (h *CardDAVHandler) propfind(w http.ResponseWriter, r *http.Request, kind davKind, name string) {
+	var req propfindRequest
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeDAVStatus(w, http.StatusRequestEntityTooLarge, "")
+		return
+	}
+	// an empty body asks for every property
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid PROPFIND body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	query := propQuery{all: req.Prop == nil, namesOnly: req.PropName != nil}
+	if req.Prop != nil {
+		query.names = req.Prop.Names
+	}
+
+	var resources []davResource
+	switch kind {
+	case davCard:
+		ctc, ok, err := h.findCard(name)
+		if err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		resources = append(resources, davResource{kind: davCard, href: cardHref(ctc), contact: ctc})
+	case davRoot:
+		resources = append(resources, davResource{kind: davRoot, href: davRootPath})
+	case davPrincipal:
+		resources = append(resources, davResource{kind: davPrincipal, href: davPrincipalPath})
+	case davHome:
+		resources = append(resources, davResource{kind: davHome, href: davHomePath})
+	case davAddressBook:
+		resources = append(resources, davResource{kind: davAddressBook, href: davAddressBookPath})
+	}
+
+	// Depth: infinity, the default, is answered like 1, the tree is only that deep
+	if r.Header.Get("Depth") != "0" {
+		switch kind {
+		case davRoot:
+			resources = append(resources, davResource{kind: davPrincipal, href: davPrincipalPath}, davResource{kind: davHome, href: davHomePath})
+		case davHome:
+			resources = append(resources, davResource{kind: davAddressBook, href: davAddressBookPath})
+		case davAddressBook:
+			contacts, err := h.contacts()
+			if err != nil {
+				writeDAVError(w, err)
+				return
+			}
+			for _, ctc := range contacts {
+				resources = append(resources, davResource{kind: davCard, href: cardHref(ctc), contact: ctc})
+			}
+		}
+	}
+
+	ms := newMultistatus()
+	for _, res := range resources {
+		resp, err := h.response(res, query)
+		if err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, resp)
+	}
+	writeMultistatus(w, ms)
+}
+
+// report answers addressbook-multiget and addressbook-query on the address book
+func (h *CardDAVHandler) report(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		writeDAVStatus(w, http.StatusRequestEntityTooLarge, "")
+		return
+	}
+
+	root, err := rootElement(body)
+	if err != nil {
+		http.Error(w, "invalid REPORT body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch root {
+	case xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}:
+		var req multigetRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid REPORT body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.multiget(w, req)
+	case xml.Name{Space: nsCardDAV, Local: "addressbook-query"}:
+		var req queryRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			http.Error(w, "invalid REPORT body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.query(w, req)
+	default:
+		writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+	}
+}
+
+// reportQuery is the properties a report answers with, by default the ETag and the vCard
+func reportQuery(prop *propRequest) propQuery {
+	if prop == nil {
+		return propQuery{names: []xml.Name{{Space: nsDAV, Local: "getetag"}, {Space: nsCardDAV, Local: "address-data"}}}
+	}
+	return propQuery{names: prop.Names}
+}
+
+func (h *CardDAVHandler) multiget(w http.ResponseWriter, req multigetRequest) {
+	query := reportQuery(req.Prop)
+	ms := newMultistatus()
+	for _, href := range req.Hrefs {
+		href = strings.TrimSpace(href)
+		// an href may be a full URL
+		path := href
+		if u, err := url.Parse(href); err == nil {
+			path = u.Path
+		}
+
+		kind, name, ok := resolveDAVPath(path)
+		var ctc domain.Contact
+		if ok && kind == davCard {
+			var err error
+			ctc, ok, err = h.findCard(name)
+			if err != nil {
+				writeDAVError(w, err)
+				return
+			}
+		}
+		if !ok || kind != davCard {
+			ms.Responses = append(ms.Responses, davResponse{Href: href, Status: davStatusLine(http.StatusNotFound)})
+			continue
+		}
+
+		resp, err := h.response(davResource{kind: davCard, href: href, contact: ctc}, query)
+		if err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, resp)
+	}
+	writeMultistatus(w, ms)
+}
+
+func (h *CardDAVHandler) query(w http.ResponseWriter, req queryRequest) {
+	for _, pf := range req.Filter.PropFilters {
+		if !pf.collationsSupported() {
+			writePrecondition(w, http.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "supported-collation"})
+			return
+		}
+	}
+
+	contacts, err := h.contacts()
+	if err != nil {
+		writeDAVError(w, err)
+		return
+	}
+
+	query := reportQuery(req.Prop)
+	ms := newMultistatus()
+	for _, ctc := range contacts {
+		if !req.Filter.matches(repository.ContactToVCard(ctc, "3.0")) {
+			continue
+		}
+		if req.Limit != nil && req.Limit.NResults > 0 && len(ms.Responses) == req.Limit.NResults {
+			// RFC 6352 section 8.6.1, a truncated result says so with 507 on the address book
+			ms.Responses = append(ms.Responses, davResponse{Href: davAddressBookPath, Status: davStatusLine(http.StatusInsufficientStorage)})
+			break
+		}
+
+		resp, err := h.response(davResource{kind: davCard, href: cardHref(ctc), contact: ctc}, query)
+		if err != nil {
+			writeDAVError(w, err)
+			return
+		}
+		ms.Responses = append(ms.Responses, resp)
+	}
+	writeMultistatus(w, ms)
+}
+
+// rootElement is the name of the first element of an XML document
+func rootElement(body []byte) (xml.Name, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
+
+// propQuery is what a PROPFIND or a REPORT asks for: every property,
+// the names of every property or the listed properties
+type propQuery struct {
+	all       bool
+	namesOnly bool
+	names     []xml.Name
+}
+
+// davProperty is a property the server knows, value returns its content
+// as XML and false when the resource does not have it
+type davProperty struct {
+	name xml.Name
+	// explicit properties are left out of allprop, they are expensive to compute
+	explicit bool
+	value    func(h *CardDAVHandler, res davResource) (string, bool, error)
+}
+
+// davProperties is every property the server knows
+var davProperties = []davProperty{
+	{name: xml.Name{Space: nsDAV, Local: "resourcetype"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		switch res.kind {
+		case davCard:
+			return "", true, nil
+		case davPrincipal:
+			return "<d:collection/><d:principal/>", true, nil
+		case davAddressBook:
+			return "<d:collection/><card:addressbook/>", true, nil
+		default:
+			return "<d:collection/>", true, nil
+		}
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "displayname"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		switch res.kind {
+		case davPrincipal:
+			return "Contact Management", true, nil
+		case davAddressBook:
+			return "Contacts", true, nil
+		case davCard:
+			return escapeXML(res.contact.Name), true, nil
+		}
+		return "", false, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "current-user-principal"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return "<d:href>" + davPrincipalPath + "</d:href>", true, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "principal-URL"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return "<d:href>" + davPrincipalPath + "</d:href>", res.kind == davPrincipal, nil
+	}},
+	{name: xml.Name{Space: nsCardDAV, Local: "addressbook-home-set"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return "<d:href>" + davHomePath + "</d:href>", res.kind == davPrincipal, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return "<d:privilege><d:read/></d:privilege><d:privilege><d:write/></d:privilege>", res.kind == davAddressBook || res.kind == davCard, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "supported-report-set"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return "<d:supported-report><d:report><card:addressbook-multiget/></d:report></d:supported-report>" +
+			"<d:supported-report><d:report><card:addressbook-query/></d:report></d:supported-report>", res.kind == davAddressBook, nil
+	}},
+	{name: xml.Name{Space: nsCardDAV, Local: "supported-address-data"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return `<card:address-data-type content-type="text/vcard" version="3.0"/>`, res.kind == davAddressBook, nil
+	}},
+	{name: xml.Name{Space: nsCardDAV, Local: "max-resource-size"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return strconv.Itoa(maxBodySize), res.kind == davAddressBook, nil
+	}},
+	{name: xml.Name{Space: nsCalendarServer, Local: "getctag"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		if res.kind != davAddressBook {
+			return "", false, nil
+		}
+		contacts, err := h.contacts()
+		if err != nil {
+			return "", false, err
+		}
+		return escapeXML(addressBookCTag(contacts)), true, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "getetag"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return escapeXML(contactETag(res.contact)), res.kind == davCard, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "getcontenttype"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return vcardContentType, res.kind == davCard, nil
+	}},
+	{name: xml.Name{Space: nsDAV, Local: "getlastmodified"}, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		return res.contact.UpdatedAt.UTC().Format(http.TimeFormat), res.kind == davCard, nil
+	}},
+	{name: xml.Name{Space: nsCardDAV, Local: "address-data"}, explicit: true, value: func(h *CardDAVHandler, res davResource) (string, bool, error) {
+		if res.kind != davCard {
+			return "", false, nil
+		}
+		var buf bytes.Buffer
+		if err := vcard.Write(&buf, "3.0", repository.ContactToVCard(res.contact, "3.0")); err != nil {
+			return "", false, err
+		}
+		return escapeXML(buf.String()), true, nil
+	}},
+}
+
+// response is the multistatus response of one resource, a property it does not have is in a 404 propstat
+func (h *CardDAVHandler) response(res davResource, query propQuery) (davResponse, error) {
+	var found, missing strings.Builder
+	if query.all || query.namesOnly {
+		for _, prop := range davProperties {
+			if prop.explicit && !query.namesOnly {
+				continue
+			}
+			value, ok, err := prop.value(h, res)
+			if err != nil {
+				return davResponse{}, err
+			}
+			if !ok {
+				continue
+			}
+			if query.namesOnly {
+				value = ""
+			}
+			found.WriteString(propXML(prop.name, value))
+		}
+	}
+
+	for _, name := range query.names {
+		known := false
+		for _, prop := range davProperties {
+			if prop.name != name {
+				continue
+			}
+			value, ok, err := prop.value(h, res)
+			if err != nil {
+				return davResponse{}, err
+			}
+			if ok {
+				found.WriteString(propXML(name, value))
+				known = true
+			}
+			break
+		}
+		if !known {
+			missing.WriteString(propXML(name, ""))
+		}
+	}
+
+	resp := davResponse{Href: res.href}
+	if found.Len() > 0 || missing.Len() == 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Prop: davProp{Inner: found.String()}, Status: davStatusLine(http.StatusOK)})
+	}
+	if missing.Len() > 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Prop: davProp{Inner: missing.String()}, Status: davStatusLine(http.StatusNotFound)})
+	}
+	return resp, nil
+}
+
+// propXML writes a property element, inner is XML already. A namespace
+// without a prefix of the multistatus is declared on the element
+func propXML(name xml.Name, inner string) string {
+	tag, attrs := name.Local, ""
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag = "x:" + name.Local
+		attrs = ` xmlns:x="` + escapeXML(name.Space) + `"`
+	}
+
+	if inner == "" {
+		return "<" + tag + attrs + "/>"
+	}
+	return "<" + tag + attrs + ">" + inner + "</" + tag + ">"
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davStatusLine(status int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", status, http.StatusText(status))
+}
+
+func davNamespaces() string {
+	return fmt.Sprintf(`xmlns:d="%s" xmlns:card="%s" xmlns:cs="%s"`, nsDAV, nsCardDAV, nsCalendarServer)
+}
+
+// multistatus is the body of a 207 answer, the element names carry
+// the prefixes declared on the root so the properties can use them
+type multistatus struct {
+	XMLName   xml.Name      `xml:"d:multistatus"`
+	DAV       string        `xml:"xmlns:d,attr"`
+	CardDAV   string        `xml:"xmlns:card,attr"`
+	CS        string        `xml:"xmlns:cs,attr"`
+	Responses []davResponse `xml:"d:response"`
+}
+
+type davResponse struct {
+	Href      string     `xml:"d:href"`
+	Propstats []propstat `xml:"d:propstat,omitempty"`
+	Status    string     `xml:"d:status,omitempty"`
+}
+
+type propstat struct {
+	Prop   davProp `xml:"d:prop"`
+	Status string  `xml:"d:status"`
+}
+
+type davProp struct {
+	Inner string `xml:",innerxml"`
+}
+
+func newMultistatus() *multistatus {
+	return &multistatus{DAV: nsDAV, CardDAV: nsCardDAV, CS: nsCalendarServer}
+}
+
+func writeMultistatus(w http.ResponseWriter, ms *multistatus) {
+	data, err := xml.Marshal(ms)
+	if err != nil {
+		http.Error(w, "failed to marshal the response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// propRequest is a DAV:prop of a request, the names of its child elements
+type propRequest struct {
+	Names []xml.Name
+}
+
+func (p *propRequest) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			p.Names = append(p.Names, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name     `xml:"DAV: propfind"`
+	PropName *struct{}    `xml:"DAV: propname"`
+	Prop     *propRequest `xml:"DAV: prop"`
+}
+
+type multigetRequest struct {
+	XMLName xml.Name     `xml:"urn:ietf:params:xml:ns:carddav addressbook-multiget"`
+	Prop    *propRequest `xml:"DAV: prop"`
+	Hrefs   []string     `xml:"DAV: href"`
+}
+
+type queryRequest struct {
+	XMLName xml.Name     `xml:"urn:ietf:params:xml:ns:carddav addressbook-query"`
+	Prop    *propRequest `xml:"DAV: prop"`
+	Filter  queryFilter  `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit   *queryLimit  `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+type queryLimit struct {
+	NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+}
+
+// queryFilter is the filter of an addressbook-query, RFC 6352 section 10.5
+type queryFilter struct {
+	Test        string       `xml:"test,attr"`
+	PropFilters []propFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+type propFilter struct {
+	Name         string        `xml:"name,attr"`
+	Test         string        `xml:"test,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []textMatch   `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	ParamFilters []paramFilter `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+type paramFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type textMatch struct {
+	Collation string `xml:"collation,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+	MatchType string `xml:"match-type,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// matches reports whether the card passes the filter, without prop-filters every card does
+func (f queryFilter) matches(card vcard.Card) bool {
+	if len(f.PropFilters) == 0 {
+		return true
+	}
+	results := make([]bool, len(f.PropFilters))
+	for i, pf := range f.PropFilters {
+		results[i] = pf.matches(card)
+	}
+	return combine(f.Test, results)
+}
+
+// combine joins the results with test, anyof (the default) or allof
+func combine(test string, results []bool) bool {
+	all := test == "allof"
+	for _, ok := range results {
+		if ok != all {
+			return ok
+		}
+	}
+	return all
+}
+
+func (pf propFilter) matches(card vcard.Card) bool {
+	props := card.All(strings.ToUpper(pf.Name))
+	if pf.IsNotDefined != nil {
+		return len(props) == 0
+	}
+	if len(props) == 0 {
+		return false
+	}
+	if len(pf.TextMatches) == 0 && len(pf.ParamFilters) == 0 {
+		return true
+	}
+
+	// the text-matches and param-filters are tested on each property of the name
+	for _, p := range props {
+		var results []bool
+		for _, tm := range pf.TextMatches {
+			results = append(results, tm.matches(p.Value))
+		}
+		for _, param := range pf.ParamFilters {
+			results = append(results, param.matches(p))
+		}
+		if combine(pf.Test, results) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pf propFilter) collationsSupported() bool {
+	for _, tm := range pf.TextMatches {
+		if !tm.collationSupported() {
+			return false
+		}
+	}
+	for _, param := range pf.ParamFilters {
+		if param.TextMatch != nil && !param.TextMatch.collationSupported() {
+			return false
+		}
+	}
+	return true
+}
+
+func (pf paramFilter) matches(p vcard.Property) bool {
+	values := p.Params[strings.ToUpper(pf.Name)]
+	if pf.IsNotDefined != nil {
+		return len(values) == 0
+	}
+	if pf.TextMatch == nil {
+		return len(values) > 0
+	}
+	for _, v := range values {
+		if pf.TextMatch.matches(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tm textMatch) collationSupported() bool {
+	switch tm.Collation {
+	case "", "i;unicode-casemap", "i;ascii-casemap", "i;octet":
+		return true
+	}
+	return false
+}
+
+// matches compares value with the text, ignoring case unless the collation is i;octet
+func (tm textMatch) matches(value string) bool {
+	text := tm.Text
+	if tm.Collation != "i;octet" {
+		text, value = strings.ToLower(text), strings.ToLower(value)
+	}
+
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == text
+	case "starts-with":
+		ok = strings.HasPrefix(value, text)
+	case "ends-with":
+		ok = strings.HasSuffix(value, text)
+	default:
+		ok = strings.Contains(value, text)
+	}
+	return ok != (tm.Negate == "yes")
+}
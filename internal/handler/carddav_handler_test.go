@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/usecase"
+)
+
+// newDAVServer serves a new address book, the tests talk to it with a real HTTP client
+func newDAVServer(t *testing.T) (*httptest.Server, *usecase.ContactService) {
+	t.Helper()
+	service := newTestService(t)
+	srv := httptest.NewServer(NewHTTPHandler(service))
+	t.Cleanup(srv.Close)
+	return srv, service
+}
+
+// davDo sends a request and returns the answer with its body read
+func davDo(t *testing.T, srv *httptest.Server, method, path string, header map[string]string, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+func vcardOf(uid, name, email string) string {
+	return "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:" + uid + "\r\nFN:" + name + "\r\nEMAIL:" + email + "\r\nEND:VCARD\r\n"
+}
+
+var vcardHeader = map[string]string{"Content-Type": "text/vcard"}
+
+// putTestCard adds a card under name and fails the test when it is not created
+func putTestCard(t *testing.T, srv *httptest.Server, name, uid, fn, email string) {
+	t.Helper()
+	header := map[string]string{"Content-Type": "text/vcard", "If-None-Match": "*"}
+	if resp, body := davDo(t, srv, http.MethodPut, davAddressBookPath+name, header, vcardOf(uid, fn, email)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PUT %s: %d %s", name, resp.StatusCode, body)
+	}
+}
+
+func TestCardDAVPutKeepsNameAndUID(t *testing.T) {
+	srv, service := newDAVServer(t)
+	const href = davAddressBookPath + "6F2A-card.vcf"
+	putTestCard(t, srv, "6F2A-card.vcf", "6F2A", "Ann Lee", "ann@mail.com")
+
+	resp, body := davDo(t, srv, http.MethodGet, href, nil, "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "UID:6F2A\r\n") || resp.Header.Get("ETag") != `"1"` {
+		t.Fatalf("GET %s: %d %s %s, want the card with its UID at version 1", href, resp.StatusCode, resp.Header.Get("ETag"), body)
+	}
+
+	// the same name and UID update the card
+	header := map[string]string{"Content-Type": "text/vcard", "If-Match": `"1"`}
+	if resp, body := davDo(t, srv, http.MethodPut, href, header, vcardOf("6F2A", "Ann Smith", "ann@mail.com")); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("PUT again: %d %s, want 204", resp.StatusCode, body)
+	}
+
+	// an edit outside CardDAV keeps the card where the client saved it
+	ctc, err := service.SearchByEmail("ann@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctc.Phones = []domain.Phone{{Number: "0811", Primary: true}}
+	if err := service.UpdateContact(ctc); err != nil {
+		t.Fatal(err)
+	}
+	resp, body = davDo(t, srv, http.MethodGet, href, nil, "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "FN:Ann Smith") || !strings.Contains(body, "UID:6F2A\r\n") || resp.Header.Get("ETag") != `"3"` {
+		t.Errorf("GET after the edits: %d %s %s", resp.StatusCode, resp.Header.Get("ETag"), body)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header map[string]string
+		card   string
+		status int
+		want   string
+	}{
+		{"new card with a used UID", davAddressBookPath + "other.vcf", vcardHeader, vcardOf("6F2A", "Bob", "bob@mail.com"), http.StatusConflict, "<d:href>" + href + "</d:href>"},
+		{"changed UID", href, vcardHeader, vcardOf("7B3C", "Ann Smith", "ann@mail.com"), http.StatusConflict, "no-uid-conflict"},
+		{"only add an existing card", href, map[string]string{"If-None-Match": "*"}, vcardOf("6F2A", "Ann", "ann@mail.com"), http.StatusPreconditionFailed, ""},
+		{"stale ETag", href, map[string]string{"If-Match": `"1"`}, vcardOf("6F2A", "Ann", "ann@mail.com"), http.StatusPreconditionFailed, ""},
+		{"email of another card", davAddressBookPath + "bob.vcf", vcardHeader, vcardOf("8D4E", "Bob", "ann@mail.com"), http.StatusConflict, ""},
+		{"no email", davAddressBookPath + "bob.vcf", vcardHeader, vcardOf("8D4E", "Bob", ""), http.StatusForbidden, "valid-address-data"},
+		{"not a vCard", davAddressBookPath + "bob.vcf", map[string]string{"Content-Type": "application/json"}, "{}", http.StatusUnsupportedMediaType, "supported-address-data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := davDo(t, srv, http.MethodPut, tt.path, tt.header, tt.card)
+			if resp.StatusCode != tt.status || !strings.Contains(body, tt.want) {
+				t.Errorf("got %d %s, want %d with %q", resp.StatusCode, body, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestCardDAVPropfind(t *testing.T) {
+	srv, service := newDAVServer(t)
+	putTestCard(t, srv, "ann.vcf", "ann-uid", "Ann", "ann@mail.com")
+	// a contact added another way is named after its id
+	if err := service.CreateContact(domain.Contact{Name: "Bob", Email: "bob@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	propfind := `<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getetag/><d:resourcetype/><d:quota/></d:prop></d:propfind>`
+	tests := []struct {
+		name, path, depth string
+		want, notWant     []string
+	}{
+		{
+			name: "address book with its cards", path: davAddressBookPath, depth: "1",
+			want: []string{"<d:href>/dav/addressbooks/contacts/</d:href>", "<card:addressbook/>",
+				"<d:href>/dav/addressbooks/contacts/ann.vcf</d:href>", "<d:href>/dav/addressbooks/contacts/2.vcf</d:href>",
+				`<d:getetag>&#34;1&#34;</d:getetag>`, "<d:quota/>", "HTTP/1.1 404 Not Found"},
+		},
+		{
+			name: "address book only", path: davAddressBookPath, depth: "0",
+			want: []string{"<card:addressbook/>"}, notWant: []string{"ann.vcf"},
+		},
+		{
+			name: "card", path: davAddressBookPath + "ann.vcf", depth: "0",
+			want: []string{"<d:href>/dav/addressbooks/contacts/ann.vcf</d:href>", "<d:getetag>"}, notWant: []string{"2.vcf"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := davDo(t, srv, "PROPFIND", tt.path, map[string]string{"Depth": tt.depth}, propfind)
+			if resp.StatusCode != http.StatusMultiStatus {
+				t.Fatalf("got %d %s, want 207", resp.StatusCode, body)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("the answer has no %s:\n%s", want, body)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(body, notWant) {
+					t.Errorf("the answer has %s:\n%s", notWant, body)
+				}
+			}
+		})
+	}
+
+	// discovery goes from the principal to the address book home
+	_, body := davDo(t, srv, "PROPFIND", davPrincipalPath, map[string]string{"Depth": "0"},
+		`<d:propfind xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:prop><card:addressbook-home-set/></d:prop></d:propfind>`)
+	if !strings.Contains(body, "<d:href>"+davHomePath+"</d:href>") {
+		t.Errorf("the principal does not name the home: %s", body)
+	}
+
+	if resp, _ := davDo(t, srv, "PROPFIND", davAddressBookPath+"1.vcf", map[string]string{"Depth": "0"}, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("the id of a card with a name of its own found it: %d", resp.StatusCode)
+	}
+}
+
+func TestCardDAVReport(t *testing.T) {
+	srv, _ := newDAVServer(t)
+	putTestCard(t, srv, "ann.vcf", "ann-uid", "Ann", "ann@work.com")
+	putTestCard(t, srv, "bob.vcf", "bob-uid", "Bob", "bob@home.com")
+
+	multiget := `<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+		<d:prop><d:getetag/><card:address-data/></d:prop>
+		<d:href>/dav/addressbooks/contacts/bob.vcf</d:href>
+		<d:href>/dav/addressbooks/contacts/gone.vcf</d:href>
+	</card:addressbook-multiget>`
+	resp, body := davDo(t, srv, "REPORT", davAddressBookPath, map[string]string{"Depth": "1"}, multiget)
+	if resp.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("multiget: %d %s", resp.StatusCode, body)
+	}
+	bob, gone, _ := strings.Cut(body, "gone.vcf")
+	if !strings.Contains(bob, "UID:bob-uid") || strings.Contains(body, "ann-uid") || !strings.Contains(gone, "404 Not Found") {
+		t.Errorf("multiget answered %s, want bob.vcf with its card and gone.vcf not found", body)
+	}
+
+	query := `<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">
+		<d:prop><d:getetag/></d:prop>
+		<card:filter><card:prop-filter name="EMAIL"><card:text-match match-type="ends-with">@WORK.com</card:text-match></card:prop-filter></card:filter>
+	</card:addressbook-query>`
+	resp, body = davDo(t, srv, "REPORT", davAddressBookPath, map[string]string{"Depth": "1"}, query)
+	if resp.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "ann.vcf") || strings.Contains(body, "bob.vcf") {
+		t.Errorf("query: %d %s, want only ann.vcf", resp.StatusCode, body)
+	}
+
+	resp, body = davDo(t, srv, "REPORT", davAddressBookPath, nil, `<d:sync-collection xmlns:d="DAV:"/>`)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(body, "supported-report") {
+		t.Errorf("unknown report: %d %s, want 403 supported-report", resp.StatusCode, body)
+	}
+}
+
+func TestCardDAVDelete(t *testing.T) {
+	srv, service := newDAVServer(t)
+	const href = davAddressBookPath + "ann.vcf"
+	putTestCard(t, srv, "ann.vcf", "ann-uid", "Ann", "ann@mail.com")
+
+	if resp, _ := davDo(t, srv, http.MethodDelete, href, map[string]string{"If-Match": `"7"`}, ""); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with a stale ETag: %d, want 412", resp.StatusCode)
+	}
+	if resp, _ := davDo(t, srv, http.MethodDelete, href, map[string]string{"If-Match": `"1"`}, ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE: %d, want 204", resp.StatusCode)
+	}
+
+	if resp, _ := davDo(t, srv, http.MethodGet, href, nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET after DELETE: %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := davDo(t, srv, http.MethodDelete, href, nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE again: %d, want 404", resp.StatusCode)
+	}
+
+	// the contact is in the trash and comes back under its name
+	trash, err := service.GetTrash()
+	if err != nil || len(trash) != 1 {
+		t.Fatalf("trash is %v (%v), want the deleted contact", trash, err)
+	}
+	if err := service.RestoreContact(trash[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if resp, body := davDo(t, srv, http.MethodGet, href, nil, ""); resp.StatusCode != http.StatusOK || !strings.Contains(body, "UID:ann-uid") {
+		t.Errorf("GET after restore: %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,534 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+// exit codes of the subcommands, a script can tell the failures apart without reading the message
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// errUsage marks a wrong command line, it exits with exitUsage
+var errUsage = errors.New("invalid usage")
+
+// CommandHandler runs one subcommand without the menu, so the tool can be used from scripts, cron or CI
+type CommandHandler struct {
+	service *usecase.ContactService
+	output  ui.Output
+}
+
+func NewCommandHandler(service *usecase.ContactService, output ui.Output) *CommandHandler {
+	return &CommandHandler{service: service, output: output}
+}
+
+// commands lists the subcommands in the order they are shown in the usage
+var commands = []struct {
+	name    string
+	summary string
+}{
+	{"add", "add a contact"},
+	{"edit", "change the given fields of a contact"},
+	{"delete", "move a contact to the trash"},
+	{"list", "show every contact"},
+	{"search", "search contacts by id, name, email, location or tag expression"},
+	{"import", "import contacts from a .json, .csv, .vcf or .ldif file"},
+	{"export", "export contacts to a .json, .csv, .vcf or .ldif file"},
+	{"serve", "serve the REST API over HTTP until interrupted"},
+}
+
+// Run executes the subcommand in args, e.g. add --name Bob --email bob@mail.com,
+// and returns the exit code of the process
+func (h *CommandHandler) Run(args []string) int {
+	if len(args) == 0 {
+		printCommands()
+		return exitUsage
+	}
+
+	var err error
+	switch args[0] {
+	case "add":
+		err = h.runAdd(args[1:])
+	case "edit":
+		err = h.runEdit(args[1:])
+	case "delete":
+		err = h.runDelete(args[1:])
+	case "list":
+		err = h.runList(args[1:])
+	case "search":
+		err = h.runSearch(args[1:])
+	case "import":
+		err = h.runImport(args[1:])
+	case "export":
+		err = h.runExport(args[1:])
+	case "serve":
+		err = h.runServe(args[1:])
+	case "help", "-h", "-help", "--help":
+		printCommands()
+		return exitOK
+	default:
+		if !h.output.Structured() {
+			fmt.Fprintf(os.Stderr, "ERROR: unknown command %q\n", args[0])
+			printCommands()
+			return exitUsage
+		}
+		err = fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+
+	return h.exitCode(err)
+}
+
+// exitCode reports err on stderr and maps it to the exit code of the process
+func (h *CommandHandler) exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		// in text the flag set already printed what was wrong and the usage
+		if h.output.Structured() {
+			ui.PrintError(h.output, ui.ErrorInfo{Code: exitUsage, Name: "usage", Message: err.Error()})
+		}
+		return exitUsage
+	}
+
+	info := ui.ErrorInfo{Code: exitFailure, Name: "failure", Message: err.Error()}
+	if se, ok := findSentinel(err); ok {
+		info.Code, info.Name = se.exit, se.name
+	}
+	ui.PrintError(h.output, info)
+	return info.Code
+}
+
+func printCommands() {
+	fmt.Fprintln(os.Stderr, "usage: contact-management [flags] <command> [command flags]")
+	fmt.Fprintln(os.Stderr, "run without a command to start the interactive menu\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nrun contact-management <command> -h to see the flags of a command")
+}
+
+// newFlagSet returns the flag set of a subcommand, errors and -h are printed on stderr.
+// a structured output reports the errors itself, so the flag set stays quiet unless -h is given
+func (h *CommandHandler) newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	if h.output.Structured() {
+		fs.SetOutput(io.Discard)
+		fs.Usage = func() {}
+	}
+	return fs
+}
+
+// parseFlags parses the flags of a subcommand, which takes no positional arguments
+func (h *CommandHandler) parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			if h.output.Structured() {
+				fs.SetOutput(os.Stderr)
+				fs.PrintDefaults()
+			}
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return h.usageError(fs, fmt.Sprintf("unexpected argument %q", fs.Arg(0)))
+	}
+	return nil
+}
+
+// requireFlag fails with a usage error when the flag was not given
+func (h *CommandHandler) requireFlag(fs *flag.FlagSet, name string) error {
+	given := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			given = true
+		}
+	})
+	if !given {
+		return h.usageError(fs, fmt.Sprintf("flag -%s is required", name))
+	}
+	return nil
+}
+
+// usageError reports a wrong command line found after parsing,
+// in text it is printed with the usage like the errors of the flag set
+func (h *CommandHandler) usageError(fs *flag.FlagSet, msg string) error {
+	if !h.output.Structured() {
+		fmt.Fprintln(os.Stderr, msg)
+		fs.Usage()
+	}
+	return fmt.Errorf("%w: %s", errUsage, msg)
+}
+
+func (h *CommandHandler) printResult(r ui.Result) error {
+	if err := ui.PrintResult(h.output, r); err != nil {
+		return fmt.Errorf("failed to print the result: %w", err)
+	}
+	return nil
+}
+
+func (h *CommandHandler) printContacts(contacts []domain.Contact, columns []ui.Column) error {
+	if err := ui.PrintContactsAs(h.output, contacts, columns); err != nil {
+		return fmt.Errorf("failed to print the contacts: %w", err)
+	}
+	return nil
+}
+
+func (h *CommandHandler) runAdd(args []string) error {
+	fs := h.newFlagSet("add")
+	name := fs.String("name", "", "name of the contact (required)")
+	email := fs.String("email", "", "emails, e.g. \"work:bob@mail.com, home:bob@home.com\", the first is the primary (required)")
+	phone := fs.String("phone", "", "phones, e.g. \"mobile:+62812345678\"")
+	address := fs.String("address", "", "addresses separated by \";\", fields street|locality|region|postal code|country code, \\ escapes a separator")
+	tags := fs.String("tags", "", "tags, comma separated")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ctc := domain.Contact{
+		Name:      *name,
+		Emails:    domain.ParseEmails(*email),
+		Phones:    domain.ParsePhones(*phone),
+		Addresses: domain.ParseAddresses(*address),
+		Tags:      domain.ParseTags(*tags),
+	}
+	if err := h.service.CreateContact(ctc); err != nil {
+		return err
+	}
+
+	// print the ID so a script can use it in the next command,
+	// the service saved a normalized copy so the primary email is looked up the same way
+	ctc.Normalize()
+	added, err := h.service.SearchByEmail(ctc.EmailList()[0].Address)
+	if err != nil {
+		return fmt.Errorf("contact added but could not be read back: %w", err)
+	}
+	return h.printResult(ui.Result{
+		Command: "add",
+		ID:      added.ID,
+		Version: added.Version,
+		Message: fmt.Sprintf("added contact %d", added.ID),
+	})
+}
+
+func (h *CommandHandler) runEdit(args []string) error {
+	fs := h.newFlagSet("edit")
+	id := fs.Int("id", 0, "ID of the contact (required)")
+	version := fs.Int("version", 0, "only save when the contact still has this version, 0 means the current one")
+	name := fs.String("name", "", "new name")
+	email := fs.String("email", "", "new emails, replaces all of them")
+	phone := fs.String("phone", "", "new phones, replaces all of them")
+	address := fs.String("address", "", "new addresses, replaces all of them")
+	tags := fs.String("tags", "", "new tags, replaces all of them")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := h.requireFlag(fs, "id"); err != nil {
+		return err
+	}
+
+	ctc, err := h.service.SearchByID(*id)
+	if err != nil {
+		return err
+	}
+	if *version != 0 {
+		ctc.Version = *version
+	}
+
+	// only the given flags are changed, an empty value clears the field
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "name":
+			ctc.Name = *name
+		case "email":
+			ctc.Emails = domain.ParseEmails(*email)
+		case "phone":
+			ctc.Phones = domain.ParsePhones(*phone)
+		case "address":
+			ctc.Addresses = domain.ParseAddresses(*address)
+		case "tags":
+			ctc.Tags = domain.ParseTags(*tags)
+		}
+	})
+
+	if err := h.service.UpdateContact(ctc); err != nil {
+		return err
+	}
+
+	updated, err := h.service.SearchByID(*id)
+	if err != nil {
+		return fmt.Errorf("contact updated but could not be read back: %w", err)
+	}
+	return h.printResult(ui.Result{
+		Command: "edit",
+		ID:      updated.ID,
+		Version: updated.Version,
+		Message: fmt.Sprintf("updated contact %d to version %d", updated.ID, updated.Version),
+	})
+}
+
+func (h *CommandHandler) runDelete(args []string) error {
+	fs := h.newFlagSet("delete")
+	id := fs.Int("id", 0, "ID of the contact (required)")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := h.requireFlag(fs, "id"); err != nil {
+		return err
+	}
+
+	if _, err := h.service.SearchByID(*id); err != nil {
+		return err
+	}
+	if err := h.service.DeleteContact(*id); err != nil {
+		return err
+	}
+	return h.printResult(ui.Result{
+		Command: "delete",
+		ID:      *id,
+		Message: fmt.Sprintf("moved contact %d to the trash", *id),
+	})
+}
+
+func (h *CommandHandler) runList(args []string) error {
+	fs := h.newFlagSet("list")
+	columnList := fs.String("columns", ui.DefaultColumns, "columns of the table output: "+ui.ColumnNames())
+	sortKey := fs.String("sort", "id", "column to sort by, -name sorts descending")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	columns, err := ui.ParseColumns(*columnList)
+	if err != nil {
+		return h.usageError(fs, err.Error())
+	}
+
+	contacts, err := h.service.GetAllContacts()
+	if err != nil {
+		return err
+	}
+	if err := ui.SortContacts(contacts, *sortKey); err != nil {
+		return h.usageError(fs, err.Error())
+	}
+	return h.printContacts(contacts, columns)
+}
+
+func (h *CommandHandler) runSearch(args []string) error {
+	fs := h.newFlagSet("search")
+	id := fs.Int("id", 0, "ID of the contact")
+	name := fs.String("name", "", "whole name of the contact, matched exactly")
+	email := fs.String("email", "", "email address")
+	location := fs.String("location", "", "city or country")
+	tags := fs.String("tags", "", "tag expression, e.g. \"customer AND NOT churned\"")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NFlag() != 1 {
+		return h.usageError(fs, "give exactly one of -id, -name, -email, -location or -tags")
+	}
+
+	var contacts []domain.Contact
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		var ctc domain.Contact
+		switch f.Name {
+		case "id":
+			ctc, err = h.service.SearchByID(*id)
+			contacts = []domain.Contact{ctc}
+		case "name":
+			contacts, err = h.service.SearchByName(*name)
+		case "email":
+			ctc, err = h.service.SearchByEmail(*email)
+			contacts = []domain.Contact{ctc}
+		case "location":
+			contacts, err = h.service.SearchByLocation(*location)
+		case "tags":
+			contacts, err = h.service.SearchByTags(*tags)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.printContacts(contacts, nil)
+}
+
+func (h *CommandHandler) runImport(args []string) error {
+	fs := h.newFlagSet("import")
+	file := fs.String("file", "", "file to import, .json, .vcf and .ldif files are read from the data folder like in the menu (required)")
+	profile := fs.String("profile", "", "saved mapping profile that reads the columns of a .csv file, by default they are detected from the header")
+	dryRun := fs.Bool("dry-run", false, "check every row of the file and print its outcome without importing anything")
+	conflict := fs.String("on-conflict", string(usecase.ConflictOverwrite), "what a row does when its email exists, skip, overwrite, merge or duplicate")
+	ids := fs.String("ids", string(usecase.IDReassign), "id of a new contact, reassign or preserve the id of the row")
+	batchSize := fs.Int("batch-size", usecase.DefaultImportBatchSize, "save the file every n rows, a batch that fails or Ctrl-C keeps the batches before it, 0 saves the whole file at once")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := h.requireFlag(fs, "file"); err != nil {
+		return err
+	}
+	if *batchSize < 0 {
+		return h.usageError(fs, "flag -batch-size must be 0 or more")
+	}
+
+	run := importRunFor(h.service, *file)
+	if run == nil {
+		return fmt.Errorf("%w, must end with .json, .csv, .vcf or .ldif", usecase.ErrInvalidImportFilename)
+	}
+	return h.runRowImport(run, *file, usecase.ImportOptions{
+		DryRun:    *dryRun,
+		Profile:   *profile,
+		Conflict:  usecase.ImportConflict(*conflict),
+		IDs:       usecase.IDPolicy(*ids),
+		BatchSize: *batchSize,
+	})
+}
+
+// runRowImport imports a file with run, a dry run prints the outcome of every row instead.
+// The rows are printed as they are checked and not kept, Ctrl-C stops the import
+func (h *CommandHandler) runRowImport(run importRun, file string, opts usecase.ImportOptions) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var printer *ui.ImportRowPrinter
+	var printErr error
+	if opts.DryRun {
+		printer = ui.NewImportRowPrinter(h.output)
+		opts.OnRow = func(r usecase.ImportRowReport) {
+			if printErr == nil {
+				printErr = printer.Print(reportRow(r))
+			}
+		}
+	} else {
+		// only the counts are printed
+		opts.OnRow = func(usecase.ImportRowReport) {}
+	}
+	// the progress line would run through the rows of a dry run on the terminal
+	if !opts.DryRun || !ui.IsTerminal(os.Stdout) {
+		opts.OnProgress = showProgress("rows")
+	}
+
+	report, err := run(ctx, file, opts)
+	if printer != nil {
+		if closeErr := printer.Close(); printErr == nil {
+			printErr = closeErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return printErr
+	}
+
+	count := report.Count(usecase.ImportCreate) + report.Count(usecase.ImportUpdate)
+	return h.printResult(ui.Result{
+		Command: "import",
+		Count:   &count,
+		File:    file,
+		Message: importSummary(report),
+	})
+}
+
+func (h *CommandHandler) runExport(args []string) error {
+	fs := h.newFlagSet("export")
+	file := fs.String("file", "", "name of the .json, .csv, .vcf or .ldif file written to the data folder (required)")
+	tags := fs.String("tags", "", "only export the contacts matching this tag expression")
+	dialect := fs.String("csv-dialect", "native", "columns of a .csv file, native, google or outlook")
+	version := fs.String("vcard-version", "3.0", "version of a .vcf file, 3.0 or 4.0")
+	dnTemplate := fs.String("dn-template", usecase.DefaultDNTemplate, "dn of a contact in an .ldif file, {uid}, {id}, {cn} and {mail} are replaced")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := h.requireFlag(fs, "file"); err != nil {
+		return err
+	}
+
+	// Ctrl-C stops the export and leaves the file as it was
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	progress := showProgress("contacts")
+
+	var err error
+	switch strings.ToLower(filepath.Ext(*file)) {
+	case ".json":
+		err = h.service.ExportToJSON(ctx, *file, *tags, progress)
+	case ".csv":
+		err = h.service.ExportToCSV(ctx, *file, *dialect, *tags, progress)
+	case ".vcf":
+		err = h.service.ExportToVCard(ctx, *file, *version, *tags, progress)
+	case ".ldif":
+		err = h.service.ExportToLDIF(ctx, *file, *dnTemplate, *tags, progress)
+	default:
+		return fmt.Errorf("%w, must end with .json, .csv, .vcf or .ldif", usecase.ErrInvalidExportFilename)
+	}
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join("data", *file)
+	return h.printResult(ui.Result{
+		Command: "export",
+		File:    path,
+		Message: "exported contacts to " + path,
+	})
+}
+
+func (h *CommandHandler) runServe(args []string) error {
+	fs := h.newFlagSet("serve")
+	addr := fs.String("addr", "localhost:8080", "address the HTTP server listens on")
+	if err := h.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	// stop on Ctrl-C or a service manager, so main still closes the storage
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           NewHTTPHandler(h.service),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(os.Stderr, "serving the contacts API on http://%s\n", *addr)
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to stop the server: %w", err)
+	}
+	return nil
+}
@@ -2,27 +2,31 @@ package handler
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Dwipasca/contact-management/internal/domain"
 	"github.com/Dwipasca/contact-management/internal/usecase"
 	"github.com/Dwipasca/contact-management/ui"
 )
 
-
 type ContactHandler struct {
-	scanner *bufio.Scanner
-	service *usecase.ContactService
+	scanner      *bufio.Scanner
+	service      *usecase.ContactService
+	groupService *usecase.GroupService
 }
 
-func NewContactHandler(service *usecase.ContactService) *ContactHandler{
+func NewContactHandler(service *usecase.ContactService, groupService *usecase.GroupService) *ContactHandler {
 	return &ContactHandler{
-		scanner: bufio.NewScanner(os.Stdin),
-		service: service,
+		scanner:      bufio.NewScanner(os.Stdin),
+		service:      service,
+		groupService: groupService,
 	}
 }
 
@@ -51,29 +55,53 @@ func (ch *ContactHandler) ShowMainMenu() {
 			ch.handleExportContacts()
 		case "8":
 			ch.handleImportContacts()
+		case "9":
+			ch.handleManageTags()
+		case "10":
+			ch.handleGroups()
+		case "11":
+			ch.handleTrash()
+		case "12":
+			ch.handleHistory()
+		case "13":
+			ch.handleUndo()
+		case "14":
+			ch.handleRedo()
 		case "0":
 			fmt.Println("Exiting application...")
 			return
 		default:
-			ui.SetRespond("Invalid input, please enter a number between 0-8", "error")
+			ui.SetRespond("Invalid input, please enter a number between 0-14", "error")
 		}
 	}
 }
 
 func (ch *ContactHandler) handleAddContact() {
 	ui.SetTitle(ui.Menus[0])
+	ui.PrintListHint()
 
 	name := ui.PromptRequiredInput(ch.scanner, "Name")
 	email := ui.PromptRequiredInput(ch.scanner, "Email")
 	phone := ui.PromptInput(ch.scanner, "Phone")
 
-	err := ch.service.AddContact(name, email, phone)
+	addresses := ch.promptAddresses()
+	tags := ui.PromptInput(ch.scanner, "Tags (comma separated)")
+
+	err := ch.service.CreateContact(domain.Contact{
+		Name:      name,
+		Emails:    domain.ParseEmails(email),
+		Phones:    domain.ParsePhones(phone),
+		Addresses: addresses,
+		Tags:      domain.ParseTags(tags),
+	})
 	if err != nil {
-		
+
 		switch {
 		case errors.Is(err, usecase.ErrNameRequired),
 			errors.Is(err, usecase.ErrEmailRequired),
 			errors.Is(err, usecase.ErrInvalidEmail),
+			errors.Is(err, usecase.ErrInvalidCountryCode),
+			errors.Is(err, usecase.ErrInvalidTag),
 			errors.Is(err, usecase.ErrEmailAlreadyExist):
 			ui.SetRespond(err.Error(), "error")
 		default:
@@ -102,6 +130,7 @@ func (ch *ContactHandler) handleAddMultipleContact() {
 
 	var newContacts []domain.Contact
 
+	ui.PrintListHint()
 	for i := 1; i <= count; i++ {
 		fmt.Printf("\n---- New Contact %d ----\n", i)
 		name := ui.PromptRequiredInput(ch.scanner, "Name")
@@ -109,9 +138,9 @@ func (ch *ContactHandler) handleAddMultipleContact() {
 		phone := ui.PromptRequiredInput(ch.scanner, "Phone")
 
 		newContacts = append(newContacts, domain.Contact{
-			Name: name,
-			Email: email,
-			Phone: phone,
+			Name:   name,
+			Emails: domain.ParseEmails(email),
+			Phones: domain.ParsePhones(phone),
 		})
 	}
 
@@ -124,6 +153,26 @@ func (ch *ContactHandler) handleAddMultipleContact() {
 	ui.SetRespond("Successfully added all contacts", "success")
 }
 
+// promptAddresses asks for postal addresses until the user has no more
+func (ch *ContactHandler) promptAddresses() []domain.Address {
+	var addresses []domain.Address
+	for {
+		more := ui.PromptInput(ch.scanner, "Add a postal address? (y/n)")
+		if strings.ToLower(more) != "y" {
+			return addresses
+		}
+
+		addresses = append(addresses, domain.Address{
+			Label:       ui.PromptInput(ch.scanner, "Label (e.g. home, work)"),
+			Street:      ui.PromptInput(ch.scanner, "Street"),
+			Locality:    ui.PromptInput(ch.scanner, "City"),
+			Region:      ui.PromptInput(ch.scanner, "Region / State"),
+			PostalCode:  ui.PromptInput(ch.scanner, "Postal Code"),
+			CountryCode: ui.PromptInput(ch.scanner, "Country Code (e.g. ID, US)"),
+		})
+	}
+}
+
 func (ch *ContactHandler) handleEditContact() {
 	ui.SetTitle(ui.Menus[2])
 	
@@ -134,49 +183,97 @@ func (ch *ContactHandler) handleEditContact() {
 		ui.SetRespond("Invalid ID format. Please enter a number", "error")
 		return
 	}
-	
-	// Get contact by ID
-	contact, err := ch.service.SearchByID(id)
-	if err != nil {
-		ui.SetRespond("Contact not found", "error")
+
+	for {
+		// Get contact by ID, again after a conflict to see the latest changes
+		contact, err := ch.service.SearchByID(id)
+		if err != nil {
+			ui.SetRespond("Contact not found", "error")
+			return
+		}
+
+		// Update contact
+		err = ch.service.UpdateContact(ch.promptContactChanges(contact))
+		if err == nil {
+			ui.SetRespond("Contact updated successfully", "success")
+			return
+		}
+
+		switch {
+		case errors.Is(err, usecase.ErrConflict):
+			ui.SetRespond(err.Error()+", your changes were not saved", "error")
+			retry := ui.PromptInput(ch.scanner, "Reload the contact and edit it again? (y/n)")
+			if strings.ToLower(retry) == "y" {
+				continue
+			}
+		case errors.Is(err, usecase.ErrNameRequired),
+			errors.Is(err, usecase.ErrEmailAlreadyExist),
+			errors.Is(err, usecase.ErrInvalidEmail),
+			errors.Is(err, usecase.ErrInvalidCountryCode),
+			errors.Is(err, usecase.ErrInvalidTag):
+			ui.SetRespond(err.Error(), "error")
+		default:
+			ui.SetRespond("Failed to update contact: "+err.Error(), "error")
+		}
 		return
 	}
-	
+}
+
+// promptContactChanges asks for the new values of every field of contact,
+// the result keeps the version of contact so a concurrent change is detected
+func (ch *ContactHandler) promptContactChanges(contact domain.Contact) domain.Contact {
 	fmt.Println("\n-- Editing Contact --")
+	if !contact.UpdatedAt.IsZero() {
+		fmt.Printf("(version %d, last updated %s)\n", contact.Version, contact.UpdatedAt.Local().Format(time.DateTime))
+	}
 	fmt.Println("(leave empty to keep current)")
-	
+	ui.PrintListHint()
+
 	fmt.Println("\nCurrent Email:", contact.Name)
 	name := ui.PromptInput(ch.scanner, "New Name")
 	if name == "" {
 		name = contact.Name
 	}
-	
-	fmt.Println("Current Email:", contact.Email)
+
+	currentEmails := domain.FormatEmails(contact.EmailList())
+	fmt.Println("Current Email:", currentEmails)
 	email := ui.PromptInput(ch.scanner, "New Email")
 	if email == "" {
-		email = contact.Email
+		email = currentEmails
 	}
-	
-	fmt.Println("Current Phone:", contact.Phone)
+
+	currentPhones := domain.FormatPhones(contact.PhoneList())
+	fmt.Println("Current Phone:", currentPhones)
 	phone := ui.PromptInput(ch.scanner, "New Phone")
 	if phone == "" {
-		phone = contact.Phone
+		phone = currentPhones
+	}
+
+	addresses := contact.Addresses
+	for _, addr := range addresses {
+		fmt.Println("Current Address:", addr.Format())
+	}
+	replace := ui.PromptInput(ch.scanner, "Replace postal addresses? (y/n)")
+	if strings.ToLower(replace) == "y" {
+		addresses = ch.promptAddresses()
+	}
+
+	currentTags := domain.FormatTags(contact.Tags)
+	fmt.Println("Current Tags:", currentTags)
+	tags := ui.PromptInput(ch.scanner, "New Tags")
+	if tags == "" {
+		tags = currentTags
+	}
+
+	return domain.Contact{
+		ID:        contact.ID,
+		Name:      name,
+		Emails:    domain.ParseEmails(email),
+		Phones:    domain.ParsePhones(phone),
+		Addresses: addresses,
+		Tags:      domain.ParseTags(tags),
+		Version:   contact.Version,
 	}
-	
-	// Update contact
-	err = ch.service.EditContact(id, name, email, phone)
-	if err != nil {
-		switch {
-		case errors.Is(err, usecase.ErrNameRequired),
-			errors.Is(err, usecase.ErrEmailAlreadyExist),
-			errors.Is(err, usecase.ErrInvalidEmail):
-			ui.SetRespond(err.Error(), "error")
-		default:
-			ui.SetRespond("Failed to update contact: "+err.Error(), "error")
-		}
-		return
-	} 
-	ui.SetRespond("Contact updated successfully", "success")
 }
 
 func (ch *ContactHandler) handleDeleteContact() {
@@ -219,7 +316,7 @@ func (ch *ContactHandler) handleDeleteContact() {
 		return
 	}
 
-	ui.SetRespond("Contact deleted successfully", "success")
+	ui.SetRespond("Contact moved to the trash, it can be restored from the Trash menu", "success")
 }
 
 func (ch *ContactHandler) handleListContacts() {
@@ -234,8 +331,74 @@ func (ch *ContactHandler) handleListContacts() {
 		}
 		return
 	}
-	
-	ui.PrintContacts(contacts...)
+
+	columns, err := ui.ParseColumns(ui.PromptInput(ch.scanner, "Columns ("+ui.ColumnNames()+", empty for "+ui.DefaultColumns+")"))
+	if err != nil {
+		ui.SetRespond(err.Error(), "error")
+		return
+	}
+
+	if err := ui.SortContacts(contacts, ui.PromptInput(ch.scanner, "Sort by (a column, -name sorts descending, empty for id)")); err != nil {
+		ui.SetRespond(err.Error(), "error")
+		return
+	}
+
+	pageSize := defaultPageSize
+	if sizeStr := ui.PromptInput(ch.scanner, fmt.Sprintf("Rows per page (empty for %d)", defaultPageSize)); sizeStr != "" {
+		pageSize, err = strconv.Atoi(sizeStr)
+		if err != nil || pageSize <= 0 {
+			ui.SetRespond("Invalid input, rows per page must be a positive number", "error")
+			return
+		}
+	}
+
+	ch.pageContacts(columns, contacts, pageSize)
+}
+
+// defaultPageSize is the number of rows the contact list shows at once
+const defaultPageSize = 20
+
+// pageContacts shows contacts pageSize rows at a time until the user quits
+func (ch *ContactHandler) pageContacts(columns []ui.Column, contacts []domain.Contact, pageSize int) {
+	pages := (len(contacts) + pageSize - 1) / pageSize
+	page := 1
+	for {
+		ui.ClearScreen()
+		ui.SetTitle(ui.Menus[4])
+
+		start := (page - 1) * pageSize
+		end := min(start+pageSize, len(contacts))
+		ui.PrintTable(columns, contacts[start:end], ui.TerminalWidth())
+		fmt.Printf("\nPage %d of %d, rows %d-%d of %d\n", page, pages, start+1, end, len(contacts))
+
+		if pages == 1 {
+			return
+		}
+
+		choice := strings.ToLower(ui.PromptInput(ch.scanner, "[n]ext, [p]revious, [j]ump to page, [q]uit"))
+		switch {
+		case choice == "" || choice == "n":
+			page = min(page+1, pages)
+		case choice == "p":
+			page = max(page-1, 1)
+		case choice == "q":
+			return
+		case strings.HasPrefix(choice, "j"):
+			// "j 5" and "j" followed by a prompt both jump
+			target := strings.TrimSpace(strings.TrimPrefix(choice, "j"))
+			if target == "" {
+				target = ui.PromptInput(ch.scanner, fmt.Sprintf("Page (1-%d)", pages))
+			}
+			if num, err := strconv.Atoi(target); err == nil && num >= 1 && num <= pages {
+				page = num
+			}
+		default:
+			// a page number on its own jumps as well
+			if num, err := strconv.Atoi(choice); err == nil && num >= 1 && num <= pages {
+				page = num
+			}
+		}
+	}
 }
 
 func (ch *ContactHandler) handleSearchContact() {
@@ -245,7 +408,9 @@ func (ch *ContactHandler) handleSearchContact() {
 	fmt.Println("1. ID")
 	fmt.Println("2. Name")
 	fmt.Println("3. Email")
-	
+	fmt.Println("4. City or Country")
+	fmt.Println("5. Tags (e.g. customer AND NOT churned)")
+
 	choice := ui.PromptRequiredInput(ch.scanner, "Select option: ")
 	
 	switch choice {
@@ -266,7 +431,7 @@ func (ch *ContactHandler) handleSearchContact() {
 			}
 			return
 		}
-		
+
 		ui.PrintContacts(contact)
 		
 	case "2":
@@ -280,7 +445,7 @@ func (ch *ContactHandler) handleSearchContact() {
 			}
 			return
 		}
-		
+
 		ui.PrintContacts(contacts...)
 		
 	case "3":
@@ -294,11 +459,42 @@ func (ch *ContactHandler) handleSearchContact() {
 			}
 			return
 		}
-		
+
 		ui.PrintContacts(contact)
-		
+
+	case "4":
+		location := ui.PromptRequiredInput(ch.scanner, "Enter City or Country: ")
+		contacts, err := ch.service.SearchByLocation(location)
+		if err != nil {
+			if errors.Is(err, usecase.ErrNoContacts) {
+				ui.SetRespond("Contacts in "+location+" is not found", "result")
+			} else {
+				ui.SetRespond("something went wrong: "+err.Error(), "error")
+			}
+			return
+		}
+
+		ui.PrintContacts(contacts...)
+
+	case "5":
+		expr := ui.PromptRequiredInput(ch.scanner, "Enter Tag Expression: ")
+		contacts, err := ch.service.SearchByTags(expr)
+		if err != nil {
+			switch {
+			case errors.Is(err, usecase.ErrNoContacts):
+				ui.SetRespond("Contacts matching "+expr+" is not found", "result")
+			case errors.Is(err, usecase.ErrInvalidTagExpression):
+				ui.SetRespond(err.Error(), "error")
+			default:
+				ui.SetRespond("something went wrong: "+err.Error(), "error")
+			}
+			return
+		}
+
+		ui.PrintContacts(contacts...)
+
 	default:
-		ui.SetRespond("Invalid option, please enter a number between 1-3 ", "error")
+		ui.SetRespond("Invalid option, please enter a number between 1-5 ", "error")
 	}
 }
 
@@ -308,16 +504,40 @@ func (ch *ContactHandler) handleExportContacts() {
 	fmt.Println("Export format:")
 	fmt.Println("1. JSON")
 	fmt.Println("2. CSV")
-	
+	fmt.Println("3. vCard (.vcf)")
+	fmt.Println("4. LDIF (.ldif)")
+
 	choice := ui.PromptRequiredInput(ch.scanner, "\nSelect option")
+	version, dnTemplate, dialect := "", "", ""
+	switch choice {
+	case "2":
+		dialect = ui.PromptInput(ch.scanner, "CSV dialect, native, google or outlook (default native)")
+	case "3":
+		version = ui.PromptInput(ch.scanner, "vCard version, 3.0 or 4.0 (default 3.0)")
+		if version == "" {
+			version = "3.0"
+		}
+	case "4":
+		dnTemplate = ui.PromptInput(ch.scanner, "DN template (default "+usecase.DefaultDNTemplate+")")
+	}
 	filename := ui.PromptRequiredInput(ch.scanner, "Enter filename (without extension)")
-	
+	tagFilter := ui.PromptInput(ch.scanner, "Tag filter, e.g. customer AND NOT churned (leave empty to export all)")
+
+	// Ctrl-C stops the export instead of the app
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	progress := showProgress("contacts")
+
 	var err error
 	switch choice {
 	case "1":
-		err = ch.service.ExportToJSON(filename + ".json")
+		err = ch.service.ExportToJSON(ctx, filename+".json", tagFilter, progress)
 	case "2":
-		err = ch.service.ExportToCSV(filename + ".csv")
+		err = ch.service.ExportToCSV(ctx, filename+".csv", dialect, tagFilter, progress)
+	case "3":
+		err = ch.service.ExportToVCard(ctx, filename+".vcf", version, tagFilter, progress)
+	case "4":
+		err = ch.service.ExportToLDIF(ctx, filename+".ldif", dnTemplate, tagFilter, progress)
 	default:
 		ui.SetRespond("Invalid option", "error")
 		return
@@ -327,6 +547,13 @@ func (ch *ContactHandler) handleExportContacts() {
 		switch {
 		case errors.Is(err, usecase.ErrInvalidExportFilename):
 			ui.SetRespond("Invalid filename, please avoid special characters.", "error")
+		case errors.Is(err, usecase.ErrInvalidTagExpression), errors.Is(err, usecase.ErrUnsupportedVCardVersion), errors.Is(err, usecase.ErrInvalidDNTemplate),
+			errors.Is(err, usecase.ErrUnsupportedCSVDialect):
+			ui.SetRespond(err.Error(), "error")
+		case errors.Is(err, usecase.ErrNoContacts):
+			ui.SetRespond("No contacts match the tag filter, nothing exported", "result")
+		case errors.Is(err, usecase.ErrCancelled):
+			ui.SetRespond("Export cancelled, "+filename+" was left as it was", "result")
 		default:
 			ui.SetRespond("Export failed: "+err.Error(), "error")
 		}
@@ -341,33 +568,192 @@ func (ch *ContactHandler) handleImportContacts() {
 	
 	fmt.Println("Import format:")
 	fmt.Println("1. JSON")
-	fmt.Println("2. CSV")
-	
+	fmt.Println("2. CSV (this app, Google Contacts, Outlook or a mapping profile)")
+	fmt.Println("3. vCard (.vcf)")
+	fmt.Println("4. LDIF (.ldif)")
+
 	choice := ui.PromptRequiredInput(ch.scanner, "\nSelect option")
 	filename := ui.PromptRequiredInput(ch.scanner, "Enter filename (with extension)")
-	
-	var contacts []domain.Contact
-	var err error
-	
+
+	var run importRun
 	switch choice {
 	case "1":
-		contacts, err = ch.service.ImportFromJSON(filename)
+		run = ch.service.ImportFromJSON
 	case "2":
-		contacts, err = ch.service.ImportFromCSV(filename)
+		run = ch.service.ImportFromCSV
+	case "3":
+		run = ch.service.ImportFromVCard
+	case "4":
+		run = ch.service.ImportFromLDIF
 	default:
 		ui.SetRespond("Invalid option", "error")
 		return
 	}
 
+	opts := usecase.ImportOptions{DryRun: true}
+	if choice == "2" {
+		var ok bool
+		if opts.Profile, ok = ch.promptCSVProfile(filename); !ok {
+			return
+		}
+	}
+	opts.Conflict = usecase.ImportConflict(ui.PromptInput(ch.scanner, "When the email exists: skip, overwrite, merge or duplicate (default overwrite)"))
+	opts.IDs = usecase.IDPolicy(ui.PromptInput(ch.scanner, "IDs of new contacts: reassign or preserve (default reassign)"))
+	opts.BatchSize = usecase.DefaultImportBatchSize
+	if size := ui.PromptInput(ch.scanner, fmt.Sprintf("Rows per batch, a large file is saved a batch at a time, 0 saves the whole file at once (default %d)", usecase.DefaultImportBatchSize)); size != "" {
+		var err error
+		if opts.BatchSize, err = strconv.Atoi(size); err != nil || opts.BatchSize < 0 {
+			ui.SetRespond("Invalid batch size, please enter 0 or more", "error")
+			return
+		}
+	}
+	ch.importWithPreview(run, filename, opts)
+}
+
+// importWithPreview checks every row of a file with run, shows what importing it does
+// and imports it once the user agrees, the rows with errors are left out. The rows are
+// shown as they are checked and not kept, Ctrl-C stops the preview or the import
+func (ch *ContactHandler) importWithPreview(run importRun, filename string, opts usecase.ImportOptions) {
+	fmt.Println()
+	printer := ui.NewImportRowPrinter(ui.OutputText)
+	opts.OnRow = func(r usecase.ImportRowReport) {
+		printer.Print(reportRow(r))
+	}
+	preview, err := runInterruptible(run, filename, opts)
+	if err != nil {
+		ch.respondImportError(err)
+		return
+	}
+	printer.Close()
+	fmt.Println("\n" + importSummary(preview))
+
+	creates, updates := preview.Count(usecase.ImportCreate), preview.Count(usecase.ImportUpdate)
+	if creates+updates == 0 {
+		ui.SetRespond("Nothing to import", "result")
+		return
+	}
+
+	confirm := ui.PromptInput(ch.scanner, fmt.Sprintf("\nImport %d new and update %d contacts? Rows with errors are left out (y/n)", creates, updates))
+	if strings.ToLower(confirm) != "y" {
+		ui.SetRespond("Import cancelled", "result")
+		return
+	}
+
+	opts.DryRun = false
+	// only the counts are shown
+	opts.OnRow = func(usecase.ImportRowReport) {}
+	opts.OnProgress = showProgress("rows")
+	report, err := runInterruptible(run, filename, opts)
+	if err != nil {
+		ch.respondImportError(err)
+		return
+	}
+	ui.SetRespond("Import done: "+importSummary(report), "success")
+}
+
+// runInterruptible runs an import that Ctrl-C stops, the interrupt is only caught
+// while it runs, so at the prompt after the preview Ctrl-C still closes the app
+func runInterruptible(run importRun, filename string, opts usecase.ImportOptions) (usecase.ImportReport, error) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return run(ctx, filename, opts)
+}
+
+func (ch *ContactHandler) respondImportError(err error) {
+	switch {
+	case errors.Is(err, usecase.ErrInvalidImportFilename):
+		ui.SetRespond("Invalid filename, must end with .json, .csv, .vcf or .ldif", "error")
+	case errors.Is(err, usecase.ErrUnrecognizedCSVHeader), errors.Is(err, usecase.ErrInvalidCSVProfile),
+		errors.Is(err, usecase.ErrUnsupportedConflictStrategy), errors.Is(err, usecase.ErrUnsupportedIDPolicy):
+		ui.SetRespond(err.Error(), "error")
+	case errors.Is(err, usecase.ErrCancelled):
+		ui.SetRespond(err.Error(), "result")
+	default:
+		ui.SetRespond("Import failed: "+err.Error(), "error")
+	}
+}
+
+func (ch *ContactHandler) handleManageTags() {
+	ui.SetTitle(ui.Menus[8])
+
+	fmt.Println("1. Add tags")
+	fmt.Println("2. Remove tags")
+
+	choice := ui.PromptRequiredInput(ch.scanner, "\nSelect option")
+	if choice != "1" && choice != "2" {
+		ui.SetRespond("Invalid option", "error")
+		return
+	}
+
+	ids, err := parseIDs(ui.PromptRequiredInput(ch.scanner, "Contact IDs (comma separated)"))
+	if err != nil {
+		ui.SetRespond("Invalid ID format, please enter numbers separated by commas", "error")
+		return
+	}
+
+	tags := domain.ParseTags(ui.PromptRequiredInput(ch.scanner, "Tags (comma separated)"))
+
+	if choice == "1" {
+		err = ch.service.AddTags(ids, tags)
+	} else {
+		err = ch.service.RemoveTags(ids, tags)
+	}
+
 	if err != nil {
 		switch {
-		case errors.Is(err, usecase.ErrInvalidImportFilename):
-			ui.SetRespond("Invalid filename, must end with .json or .csv", "error")
+		case errors.Is(err, usecase.ErrNoContacts),
+			errors.Is(err, usecase.ErrInvalidTag):
+			ui.SetRespond(err.Error(), "error")
 		default:
-			ui.SetRespond("Import failed: "+err.Error(), "error")
+			ui.SetRespond("Failed to update tags: "+err.Error(), "error")
 		}
 		return
 	}
-	
-	ui.SetRespond(fmt.Sprintf("Successfully imported %d contacts", len(contacts)), "success")
+
+	ui.SetRespond(fmt.Sprintf("Tags updated on %d contacts", len(ids)), "success")
+}
+
+func (ch *ContactHandler) handleUndo() {
+	ui.SetTitle(ui.Menus[12])
+
+	label, err := ch.service.Undo()
+	if err != nil {
+		if errors.Is(err, usecase.ErrNothingToUndo) {
+			ui.SetRespond("Nothing to undo", "result")
+		} else {
+			ui.SetRespond(err.Error(), "error")
+		}
+		return
+	}
+
+	ui.SetRespond("Undone: "+label, "success")
+}
+
+func (ch *ContactHandler) handleRedo() {
+	ui.SetTitle(ui.Menus[13])
+
+	label, err := ch.service.Redo()
+	if err != nil {
+		if errors.Is(err, usecase.ErrNothingToRedo) {
+			ui.SetRespond("Nothing to redo", "result")
+		} else {
+			ui.SetRespond(err.Error(), "error")
+		}
+		return
+	}
+
+	ui.SetRespond("Redone: "+label, "success")
+}
+
+// parseIDs reads a list of ids written as "1, 2, 3"
+func parseIDs(text string) ([]int, error) {
+	var ids []int
+	for _, part := range strings.Split(text, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
 }
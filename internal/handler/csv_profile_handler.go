@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+// addressFields are the profile fields that make up a postal address
+var addressFields = map[string]bool{"street": true, "city": true, "region": true, "postal_code": true, "country": true}
+
+// promptCSVProfile asks which saved mapping profile reads the file, an empty name detects
+// the columns from the header. A new name creates the profile from the columns of the file,
+// ok is false when the user cancels
+func (ch *ContactHandler) promptCSVProfile(filename string) (name string, ok bool) {
+	profiles, err := ch.service.CSVProfiles()
+	if err != nil {
+		ui.SetRespond("Failed to read the mapping profiles: "+err.Error(), "error")
+		return "", false
+	}
+	if len(profiles) > 0 {
+		names := make([]string, len(profiles))
+		for i, p := range profiles {
+			names[i] = p.Name
+		}
+		fmt.Println("Saved mapping profiles: " + strings.Join(names, ", "))
+	}
+
+	name = ui.PromptInput(ch.scanner, "Mapping profile (leave empty to detect the columns of this app, Google Contacts or Outlook)")
+	if name == "" {
+		return "", true
+	}
+
+	_, err = ch.service.GetCSVProfile(name)
+	if err == nil {
+		return name, true
+	}
+	if !errors.Is(err, usecase.ErrCSVProfileNotFound) {
+		ui.SetRespond("Failed to read the mapping profile: "+err.Error(), "error")
+		return "", false
+	}
+
+	create := ui.PromptInput(ch.scanner, "Profile "+name+" does not exist, create it from the columns of the file? (y/n)")
+	if strings.ToLower(create) != "y" {
+		ui.SetRespond("Import cancelled", "result")
+		return "", false
+	}
+	return name, ch.createCSVProfile(name, filename)
+}
+
+// createCSVProfile asks which columns of the file fill each field of a contact and saves the profile
+func (ch *ContactHandler) createCSVProfile(name, filename string) bool {
+	header, err := ch.service.CSVHeader(filename)
+	if err != nil {
+		ui.SetRespond("Failed to read the file: "+err.Error(), "error")
+		return false
+	}
+
+	fmt.Println("\nColumns of " + filename + ":")
+	for i, column := range header {
+		fmt.Printf("%d. %s\n", i+1, column)
+	}
+	fmt.Println("\nEnter header names or column numbers, several columns are joined into one value.")
+	fmt.Println("Transforms: " + strings.Join(usecase.CSVTransforms(), ", "))
+
+	profile := usecase.CSVProfile{Name: name}
+	hasAddress := false
+	for _, field := range usecase.CSVProfileFields {
+		columns := splitInput(ui.PromptInput(ch.scanner, "\nColumns for "+field+" (comma separated, leave empty to skip)"))
+		if len(columns) == 0 {
+			continue
+		}
+
+		f := usecase.CSVField{Field: field, Columns: columns}
+		if len(columns) > 1 {
+			f.Separator = ui.PromptInput(ch.scanner, "Join them with (default a space)")
+		}
+		f.Transforms = splitInput(ui.PromptInput(ch.scanner, "Transforms for "+field+" (comma separated, optional)"))
+		if field == "email" || field == "phone" {
+			f.Label = ui.PromptInput(ch.scanner, "Label (e.g. home, work, optional)")
+		}
+		hasAddress = hasAddress || addressFields[field]
+		profile.Fields = append(profile.Fields, f)
+	}
+
+	if hasAddress {
+		label := ui.PromptInput(ch.scanner, "\nAddress label (e.g. home, work, optional)")
+		for i := range profile.Fields {
+			if addressFields[profile.Fields[i].Field] {
+				profile.Fields[i].Label = label
+			}
+		}
+	}
+
+	if err := ch.service.SaveCSVProfile(profile); err != nil {
+		ui.SetRespond(err.Error(), "error")
+		return false
+	}
+	fmt.Println("Saved mapping profile " + name)
+	return true
+}
+
+// splitInput splits a comma separated answer, empty items are left out
+func splitInput(text string) []string {
+	var items []string
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+)
+
+// sentinelErrors gives every sentinel error of the service a stable name, the exit code
+// of the commands and the status of the HTTP API. The first match wins.
+// keep the names and codes stable, scripts and clients depend on them, and add new ones at the end
+var sentinelErrors = []sentinelError{
+	{usecase.ErrNoContacts, "no_contacts", 3, http.StatusNotFound},
+	{usecase.ErrEmailAlreadyExist, "email_already_exists", 4, http.StatusConflict},
+	{usecase.ErrNameRequired, "name_required", 5, http.StatusUnprocessableEntity},
+	{usecase.ErrEmailRequired, "email_required", 6, http.StatusUnprocessableEntity},
+	{usecase.ErrInvalidEmail, "invalid_email", 7, http.StatusUnprocessableEntity},
+	{usecase.ErrInvalidCountryCode, "invalid_country_code", 8, http.StatusUnprocessableEntity},
+	{usecase.ErrInvalidTag, "invalid_tag", 9, http.StatusUnprocessableEntity},
+	{usecase.ErrInvalidTagExpression, "invalid_tag_expression", 10, http.StatusBadRequest},
+	{usecase.ErrConflict, "conflict", 11, http.StatusConflict},
+	{usecase.ErrInvalidImportFilename, "invalid_import_filename", 12, http.StatusBadRequest},
+	{usecase.ErrInvalidExportFilename, "invalid_export_filename", 13, http.StatusBadRequest},
+	{usecase.ErrUnsupportedVCardVersion, "unsupported_vcard_version", 14, http.StatusBadRequest},
+	{usecase.ErrInvalidDNTemplate, "invalid_dn_template", 15, http.StatusBadRequest},
+	{usecase.ErrUnsupportedCSVDialect, "unsupported_csv_dialect", 16, http.StatusBadRequest},
+	{usecase.ErrUnrecognizedCSVHeader, "unrecognized_csv_header", 17, http.StatusBadRequest},
+	{usecase.ErrInvalidCSVProfile, "invalid_csv_profile", 18, http.StatusUnprocessableEntity},
+	{usecase.ErrCSVProfileNotFound, "csv_profile_not_found", 19, http.StatusNotFound},
+	{usecase.ErrUnsupportedConflictStrategy, "unsupported_conflict_strategy", 20, http.StatusBadRequest},
+	{usecase.ErrUnsupportedIDPolicy, "unsupported_id_policy", 21, http.StatusBadRequest},
+	{usecase.ErrIDAlreadyExist, "id_already_exists", 22, http.StatusConflict},
+	{usecase.ErrCancelled, "cancelled", 23, http.StatusServiceUnavailable},
+}
+
+type sentinelError struct {
+	err    error
+	name   string
+	exit   int
+	status int
+}
+
+// findSentinel finds the sentinel error wrapped in err
+func findSentinel(err error) (sentinelError, bool) {
+	for _, se := range sentinelErrors {
+		if errors.Is(err, se.err) {
+			return se, true
+		}
+	}
+	return sentinelError{}, false
+}
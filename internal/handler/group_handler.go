@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+func (ch *ContactHandler) handleGroups() {
+	ui.SetTitle(ui.Menus[9])
+
+	fmt.Println("1. List groups")
+	fmt.Println("2. Create group")
+	fmt.Println("3. Delete group")
+	fmt.Println("4. Add contacts to group")
+	fmt.Println("5. Remove contacts from group")
+	fmt.Println("6. Nest group")
+	fmt.Println("7. Unnest group")
+	fmt.Println("8. Expand recipients")
+
+	choice := ui.PromptRequiredInput(ch.scanner, "\nSelect option")
+
+	switch choice {
+	case "1":
+		groups, err := ch.groupService.GetAllGroups()
+		if err != nil {
+			respondGroupError(err)
+			return
+		}
+		ui.PrintGroups(groups...)
+
+	case "2":
+		name := ui.PromptRequiredInput(ch.scanner, "Group name")
+		if err := ch.groupService.CreateGroup(name); err != nil {
+			respondGroupError(err)
+			return
+		}
+		ui.SetRespond("Group created successfully", "success")
+
+	case "3":
+		id, ok := ch.promptGroupID("Group ID to delete")
+		if !ok {
+			return
+		}
+		confirm := ui.PromptRequiredInput(ch.scanner, "Are you sure you want to delete this group? The contacts are kept (y/n)")
+		if confirm != "y" && confirm != "Y" {
+			ui.SetRespond("Deletion cancelled", "result")
+			return
+		}
+		if err := ch.groupService.DeleteGroup(id); err != nil {
+			respondGroupError(err)
+			return
+		}
+		ui.SetRespond("Group deleted successfully", "success")
+
+	case "4", "5":
+		id, ok := ch.promptGroupID("Group ID")
+		if !ok {
+			return
+		}
+		contactIDs, err := parseIDs(ui.PromptRequiredInput(ch.scanner, "Contact IDs (comma separated)"))
+		if err != nil {
+			ui.SetRespond("Invalid ID format, please enter numbers separated by commas", "error")
+			return
+		}
+		if choice == "4" {
+			err = ch.groupService.AddMembers(id, contactIDs)
+		} else {
+			err = ch.groupService.RemoveMembers(id, contactIDs)
+		}
+		if err != nil {
+			respondGroupError(err)
+			return
+		}
+		ui.SetRespond("Group members updated", "success")
+
+	case "6", "7":
+		id, ok := ch.promptGroupID("Group ID")
+		if !ok {
+			return
+		}
+		subID, ok := ch.promptGroupID("Nested group ID")
+		if !ok {
+			return
+		}
+		var err error
+		if choice == "6" {
+			err = ch.groupService.AddSubgroup(id, subID)
+		} else {
+			err = ch.groupService.RemoveSubgroup(id, subID)
+		}
+		if err != nil {
+			respondGroupError(err)
+			return
+		}
+		ui.SetRespond("Nested groups updated", "success")
+
+	case "8":
+		id, ok := ch.promptGroupID("Group ID")
+		if !ok {
+			return
+		}
+		recipients, err := ch.groupService.ExpandGroup(id)
+		if err != nil {
+			respondGroupError(err)
+			return
+		}
+		if len(recipients) == 0 {
+			ui.SetRespond("The group has no recipients", "result")
+			return
+		}
+		fmt.Println("\n-- Recipients --")
+		for _, rcpt := range recipients {
+			fmt.Println(rcpt)
+		}
+
+	default:
+		ui.SetRespond("Invalid option", "error")
+	}
+}
+
+func (ch *ContactHandler) promptGroupID(label string) (int, bool) {
+	id, err := strconv.Atoi(ui.PromptRequiredInput(ch.scanner, label))
+	if err != nil {
+		ui.SetRespond("Invalid ID format, please enter a number", "error")
+		return 0, false
+	}
+	return id, true
+}
+
+func respondGroupError(err error) {
+	switch {
+	case errors.Is(err, usecase.ErrNoGroups):
+		ui.SetRespond(err.Error(), "result")
+	case errors.Is(err, usecase.ErrNoContacts),
+		errors.Is(err, usecase.ErrGroupNameRequired),
+		errors.Is(err, usecase.ErrGroupNameExist),
+		errors.Is(err, usecase.ErrGroupCycle):
+		ui.SetRespond(err.Error(), "error")
+	default:
+		ui.SetRespond("something went wrong: "+err.Error(), "error")
+	}
+}
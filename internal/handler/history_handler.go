@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+func (ch *ContactHandler) handleHistory() {
+	ui.SetTitle(ui.Menus[11])
+
+	id, err := strconv.Atoi(ui.PromptRequiredInput(ch.scanner, "Contact ID"))
+	if err != nil {
+		ui.SetRespond("Invalid ID format, please enter a number", "error")
+		return
+	}
+
+	entries, err := ch.service.GetHistory(id)
+	if err != nil {
+		if errors.Is(err, usecase.ErrNoHistory) {
+			ui.SetRespond("No history for this contact", "result")
+		} else {
+			ui.SetRespond("something went wrong: "+err.Error(), "error")
+		}
+		return
+	}
+
+	ui.PrintHistory(entries...)
+
+	revisionStr := ui.PromptInput(ch.scanner, "\nRevert to revision (leave empty to go back)")
+	if revisionStr == "" {
+		return
+	}
+	revision, err := strconv.Atoi(revisionStr)
+	if err != nil {
+		ui.SetRespond("Invalid revision, please enter a number", "error")
+		return
+	}
+
+	if err := ch.service.RevertContact(id, revision); err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrRevisionNotFound),
+			errors.Is(err, usecase.ErrRevertNeedsActive),
+			errors.Is(err, usecase.ErrNoContacts),
+			errors.Is(err, usecase.ErrEmailAlreadyExist),
+			errors.Is(err, usecase.ErrConflict):
+			ui.SetRespond(err.Error(), "error")
+		default:
+			ui.SetRespond("Failed to revert contact: "+err.Error(), "error")
+		}
+		return
+	}
+
+	ui.SetRespond(fmt.Sprintf("Contact %d reverted to revision %d", id, revision), "success")
+}
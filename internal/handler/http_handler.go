@@ -0,0 +1,787 @@
+package handler
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+	// maxBodySize limits a contact in a request body
+	maxBodySize = 1 << 20
+	// maxUploadSize limits an uploaded import file
+	maxUploadSize = 32 << 20
+	// ldifContentType has no registered type, text/x-ldif is the common one
+	ldifContentType = "text/x-ldif; charset=utf-8"
+)
+
+// HTTPHandler serves the REST API and the CardDAV address book over the ContactService.
+// The service keeps the session state of the menu, e.g. undo, so the requests are handled one at a time
+type HTTPHandler struct {
+	mu      sync.Mutex
+	service *usecase.ContactService
+	// actor is recorded in the audit log for requests without an X-Actor header
+	actor string
+	mux   *http.ServeMux
+	spec  *openAPIDoc
+}
+
+// NewHTTPHandler registers the routes and generates their OpenAPI document,
+// openapi_test.go fails when a route is not described in it
+func NewHTTPHandler(service *usecase.ContactService) *HTTPHandler {
+	h := &HTTPHandler{
+		service: service,
+		actor:   service.Actor(),
+		mux:     http.NewServeMux(),
+	}
+
+	g := &schemaGenerator{components: map[string]*schema{}}
+	routes := h.routes(g)
+	h.spec = openAPI(routes, g)
+
+	for _, rt := range routes {
+		h.mux.HandleFunc(rt.method+" "+rt.pattern, rt.handle)
+	}
+
+	// address book apps sync over CardDAV, it is not part of the REST API or its document
+	carddav := NewCardDAVHandler(service)
+	h.mux.Handle(davRootPath, carddav)
+	h.mux.Handle("/.well-known/carddav", carddav)
+	return h
+}
+
+// route is one endpoint of the API, op is its description in the OpenAPI document
+type route struct {
+	method  string
+	pattern string
+	handle  http.HandlerFunc
+	op      operation
+}
+
+// routes lists every endpoint of the API, a new one needs its operation here as well
+func (h *HTTPHandler) routes(g *schemaGenerator) []route {
+	contactBody := &requestBody{Required: true, Content: g.jsonBody(contactInput{})}
+	contactResponse := response{Description: "the contact", Headers: etagHeader, Content: g.jsonBody(domain.Contact{})}
+	badRequest := g.errorResponse("the request can not be read")
+	notFound := g.errorResponse("no contact has this ID, or it is in the trash")
+	conflict := g.errorResponse("an email belongs to another contact, or the contact was changed since it was read")
+	invalid := g.errorResponse("the contact is not valid, e.g. it has no name or an invalid email")
+
+	return []route{
+		{method: "GET", pattern: "/contacts", handle: h.listContacts, op: operation{
+			OperationID: "listContacts",
+			Summary:     "List or search contacts",
+			Description: "Lists a page of the contacts. At most one of id, name, email, location and tags searches them, a search without results is an empty page.",
+			Parameters: []parameter{
+				queryParam("id", "the contact with this ID", &schema{Type: "integer"}),
+				queryParam("name", "contacts with exactly this whole name", &schema{Type: "string"}),
+				queryParam("email", "the contact with this email", &schema{Type: "string"}),
+				queryParam("location", "contacts with an address in this city or country", &schema{Type: "string"}),
+				queryParam("tags", "contacts matching a tag expression, e.g. customer AND NOT churned", &schema{Type: "string"}),
+				queryParam("sort", "column to sort by, e.g. name, -name sorts descending", &schema{Type: "string", Enum: sortKeys()}),
+				queryParam("page", "page to return, starting at 1", intSchema(1, 0)),
+				queryParam("per_page", fmt.Sprintf("contacts per page, default %d", defaultPerPage), intSchema(1, maxPerPage)),
+			},
+			Responses: map[string]response{
+				"200": {Description: "a page of contacts with the links to the other pages", Content: g.jsonBody(contactPage{})},
+				"400": badRequest,
+			},
+		}},
+		{method: "POST", pattern: "/contacts", handle: h.createContact, op: operation{
+			OperationID: "createContact",
+			Summary:     "Add a contact",
+			RequestBody: contactBody,
+			Responses: map[string]response{
+				"201": {
+					Description: "the added contact, Location is its URL",
+					Headers: map[string]header{
+						"Location": {Schema: &schema{Type: "string"}},
+						"ETag":     etagHeader["ETag"],
+					},
+					Content: g.jsonBody(domain.Contact{}),
+				},
+				"400": badRequest,
+				"409": conflict,
+				"422": invalid,
+			},
+		}},
+		{method: "GET", pattern: "/contacts/export", handle: h.exportContacts, op: operation{
+			OperationID: "exportContacts",
+			Summary:     "Download the contacts",
+			Parameters: []parameter{
+				queryParam("format", "format of the file, default json", &schema{Type: "string", Enum: []string{"json", "csv", "vcf", "ldif"}}),
+				queryParam("dialect", "columns of a csv file, default native", &schema{Type: "string", Enum: []string{"native", "google", "outlook"}}),
+				queryParam("version", "vCard version of a vcf file, default 3.0", &schema{Type: "string", Enum: []string{"3.0", "4.0"}}),
+				queryParam("dn_template", "dn of a contact in an ldif file, default "+usecase.DefaultDNTemplate, &schema{Type: "string"}),
+				queryParam("tags", "only the contacts matching this tag expression", &schema{Type: "string"}),
+			},
+			Responses: map[string]response{
+				"200": {Description: "the file of the JSON, CSV, vCard or LDIF export", Content: map[string]mediaType{
+					"application/json": g.jsonBody([]domain.Contact{})["application/json"],
+					"text/csv":         contactsAsCSV,
+					"text/vcard":       contactsAsVCard,
+					"text/x-ldif":      contactsAsLDIF,
+				}},
+				"400": badRequest,
+				"404": g.errorResponse("no contacts match the tag expression"),
+			},
+		}},
+		{method: "POST", pattern: "/contacts/import", handle: h.importContacts, op: operation{
+			OperationID: "importContacts",
+			Summary:     "Import a file of contacts",
+			Description: "Imports a file in the format of the JSON export, a CSV of this app, Google Contacts or Outlook, or a vCard or LDIF file, the format comes from the format parameter or the Content-Type. Every row, a contact of a JSON file, a line of a CSV file, a vCard or an LDIF entry, is validated like a new contact, Rows tells which ones were created, updated, skipped or left out with an error.",
+			Parameters: []parameter{
+				queryParam("format", "format of the file when the Content-Type does not tell", &schema{Type: "string", Enum: []string{"json", "csv", "vcf", "ldif"}}),
+				queryParam("profile", "saved mapping profile that reads the columns of a csv file, by default they are detected from the header", &schema{Type: "string"}),
+				queryParam("dry_run", "check every row and report its outcome without saving anything", &schema{Type: "boolean"}),
+				queryParam("on_conflict", "what a row does when its email exists, overwrite by default", &schema{Type: "string", Enum: []string{"skip", "overwrite", "merge", "duplicate"}}),
+				queryParam("ids", "id of a new contact, reassign by default or preserve the id of the row", &schema{Type: "string", Enum: []string{"reassign", "preserve"}}),
+				queryParam("batch_size", fmt.Sprintf("save the file every n rows, a batch that fails keeps the batches before it, 0 saves the whole file at once, default %d", usecase.DefaultImportBatchSize), &schema{Type: "integer"}),
+			},
+			RequestBody: &requestBody{Required: true, Content: map[string]mediaType{
+				"application/json": g.jsonBody([]domain.Contact{})["application/json"],
+				"text/csv":         contactsAsCSV,
+				"text/vcard":       contactsAsVCard,
+				"text/x-ldif":      contactsAsLDIF,
+			}},
+			Responses: map[string]response{
+				"200": {Description: "the contacts created or updated, or that would be on a dry run", Content: g.jsonBody(importResult{})},
+				"400": badRequest,
+				"404": g.errorResponse("the mapping profile does not exist"),
+				"409": g.errorResponse("the store refused the import, e.g. an id is used already, nothing was saved"),
+				"422": g.errorResponse("the mapping profile does not fit the file"),
+			},
+		}},
+		{method: "GET", pattern: "/contacts/{id}", handle: h.getContact, op: operation{
+			OperationID: "getContact",
+			Summary:     "Get a contact",
+			Parameters:  []parameter{contactIDParam},
+			Responses: map[string]response{
+				"200": contactResponse,
+				"400": badRequest,
+				"404": notFound,
+			},
+		}},
+		{method: "PUT", pattern: "/contacts/{id}", handle: h.replaceContact, op: operation{
+			OperationID: "replaceContact",
+			Summary:     "Replace a contact",
+			Description: "Replaces every field of the contact, a field left out is cleared.",
+			Parameters:  []parameter{contactIDParam, ifMatchParam},
+			RequestBody: contactBody,
+			Responses: map[string]response{
+				"200": contactResponse,
+				"400": badRequest,
+				"404": notFound,
+				"409": conflict,
+				"422": invalid,
+			},
+		}},
+		{method: "PATCH", pattern: "/contacts/{id}", handle: h.patchContact, op: operation{
+			OperationID: "patchContact",
+			Summary:     "Change fields of a contact",
+			Description: "Changes only the fields in the body.",
+			Parameters:  []parameter{contactIDParam, ifMatchParam},
+			RequestBody: contactBody,
+			Responses: map[string]response{
+				"200": contactResponse,
+				"400": badRequest,
+				"404": notFound,
+				"409": conflict,
+				"422": invalid,
+			},
+		}},
+		{method: "DELETE", pattern: "/contacts/{id}", handle: h.deleteContact, op: operation{
+			OperationID: "deleteContact",
+			Summary:     "Move a contact to the trash",
+			Parameters:  []parameter{contactIDParam},
+			Responses: map[string]response{
+				"204": {Description: "the contact is in the trash"},
+				"400": badRequest,
+				"404": notFound,
+			},
+		}},
+		{method: "GET", pattern: "/openapi.json", handle: h.getOpenAPI, op: operation{
+			OperationID: "getOpenAPI",
+			Summary:     "This OpenAPI document",
+			Responses: map[string]response{
+				"200": {Description: "the OpenAPI 3.1 document of the API", Content: map[string]mediaType{"application/json": {Schema: &schema{Type: "object"}}}},
+			},
+		}},
+		{method: "GET", pattern: "/docs", handle: h.getDocs, op: operation{
+			OperationID: "getDocs",
+			Summary:     "Interactive documentation of the API",
+			Responses: map[string]response{
+				"200": {Description: "a page that lists the endpoints and can send requests to them", Content: map[string]mediaType{"text/html": {Schema: &schema{Type: "string"}}}},
+			},
+		}},
+	}
+}
+
+// sortKeys are the values of the sort parameter
+func sortKeys() []string {
+	var keys []string
+	for _, col := range ui.ContactColumns {
+		keys = append(keys, col.Name, "-"+col.Name)
+	}
+	return keys
+}
+
+func (h *HTTPHandler) getOpenAPI(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.spec)
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+func (h *HTTPHandler) getDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	actor := strings.TrimSpace(r.Header.Get("X-Actor"))
+	if actor == "" {
+		actor = h.actor
+	}
+	h.service.SetActor(actor)
+
+	h.mux.ServeHTTP(w, r)
+}
+
+// contactInput is the body of POST, PUT and PATCH. A field left out of a PATCH keeps
+// its value, of a PUT or POST it is cleared. Email and Phone take a list in the format
+// of the menu, e.g. "work:bob@mail.com, home:bob@home.com", instead of Emails and Phones
+type contactInput struct {
+	Name      *string
+	Email     *string
+	Phone     *string
+	Emails    *[]domain.Email
+	Phones    *[]domain.Phone
+	Addresses *[]domain.Address
+	Tags      *[]string
+	// Version is the version the contact was read at, the If-Match header can carry it as well
+	Version *int
+}
+
+// apply sets the given fields on ctc
+func (in contactInput) apply(ctc *domain.Contact) {
+	if in.Name != nil {
+		ctc.Name = *in.Name
+	}
+	if in.Emails != nil {
+		ctc.Emails = *in.Emails
+	} else if in.Email != nil {
+		ctc.Emails = domain.ParseEmails(*in.Email)
+	}
+	if in.Phones != nil {
+		ctc.Phones = *in.Phones
+	} else if in.Phone != nil {
+		ctc.Phones = domain.ParsePhones(*in.Phone)
+	}
+	if in.Addresses != nil {
+		ctc.Addresses = *in.Addresses
+	}
+	if in.Tags != nil {
+		ctc.Tags = domain.NormalizeTags(*in.Tags)
+	}
+	// the flat fields follow the lists, Normalize fills them in again
+	ctc.Email, ctc.Phone = "", ""
+}
+
+// contactPage is the response of GET /contacts
+type contactPage struct {
+	Data    []domain.Contact
+	Page    int
+	PerPage int
+	Total   int
+	Links   pageLinks
+}
+
+type pageLinks struct {
+	Self  string
+	First string
+	Prev  string `json:",omitempty"`
+	Next  string `json:",omitempty"`
+	Last  string
+}
+
+// listContacts lists every contact, or searches them with one of the query parameters
+// id, name, email, location or tags. sort, page and per_page choose the page
+func (h *HTTPHandler) listContacts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := positiveParam(query, "page", 1)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	perPage, err := positiveParam(query, "per_page", defaultPerPage)
+	if err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+	perPage = min(perPage, maxPerPage)
+
+	contacts, err := h.searchContacts(query)
+	if errors.Is(err, usecase.ErrNoContacts) {
+		// an empty list is a page without data, not a missing resource
+		contacts, err = nil, nil
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := ui.SortContacts(contacts, query.Get("sort")); err != nil {
+		writeBadRequest(w, err.Error())
+		return
+	}
+
+	lastPage := max((len(contacts)+perPage-1)/perPage, 1)
+	start := min((page-1)*perPage, len(contacts))
+	end := min(start+perPage, len(contacts))
+
+	result := contactPage{
+		Data:    contacts[start:end],
+		Page:    page,
+		PerPage: perPage,
+		Total:   len(contacts),
+		Links: pageLinks{
+			Self:  pageURL(r.URL, page, perPage),
+			First: pageURL(r.URL, 1, perPage),
+			Last:  pageURL(r.URL, lastPage, perPage),
+		},
+	}
+	if result.Data == nil {
+		result.Data = []domain.Contact{}
+	}
+	if page > 1 {
+		result.Links.Prev = pageURL(r.URL, min(page-1, lastPage), perPage)
+	}
+	if page < lastPage {
+		result.Links.Next = pageURL(r.URL, page+1, perPage)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// searchParams are the query parameters of GET /contacts that search, at most one is used
+var searchParams = []string{"id", "name", "email", "location", "tags"}
+
+func (h *HTTPHandler) searchContacts(query url.Values) ([]domain.Contact, error) {
+	var param string
+	for _, name := range searchParams {
+		if query.Has(name) {
+			if param != "" {
+				return nil, fmt.Errorf("%w: search by only one of id, name, email, location or tags", errBadRequest)
+			}
+			param = name
+		}
+	}
+
+	value := query.Get(param)
+	switch param {
+	case "id":
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: id must be a number", errBadRequest)
+		}
+		ctc, err := h.service.SearchByID(id)
+		return []domain.Contact{ctc}, err
+	case "name":
+		return h.service.SearchByName(value)
+	case "email":
+		ctc, err := h.service.SearchByEmail(value)
+		return []domain.Contact{ctc}, err
+	case "location":
+		return h.service.SearchByLocation(value)
+	case "tags":
+		return h.service.SearchByTags(value)
+	default:
+		return h.service.GetAllContacts()
+	}
+}
+
+func (h *HTTPHandler) createContact(w http.ResponseWriter, r *http.Request) {
+	var in contactInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	var ctc domain.Contact
+	in.apply(&ctc)
+	if err := h.service.CreateContact(ctc); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the service saved a normalized copy, the primary email finds it
+	ctc.Normalize()
+	created, err := h.service.SearchByEmail(ctc.EmailList()[0].Address)
+	if err != nil {
+		writeError(w, fmt.Errorf("contact added but could not be read back: %w", err))
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/contacts/%d", created.ID))
+	writeContact(w, http.StatusCreated, created)
+}
+
+func (h *HTTPHandler) getContact(w http.ResponseWriter, r *http.Request) {
+	ctc, ok := h.findContact(w, r)
+	if !ok {
+		return
+	}
+	writeContact(w, http.StatusOK, ctc)
+}
+
+func (h *HTTPHandler) replaceContact(w http.ResponseWriter, r *http.Request) {
+	h.updateContact(w, r, func(in contactInput, current domain.Contact) domain.Contact {
+		replaced := domain.Contact{ID: current.ID, Version: current.Version}
+		in.apply(&replaced)
+		return replaced
+	})
+}
+
+func (h *HTTPHandler) patchContact(w http.ResponseWriter, r *http.Request) {
+	h.updateContact(w, r, func(in contactInput, current domain.Contact) domain.Contact {
+		in.apply(&current)
+		return current
+	})
+}
+
+// updateContact saves the contact built by change from the request body and the stored contact.
+// the version to check comes from the body or the If-Match header, without one the update always wins
+func (h *HTTPHandler) updateContact(w http.ResponseWriter, r *http.Request, change func(in contactInput, current domain.Contact) domain.Contact) {
+	current, ok := h.findContact(w, r)
+	if !ok {
+		return
+	}
+
+	var in contactInput
+	if !readJSON(w, r, &in) {
+		return
+	}
+
+	updated := change(in, current)
+	if in.Version != nil {
+		updated.Version = *in.Version
+	} else if match := r.Header.Get("If-Match"); match != "" {
+		version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(match, "W/"), `"`))
+		if err != nil {
+			writeBadRequest(w, "If-Match must be the ETag of the contact")
+			return
+		}
+		updated.Version = version
+	}
+
+	if err := h.service.UpdateContact(updated); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	saved, err := h.service.SearchByID(current.ID)
+	if err != nil {
+		writeError(w, fmt.Errorf("contact updated but could not be read back: %w", err))
+		return
+	}
+	writeContact(w, http.StatusOK, saved)
+}
+
+// deleteContact moves the contact to the trash
+func (h *HTTPHandler) deleteContact(w http.ResponseWriter, r *http.Request) {
+	ctc, ok := h.findContact(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteContact(ctc.ID); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// importContacts imports the uploaded file, the format is the format query parameter
+// or the Content-Type, text/csv, application/json, text/vcard or text/x-ldif
+func (h *HTTPHandler) importContacts(w http.ResponseWriter, r *http.Request) {
+	format, ok := fileFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"))
+	if !ok {
+		writeBadRequest(w, "upload a text/csv, application/json, text/vcard or text/x-ldif file, or set format to csv, json, vcf or ldif")
+		return
+	}
+
+	// the service imports from the data folder, the upload is kept there while it is imported
+	if err := os.MkdirAll("data", os.ModePerm); err != nil {
+		writeError(w, fmt.Errorf("failed to create data folder: %w", err))
+		return
+	}
+	file, err := os.CreateTemp("data", "upload-*."+format)
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to store the upload: %w", err))
+		return
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeBadRequest(w, "failed to read the upload: "+err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	opts := usecase.ImportOptions{
+		Profile:   query.Get("profile"),
+		Conflict:  usecase.ImportConflict(query.Get("on_conflict")),
+		IDs:       usecase.IDPolicy(query.Get("ids")),
+		BatchSize: usecase.DefaultImportBatchSize,
+	}
+	if v := query.Get("dry_run"); v != "" {
+		if opts.DryRun, err = strconv.ParseBool(v); err != nil {
+			writeBadRequest(w, "dry_run must be true or false")
+			return
+		}
+	}
+	if v := query.Get("batch_size"); v != "" {
+		if opts.BatchSize, err = strconv.Atoi(v); err != nil || opts.BatchSize < 0 {
+			writeBadRequest(w, "batch_size must be 0 or more")
+			return
+		}
+	}
+
+	// a .csv file is read where it is, the other formats from the data folder
+	name := filepath.Base(file.Name())
+	if format == "csv" {
+		name = file.Name()
+	}
+	report, err := importRunFor(h.service, name)(r.Context(), name, opts)
+	result := importResult{
+		DryRun:   report.DryRun,
+		Data:     report.Contacts(),
+		Rows:     make([]importRow, len(report.Rows)),
+		Unmapped: report.Unmapped,
+	}
+	for i, row := range reportRows(report) {
+		result.Rows[i] = importRow(row)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if result.Data == nil {
+		result.Data = []domain.Contact{}
+	}
+	result.Count = len(result.Data)
+	writeJSON(w, http.StatusOK, result)
+}
+
+// importResult is the response of POST /contacts/import, on a dry run Data and
+// Count are the contacts that would be created or updated and nothing is saved
+type importResult struct {
+	Count  int
+	DryRun bool `json:",omitempty"`
+	Data   []domain.Contact
+	// Rows is the outcome of every row of the file
+	Rows []importRow `json:",omitempty"`
+	// Unmapped are the columns of a CSV file that hold values but were not imported
+	Unmapped []string `json:",omitempty"`
+}
+
+// importRow is the outcome of one row, Outcome is create, update, skip or error
+type importRow struct {
+	Line    int
+	Outcome string
+	ID      int `json:",omitempty"`
+	Row     string
+	Error   string `json:",omitempty"`
+}
+
+// exportContacts downloads the contacts as a file, format is csv, json (the default), vcf
+// or ldif and tags an optional tag expression
+func (h *HTTPHandler) exportContacts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" && format != "vcf" && format != "ldif" {
+		writeBadRequest(w, "format must be csv, json, vcf or ldif")
+		return
+	}
+
+	// the service exports into the data folder, the file is removed once it is sent
+	if err := os.MkdirAll("data", os.ModePerm); err != nil {
+		writeError(w, fmt.Errorf("failed to create data folder: %w", err))
+		return
+	}
+	file, err := os.CreateTemp("data", "download-*."+format)
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to create the export: %w", err))
+		return
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+
+	switch format {
+	case "json":
+		err = h.service.ExportToJSON(r.Context(), filepath.Base(file.Name()), query.Get("tags"), nil)
+		w.Header().Set("Content-Type", "application/json")
+	case "vcf":
+		version := query.Get("version")
+		if version == "" {
+			version = "3.0"
+		}
+		err = h.service.ExportToVCard(r.Context(), filepath.Base(file.Name()), version, query.Get("tags"), nil)
+		w.Header().Set("Content-Type", vcardContentType)
+	case "ldif":
+		err = h.service.ExportToLDIF(r.Context(), filepath.Base(file.Name()), query.Get("dn_template"), query.Get("tags"), nil)
+		w.Header().Set("Content-Type", ldifContentType)
+	default:
+		err = h.service.ExportToCSV(r.Context(), filepath.Base(file.Name()), query.Get("dialect"), query.Get("tags"), nil)
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	if err != nil {
+		w.Header().Del("Content-Type")
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="contacts.`+format+`"`)
+	http.ServeFile(w, r, file.Name())
+}
+
+// fileFormat picks csv, json, vcf or ldif from the format parameter or else the Content-Type
+func fileFormat(param, contentType string) (string, bool) {
+	switch strings.ToLower(param) {
+	case "csv", "json", "vcf", "ldif":
+		return strings.ToLower(param), true
+	case "":
+	default:
+		return "", false
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mediaType)) {
+	case "text/csv":
+		return "csv", true
+	case "application/json":
+		return "json", true
+	case "text/vcard", "text/x-vcard":
+		return "vcf", true
+	case "text/x-ldif", "text/ldif", "application/ldif":
+		return "ldif", true
+	default:
+		return "", false
+	}
+}
+
+// findContact reads the contact of the {id} in the path, it writes the error response when it fails
+func (h *HTTPHandler) findContact(w http.ResponseWriter, r *http.Request) (domain.Contact, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeBadRequest(w, "contact id must be a number")
+		return domain.Contact{}, false
+	}
+
+	ctc, err := h.service.SearchByID(id)
+	if err != nil {
+		writeError(w, err)
+		return domain.Contact{}, false
+	}
+	return ctc, true
+}
+
+// errBadRequest marks a request the API can not read, it is answered with 400
+var errBadRequest = errors.New("bad request")
+
+// writeError answers with the status of the sentinel error in err, or 500
+func writeError(w http.ResponseWriter, err error) {
+	info := ui.ErrorInfo{Code: http.StatusInternalServerError, Name: "failure", Message: err.Error()}
+	if errors.Is(err, errBadRequest) {
+		info.Code, info.Name = http.StatusBadRequest, "bad_request"
+	} else if se, ok := findSentinel(err); ok {
+		info.Code, info.Name = se.status, se.name
+	}
+
+	writeJSON(w, info.Code, errorEnvelope{Error: info})
+}
+
+// errorEnvelope is the envelope of every error of the API
+type errorEnvelope struct {
+	Error ui.ErrorInfo `json:"error"`
+}
+
+func writeBadRequest(w http.ResponseWriter, msg string) {
+	writeError(w, fmt.Errorf("%w: %s", errBadRequest, msg))
+}
+
+// writeContact answers with one contact, its version is the ETag for If-Match
+func writeContact(w http.ResponseWriter, status int, ctc domain.Contact) {
+	w.Header().Set("ETag", fmt.Sprintf(`"%d"`, ctc.Version))
+	writeJSON(w, status, ctc)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, "failed to marshal the response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
+
+// readJSON decodes the request body into v, it writes the error response when it fails
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeBadRequest(w, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// positiveParam reads a query parameter that must be a positive number
+func positiveParam(query url.Values, name string, fallback int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	num, err := strconv.Atoi(value)
+	if err != nil || num < 1 {
+		return 0, fmt.Errorf("%s must be a positive number", name)
+	}
+	return num, nil
+}
+
+// pageURL is the request URL with page and per_page replaced
+func pageURL(u *url.URL, page, perPage int) string {
+	query := u.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	return u.Path + "?" + query.Encode()
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+// importRun imports a file of one format, see usecase.ContactService.ImportFromJSON
+type importRun func(ctx context.Context, filename string, opts usecase.ImportOptions) (usecase.ImportReport, error)
+
+// importRunFor is the import of the format told by the extension of filename, nil when it is none
+func importRunFor(service *usecase.ContactService, filename string) importRun {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return service.ImportFromJSON
+	case ".csv":
+		return service.ImportFromCSV
+	case ".vcf", ".vcard":
+		return service.ImportFromVCard
+	case ".ldif":
+		return service.ImportFromLDIF
+	}
+	return nil
+}
+
+// reportRows turns the rows of an import report into rows to print
+func reportRows(report usecase.ImportReport) []ui.ImportRow {
+	rows := make([]ui.ImportRow, len(report.Rows))
+	for i, r := range report.Rows {
+		rows[i] = reportRow(r)
+	}
+	return rows
+}
+
+// reportRow turns the outcome of one row into a row to print
+func reportRow(r usecase.ImportRowReport) ui.ImportRow {
+	row := ui.ImportRow{Line: r.Line, Outcome: string(r.Outcome), ID: r.Contact.ID, Row: r.Row}
+	if r.Err != nil {
+		row.Error = r.Err.Error()
+	}
+	return row
+}
+
+// importSummary counts the outcomes of an import report and names the columns that were not imported
+func importSummary(report usecase.ImportReport) string {
+	verbs := []string{"created", "updated", "skipped"}
+	if report.DryRun {
+		verbs = []string{"to create", "to update", "to skip"}
+	}
+	summary := fmt.Sprintf("%d %s, %d %s, %d %s, %d with errors",
+		report.Count(usecase.ImportCreate), verbs[0],
+		report.Count(usecase.ImportUpdate), verbs[1],
+		report.Count(usecase.ImportSkip), verbs[2],
+		report.Count(usecase.ImportFailed))
+	if len(report.Unmapped) > 0 {
+		summary += ", these columns were not imported: " + strings.Join(report.Unmapped, ", ")
+	}
+	return summary
+}
+
+// progressLine tells how far an import or export got, e.g. "12000 rows, 4100 rows/s, 37%, 20s left"
+func progressLine(p usecase.Progress, unit string) string {
+	parts := []string{fmt.Sprintf("%d %s", p.Rows, unit), fmt.Sprintf("%.0f %s/s", p.Rate(), unit)}
+	if p.Total > 0 {
+		parts = append(parts, fmt.Sprintf("%d%%", p.Done*100/p.Total))
+	}
+	if eta := p.ETA().Round(time.Second); eta > 0 {
+		parts = append(parts, eta.String()+" left")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// showProgress returns the OnProgress of an import or export that keeps a progress line
+// on the terminal, nil when stderr is not one. Nothing is shown for what ends within the
+// first report interval
+func showProgress(unit string) func(usecase.Progress) {
+	if !ui.IsTerminal(os.Stderr) {
+		return nil
+	}
+	shown := false
+	return func(p usecase.Progress) {
+		if p.Finished && !shown {
+			return
+		}
+		shown = true
+		ui.PrintProgress(progressLine(p, unit))
+		if p.Finished {
+			ui.EndProgress()
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// the OpenAPI 3.1 document of the REST API is generated from the routes of HTTPHandler
+// and the Go types of the bodies, so it can not drift from the handlers
+
+type openAPIDoc struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       openAPIInfo                     `json:"info"`
+	Paths      map[string]map[string]operation `json:"paths"`
+	Components openAPIComponents               `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIComponents struct {
+	Schemas map[string]*schema `json:"schemas"`
+}
+
+// operation describes one route, see HTTPHandler.routes
+type operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Description string              `json:"description,omitempty"`
+	Parameters  []parameter         `json:"parameters,omitempty"`
+	RequestBody *requestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]response `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Headers     map[string]header    `json:"headers,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type schema struct {
+	Ref string `json:"$ref,omitempty"`
+	// Type is a string, or a list like ["array", "null"] for a value that can be null
+	Type        any                `json:"type,omitempty"`
+	Format      string             `json:"format,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *int               `json:"minimum,omitempty"`
+	Maximum     *int               `json:"maximum,omitempty"`
+	Items       *schema            `json:"items,omitempty"`
+	Properties  map[string]*schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+// schemaGenerator turns Go types into schemas, every struct becomes a component
+type schemaGenerator struct {
+	components map[string]*schema
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// ref returns the schema of t, named structs are added to the components and referenced
+func (g *schemaGenerator) ref(t reflect.Type) *schema {
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return g.ref(t.Elem())
+	case t.Kind() == reflect.Struct:
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// reserve the name first, a type may refer to itself
+			g.components[name] = nil
+			g.components[name] = g.object(t)
+		}
+		return &schema{Ref: "#/components/schemas/" + name}
+	case t.Kind() == reflect.Slice:
+		// a nil slice is written as null
+		return &schema{Type: []string{"array", "null"}, Items: g.ref(t.Elem())}
+	case t.Kind() == reflect.String:
+		return &schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &schema{Type: "number"}
+	default:
+		return &schema{}
+	}
+}
+
+// object is the schema of a struct with the field names encoding/json writes
+func (g *schemaGenerator) object(t reflect.Type) *schema {
+	obj := &schema{Type: "object", Properties: map[string]*schema{}}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		obj.Properties[name] = g.ref(field.Type)
+		// a request body leaves out what it does not change, see contactInput
+		if field.Type.Kind() != reflect.Pointer && !strings.Contains(opts, "omitempty") {
+			obj.Required = append(obj.Required, name)
+		}
+	}
+	return obj
+}
+
+// componentName is the name of the type with a capital letter, e.g. ContactInput for contactInput
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	if len(name) == 0 {
+		return "Object"
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// jsonBody is a body of the given Go type
+func (g *schemaGenerator) jsonBody(v any) map[string]mediaType {
+	return map[string]mediaType{"application/json": {Schema: g.ref(reflect.TypeOf(v))}}
+}
+
+// errorResponse is a response with the error envelope
+func (g *schemaGenerator) errorResponse(description string) response {
+	return response{Description: description, Content: g.jsonBody(errorEnvelope{})}
+}
+
+// openAPI builds the document of routes
+func openAPI(routes []route, g *schemaGenerator) *openAPIDoc {
+	doc := &openAPIDoc{
+		OpenAPI: "3.1.0",
+		Info: openAPIInfo{
+			Title:       "Contact Management API",
+			Version:     "1.0.0",
+			Description: "Read and write the address book. The contact fields are written like the JSON export.",
+		},
+		Paths:      map[string]map[string]operation{},
+		Components: openAPIComponents{Schemas: g.components},
+	}
+
+	for _, rt := range routes {
+		if doc.Paths[rt.pattern] == nil {
+			doc.Paths[rt.pattern] = map[string]operation{}
+		}
+		doc.Paths[rt.pattern][strings.ToLower(rt.method)] = rt.op
+	}
+	return doc
+}
+
+// contactIDParam is the {id} of the contact routes
+var contactIDParam = parameter{Name: "id", In: "path", Required: true, Description: "ID of the contact", Schema: &schema{Type: "integer"}}
+
+// queryParam is an optional query parameter
+func queryParam(name, description string, s *schema) parameter {
+	return parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+func intSchema(minimum int, maximum int) *schema {
+	s := &schema{Type: "integer", Minimum: &minimum}
+	if maximum > 0 {
+		s.Maximum = &maximum
+	}
+	return s
+}
+
+// etagHeader is the version of the returned contact
+var etagHeader = map[string]header{
+	"ETag": {Description: "version of the contact, send it back in If-Match to update it", Schema: &schema{Type: "string"}},
+}
+
+var ifMatchParam = parameter{Name: "If-Match", In: "header", Description: "ETag the contact was read at, the update fails with 409 when it changed since. The Version field of the body does the same", Schema: &schema{Type: "string"}}
+
+// contactsAsCSV is the CSV of the export and the import
+var contactsAsCSV = mediaType{Schema: &schema{Type: "string", Description: "CSV with the header ID,Name,Email,Phone,Emails,Phones,Addresses,Tags,CreatedAt,UpdatedAt"}}
+
+// contactsAsVCard is the .vcf file of the export and the import
+var contactsAsVCard = mediaType{Schema: &schema{Type: "string", Description: "one vCard per contact, version 3.0 or 4.0, the import also reads 2.1"}}
+
+// contactsAsLDIF is the .ldif file of the export and the import
+var contactsAsLDIF = mediaType{Schema: &schema{Type: "string", Description: "LDIF (RFC 2849) with one inetOrgPerson entry per contact"}}
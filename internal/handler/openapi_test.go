@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/repository"
+	"github.com/Dwipasca/contact-management/internal/usecase"
+)
+
+var pathParamRegex = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+func newTestService(t *testing.T) *usecase.ContactService {
+	return usecase.NewContactService(repository.NewContactRepository(), repository.NewGroupRepository(), repository.NewAuditRepository(),
+		repository.NewCSVProfileStore(t.TempDir()))
+}
+
+// TestOpenAPIDescribesEveryRoute fails when a route is added without its operation:
+// every route needs an operationId, a summary and responses, and every {param} of its path
+func TestOpenAPIDescribesEveryRoute(t *testing.T) {
+	h := NewHTTPHandler(newTestService(t))
+	routes := h.routes(&schemaGenerator{components: map[string]*schema{}})
+	if len(routes) == 0 {
+		t.Fatal("no routes")
+	}
+
+	ids := map[string]string{}
+	for _, rt := range routes {
+		name := rt.method + " " + rt.pattern
+		op, ok := h.spec.Paths[rt.pattern][strings.ToLower(rt.method)]
+		if !ok {
+			t.Errorf("%s is missing in the OpenAPI document", name)
+			continue
+		}
+		if op.OperationID == "" || op.Summary == "" || len(op.Responses) == 0 {
+			t.Errorf("%s needs an operationId, a summary and responses", name)
+		}
+		if other, ok := ids[op.OperationID]; ok {
+			t.Errorf("%s reuses the operationId %q of %s", name, op.OperationID, other)
+		}
+		ids[op.OperationID] = name
+
+		for _, match := range pathParamRegex.FindAllStringSubmatch(rt.pattern, -1) {
+			declared := slices.ContainsFunc(op.Parameters, func(p parameter) bool {
+				return p.In == "path" && p.Name == match[1] && p.Required
+			})
+			if !declared {
+				t.Errorf("%s does not describe the path parameter %q", name, match[1])
+			}
+		}
+
+		for status := range op.Responses {
+			if code, err := strconv.Atoi(status); err != nil || http.StatusText(code) == "" {
+				t.Errorf("%s has an invalid response status %q", name, status)
+			}
+		}
+	}
+
+	for name, component := range h.spec.Components.Schemas {
+		if component == nil {
+			t.Errorf("schema %s is referenced but never generated", name)
+		}
+	}
+}
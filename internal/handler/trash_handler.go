@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/usecase"
+	"github.com/Dwipasca/contact-management/ui"
+)
+
+func (ch *ContactHandler) handleTrash() {
+	ui.SetTitle(ui.Menus[10])
+
+	contacts, err := ch.service.GetTrash()
+	if err != nil {
+		if errors.Is(err, usecase.ErrTrashEmpty) {
+			ui.SetRespond("The trash is empty", "result")
+		} else {
+			ui.SetRespond("something went wrong: "+err.Error(), "error")
+		}
+		return
+	}
+
+	ui.PrintContacts(contacts...)
+
+	fmt.Println("\n1. Restore contact")
+	fmt.Println("2. Delete contact permanently")
+	fmt.Println("3. Empty trash")
+	fmt.Println("0. Back")
+
+	switch ui.PromptRequiredInput(ch.scanner, "\nSelect option") {
+	case "1":
+		id, err := strconv.Atoi(ui.PromptRequiredInput(ch.scanner, "Contact ID to restore"))
+		if err != nil {
+			ui.SetRespond("Invalid ID format, please enter a number", "error")
+			return
+		}
+		if err := ch.service.RestoreContact(id); err != nil {
+			ui.SetRespond(err.Error(), "error")
+			return
+		}
+		ui.SetRespond(fmt.Sprintf("Contact %d restored", id), "success")
+
+	case "2":
+		id, err := strconv.Atoi(ui.PromptRequiredInput(ch.scanner, "Contact ID to delete permanently"))
+		if err != nil {
+			ui.SetRespond("Invalid ID format, please enter a number", "error")
+			return
+		}
+		confirm := ui.PromptRequiredInput(ch.scanner, "This can not be undone, are you sure? (y/n)")
+		if strings.ToLower(confirm) != "y" {
+			ui.SetRespond("Deletion cancelled", "result")
+			return
+		}
+		if err := ch.service.PurgeContact(id); err != nil {
+			ui.SetRespond(err.Error(), "error")
+			return
+		}
+		ui.SetRespond(fmt.Sprintf("Contact %d deleted permanently", id), "success")
+
+	case "3":
+		confirm := ui.PromptRequiredInput(ch.scanner, "Delete every contact in the trash permanently? (y/n)")
+		if strings.ToLower(confirm) != "y" {
+			ui.SetRespond("Deletion cancelled", "result")
+			return
+		}
+		purged, err := ch.service.EmptyTrash()
+		if err != nil {
+			ui.SetRespond(err.Error(), "error")
+			return
+		}
+		ui.SetRespond(fmt.Sprintf("%d contacts deleted permanently", purged), "success")
+
+	case "0":
+		return
+
+	default:
+		ui.SetRespond("Invalid option", "error")
+	}
+}
@@ -0,0 +1,329 @@
+package kvstore
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Get returns the value stored for key, or nil when the key does not exist
+func (tx *Tx) Get(key []byte) ([]byte, error) {
+	if err := tx.check(false); err != nil {
+		return nil, err
+	}
+
+	id := tx.root
+	for id != 0 {
+		n, err := tx.node(id)
+		if err != nil {
+			return nil, err
+		}
+
+		if !n.leaf {
+			id = n.children[childIndex(n, key)]
+			continue
+		}
+
+		idx, found := leafIndex(n, key)
+		if !found {
+			return nil, nil
+		}
+		return tx.value(n, idx)
+	}
+
+	return nil, nil
+}
+
+// Put stores value for key, replacing the previous value
+func (tx *Tx) Put(key, value []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrKeyEmpty
+	}
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	flags := byte(0)
+	stored := clone(value)
+	if len(key)+len(value) > maxInlineSize {
+		flags = flagOverflow
+		stored = tx.writeOverflow(value)
+	}
+
+	if tx.root == 0 {
+		tx.root = tx.newNode(&node{
+			leaf:  true,
+			keys:  [][]byte{clone(key)},
+			vals:  [][]byte{stored},
+			flags: []byte{flags},
+		})
+		return nil
+	}
+
+	newRoot, splitKey, splitID, err := tx.insert(tx.root, clone(key), stored, flags)
+	if err != nil {
+		return err
+	}
+
+	// the root was split, the tree grows by one level
+	if splitID != 0 {
+		newRoot = tx.newNode(&node{
+			keys:     [][]byte{splitKey},
+			children: []pgid{newRoot, splitID},
+		})
+	}
+	tx.root = newRoot
+	return nil
+}
+
+// insert puts the entry into the subtree at id and returns the new id of the subtree.
+// When the node had to be split, splitID is the new right sibling
+// and splitKey the smallest key in it.
+func (tx *Tx) insert(id pgid, key, value []byte, flags byte) (newID pgid, splitKey []byte, splitID pgid, err error) {
+	newID, n, err := tx.writableNode(id)
+	if err != nil {
+		return 0, nil, 0, err
+	}
+
+	if n.leaf {
+		idx, found := leafIndex(n, key)
+		if found {
+			if n.flags[idx]&flagOverflow != 0 {
+				if err := tx.releaseOverflow(n.vals[idx]); err != nil {
+					return 0, nil, 0, err
+				}
+			}
+			n.vals[idx] = value
+			n.flags[idx] = flags
+		} else {
+			n.keys = insertAt(n.keys, idx, key)
+			n.vals = insertAt(n.vals, idx, value)
+			n.flags = insertAt(n.flags, idx, flags)
+		}
+	} else {
+		idx := childIndex(n, key)
+		childID, childSplitKey, childSplitID, err := tx.insert(n.children[idx], key, value, flags)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		n.children[idx] = childID
+		if childSplitID != 0 {
+			n.keys = insertAt(n.keys, idx, childSplitKey)
+			n.children = insertAt(n.children, idx+1, childSplitID)
+		}
+	}
+
+	if n.size() <= PageSize {
+		return newID, nil, 0, nil
+	}
+
+	splitKey, right := split(n)
+	return newID, splitKey, tx.newNode(right), nil
+}
+
+// split moves the upper half of an overflowing node into a new node
+// and returns it with the key that separates the two
+func split(n *node) ([]byte, *node) {
+	half := n.size() / 2
+
+	if n.leaf {
+		size := leafHeaderSize
+		mid := 0
+		for mid < len(n.keys)-1 && size < half {
+			size += leafEntryHeader + len(n.keys[mid]) + len(n.vals[mid])
+			mid++
+		}
+		mid = max(mid, 1)
+
+		right := &node{
+			leaf:  true,
+			keys:  append([][]byte(nil), n.keys[mid:]...),
+			vals:  append([][]byte(nil), n.vals[mid:]...),
+			flags: append([]byte(nil), n.flags[mid:]...),
+		}
+		n.keys, n.vals, n.flags = n.keys[:mid], n.vals[:mid], n.flags[:mid]
+		return clone(right.keys[0]), right
+	}
+
+	// the middle key moves up to the parent, it is in neither half
+	size := branchHeaderSize
+	mid := 0
+	for mid < len(n.keys)-2 && size < half {
+		size += branchEntryHeader + len(n.keys[mid])
+		mid++
+	}
+	mid = max(mid, 1)
+
+	splitKey := n.keys[mid]
+	right := &node{
+		keys:     append([][]byte(nil), n.keys[mid+1:]...),
+		children: append([]pgid(nil), n.children[mid+1:]...),
+	}
+	n.keys, n.children = n.keys[:mid], n.children[:mid+1]
+	return splitKey, right
+}
+
+// Delete removes key, deleting a key that does not exist is not an error
+func (tx *Tx) Delete(key []byte) error {
+	if err := tx.check(true); err != nil {
+		return err
+	}
+	if tx.root == 0 {
+		return nil
+	}
+
+	// look first, so a missing key does not copy the path to it
+	value, err := tx.Get(key)
+	if err != nil || value == nil {
+		return err
+	}
+
+	newRoot, empty, err := tx.remove(tx.root, key)
+	if err != nil {
+		return err
+	}
+	if empty {
+		tx.root = 0
+		return nil
+	}
+
+	// a branch left with a single child is replaced by that child
+	for {
+		n, err := tx.node(newRoot)
+		if err != nil {
+			return err
+		}
+		if n.leaf || len(n.children) > 1 {
+			break
+		}
+		tx.release(newRoot)
+		newRoot = n.children[0]
+	}
+	tx.root = newRoot
+	return nil
+}
+
+// remove deletes key from the subtree at id. Nodes that become empty are
+// dropped from their parent, nodes are not merged with their siblings,
+// so the tree stays balanced in depth but pages can be less than half full.
+func (tx *Tx) remove(id pgid, key []byte) (newID pgid, empty bool, err error) {
+	newID, n, err := tx.writableNode(id)
+	if err != nil {
+		return 0, false, err
+	}
+
+	if n.leaf {
+		idx, found := leafIndex(n, key)
+		if found {
+			if n.flags[idx]&flagOverflow != 0 {
+				if err := tx.releaseOverflow(n.vals[idx]); err != nil {
+					return 0, false, err
+				}
+			}
+			n.keys = removeAt(n.keys, idx)
+			n.vals = removeAt(n.vals, idx)
+			n.flags = removeAt(n.flags, idx)
+		}
+	} else {
+		idx := childIndex(n, key)
+		childID, childEmpty, err := tx.remove(n.children[idx], key)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if !childEmpty {
+			n.children[idx] = childID
+		} else {
+			n.children = removeAt(n.children, idx)
+			if len(n.keys) > 0 {
+				n.keys = removeAt(n.keys, max(idx-1, 0))
+			}
+		}
+	}
+
+	if len(n.keys) == 0 && (n.leaf || len(n.children) == 0) {
+		tx.release(newID)
+		return 0, true, nil
+	}
+	return newID, false, nil
+}
+
+// Scan calls fn for every key from start onwards in key order until fn returns false
+func (tx *Tx) Scan(start []byte, fn func(key, value []byte) bool) error {
+	if err := tx.check(false); err != nil {
+		return err
+	}
+	if tx.root == 0 {
+		return nil
+	}
+	_, err := tx.scan(tx.root, start, fn)
+	return err
+}
+
+// ScanPrefix calls fn for every key that starts with prefix until fn returns false
+func (tx *Tx) ScanPrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	return tx.Scan(prefix, func(key, value []byte) bool {
+		if !bytes.HasPrefix(key, prefix) {
+			return false
+		}
+		return fn(key, value)
+	})
+}
+
+func (tx *Tx) scan(id pgid, start []byte, fn func(key, value []byte) bool) (bool, error) {
+	n, err := tx.node(id)
+	if err != nil {
+		return false, err
+	}
+
+	if n.leaf {
+		idx, _ := leafIndex(n, start)
+		for ; idx < len(n.keys); idx++ {
+			value, err := tx.value(n, idx)
+			if err != nil {
+				return false, err
+			}
+			if !fn(n.keys[idx], value) {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	for idx := childIndex(n, start); idx < len(n.children); idx++ {
+		more, err := tx.scan(n.children[idx], start, fn)
+		if err != nil || !more {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// leafIndex returns the position of key in the leaf, or where it would be inserted
+func leafIndex(n *node, key []byte) (int, bool) {
+	idx := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return idx, idx < len(n.keys) && bytes.Equal(n.keys[idx], key)
+}
+
+// childIndex returns the child of a branch whose range holds key
+func childIndex(n *node, key []byte) int {
+	return sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	})
+}
+
+func insertAt[T any](s []T, idx int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[idx+1:], s[idx:])
+	s[idx] = v
+	return s
+}
+
+func removeAt[T any](s []T, idx int) []T {
+	return append(s[:idx], s[idx+1:]...)
+}
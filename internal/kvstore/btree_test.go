@@ -0,0 +1,275 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openTestDB(t *testing.T) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, path
+}
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+// rootNode returns the node the committed tree starts at
+func rootNode(t *testing.T, db *DB) *node {
+	t.Helper()
+	var root *node
+	err := db.View(func(tx *Tx) error {
+		var err error
+		root, err = tx.node(tx.root)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestSplitAtPageBoundary(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	// 10 entries with a key of 8 bytes fill a leaf to exactly PageSize
+	err := db.Update(func(tx *Tx) error {
+		for i := range 9 {
+			if err := tx.Put(testKey(i), bytes.Repeat([]byte{'v'}, 390)); err != nil {
+				return err
+			}
+		}
+		return tx.Put(testKey(9), bytes.Repeat([]byte{'v'}, 433))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := rootNode(t, db)
+	if !root.leaf || root.size() != PageSize {
+		t.Fatalf("root is a leaf %v of %d bytes, want a full leaf of %d", root.leaf, root.size(), PageSize)
+	}
+
+	// one byte more splits it in two leaves under a new root
+	if err := db.Update(func(tx *Tx) error { return tx.Put(testKey(10), nil) }); err != nil {
+		t.Fatal(err)
+	}
+	root = rootNode(t, db)
+	if root.leaf || len(root.children) != 2 {
+		t.Fatalf("root is a leaf %v with %d children, want a branch with 2", root.leaf, len(root.children))
+	}
+
+	err = db.View(func(tx *Tx) error {
+		for i, id := range root.children {
+			child, err := tx.node(id)
+			if err != nil {
+				return err
+			}
+			if child.size() > PageSize {
+				t.Errorf("leaf %d is %d bytes", i, child.size())
+			}
+		}
+		for i := range 11 {
+			if value, err := tx.Get(testKey(i)); err != nil || (i < 10 && value == nil) {
+				t.Errorf("get %s after the split: %q, %v", testKey(i), value, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteDropsEmptyPages(t *testing.T) {
+	db, _ := openTestDB(t)
+	value := bytes.Repeat([]byte{'v'}, 200)
+
+	// a few hundred entries make a tree of three levels
+	err := db.Update(func(tx *Tx) error {
+		for i := range 2000 {
+			if err := tx.Put(testKey(i), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := rootNode(t, db); root.leaf {
+		t.Fatal("2000 entries fit in one leaf")
+	}
+
+	// deleting every key but the last one of the tree leaves a single leaf
+	keys := rand.New(rand.NewPCG(1, 2)).Perm(1999)
+	err = db.Update(func(tx *Tx) error {
+		for _, i := range keys {
+			if err := tx.Delete(testKey(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := rootNode(t, db); !root.leaf || len(root.keys) != 1 {
+		t.Fatalf("root is a leaf %v with %d keys, want the leaf of the last key", root.leaf, len(root.keys))
+	}
+
+	// the last delete empties the tree, it can be filled again
+	err = db.Update(func(tx *Tx) error {
+		if err := tx.Delete(testKey(1999)); err != nil {
+			return err
+		}
+		if tx.root != 0 {
+			t.Errorf("root is page %d after deleting every key", tx.root)
+		}
+		return tx.Put(testKey(1), value)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.View(func(tx *Tx) error {
+		got, err := tx.Get(testKey(1))
+		if !bytes.Equal(got, value) {
+			t.Errorf("get after refill: %d bytes, %v", len(got), err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanOrder(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	order := rand.New(rand.NewPCG(3, 4)).Perm(1500)
+	err := db.Update(func(tx *Tx) error {
+		for _, i := range order {
+			if err := tx.Put(testKey(i), []byte{byte(i)}); err != nil {
+				return err
+			}
+		}
+		return tx.Put([]byte("other"), []byte("x"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	err = db.View(func(tx *Tx) error {
+		return tx.Scan(nil, func(key, _ []byte) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1501 || !slices.IsSorted(keys) {
+		t.Fatalf("scan returned %d keys, sorted %v", len(keys), slices.IsSorted(keys))
+	}
+
+	// a scan starts at the first key that is not smaller than start and stops when fn returns false
+	var from []string
+	err = db.View(func(tx *Tx) error {
+		return tx.Scan([]byte("key00999x"), func(key, _ []byte) bool {
+			from = append(from, string(key))
+			return len(from) < 3
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"key01000", "key01001", "key01002"}; !slices.Equal(from, want) {
+		t.Errorf("scan from key00999x got %v, want %v", from, want)
+	}
+
+	var prefixed int
+	err = db.View(func(tx *Tx) error {
+		return tx.ScanPrefix([]byte("key014"), func(key, _ []byte) bool {
+			prefixed++
+			return true
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prefixed != 100 {
+		t.Errorf("prefix key014 matched %d keys, want 100", prefixed)
+	}
+}
+
+// TestRandomAgainstMap puts and deletes random keys, some with values that need
+// overflow pages, reopens the store now and then and compares it with a map
+func TestRandomAgainstMap(t *testing.T) {
+	db, path := openTestDB(t)
+	rnd := rand.New(rand.NewPCG(5, 6))
+	model := map[string][]byte{}
+
+	for round := range 30 {
+		err := db.Update(func(tx *Tx) error {
+			for range 200 {
+				key := testKey(rnd.IntN(800))
+				if rnd.IntN(3) == 0 {
+					delete(model, string(key))
+					if err := tx.Delete(key); err != nil {
+						return err
+					}
+					continue
+				}
+				value := bytes.Repeat([]byte{byte(round)}, rnd.IntN(3*PageSize))
+				model[string(key)] = value
+				if err := tx.Put(key, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("round %d: %v", round, err)
+		}
+
+		if round%5 == 4 {
+			if err := db.Close(); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			if db, err = Open(path); err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+		}
+
+		got := map[string][]byte{}
+		err = db.View(func(tx *Tx) error {
+			return tx.Scan(nil, func(key, value []byte) bool {
+				got[string(key)] = value
+				return true
+			})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(model) {
+			t.Fatalf("round %d: store has %d keys, want %d", round, len(got), len(model))
+		}
+		for key, want := range model {
+			if !bytes.Equal(got[key], want) {
+				t.Fatalf("round %d: %s has %d bytes, want %d", round, key, len(got[key]), len(want))
+			}
+		}
+	}
+	db.Close()
+}
@@ -0,0 +1,460 @@
+// Package kvstore is a small embedded key-value store kept in a single file.
+//
+// Keys are kept sorted in a page-based B+tree. Pages are never changed in place:
+// a transaction writes the changed nodes to free pages and then switches
+// to the new tree by writing one of the two meta pages, so a crash
+// always leaves the last committed state readable.
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	ErrKeyTooLarge = errors.New("kvstore: key is too large")
+	ErrKeyEmpty    = errors.New("kvstore: key is empty")
+	ErrTxClosed    = errors.New("kvstore: transaction is closed")
+	ErrReadOnly    = errors.New("kvstore: transaction is read-only")
+)
+
+// DB is an open store file, transactions are serialized by a mutex
+type DB struct {
+	mu   sync.Mutex
+	file *os.File
+	meta meta
+	// pages that are free in the last committed state
+	free []pgid
+}
+
+func Open(path string) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("kvstore: failed to open %s: %w", path, err)
+	}
+
+	db := &DB{file: file}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("kvstore: failed to stat %s: %w", path, err)
+	}
+
+	if info.Size() == 0 {
+		err = db.init()
+	} else {
+		err = db.load()
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.file.Close()
+}
+
+// init writes both meta pages of an empty store
+func (db *DB) init() error {
+	db.meta = meta{pageCount: 2}
+	for slot := range 2 {
+		if _, err := db.file.WriteAt(db.meta.encode(), int64(slot)*PageSize); err != nil {
+			return fmt.Errorf("kvstore: failed to write meta page: %w", err)
+		}
+	}
+	return db.file.Sync()
+}
+
+// load picks the newest valid meta page and reads the freelist it points to
+func (db *DB) load() error {
+	found := false
+	for slot := range 2 {
+		buf := make([]byte, PageSize)
+		if _, err := db.file.ReadAt(buf, int64(slot)*PageSize); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("kvstore: failed to read meta page: %w", err)
+		}
+		if m, ok := decodeMeta(buf); ok && (!found || m.txid > db.meta.txid) {
+			db.meta = m
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("kvstore: no valid meta page, not a kvstore file or %w", ErrCorrupted)
+	}
+
+	free, err := db.readFreelist(db.meta.freelist)
+	if err != nil {
+		return err
+	}
+	db.free = free
+
+	return nil
+}
+
+func (db *DB) readPage(id pgid) ([]byte, error) {
+	buf := make([]byte, PageSize)
+	if _, err := db.file.ReadAt(buf, int64(id)*PageSize); err != nil {
+		return nil, fmt.Errorf("kvstore: failed to read page %d: %w", id, err)
+	}
+	return buf, nil
+}
+
+func (db *DB) readFreelist(id pgid) ([]pgid, error) {
+	var free []pgid
+	for id != 0 {
+		buf, err := db.readPage(id)
+		if err != nil {
+			return nil, err
+		}
+		if buf[0] != pageTypeFreelist {
+			return nil, ErrCorrupted
+		}
+		next := pgid(binary.LittleEndian.Uint64(buf[1:]))
+		count := int(binary.LittleEndian.Uint16(buf[9:]))
+		if count > freelistCapacity {
+			return nil, ErrCorrupted
+		}
+		for i := range count {
+			free = append(free, pgid(binary.LittleEndian.Uint64(buf[freelistHeaderSize+i*8:])))
+		}
+		id = next
+	}
+	return free, nil
+}
+
+// View runs fn in a read-only transaction
+func (db *DB) View(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := db.begin(false)
+	defer tx.close()
+	return fn(tx)
+}
+
+// Update runs fn in a read-write transaction, the changes are committed
+// when fn returns nil and thrown away when it returns an error
+func (db *DB) Update(fn func(tx *Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx := db.begin(true)
+	defer tx.close()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.commit()
+}
+
+// Tx is a transaction, it must only be used inside the View or Update callback
+type Tx struct {
+	db       *DB
+	writable bool
+	closed   bool
+	root     pgid
+	// a copy of db.free, pages are taken from it while the transaction runs
+	free      []pgid
+	pageCount pgid
+	// pages allocated by this transaction, they can be changed in place
+	nodes    map[pgid]*node
+	overflow map[pgid][]byte
+	// committed pages this transaction stopped using, they become free after the commit
+	pending []pgid
+}
+
+func (db *DB) begin(writable bool) *Tx {
+	tx := &Tx{
+		db:        db,
+		writable:  writable,
+		root:      db.meta.root,
+		pageCount: db.meta.pageCount,
+	}
+	if writable {
+		tx.free = append([]pgid(nil), db.free...)
+		tx.nodes = map[pgid]*node{}
+		tx.overflow = map[pgid][]byte{}
+	}
+	return tx
+}
+
+func (tx *Tx) close() {
+	tx.closed = true
+}
+
+func (tx *Tx) check(write bool) error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	if write && !tx.writable {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+func (tx *Tx) allocate() pgid {
+	if n := len(tx.free); n > 0 {
+		id := tx.free[n-1]
+		tx.free = tx.free[:n-1]
+		return id
+	}
+	id := tx.pageCount
+	tx.pageCount++
+	return id
+}
+
+// release gives up a page. A page allocated by this transaction is not part
+// of the committed tree and can be reused right away, any other page only
+// once the commit no longer points to it.
+func (tx *Tx) release(id pgid) {
+	if _, ok := tx.nodes[id]; ok {
+		delete(tx.nodes, id)
+		tx.free = append(tx.free, id)
+		return
+	}
+	if _, ok := tx.overflow[id]; ok {
+		delete(tx.overflow, id)
+		tx.free = append(tx.free, id)
+		return
+	}
+	tx.pending = append(tx.pending, id)
+}
+
+func (tx *Tx) node(id pgid) (*node, error) {
+	if n, ok := tx.nodes[id]; ok {
+		return n, nil
+	}
+	buf, err := tx.db.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	return decodeNode(buf)
+}
+
+// writableNode returns a node that may be changed, copying a committed page
+// to a newly allocated one. The caller must store the returned id in the parent.
+func (tx *Tx) writableNode(id pgid) (pgid, *node, error) {
+	if n, ok := tx.nodes[id]; ok {
+		return id, n, nil
+	}
+	n, err := tx.node(id)
+	if err != nil {
+		return 0, nil, err
+	}
+	tx.release(id)
+	newID := tx.allocate()
+	tx.nodes[newID] = n
+	return newID, n, nil
+}
+
+func (tx *Tx) newNode(n *node) pgid {
+	id := tx.allocate()
+	tx.nodes[id] = n
+	return id
+}
+
+// writeOverflow stores a large value in a chain of overflow pages
+// and returns the reference kept in the leaf
+func (tx *Tx) writeOverflow(value []byte) []byte {
+	pages := (len(value) + overflowCapacity - 1) / overflowCapacity
+	ids := make([]pgid, pages)
+	for i := range ids {
+		ids[i] = tx.allocate()
+	}
+
+	for i, id := range ids {
+		chunk := value[i*overflowCapacity : min((i+1)*overflowCapacity, len(value))]
+		buf := make([]byte, PageSize)
+		buf[0] = pageTypeOverflow
+		if i+1 < len(ids) {
+			binary.LittleEndian.PutUint64(buf[1:], uint64(ids[i+1]))
+		}
+		binary.LittleEndian.PutUint16(buf[9:], uint16(len(chunk)))
+		copy(buf[overflowHeaderSize:], chunk)
+		tx.overflow[id] = buf
+	}
+
+	ref := make([]byte, overflowRefSize)
+	binary.LittleEndian.PutUint64(ref[0:], uint64(ids[0]))
+	binary.LittleEndian.PutUint32(ref[8:], uint32(len(value)))
+	return ref
+}
+
+func (tx *Tx) overflowPage(id pgid) ([]byte, error) {
+	if buf, ok := tx.overflow[id]; ok {
+		return buf, nil
+	}
+	buf, err := tx.db.readPage(id)
+	if err != nil {
+		return nil, err
+	}
+	if buf[0] != pageTypeOverflow {
+		return nil, ErrCorrupted
+	}
+	return buf, nil
+}
+
+func (tx *Tx) readOverflow(ref []byte) ([]byte, error) {
+	if len(ref) != overflowRefSize {
+		return nil, ErrCorrupted
+	}
+	id := pgid(binary.LittleEndian.Uint64(ref[0:]))
+	length := int(binary.LittleEndian.Uint32(ref[8:]))
+
+	value := make([]byte, 0, length)
+	for id != 0 && len(value) < length {
+		buf, err := tx.overflowPage(id)
+		if err != nil {
+			return nil, err
+		}
+		chunk := int(binary.LittleEndian.Uint16(buf[9:]))
+		if chunk > overflowCapacity {
+			return nil, ErrCorrupted
+		}
+		value = append(value, buf[overflowHeaderSize:overflowHeaderSize+chunk]...)
+		id = pgid(binary.LittleEndian.Uint64(buf[1:]))
+	}
+	if len(value) != length {
+		return nil, ErrCorrupted
+	}
+	return value, nil
+}
+
+func (tx *Tx) releaseOverflow(ref []byte) error {
+	if len(ref) != overflowRefSize {
+		return ErrCorrupted
+	}
+	id := pgid(binary.LittleEndian.Uint64(ref[0:]))
+	for id != 0 {
+		buf, err := tx.overflowPage(id)
+		if err != nil {
+			return err
+		}
+		next := pgid(binary.LittleEndian.Uint64(buf[1:]))
+		tx.release(id)
+		id = next
+	}
+	return nil
+}
+
+// value resolves the value of a leaf entry
+func (tx *Tx) value(n *node, idx int) ([]byte, error) {
+	if n.flags[idx]&flagOverflow != 0 {
+		return tx.readOverflow(n.vals[idx])
+	}
+	return clone(n.vals[idx]), nil
+}
+
+// commit writes the changed pages and the freelist, syncs them
+// and only then writes the meta page that makes them visible
+func (tx *Tx) commit() error {
+	if len(tx.nodes) == 0 && len(tx.overflow) == 0 && len(tx.pending) == 0 {
+		return nil
+	}
+	db := tx.db
+
+	for id, n := range tx.nodes {
+		if _, err := db.file.WriteAt(n.encode(), int64(id)*PageSize); err != nil {
+			return fmt.Errorf("kvstore: failed to write page %d: %w", id, err)
+		}
+	}
+	for id, buf := range tx.overflow {
+		if _, err := db.file.WriteAt(buf, int64(id)*PageSize); err != nil {
+			return fmt.Errorf("kvstore: failed to write page %d: %w", id, err)
+		}
+	}
+
+	// the old freelist pages and the pages released by this transaction are
+	// still used by the committed state, the new freelist must not land on them
+	oldList, err := db.freelistPageIDs(db.meta.freelist)
+	if err != nil {
+		return err
+	}
+	free := append([]pgid(nil), tx.free...)
+	// enough pages for every free id, taking some of them only makes the list shorter
+	freelistPages := (len(free)+len(tx.pending)+len(oldList))/freelistCapacity + 1
+	listIDs := make([]pgid, 0, freelistPages)
+	for range freelistPages {
+		if n := len(free); n > 0 {
+			listIDs = append(listIDs, free[n-1])
+			free = free[:n-1]
+		} else {
+			listIDs = append(listIDs, tx.pageCount)
+			tx.pageCount++
+		}
+	}
+	free = append(free, tx.pending...)
+	free = append(free, oldList...)
+
+	if err := db.writeFreelist(listIDs, free); err != nil {
+		return err
+	}
+
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("kvstore: failed to sync pages: %w", err)
+	}
+
+	newMeta := meta{
+		root:      tx.root,
+		freelist:  listIDs[0],
+		pageCount: tx.pageCount,
+		txid:      db.meta.txid + 1,
+	}
+	if _, err := db.file.WriteAt(newMeta.encode(), int64(newMeta.txid%2)*PageSize); err != nil {
+		return fmt.Errorf("kvstore: failed to write meta page: %w", err)
+	}
+	if err := db.file.Sync(); err != nil {
+		return fmt.Errorf("kvstore: failed to sync meta page: %w", err)
+	}
+
+	db.meta = newMeta
+	db.free = free
+	return nil
+}
+
+func (db *DB) freelistPageIDs(id pgid) ([]pgid, error) {
+	var ids []pgid
+	for id != 0 {
+		buf, err := db.readPage(id)
+		if err != nil {
+			return nil, err
+		}
+		if buf[0] != pageTypeFreelist {
+			return nil, ErrCorrupted
+		}
+		ids = append(ids, id)
+		id = pgid(binary.LittleEndian.Uint64(buf[1:]))
+	}
+	return ids, nil
+}
+
+// writeFreelist spreads free over the given chain of pages,
+// there is always at least one page even when free is empty
+func (db *DB) writeFreelist(listIDs []pgid, free []pgid) error {
+	for i, id := range listIDs {
+		chunk := free[min(i*freelistCapacity, len(free)):min((i+1)*freelistCapacity, len(free))]
+		buf := make([]byte, PageSize)
+		buf[0] = pageTypeFreelist
+		if i+1 < len(listIDs) {
+			binary.LittleEndian.PutUint64(buf[1:], uint64(listIDs[i+1]))
+		}
+		binary.LittleEndian.PutUint16(buf[9:], uint16(len(chunk)))
+		for j, freeID := range chunk {
+			binary.LittleEndian.PutUint64(buf[freelistHeaderSize+j*8:], uint64(freeID))
+		}
+		if _, err := db.file.WriteAt(buf, int64(id)*PageSize); err != nil {
+			return fmt.Errorf("kvstore: failed to write freelist page %d: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package kvstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestFreelistReusedAfterReopen(t *testing.T) {
+	db, path := openTestDB(t)
+	value := bytes.Repeat([]byte{'v'}, 2*PageSize)
+
+	fill := func() {
+		t.Helper()
+		err := db.Update(func(tx *Tx) error {
+			for i := range 300 {
+				if err := tx.Put(testKey(i), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	fill()
+	err := db.Update(func(tx *Tx) error {
+		for i := range 300 {
+			if err := tx.Delete(testKey(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := db.meta.pageCount
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	if len(db.free) == 0 {
+		t.Fatal("no free pages after reopen")
+	}
+
+	// the same entries again fit in the pages freed before the reopen
+	fill()
+	if db.meta.pageCount > pages {
+		t.Errorf("file grew from %d to %d pages, want the free pages reused", pages, db.meta.pageCount)
+	}
+	err = db.View(func(tx *Tx) error {
+		for i := range 300 {
+			if got, err := tx.Get(testKey(i)); err != nil || !bytes.Equal(got, value) {
+				t.Fatalf("get %s: %d bytes, %v", testKey(i), len(got), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFallsBackToOtherMetaPage(t *testing.T) {
+	db, path := openTestDB(t)
+	put := func(key string) {
+		t.Helper()
+		if err := db.Update(func(tx *Tx) error { return tx.Put([]byte(key), []byte(key)) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("first")
+	put("second")
+	// the last commit wrote the meta page of its txid
+	slot := db.meta.txid % 2
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn write of that meta page
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.WriteAt([]byte{0xff, 0xff}, int64(slot)*PageSize+40); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	err = db.View(func(tx *Tx) error {
+		if got, _ := tx.Get([]byte("first")); string(got) != "first" {
+			t.Errorf("first is %q after the fallback", got)
+		}
+		if got, _ := tx.Get([]byte("second")); got != nil {
+			t.Errorf("second is %q, want the commit before it", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both meta pages broken is not a store
+	file, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte{0xff, 0xff}, int64(1-slot)*PageSize+40)
+	file.Close()
+	if db, err := Open(path); err == nil {
+		db.Close()
+		t.Error("opening a store without a valid meta page succeeded")
+	}
+}
+
+func TestReadOnlyTransaction(t *testing.T) {
+	db, _ := openTestDB(t)
+
+	err := db.View(func(tx *Tx) error { return tx.Put([]byte("k"), nil) })
+	if err != ErrReadOnly {
+		t.Errorf("put in View got %v, want ErrReadOnly", err)
+	}
+
+	// an Update that fails keeps nothing
+	db.Update(func(tx *Tx) error {
+		tx.Put([]byte("k"), []byte("v"))
+		return os.ErrInvalid
+	})
+	db.View(func(tx *Tx) error {
+		if got, _ := tx.Get([]byte("k")); got != nil {
+			t.Errorf("k is %q after a failed update", got)
+		}
+		return nil
+	})
+}
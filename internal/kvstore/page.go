@@ -0,0 +1,207 @@
+package kvstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// pgid is the index of a page in the file, page 0 and 1 hold the meta pages
+// so 0 is free to mean "no page"
+type pgid uint64
+
+const (
+	PageSize = 4096
+
+	magic   = 0x434b5653 // "CKVS"
+	version = 1
+
+	pageTypeLeaf     byte = 1
+	pageTypeBranch   byte = 2
+	pageTypeOverflow byte = 3
+	pageTypeFreelist byte = 4
+
+	// MaxKeySize and maxInlineSize keep every entry well below a quarter page,
+	// so a node that overflows a page can always be split in two that fit
+	MaxKeySize    = 512
+	maxInlineSize = 768
+
+	leafHeaderSize     = 3  // type, count
+	leafEntryHeader    = 7  // flags, key length, value length
+	branchHeaderSize   = 11 // type, count, first child
+	branchEntryHeader  = 10 // key length, child
+	overflowHeaderSize = 11 // type, next, length
+	freelistHeaderSize = 11 // type, next, count
+	overflowRefSize    = 12 // first page, total length
+
+	overflowCapacity = PageSize - overflowHeaderSize
+	freelistCapacity = (PageSize - freelistHeaderSize) / 8
+
+	// leaf entry flag for a value stored in a chain of overflow pages
+	flagOverflow byte = 1
+)
+
+var ErrCorrupted = errors.New("kvstore: corrupted page")
+
+// meta is the root of a committed state, two copies are written alternately
+// so a torn meta write falls back to the previous commit
+type meta struct {
+	root      pgid
+	freelist  pgid
+	pageCount pgid
+	txid      uint64
+}
+
+func (m meta) encode() []byte {
+	buf := make([]byte, PageSize)
+	binary.LittleEndian.PutUint32(buf[0:], magic)
+	binary.LittleEndian.PutUint32(buf[4:], version)
+	binary.LittleEndian.PutUint32(buf[8:], PageSize)
+	binary.LittleEndian.PutUint64(buf[12:], uint64(m.root))
+	binary.LittleEndian.PutUint64(buf[20:], uint64(m.freelist))
+	binary.LittleEndian.PutUint64(buf[28:], uint64(m.pageCount))
+	binary.LittleEndian.PutUint64(buf[36:], m.txid)
+	binary.LittleEndian.PutUint32(buf[44:], crc32.ChecksumIEEE(buf[:44]))
+	return buf
+}
+
+func decodeMeta(buf []byte) (meta, bool) {
+	if len(buf) < 48 ||
+		binary.LittleEndian.Uint32(buf[0:]) != magic ||
+		binary.LittleEndian.Uint32(buf[4:]) != version ||
+		binary.LittleEndian.Uint32(buf[8:]) != PageSize ||
+		binary.LittleEndian.Uint32(buf[44:]) != crc32.ChecksumIEEE(buf[:44]) {
+		return meta{}, false
+	}
+
+	return meta{
+		root:      pgid(binary.LittleEndian.Uint64(buf[12:])),
+		freelist:  pgid(binary.LittleEndian.Uint64(buf[20:])),
+		pageCount: pgid(binary.LittleEndian.Uint64(buf[28:])),
+		txid:      binary.LittleEndian.Uint64(buf[36:]),
+	}, true
+}
+
+// node is the decoded form of a leaf or branch page.
+// A branch with n keys has n+1 children, keys[i] is the smallest key of children[i+1].
+type node struct {
+	leaf     bool
+	keys     [][]byte
+	vals     [][]byte // leaf only, the overflow reference when flags[i] is set
+	flags    []byte   // leaf only
+	children []pgid   // branch only
+}
+
+func (n *node) size() int {
+	if n.leaf {
+		size := leafHeaderSize
+		for i := range n.keys {
+			size += leafEntryHeader + len(n.keys[i]) + len(n.vals[i])
+		}
+		return size
+	}
+
+	size := branchHeaderSize
+	for _, key := range n.keys {
+		size += branchEntryHeader + len(key)
+	}
+	return size
+}
+
+func (n *node) encode() []byte {
+	buf := make([]byte, PageSize)
+
+	if n.leaf {
+		buf[0] = pageTypeLeaf
+		binary.LittleEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+		off := leafHeaderSize
+		for i, key := range n.keys {
+			buf[off] = n.flags[i]
+			binary.LittleEndian.PutUint16(buf[off+1:], uint16(len(key)))
+			binary.LittleEndian.PutUint32(buf[off+3:], uint32(len(n.vals[i])))
+			off += leafEntryHeader
+			off += copy(buf[off:], key)
+			off += copy(buf[off:], n.vals[i])
+		}
+		return buf
+	}
+
+	buf[0] = pageTypeBranch
+	binary.LittleEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+	binary.LittleEndian.PutUint64(buf[3:], uint64(n.children[0]))
+	off := branchHeaderSize
+	for i, key := range n.keys {
+		binary.LittleEndian.PutUint16(buf[off:], uint16(len(key)))
+		off += 2
+		off += copy(buf[off:], key)
+		binary.LittleEndian.PutUint64(buf[off:], uint64(n.children[i+1]))
+		off += 8
+	}
+	return buf
+}
+
+func decodeNode(buf []byte) (*node, error) {
+	if len(buf) != PageSize {
+		return nil, ErrCorrupted
+	}
+
+	count := int(binary.LittleEndian.Uint16(buf[1:]))
+
+	switch buf[0] {
+	case pageTypeLeaf:
+		n := &node{
+			leaf:  true,
+			keys:  make([][]byte, 0, count),
+			vals:  make([][]byte, 0, count),
+			flags: make([]byte, 0, count),
+		}
+		off := leafHeaderSize
+		for range count {
+			if off+leafEntryHeader > PageSize {
+				return nil, ErrCorrupted
+			}
+			flags := buf[off]
+			klen := int(binary.LittleEndian.Uint16(buf[off+1:]))
+			vlen := int(binary.LittleEndian.Uint32(buf[off+3:]))
+			off += leafEntryHeader
+			if off+klen+vlen > PageSize {
+				return nil, ErrCorrupted
+			}
+			n.flags = append(n.flags, flags)
+			n.keys = append(n.keys, clone(buf[off:off+klen]))
+			n.vals = append(n.vals, clone(buf[off+klen:off+klen+vlen]))
+			off += klen + vlen
+		}
+		return n, nil
+
+	case pageTypeBranch:
+		n := &node{
+			keys:     make([][]byte, 0, count),
+			children: make([]pgid, 0, count+1),
+		}
+		n.children = append(n.children, pgid(binary.LittleEndian.Uint64(buf[3:])))
+		off := branchHeaderSize
+		for range count {
+			if off+2 > PageSize {
+				return nil, ErrCorrupted
+			}
+			klen := int(binary.LittleEndian.Uint16(buf[off:]))
+			off += 2
+			if off+klen+8 > PageSize {
+				return nil, ErrCorrupted
+			}
+			n.keys = append(n.keys, clone(buf[off:off+klen]))
+			off += klen
+			n.children = append(n.children, pgid(binary.LittleEndian.Uint64(buf[off:])))
+			off += 8
+		}
+		return n, nil
+
+	default:
+		return nil, ErrCorrupted
+	}
+}
+
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
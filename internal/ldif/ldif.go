@@ -0,0 +1,312 @@
+// Package ldif reads and writes the LDAP Data Interchange Format, RFC 2849.
+//
+// Only content records are supported, the format a directory is exported in.
+// A change record of changetype add is read like a content record.
+package ldif
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrNoRecord = errors.New("ldif: no record found")
+
+// Attr is one attribute value of a record, a multi-valued attribute has one Attr per value
+type Attr struct {
+	// Name is without its options, e.g. cn for cn;lang-de, it is read in lower case
+	Name  string
+	Value string
+}
+
+// Record is one entry, its dn and its attribute values in the order they were read
+type Record struct {
+	DN    string
+	Attrs []Attr
+}
+
+// Values returns every value of the attribute named name, ignoring case
+func (r Record) Values(name string) []string {
+	var values []string
+	for _, a := range r.Attrs {
+		if strings.EqualFold(a.Name, name) {
+			values = append(values, a.Value)
+		}
+	}
+	return values
+}
+
+// Value returns the first value of the attribute named name, or ""
+func (r Record) Value(name string) string {
+	if values := r.Values(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Add appends a value, an empty value is left out
+func (r *Record) Add(name, value string) {
+	if value != "" {
+		r.Attrs = append(r.Attrs, Attr{Name: name, Value: value})
+	}
+}
+
+// ReadAll reads every record of r
+func ReadAll(r io.Reader) ([]Record, error) {
+	lr := NewReader(r)
+	var records []Record
+	for {
+		rec, err := lr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// Reader reads the records of r one at a time, so a large export does not have to fit in memory
+type Reader struct {
+	r *bufio.Reader
+	// next is the line read ahead to see whether the line before it goes on
+	next      *numberedLine
+	num       int
+	read      int64
+	records   int
+	recordNum int
+	recordEnd int64
+}
+
+// numberedLine is one line of r, end is the offset just after it
+type numberedLine struct {
+	num  int
+	text string
+	end  int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Read returns the next record, or io.EOF after the last one. It returns ErrNoRecord when r has no record at all
+func (lr *Reader) Read() (Record, error) {
+	var rec *Record
+	for {
+		line, err := lr.unfolded()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Record{}, fmt.Errorf("ldif: %w", err)
+		}
+
+		if line.text == "" {
+			// a blank line ends the record
+			if rec != nil {
+				break
+			}
+			continue
+		}
+
+		name, value, err := parseLine(line.text)
+		if err != nil {
+			return Record{}, fmt.Errorf("ldif: line %d: %w", line.num, err)
+		}
+
+		switch {
+		case rec == nil && name == "version":
+			if value != "1" {
+				return Record{}, fmt.Errorf("ldif: line %d: unsupported version %q", line.num, value)
+			}
+		case rec == nil && name == "dn":
+			rec = &Record{DN: value}
+			lr.recordNum = line.num
+		case rec == nil:
+			return Record{}, fmt.Errorf("ldif: line %d: a record must start with dn", line.num)
+		case name == "changetype":
+			if !strings.EqualFold(value, "add") {
+				return Record{}, fmt.Errorf("ldif: line %d: changetype %s is not supported, only content records and changetype add", line.num, value)
+			}
+		case name == "control":
+			return Record{}, fmt.Errorf("ldif: line %d: controls are not supported", line.num)
+		default:
+			rec.Attrs = append(rec.Attrs, Attr{Name: name, Value: value})
+		}
+		lr.recordEnd = line.end
+	}
+
+	if rec == nil {
+		if lr.records == 0 {
+			return Record{}, ErrNoRecord
+		}
+		return Record{}, io.EOF
+	}
+	lr.records++
+	return *rec, nil
+}
+
+// Line is the line the record returned last begins on, the line of its dn
+func (lr *Reader) Line() int {
+	return lr.recordNum
+}
+
+// Offset is the offset in r just after the last line of the record returned last
+func (lr *Reader) Offset() int64 {
+	return lr.recordEnd
+}
+
+// unfolded returns the next line with its continuation lines, which start with one space.
+// Comments are skipped
+func (lr *Reader) unfolded() (numberedLine, error) {
+	for {
+		line, err := lr.physical()
+		if err != nil {
+			return numberedLine{}, err
+		}
+		for {
+			next, err := lr.physical()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return numberedLine{}, err
+			}
+			if !strings.HasPrefix(next.text, " ") {
+				lr.next = &next
+				break
+			}
+			line.text += next.text[1:]
+			line.end = next.end
+		}
+		// a comment can be folded as well
+		if !strings.HasPrefix(line.text, "#") {
+			return line, nil
+		}
+	}
+}
+
+// physical returns the next line of r without its line break
+func (lr *Reader) physical() (numberedLine, error) {
+	if lr.next != nil {
+		line := *lr.next
+		lr.next = nil
+		return line, nil
+	}
+
+	text, err := lr.r.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		return numberedLine{}, err
+	}
+	lr.num++
+	lr.read += int64(len(text))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if lr.num == 1 {
+		text = strings.TrimPrefix(text, "\uFEFF")
+	}
+	return numberedLine{num: lr.num, text: text, end: lr.read}, nil
+}
+
+// parseLine reads name: value, name:: base64 value. A value given by URL, name:< url, is refused
+func parseLine(line string) (name, value string, err error) {
+	name, value, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return "", "", fmt.Errorf("missing ':' in %q", line)
+	}
+	// attribute options like ;lang-de or ;binary are dropped
+	name, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(name)), ";")
+
+	switch {
+	case strings.HasPrefix(value, ":"):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+		if err != nil {
+			return "", "", fmt.Errorf("invalid base64 value of %s: %w", name, err)
+		}
+		return name, string(data), nil
+	case strings.HasPrefix(value, "<"):
+		return "", "", fmt.Errorf("the value of %s is a URL, which is not supported", name)
+	default:
+		return name, strings.TrimLeft(value, " "), nil
+	}
+}
+
+// maxLineLength is where lines are folded, RFC 2849 asks for at most 76 columns
+const maxLineLength = 76
+
+// Write writes the version line and the records, a value that is not
+// a safe string, e.g. one with non-ASCII characters, is base64 encoded
+func Write(w io.Writer, records ...Record) error {
+	lw := NewWriter(w)
+	for _, rec := range records {
+		lw.Write(rec)
+	}
+	return lw.Flush()
+}
+
+// Writer writes records one at a time like Write, the version line
+// is written before the first one
+type Writer struct {
+	bw *bufio.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("version: 1\n")
+	return &Writer{bw: bw}
+}
+
+// Write buffers a record, an error of the underlying writer is returned by Flush
+func (lw *Writer) Write(rec Record) {
+	lw.bw.WriteString("\n")
+	writeLine(lw.bw, "dn", rec.DN)
+	for _, a := range rec.Attrs {
+		writeLine(lw.bw, a.Name, a.Value)
+	}
+}
+
+func (lw *Writer) Flush() error {
+	if err := lw.bw.Flush(); err != nil {
+		return fmt.Errorf("ldif: %w", err)
+	}
+	return nil
+}
+
+func writeLine(w *bufio.Writer, name, value string) {
+	line := name + ": " + value
+	if !isSafeString(value) {
+		line = name + ":: " + base64.StdEncoding.EncodeToString([]byte(value))
+	}
+
+	// the values written are ASCII, so the line can be cut at any byte
+	limit := maxLineLength
+	for len(line) > limit {
+		w.WriteString(line[:limit] + "\n ")
+		line = line[limit:]
+		// the leading space of a continuation line counts towards its length
+		limit = maxLineLength - 1
+	}
+	w.WriteString(line + "\n")
+}
+
+// isSafeString reports whether value can be written as it is, RFC 2849 SAFE-STRING:
+// ASCII without NUL, CR and LF that does not start with a space, ':' or '<'.
+// A trailing space would be lost as well
+func isSafeString(value string) bool {
+	if value == "" {
+		return true
+	}
+	if value[0] == ' ' || value[0] == ':' || value[0] == '<' || value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == 0 || c == '\r' || c == '\n' || c >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,12 @@
+package repository
+
+import "github.com/Dwipasca/contact-management/internal/domain"
+
+// AuditRepository keeps the audit log, entries are only ever appended
+type AuditRepository interface {
+	// Append stores the entry under the next revision number
+	Append(entry domain.AuditEntry) error
+	GetByID(id int) (domain.AuditEntry, error)
+	// GetByContact returns the entries of one contact, oldest first
+	GetByContact(contactID int) ([]domain.AuditEntry, error)
+}
@@ -0,0 +1,43 @@
+package repository
+
+import "github.com/Dwipasca/contact-management/internal/domain"
+
+type AuditRepositoryImpl struct {
+	entries []domain.AuditEntry
+	nextID  int
+}
+
+func NewAuditRepository() *AuditRepositoryImpl {
+	return &AuditRepositoryImpl{
+		entries: []domain.AuditEntry{},
+		nextID:  1,
+	}
+}
+
+func (ar *AuditRepositoryImpl) Append(entry domain.AuditEntry) error {
+	entry.ID = ar.nextID
+	ar.entries = append(ar.entries, entry)
+	ar.nextID++
+	return nil
+}
+
+func (ar *AuditRepositoryImpl) GetByID(id int) (domain.AuditEntry, error) {
+	for _, entry := range ar.entries {
+		if entry.ID == id {
+			return entry, nil
+		}
+	}
+
+	return domain.AuditEntry{}, nil
+}
+
+func (ar *AuditRepositoryImpl) GetByContact(contactID int) ([]domain.AuditEntry, error) {
+	var result []domain.AuditEntry
+	for _, entry := range ar.entries {
+		if entry.ContactID == contactID {
+			result = append(result, entry)
+		}
+	}
+
+	return result, nil
+}
@@ -1,20 +1,47 @@
 package repository
 
-import "github.com/Dwipasca/contact-management/internal/domain"
+import (
+	"errors"
 
-type ContactRepository  interface {
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// ErrStoreLocked means another process has the store open,
+// the file and journal storages are used by one process at a time
+var ErrStoreLocked = errors.New("the store is in use by another process")
+
+// ContactRepository stores the contacts. Contacts in the trash are left out
+// of every read except GetByEmail and GetTrash.
+type ContactRepository interface {
 	GetAll() ([]domain.Contact, error)
 	GetByID(id int) (domain.Contact, error)
 	GetByName(name string) ([]domain.Contact, error)
+	// GetByEmail also finds contacts in the trash, their emails stay taken
+	// until they are purged so a restore never clashes with a newer contact
 	GetByEmail(email string) (domain.Contact, error)
+	// GetByLocation returns the contacts with an address in the city or in the country
+	GetByLocation(locality, countryCode string) ([]domain.Contact, error)
 
 	Save(contact domain.Contact) error
 	SaveAll(contacts []domain.Contact) error
 	Update(contact domain.Contact) error
+	// ImportAll saves created and updates updated as one change, when one of them fails
+	// the store is left as it was. A created contact with an ID keeps it, or fails with
+	// domain.ErrIDAlreadyExist when that ID is used, the others get the next IDs like SaveAll.
+	// It returns the created contacts with their IDs
+	ImportAll(created, updated []domain.Contact) ([]domain.Contact, error)
+	// Delete moves the contact to the trash
 	Delete(id int) error
 
-	ExportToJSON(filename string) error
-	ExportToCSV(filename string) error
-	ImportFromJSON(filename string) ([]domain.Contact, error)
-	ImportFromCSV(filename string) ([]domain.Contact, error)
-}
\ No newline at end of file
+	// GetTrash returns the contacts in the trash
+	GetTrash() ([]domain.Contact, error)
+	// Restore takes a contact out of the trash, it keeps its ID
+	Restore(id int) error
+	// Purge removes a contact in the trash for good
+	Purge(id int) error
+
+	// Each calls fn for every contact out of the trash in the order of their IDs until fn
+	// returns an error, which it returns. The stores on disk read the contacts a page at
+	// a time, so an export never holds all of them in memory, see ContactSource
+	Each(fn func(domain.Contact) error) error
+}
@@ -1,12 +1,16 @@
 package repository
 
 import (
+	"bytes"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/Dwipasca/contact-management/internal/domain"
 )
@@ -24,14 +28,19 @@ func NewContactRepository() *ContactRepositoryImpl {
 }
 
 func (cr *ContactRepositoryImpl) GetAll() ([]domain.Contact, error) {
-	return cr.contacts, nil
+	result := []domain.Contact{}
+	for _, ctc := range cr.contacts {
+		if !ctc.InTrash() {
+			result = append(result, ctc)
+		}
+	}
+
+	return result, nil
 }
 
 func (cr *ContactRepositoryImpl) GetByID(id int) (domain.Contact, error) {
-	for _, ctc := range cr.contacts {
-		if ctc.ID == id{
-			return ctc, nil
-		}
+	if idx := cr.findIndexIn(id, false); idx != -1 {
+		return cr.contacts[idx], nil
 	}
 
 	return domain.Contact{}, nil
@@ -39,8 +48,8 @@ func (cr *ContactRepositoryImpl) GetByID(id int) (domain.Contact, error) {
 
 func (cr *ContactRepositoryImpl) GetByName(name string) ([]domain.Contact, error) {
 	var result []domain.Contact
-	for _, ctc := range cr.contacts{
-		if ctc.Name == name {
+	for _, ctc := range cr.contacts {
+		if ctc.Name == name && !ctc.InTrash() {
 			result = append(result, ctc)
 		}
 	}
@@ -50,7 +59,7 @@ func (cr *ContactRepositoryImpl) GetByName(name string) ([]domain.Contact, error
 
 func (cr *ContactRepositoryImpl) GetByEmail(email string) (domain.Contact, error) {
 	for _, ctc := range cr.contacts {
-		if ctc.Email == email{
+		if ctc.HasEmail(email) {
 			return ctc, nil
 		}
 	}
@@ -58,8 +67,26 @@ func (cr *ContactRepositoryImpl) GetByEmail(email string) (domain.Contact, error
 	return domain.Contact{}, nil
 }
 
+func (cr *ContactRepositoryImpl) GetByLocation(locality, countryCode string) ([]domain.Contact, error) {
+	var result []domain.Contact
+	for _, ctc := range cr.contacts {
+		if ctc.InTrash() {
+			continue
+		}
+		for _, addr := range ctc.Addresses {
+			if addr.MatchesLocation(locality, countryCode) {
+				result = append(result, ctc)
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
 func (cr *ContactRepositoryImpl) Save(contact domain.Contact) error {
 	contact.ID = cr.nextID
+	contact.MarkCreated(time.Now().UTC())
 	cr.contacts = append(cr.contacts, contact)
 	cr.nextID++
 	return nil
@@ -74,6 +101,49 @@ func (cr *ContactRepositoryImpl) SaveAll(contacts []domain.Contact) error {
 	return nil
 }
 
+// ImportAll applies the whole batch to a copy of the store, so a failure changes nothing
+func (cr *ContactRepositoryImpl) ImportAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	prevContacts := slices.Clone(cr.contacts)
+	prevNextID := cr.nextID
+
+	saved, err := cr.importAll(created, updated)
+	if err != nil {
+		cr.contacts = prevContacts
+		cr.nextID = prevNextID
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (cr *ContactRepositoryImpl) importAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	// a kept id is never handed out again to a contact of the batch without one
+	for _, ctc := range created {
+		if ctc.ID != 0 && cr.findIndexByID(ctc.ID) != -1 {
+			return nil, fmt.Errorf("failed to save contact %s: %w", ctc.Name, domain.ErrIDAlreadyExist)
+		}
+		cr.nextID = max(cr.nextID, ctc.ID+1)
+	}
+
+	now := time.Now().UTC()
+	saved := make([]domain.Contact, len(created))
+	for i, ctc := range created {
+		if ctc.ID == 0 {
+			ctc.ID = cr.nextID
+			cr.nextID++
+		}
+		ctc.MarkCreated(now)
+		cr.contacts = append(cr.contacts, ctc)
+		saved[i] = ctc
+	}
+
+	for _, ctc := range updated {
+		if err := cr.Update(ctc); err != nil {
+			return nil, fmt.Errorf("failed to update contact %d: %w", ctc.ID, err)
+		}
+	}
+	return saved, nil
+}
+
 func (cr *ContactRepositoryImpl) findIndexByID(id int) int {
 	for idx, ctc := range cr.contacts {
 		if ctc.ID == id {
@@ -83,139 +153,393 @@ func (cr *ContactRepositoryImpl) findIndexByID(id int) int {
 	return -1 // not found
 }
 
+// findIndexIn finds a contact in the trash or out of it
+func (cr *ContactRepositoryImpl) findIndexIn(id int, trash bool) int {
+	idx := cr.findIndexByID(id)
+	if idx == -1 || cr.contacts[idx].InTrash() != trash {
+		return -1
+	}
+	return idx
+}
+
 func (cr *ContactRepositoryImpl) Update(updated domain.Contact) error {
-	idx := cr.findIndexByID(updated.ID)
+	idx := cr.findIndexIn(updated.ID, false)
 	if idx == -1 {
 		return errors.New("contact is not found")
 	}
+	if err := updated.MarkUpdated(cr.contacts[idx], time.Now().UTC()); err != nil {
+		return err
+	}
 	cr.contacts[idx] = updated
 	return nil
 }
 
 func (cr *ContactRepositoryImpl) Delete(id int) error {
-	idx := cr.findIndexByID(id)
+	idx := cr.findIndexIn(id, false)
 	if idx == -1 {
 		return errors.New("contact is not found")
 	}
-	cr.contacts = append(cr.contacts[:idx], cr.contacts[idx+1:]... )
+	cr.contacts[idx].DeletedAt = time.Now().UTC()
 	return nil
 }
 
-func (cr *ContactRepositoryImpl) ExportToJSON(filename string) error {
+func (cr *ContactRepositoryImpl) GetTrash() ([]domain.Contact, error) {
+	var result []domain.Contact
+	for _, ctc := range cr.contacts {
+		if ctc.InTrash() {
+			result = append(result, ctc)
+		}
+	}
 
-	// Convert contacts slice into JSON format
-	// "" means no prefix, "  " means 2-space indentation
-	data, err := json.MarshalIndent(cr.contacts, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal contacts to JSON: %w", err)
+	return result, nil
+}
+
+func (cr *ContactRepositoryImpl) Restore(id int) error {
+	idx := cr.findIndexIn(id, true)
+	if idx == -1 {
+		return errors.New("contact is not found in the trash")
 	}
+	cr.contacts[idx].DeletedAt = time.Time{}
+	return nil
+}
 
-	// Write the JSON data to the specified file with 0644 permissions
-	// 0644 = owner can read/write, others can only read
-	if err := os.WriteFile(filename, data, 0644); err != nil {
-		return fmt.Errorf("failed to write to file %s: %w", filename, err)
+func (cr *ContactRepositoryImpl) Purge(id int) error {
+	idx := cr.findIndexIn(id, true)
+	if idx == -1 {
+		return errors.New("contact is not found in the trash")
+	}
+	cr.contacts = append(cr.contacts[:idx], cr.contacts[idx+1:]...)
+	return nil
+}
+
+func (cr *ContactRepositoryImpl) Each(fn func(domain.Contact) error) error {
+	for _, ctc := range cr.contacts {
+		if ctc.InTrash() {
+			continue
+		}
+		if err := fn(ctc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ContactSource calls fn for every contact it has until fn returns an error, which it returns.
+// ContactRepository.Each is one, a file is written from it without holding every contact in memory
+type ContactSource func(fn func(domain.Contact) error) error
+
+// ContactSlice is the ContactSource of contacts that are in memory already
+func ContactSlice(contacts []domain.Contact) ContactSource {
+	return func(fn func(domain.Contact) error) error {
+		for _, ctc := range contacts {
+			if err := fn(ctc); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+}
 
+// WriteContactsJSON writes the contacts of source to a JSON file in the format read by ReadJSONRows.
+// The file is written a contact at a time and only replaces filename once it is complete
+func WriteContactsJSON(filename string, source ContactSource) error {
+	err := writeFileStream(filename, func(w io.Writer) error {
+		// the same layout as json.MarshalIndent of the whole list with 2-space indentation
+		sep := "[\n  "
+		err := source(func(ctc domain.Contact) error {
+			data, err := json.MarshalIndent(ctc, "  ", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal contact %d to JSON: %w", ctc.ID, err)
+			}
+			if _, err := io.WriteString(w, sep); err != nil {
+				return err
+			}
+			sep = ",\n  "
+			_, err = w.Write(data)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		end := "\n]\n"
+		if sep == "[\n  " {
+			end = "[]\n"
+		}
+		_, err = io.WriteString(w, end)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %w", filename, err)
+	}
 	return nil
 }
 
-func (cr *ContactRepositoryImpl) ExportToCSV(filename string) error {
-	// create the file to write csv data
-	file, err := os.Create(filename)
+// WriteContactsCSV writes the contacts of source to a CSV file in one of CSVDialects,
+// every dialect is read back by ReadCSVRows. The columns of Google depend on the
+// contacts, so source is read twice for it
+func WriteContactsCSV(filename, dialect string, source ContactSource) error {
+	var layout csvLayout
+	switch dialect {
+	case CSVNative:
+		layout = nativeCSV()
+	case CSVGoogle:
+		emails, phones, addresses := 1, 1, 1
+		err := source(func(ctc domain.Contact) error {
+			emails = max(emails, len(ctc.EmailList()))
+			phones = max(phones, len(ctc.PhoneList()))
+			addresses = max(addresses, len(ctc.Addresses))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		layout = googleCSV(emails, phones, addresses)
+	case CSVOutlook:
+		layout = outlookCSV()
+	default:
+		return fmt.Errorf("unknown CSV dialect %q", dialect)
+	}
+
+	return writeFileStream(filename, func(w io.Writer) error {
+		// initialize csv writer
+		writer := csv.NewWriter(w)
+
+		// write header
+		if err := writer.Write(layout.header); err != nil {
+			return fmt.Errorf("failed to write header: %w", err)
+		}
+
+		// write data rows
+		err := source(func(ctc domain.Contact) error {
+			if err := writer.Write(layout.record(ctc)); err != nil {
+				return fmt.Errorf("failed to write record for ID %d: %w", ctc.ID, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("failed to flush CSV writer: %w", err)
+		}
+		return nil
+	})
+}
+
+// ImportRow is a contact read from one row of a file, Err is set when the row
+// can not be read into a contact. The contact still has to be validated
+type ImportRow struct {
+	// Line is the line of the file the row starts on
+	Line int
+	// Text is the row as written in the file, a JSON object is compacted to one line
+	Text    string
+	Contact domain.Contact
+	Err     error
+	// End is the offset in the file just after the row, it tells how much of the file was read
+	End int64
+}
+
+// ReadJSONRows reads the contacts of a file written by WriteContactsJSON and calls fn with
+// each of them in the order of the file. The file is decoded an element at a time, so it
+// does not have to fit in memory. An error of fn stops the reading and is returned as it is
+func ReadJSONRows(filename string, fn func(ImportRow) error) error {
+
+	// open the json file
+	file, err := os.Open(filename)
 	if err != nil {
-		return fmt.Errorf("failed to create file contacts CSV: %w", err)
+		return fmt.Errorf("failed to read file %s: %w", filename, err)
 	}
-	//ensure file is closed after write the file
 	defer file.Close()
 
-	// initialize csv writer
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	lines := &lineCounter{r: file}
 
-	// write header
-	if err := writer.Write([]string{"ID", "Name", "Email", "Phone"}); err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	// the array is read an element at a time, so a bad contact fails its own row only
+	dec := json.NewDecoder(lines)
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return fmt.Errorf("file %s is empty", filename)
+	}
+	if err != nil || tok != json.Delim('[') {
+		return fmt.Errorf("failed to decode JSON: expected an array of contacts")
 	}
 
-	// write data rows
-	for _, ctc := range cr.contacts {
-		record := []string{
-			strconv.Itoa(ctc.ID),
-			ctc.Name,
-			ctc.Email,
-			ctc.Phone,
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("failed to decode JSON: %w", err)
+		}
+
+		// the element ends where the decoder is, so it starts len(raw) bytes earlier
+		end := dec.InputOffset()
+		row := ImportRow{Line: lines.lineAt(end - int64(len(raw))), End: end}
+		var compact bytes.Buffer
+		if json.Compact(&compact, raw) == nil {
+			row.Text = compact.String()
+		}
+		if err := json.Unmarshal(raw, &row.Contact); err != nil {
+			row.Err = fmt.Errorf("failed to decode JSON: %w", err)
 		}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("failed to write record for ID %d: %w", ctc.ID, err)
+		// old files only have the flat Email and Phone fields
+		row.Contact.Normalize()
+		if err := fn(row); err != nil {
+			return err
 		}
 	}
 
-	if err := writer.Error(); err != nil {
-		return fmt.Errorf("failed to flush CSV writer: %w", err)
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("failed to decode JSON: %w", err)
 	}
 
 	return nil
 }
 
-func (cr *ContactRepositoryImpl) ImportFromJSON(filename string) ([]domain.Contact, error) {
-	
-	// read data from json file
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file %s: %w", filename, err)
-	}
+// lineCounter tells the line of an offset of the file read through it. It remembers
+// the line breaks the reader is ahead of the decoder only, they are dropped once
+// an offset past them was asked for
+type lineCounter struct {
+	r      io.Reader
+	read   int64
+	breaks []int64
+	// line is the line of the first of breaks
+	line int
+}
 
-	if len(data) == 0 {
-		return nil, fmt.Errorf("file %s is empty", filename)
+func (lc *lineCounter) Read(p []byte) (int, error) {
+	n, err := lc.r.Read(p)
+	for i, b := range p[:n] {
+		if b == '\n' {
+			lc.breaks = append(lc.breaks, lc.read+int64(i))
+		}
 	}
+	lc.read += int64(n)
+	return n, err
+}
 
-	// temporary slice
-	var dataFromJSON []domain.Contact
-	// decode json data into temporary slice
-	if err := json.Unmarshal(data, &dataFromJSON); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON: %w", err)
+// lineAt returns the line of offset, offsets must not go backwards
+func (lc *lineCounter) lineAt(offset int64) int {
+	passed := 0
+	for passed < len(lc.breaks) && lc.breaks[passed] < offset {
+		passed++
 	}
+	lc.line += passed
+	lc.breaks = lc.breaks[passed:]
+	return lc.line + 1
+}
 
-	cr.SaveAll(dataFromJSON)
-
-	return dataFromJSON, nil
+// CSVImport is what ReadCSVRows read from a file besides its rows
+type CSVImport struct {
+	// Dialect is the dialect told by the header, it is empty when a profile was used
+	Dialect string
+	Profile string
+	// Unmapped are the columns that hold values but are not read into a contact
+	Unmapped []string
 }
 
-func (cr *ContactRepositoryImpl) ImportFromCSV(filename string) ([]domain.Contact, error) {
+// ReadCSVRows reads a .csv file with the columns of profile, or of the dialect told by
+// the header row when profile is nil, and calls fn with every row in the order of the file.
+// The file is read a row at a time. An error of fn stops the reading and is returned as it is
+func ReadCSVRows(filename string, profile *CSVProfile, fn func(ImportRow) error) (CSVImport, error) {
 	// open the csv file
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return CSVImport{}, err
 	}
 	// make sure to file is closed after the function is finished
 	defer file.Close()
 
-	// create new csv reader
-	reader := csv.NewReader(file)
-	// read all the rows in csv file
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
+	reader := newCSVReader(file)
+	var header []string
+	if profile == nil || !profile.NoHeader {
+		header, err = reader.Read()
+		if err == io.EOF {
+			return CSVImport{}, fmt.Errorf("file %s is empty", filename)
+		}
+		if err != nil {
+			return CSVImport{}, err
+		}
+	}
+	columns := newCSVColumns(header)
+
+	var result CSVImport
+	var mappings []csvMapping
+	if profile != nil {
+		result.Profile = profile.Name
+		if mappings, err = mapCSVProfile(*profile, columns); err != nil {
+			return CSVImport{}, err
+		}
+	} else {
+		if result.Dialect, err = DetectCSVDialect(header); err != nil {
+			return CSVImport{}, err
+		}
+		if result.Dialect == CSVNative {
+			// the ID column is read too, the preserve id policy of an import keeps it
+			for i := range min(len(header), 10) {
+				columns.used[i] = true
+			}
+		}
 	}
 
-	var dataFromCSV []domain.Contact
-	for idx, dt := range records {
-		
-		// because it's a header then we can skip it
-		if idx == 0 {
-			continue
+	filled := map[int]bool{}
+	for {
+		dt, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return CSVImport{}, err
+		}
+		// a quoted cell can span lines, the line of the row is where it starts
+		line, _ := reader.FieldPos(0)
+		for i, cell := range dt {
+			filled[i] = filled[i] || strings.TrimSpace(cell) != ""
 		}
 
-		// insert data from csv to the temporary slice
-		dataFromCSV = append(dataFromCSV, domain.Contact{
-			// and make sure the id will be unique
-			ID: cr.nextID,
-			Name: dt[1],
-			Email: dt[2],
-			Phone: dt[3],
-		})
+		row := ImportRow{Line: line, Text: csvText(dt, reader.Comma), End: reader.InputOffset()}
+		switch {
+		case profile != nil:
+			row.Contact = profileCSVContact(mappings, dt)
+		case result.Dialect == CSVGoogle:
+			row.Contact = googleCSVContact(columns, dt)
+		case result.Dialect == CSVOutlook:
+			row.Contact = outlookCSVContact(columns, dt)
+		default:
+			row.Contact, row.Err = nativeCSVContact(dt)
+		}
+
+		if err := fn(row); err != nil {
+			return CSVImport{}, err
+		}
+	}
+
+	result.Unmapped = columns.unmapped(filled)
+	return result, nil
+}
+
+// csvText writes the cells of a row back as one line of CSV
+func csvText(cells []string, comma rune) string {
+	var b strings.Builder
+	writer := csv.NewWriter(&b)
+	writer.Comma = comma
+	writer.Write(cells)
+	writer.Flush()
+	return strings.TrimRight(b.String(), "\r\n")
+}
+
+// formatCSVTime writes a timestamp as RFC 3339, a contact saved before
+// timestamps existed has none and gets an empty cell
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
+	return t.Format(time.RFC3339Nano)
+}
 
-	cr.SaveAll(dataFromCSV)
-	return dataFromCSV, nil
-}
\ No newline at end of file
+func parseCSVTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
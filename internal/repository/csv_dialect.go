@@ -0,0 +1,540 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// the CSV dialects a file is read and written in: the columns of this app,
+// of a Google Contacts export and of an Outlook export
+const (
+	CSVNative  = "native"
+	CSVGoogle  = "google"
+	CSVOutlook = "outlook"
+)
+
+// CSVDialects are the dialects WriteContactsCSV writes
+var CSVDialects = []string{CSVNative, CSVGoogle, CSVOutlook}
+
+var ErrUnrecognizedCSVHeader = errors.New("unrecognized CSV header, expected the columns of an export of this app, Google Contacts or Outlook")
+
+// csvColumns finds a cell of a row by the name of its column, ignoring case,
+// and remembers the columns it read so the others can be reported
+type csvColumns struct {
+	header []string
+	index  map[string]int
+	used   map[int]bool
+}
+
+func newCSVColumns(header []string) *csvColumns {
+	columns := &csvColumns{header: header, index: map[string]int{}, used: map[int]bool{}}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := columns.index[name]; !ok {
+			columns.index[name] = i
+		}
+	}
+	return columns
+}
+
+func (c *csvColumns) has(name string) bool {
+	_, ok := c.index[strings.ToLower(name)]
+	return ok
+}
+
+// get returns the first cell of the named columns that is not empty
+func (c *csvColumns) get(row []string, names ...string) string {
+	for _, name := range names {
+		i, ok := c.index[strings.ToLower(name)]
+		if !ok {
+			continue
+		}
+		c.used[i] = true
+		if i < len(row) {
+			if value := strings.TrimSpace(row[i]); value != "" {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// unmapped names the columns that hold a value in some row but were never read,
+// a column without a name in the header is called by its number
+func (c *csvColumns) unmapped(filled map[int]bool) []string {
+	last := -1
+	for i := range filled {
+		last = max(last, i)
+	}
+
+	var names []string
+	for i := 0; i <= last; i++ {
+		if !filled[i] || c.used[i] {
+			continue
+		}
+		if i < len(c.header) && strings.TrimSpace(c.header[i]) != "" {
+			names = append(names, strings.TrimSpace(c.header[i]))
+		} else {
+			names = append(names, fmt.Sprintf("column %d", i+1))
+		}
+	}
+	return names
+}
+
+// DetectCSVDialect tells the dialect of a file from its header row
+func DetectCSVDialect(header []string) (string, error) {
+	columns := newCSVColumns(header)
+	switch {
+	case len(header) >= 4 && strings.EqualFold(strings.TrimSpace(header[0]), "ID") && strings.EqualFold(strings.TrimSpace(header[1]), "Name"):
+		return CSVNative, nil
+	case columns.has("E-mail 1 - Value") || columns.has("Phone 1 - Value"):
+		return CSVGoogle, nil
+	case columns.has("E-mail Address") || columns.has("Mobile Phone") || columns.has("Business Phone"):
+		return CSVOutlook, nil
+	}
+	return "", ErrUnrecognizedCSVHeader
+}
+
+// newCSVReader skips a byte order mark and finds the separator in the header row,
+// Outlook writes a semicolon in locales that use a decimal comma
+func newCSVReader(r io.Reader) *csv.Reader {
+	br := bufio.NewReader(r)
+	if bom, _ := br.Peek(3); string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+
+	// the header row is expected to fit in the buffer of the reader
+	head, _ := br.Peek(br.Size())
+	counts := map[byte]int{}
+	quoted := false
+	for _, c := range head {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == '\n' && !quoted {
+			break
+		} else if !quoted {
+			counts[c]++
+		}
+	}
+
+	reader := csv.NewReader(br)
+	if counts[';'] > counts[','] && counts[';'] > counts['\t'] {
+		reader.Comma = ';'
+	} else if counts['\t'] > counts[','] {
+		reader.Comma = '\t'
+	}
+	// files exported before Emails, Phones, Addresses, Tags and the timestamps
+	// existed have fewer columns, a Google export leaves trailing cells out
+	reader.FieldsPerRecord = -1
+	return reader
+}
+
+// googleSeparator separates the values of one cell, e.g. two emails with the same label
+const googleSeparator = " ::: "
+
+// googleLabel reads a label like "* Work", the star marks the primary value
+func googleLabel(text string) (label string, primary bool) {
+	label, primary = strings.CutPrefix(strings.TrimSpace(text), "*")
+	return strings.ToLower(strings.TrimSpace(label)), primary
+}
+
+// googleCSVContact reads a row of a Google Contacts export. Google has written two headers,
+// the old one with Given Name, Family Name and E-mail 1 - Type and the current one
+// with First Name, Last Name and E-mail 1 - Label, both are read
+func googleCSVContact(columns *csvColumns, row []string) domain.Contact {
+	ctc := domain.Contact{Name: columns.get(row, "Name")}
+	if ctc.Name == "" {
+		ctc.Name = strings.Join(strings.Fields(strings.Join([]string{
+			columns.get(row, "Name Prefix"),
+			columns.get(row, "First Name", "Given Name"),
+			columns.get(row, "Middle Name", "Additional Name"),
+			columns.get(row, "Last Name", "Family Name"),
+			columns.get(row, "Name Suffix"),
+		}, " ")), " ")
+	}
+	if ctc.Name == "" {
+		ctc.Name = columns.get(row, "File As", "Nickname", "Organization Name", "Organization 1 - Name")
+	}
+
+	for n := 1; columns.has(fmt.Sprintf("E-mail %d - Value", n)); n++ {
+		label, primary := googleLabel(columns.get(row, fmt.Sprintf("E-mail %d - Label", n), fmt.Sprintf("E-mail %d - Type", n)))
+		for _, addr := range strings.Split(columns.get(row, fmt.Sprintf("E-mail %d - Value", n)), googleSeparator) {
+			ctc.Emails = append(ctc.Emails, domain.Email{Label: label, Address: addr, Primary: primary})
+		}
+	}
+
+	for n := 1; columns.has(fmt.Sprintf("Phone %d - Value", n)); n++ {
+		label, primary := googleLabel(columns.get(row, fmt.Sprintf("Phone %d - Label", n), fmt.Sprintf("Phone %d - Type", n)))
+		for _, number := range strings.Split(columns.get(row, fmt.Sprintf("Phone %d - Value", n)), googleSeparator) {
+			ctc.Phones = append(ctc.Phones, domain.Phone{Label: label, Number: number, Primary: primary})
+		}
+	}
+
+	for n := 1; columns.has(fmt.Sprintf("Address %d - Formatted", n)) || columns.has(fmt.Sprintf("Address %d - Street", n)); n++ {
+		field := func(name string) string {
+			return columns.get(row, fmt.Sprintf("Address %d - %s", n, name))
+		}
+		label, _ := googleLabel(field("Label"))
+		if label == "" {
+			label, _ = googleLabel(field("Type"))
+		}
+		addr := domain.Address{
+			Label:       label,
+			Street:      strings.Join(strings.Fields(field("Street")+" "+field("Extended Address")+" "+field("PO Box")), " "),
+			Locality:    field("City"),
+			Region:      field("Region"),
+			PostalCode:  field("Postal Code"),
+			CountryCode: domain.CountryCodeFor(field("Country")),
+		}
+		if addr.IsEmpty() {
+			// an address typed in one field only has its formatted lines
+			addr.Street = strings.Join(strings.FieldsFunc(field("Formatted"), func(r rune) bool { return r == '\n' || r == '\r' }), ", ")
+		}
+		ctc.Addresses = append(ctc.Addresses, addr)
+	}
+
+	// the labels of Google are the groups of a contact, the system groups
+	// like "* myContacts" and "* starred" are left out
+	var tags []string
+	for _, group := range strings.Split(columns.get(row, "Labels", "Group Membership"), googleSeparator) {
+		if !strings.HasPrefix(strings.TrimSpace(group), "*") {
+			if tag := sanitizeTag(group); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	ctc.Tags = tags
+
+	ctc.Normalize()
+	return ctc
+}
+
+// googleTitle writes a label the way Google shows it, e.g. "Work", the primary one with a star
+func googleTitle(label string, primary bool) string {
+	if first, size := utf8.DecodeRuneInString(label); size > 0 {
+		label = string(unicode.ToUpper(first)) + label[size:]
+	}
+	if primary {
+		label = strings.TrimSpace("* " + label)
+	}
+	return label
+}
+
+// csvLayout is the header of a CSV dialect and the record a contact is written as under it
+type csvLayout struct {
+	header []string
+	record func(ctc domain.Contact) []string
+}
+
+// googleCSV writes contacts in the current header of Google Contacts, with the given number
+// of E-mail, Phone and Address columns, the most the contacts of the file need
+func googleCSV(emails, phones, addresses int) csvLayout {
+	header := []string{"First Name", "Middle Name", "Last Name", "Labels"}
+	for n := 1; n <= emails; n++ {
+		header = append(header, fmt.Sprintf("E-mail %d - Label", n), fmt.Sprintf("E-mail %d - Value", n))
+	}
+	for n := 1; n <= phones; n++ {
+		header = append(header, fmt.Sprintf("Phone %d - Label", n), fmt.Sprintf("Phone %d - Value", n))
+	}
+	addressFields := []string{"Label", "Formatted", "Street", "City", "PO Box", "Region", "Postal Code", "Country", "Extended Address"}
+	for n := 1; n <= addresses; n++ {
+		for _, field := range addressFields {
+			header = append(header, fmt.Sprintf("Address %d - %s", n, field))
+		}
+	}
+
+	record := func(ctc domain.Contact) []string {
+		name := splitName(ctc.Name)
+		labels := []string{"* myContacts"}
+		labels = append(labels, ctc.Tags...)
+		record := []string{name[1], "", name[0], strings.Join(labels, googleSeparator)}
+
+		for n := 0; n < emails; n++ {
+			if list := ctc.EmailList(); n < len(list) {
+				record = append(record, googleTitle(list[n].Label, list[n].Primary), list[n].Address)
+			} else {
+				record = append(record, "", "")
+			}
+		}
+		for n := 0; n < phones; n++ {
+			if list := ctc.PhoneList(); n < len(list) {
+				record = append(record, googleTitle(list[n].Label, list[n].Primary), list[n].Number)
+			} else {
+				record = append(record, "", "")
+			}
+		}
+		for n := 0; n < addresses; n++ {
+			if n >= len(ctc.Addresses) {
+				record = append(record, make([]string, len(addressFields))...)
+				continue
+			}
+			addr := ctc.Addresses[n]
+			country := ""
+			if addr.CountryCode != "" {
+				country = domain.CountryName(addr.CountryCode)
+			}
+			record = append(record, googleTitle(addr.Label, false), strings.Join(addr.Lines(), "\n"),
+				addr.Street, addr.Locality, "", addr.Region, addr.PostalCode, country, "")
+		}
+		return record
+	}
+	return csvLayout{header: header, record: record}
+}
+
+// outlookPhones are the phone columns of Outlook and the label each stands for
+var outlookPhones = []struct{ column, label string }{
+	{"Mobile Phone", "mobile"},
+	{"Home Phone", "home"},
+	{"Home Phone 2", "home"},
+	{"Business Phone", "work"},
+	{"Business Phone 2", "work"},
+	{"Company Main Phone", "work"},
+	{"Business Fax", "fax"},
+	{"Home Fax", "fax"},
+	{"Pager", "pager"},
+	{"Car Phone", "car"},
+	{"Other Phone", "other"},
+}
+
+// outlookAddresses are the address prefixes of Outlook and the label each stands for
+var outlookAddresses = []struct{ prefix, label string }{
+	{"Business", "work"},
+	{"Home", "home"},
+	{"Other", "other"},
+}
+
+// outlookEmails are the email columns of Outlook, which has no labels for them
+var outlookEmails = []string{"E-mail Address", "E-mail 2 Address", "E-mail 3 Address"}
+
+// outlookCSVContact reads a row of an Outlook export. Primary Phone repeats one
+// of the other numbers, it marks that number as the primary one
+func outlookCSVContact(columns *csvColumns, row []string) domain.Contact {
+	ctc := domain.Contact{Name: strings.Join(strings.Fields(strings.Join([]string{
+		columns.get(row, "Title"),
+		columns.get(row, "First Name"),
+		columns.get(row, "Middle Name"),
+		columns.get(row, "Last Name"),
+		columns.get(row, "Suffix"),
+	}, " ")), " ")}
+	if ctc.Name == "" {
+		ctc.Name = columns.get(row, "Name", "Display Name", "Nickname", "Company")
+	}
+
+	for _, column := range outlookEmails {
+		ctc.Emails = append(ctc.Emails, domain.Email{Address: columns.get(row, column)})
+	}
+
+	primary := columns.get(row, "Primary Phone")
+	for _, ph := range outlookPhones {
+		number := columns.get(row, ph.column)
+		isPrimary := number != "" && number == primary
+		if isPrimary {
+			primary = ""
+		}
+		ctc.Phones = append(ctc.Phones, domain.Phone{Label: ph.label, Number: number, Primary: isPrimary})
+	}
+	if primary != "" {
+		ctc.Phones = append([]domain.Phone{{Number: primary, Primary: true}}, ctc.Phones...)
+	}
+
+	for _, a := range outlookAddresses {
+		ctc.Addresses = append(ctc.Addresses, domain.Address{
+			Label:       a.label,
+			Street:      strings.Join(strings.Fields(columns.get(row, a.prefix+" Street")+" "+columns.get(row, a.prefix+" Street 2")+" "+columns.get(row, a.prefix+" Street 3")), " "),
+			Locality:    columns.get(row, a.prefix+" City"),
+			Region:      columns.get(row, a.prefix+" State"),
+			PostalCode:  columns.get(row, a.prefix+" Postal Code"),
+			CountryCode: domain.CountryCodeFor(columns.get(row, a.prefix+" Country/Region", a.prefix+" Country")),
+		})
+	}
+
+	// Outlook separates categories with a semicolon, some versions with a comma
+	var tags []string
+	for _, category := range strings.FieldsFunc(columns.get(row, "Categories"), func(r rune) bool { return r == ';' || r == ',' }) {
+		if tag := sanitizeTag(category); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	ctc.Tags = tags
+
+	ctc.Normalize()
+	return ctc
+}
+
+// outlookCSV writes contacts in the columns of an Outlook export. Outlook has a fixed
+// number of columns: three emails without labels, a phone goes to the column of
+// its label or else Other Phone, an address to Business, Home or Other. What does
+// not fit is left out
+func outlookCSV() csvLayout {
+	header := []string{"First Name", "Middle Name", "Last Name"}
+	header = append(header, outlookEmails...)
+	header = append(header, "Primary Phone")
+	for _, ph := range outlookPhones {
+		header = append(header, ph.column)
+	}
+	for _, a := range outlookAddresses {
+		header = append(header, a.prefix+" Street", a.prefix+" City", a.prefix+" State", a.prefix+" Postal Code", a.prefix+" Country/Region")
+	}
+	header = append(header, "Categories")
+	index := map[string]int{}
+	for i, column := range header {
+		index[strings.ToLower(column)] = i
+	}
+
+	record := func(ctc domain.Contact) []string {
+		record := make([]string, len(header))
+		set := func(column, value string) bool {
+			i := index[strings.ToLower(column)]
+			if record[i] != "" {
+				return false
+			}
+			record[i] = value
+			return true
+		}
+
+		name := splitName(ctc.Name)
+		set("First Name", name[1])
+		set("Last Name", name[0])
+
+		for i, em := range ctc.EmailList() {
+			if i < len(outlookEmails) {
+				set(outlookEmails[i], em.Address)
+			}
+		}
+
+		for _, ph := range ctc.PhoneList() {
+			if ph.Primary {
+				set("Primary Phone", ph.Number)
+			}
+			label := strings.ToLower(ph.Label)
+			if label == "cell" {
+				label = "mobile"
+			}
+			placed := false
+			for _, column := range outlookPhones {
+				if column.label == label && set(column.column, ph.Number) {
+					placed = true
+					break
+				}
+			}
+			if !placed {
+				set("Other Phone", ph.Number)
+			}
+		}
+
+		for _, addr := range ctc.Addresses {
+			// the column of the label first, then any free one
+			prefixes := []string{"Other", "Business", "Home"}
+			for _, a := range outlookAddresses {
+				if strings.EqualFold(addr.Label, a.label) || strings.EqualFold(addr.Label, a.prefix) {
+					prefixes = append([]string{a.prefix}, prefixes...)
+				}
+			}
+			for _, prefix := range prefixes {
+				country := ""
+				if addr.CountryCode != "" {
+					country = domain.CountryName(addr.CountryCode)
+				}
+				if record[index[strings.ToLower(prefix+" Street")]] == "" && record[index[strings.ToLower(prefix+" City")]] == "" {
+					set(prefix+" Street", addr.Street)
+					set(prefix+" City", addr.Locality)
+					set(prefix+" State", addr.Region)
+					set(prefix+" Postal Code", addr.PostalCode)
+					set(prefix+" Country/Region", country)
+					break
+				}
+			}
+		}
+
+		set("Categories", strings.Join(ctc.Tags, ";"))
+		return record
+	}
+	return csvLayout{header: header, record: record}
+}
+
+// nativeCSV writes contacts in the columns read by nativeCSVContact,
+// Email and Phone hold the primary values, Emails and Phones every labelled value
+func nativeCSV() csvLayout {
+	header := []string{"ID", "Name", "Email", "Phone", "Emails", "Phones", "Addresses", "Tags", "CreatedAt", "UpdatedAt"}
+	record := func(ctc domain.Contact) []string {
+		return []string{
+			strconv.Itoa(ctc.ID),
+			ctc.Name,
+			ctc.Email,
+			ctc.Phone,
+			domain.FormatEmails(ctc.EmailList()),
+			domain.FormatPhones(ctc.PhoneList()),
+			domain.FormatAddresses(ctc.Addresses),
+			domain.FormatTags(ctc.Tags),
+			formatCSVTime(ctc.CreatedAt),
+			formatCSVTime(ctc.UpdatedAt),
+		}
+	}
+	return csvLayout{header: header, record: record}
+}
+
+// nativeCSVContact reads a row written by nativeCSV, files exported before Emails,
+// Phones, Addresses, Tags and the timestamps existed have fewer columns
+func nativeCSVContact(dt []string) (domain.Contact, error) {
+	if len(dt) < 4 {
+		return domain.Contact{}, fmt.Errorf("expected at least 4 columns, got %d", len(dt))
+	}
+
+	ctc := domain.Contact{
+		Name:  dt[1],
+		Email: dt[2],
+		Phone: dt[3],
+	}
+	// an import only keeps the id with the preserve id policy, a cell that is not an id is left out
+	if id, err := strconv.Atoi(strings.TrimSpace(dt[0])); err == nil && id > 0 {
+		ctc.ID = id
+	}
+	if len(dt) > 4 {
+		ctc.Emails = domain.ParseEmails(dt[4])
+	}
+	if len(dt) > 5 {
+		ctc.Phones = domain.ParsePhones(dt[5])
+	}
+	if len(dt) > 6 {
+		ctc.Addresses = domain.ParseAddresses(dt[6])
+	}
+	if len(dt) > 7 {
+		ctc.Tags = domain.ParseTags(dt[7])
+	}
+	if len(dt) > 9 {
+		var err error
+		if ctc.CreatedAt, err = parseCSVTime(dt[8]); err != nil {
+			return domain.Contact{}, fmt.Errorf("invalid CreatedAt: %w", err)
+		}
+		if ctc.UpdatedAt, err = parseCSVTime(dt[9]); err != nil {
+			return domain.Contact{}, fmt.Errorf("invalid UpdatedAt: %w", err)
+		}
+	}
+	ctc.Normalize()
+	return ctc, nil
+}
+
+// ReadCSVHeader returns the first row of a .csv file
+func ReadCSVHeader(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := newCSVReader(file).Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("file %s is empty", filename)
+	}
+	return header, err
+}
@@ -0,0 +1,23 @@
+package repository
+
+import "testing"
+
+func TestGoogleTitle(t *testing.T) {
+	tests := []struct {
+		label   string
+		primary bool
+		want    string
+	}{
+		{"work", false, "Work"},
+		{"home", true, "* Home"},
+		{"", true, "*"},
+		{"", false, ""},
+		{"élan", false, "Élan"},
+		{"übersee", true, "* Übersee"},
+	}
+	for _, tt := range tests {
+		if got := googleTitle(tt.label, tt.primary); got != tt.want {
+			t.Errorf("googleTitle(%q, %v) = %q, want %q", tt.label, tt.primary, got, tt.want)
+		}
+	}
+}
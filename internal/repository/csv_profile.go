@@ -0,0 +1,273 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+var (
+	ErrInvalidCSVProfile  = errors.New("invalid CSV mapping profile")
+	ErrCSVProfileNotFound = errors.New("CSV mapping profile not found")
+)
+
+// CSVProfile maps the columns of a CSV file with any header onto the fields of a contact,
+// it is saved by its name and used by ImportFromCSV instead of the dialect of the header
+type CSVProfile struct {
+	Name string
+	// NoHeader is set for a file without a header row, its columns are only found by number
+	NoHeader bool
+	Fields   []CSVField
+}
+
+// CSVField fills one field of a contact from one or more columns
+type CSVField struct {
+	// Field is one of CSVProfileFields
+	Field string
+	// Columns are header names or column numbers starting at 1, the values of several
+	// columns are joined with Separator, a single space when it is empty
+	Columns   []string
+	Separator string `json:",omitempty"`
+	// Transforms are applied in order to the joined value, see CSVTransforms
+	Transforms []string `json:",omitempty"`
+	// Label is the label of an email, phone or address, the address fields
+	// with the same label make one address
+	Label string `json:",omitempty"`
+}
+
+// CSVProfileFields are the fields of a contact a column can be mapped to.
+// Every email and phone field adds one, the first one is the primary one
+var CSVProfileFields = []string{"name", "email", "phone", "street", "city", "region", "postal_code", "country", "tags"}
+
+// CSVTransforms change the value of a field:
+// trim collapses the spaces, lowercase and uppercase change the case and split_name
+// turns a full name written as "Lopez, Ana" into "Ana Lopez"
+var CSVTransforms = map[string]func(string) string{
+	"trim":      func(v string) string { return strings.Join(strings.Fields(v), " ") },
+	"lowercase": strings.ToLower,
+	"uppercase": strings.ToUpper,
+	"split_name": func(v string) string {
+		family, given, found := strings.Cut(v, ",")
+		if !found {
+			return v
+		}
+		return strings.TrimSpace(strings.TrimSpace(given) + " " + strings.TrimSpace(family))
+	},
+}
+
+var csvProfileNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// CheckCSVProfile makes sure a profile can be saved and used
+func CheckCSVProfile(profile CSVProfile) error {
+	if !csvProfileNameRegex.MatchString(profile.Name) {
+		return fmt.Errorf("%w, the name must use lowercase letters, digits, '.', '_' or '-'", ErrInvalidCSVProfile)
+	}
+	if len(profile.Fields) == 0 {
+		return fmt.Errorf("%w, it maps no column", ErrInvalidCSVProfile)
+	}
+	for _, f := range profile.Fields {
+		if !slices.Contains(CSVProfileFields, f.Field) {
+			return fmt.Errorf("%w, unknown field %q, use %s", ErrInvalidCSVProfile, f.Field, strings.Join(CSVProfileFields, ", "))
+		}
+		if len(f.Columns) == 0 {
+			return fmt.Errorf("%w, field %s has no column", ErrInvalidCSVProfile, f.Field)
+		}
+		for _, column := range f.Columns {
+			if strings.TrimSpace(column) == "" {
+				return fmt.Errorf("%w, field %s has an empty column", ErrInvalidCSVProfile, f.Field)
+			}
+			if n, err := strconv.Atoi(column); profile.NoHeader && (err != nil || n < 1) {
+				return fmt.Errorf("%w, column %q of field %s must be a number as the file has no header", ErrInvalidCSVProfile, column, f.Field)
+			}
+		}
+		for _, t := range f.Transforms {
+			if _, ok := CSVTransforms[t]; !ok {
+				return fmt.Errorf("%w, unknown transform %q of field %s", ErrInvalidCSVProfile, t, f.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// csvMapping is a field of a profile with its columns found in the header of a file
+type csvMapping struct {
+	CSVField
+	indexes []int
+}
+
+// mapCSVProfile finds the columns of the profile, a header name first and else a column number
+func mapCSVProfile(profile CSVProfile, columns *csvColumns) ([]csvMapping, error) {
+	mappings := make([]csvMapping, len(profile.Fields))
+	for i, f := range profile.Fields {
+		mappings[i].CSVField = f
+		for _, column := range f.Columns {
+			idx, ok := columns.index[strings.ToLower(strings.TrimSpace(column))]
+			if !ok {
+				n, err := strconv.Atoi(column)
+				if err != nil || n < 1 || (columns.header != nil && n > len(columns.header)) {
+					return nil, fmt.Errorf("%w, column %q of field %s is not in the file", ErrInvalidCSVProfile, column, f.Field)
+				}
+				idx = n - 1
+			}
+			columns.used[idx] = true
+			mappings[i].indexes = append(mappings[i].indexes, idx)
+		}
+	}
+	return mappings, nil
+}
+
+// profileCSVContact reads a row with the mapped columns of a profile
+func profileCSVContact(mappings []csvMapping, row []string) domain.Contact {
+	var ctc domain.Contact
+	addresses := map[string]*domain.Address{}
+	var labels []string
+	address := func(label string) *domain.Address {
+		if _, ok := addresses[label]; !ok {
+			addresses[label] = &domain.Address{Label: label}
+			labels = append(labels, label)
+		}
+		return addresses[label]
+	}
+
+	for _, m := range mappings {
+		var values []string
+		for _, idx := range m.indexes {
+			if idx < len(row) && strings.TrimSpace(row[idx]) != "" {
+				values = append(values, strings.TrimSpace(row[idx]))
+			}
+		}
+		separator := m.Separator
+		if separator == "" {
+			separator = " "
+		}
+		value := strings.Join(values, separator)
+		for _, t := range m.Transforms {
+			value = CSVTransforms[t](value)
+		}
+
+		switch m.Field {
+		case "name":
+			ctc.Name = value
+		case "email":
+			ctc.Emails = append(ctc.Emails, domain.Email{Label: m.Label, Address: value})
+		case "phone":
+			ctc.Phones = append(ctc.Phones, domain.Phone{Label: m.Label, Number: value})
+		case "street":
+			address(m.Label).Street = value
+		case "city":
+			address(m.Label).Locality = value
+		case "region":
+			address(m.Label).Region = value
+		case "postal_code":
+			address(m.Label).PostalCode = value
+		case "country":
+			address(m.Label).CountryCode = domain.CountryCodeFor(value)
+		case "tags":
+			for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' }) {
+				if tag := sanitizeTag(item); tag != "" {
+					ctc.Tags = append(ctc.Tags, tag)
+				}
+			}
+		}
+	}
+
+	for _, label := range labels {
+		ctc.Addresses = append(ctc.Addresses, *addresses[label])
+	}
+
+	ctc.Normalize()
+	return ctc
+}
+
+// CSVProfileStore saves every profile as a JSON file named after it in one folder,
+// so a profile can also be written by hand
+type CSVProfileStore struct {
+	dir string
+}
+
+func NewCSVProfileStore(dir string) *CSVProfileStore {
+	return &CSVProfileStore{dir: dir}
+}
+
+func (ps *CSVProfileStore) path(name string) string {
+	return filepath.Join(ps.dir, name+".json")
+}
+
+// GetAll returns the saved profiles sorted by name
+func (ps *CSVProfileStore) GetAll() ([]CSVProfile, error) {
+	entries, err := os.ReadDir(ps.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profiles folder %s: %w", ps.dir, err)
+	}
+
+	var profiles []CSVProfile
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !csvProfileNameRegex.MatchString(name) {
+			continue
+		}
+		profile, err := ps.GetByName(name)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, profile)
+	}
+	return profiles, nil
+}
+
+func (ps *CSVProfileStore) GetByName(name string) (CSVProfile, error) {
+	if !csvProfileNameRegex.MatchString(name) {
+		return CSVProfile{}, ErrCSVProfileNotFound
+	}
+
+	data, err := os.ReadFile(ps.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return CSVProfile{}, ErrCSVProfileNotFound
+	}
+	if err != nil {
+		return CSVProfile{}, fmt.Errorf("failed to read profile %s: %w", name, err)
+	}
+
+	var profile CSVProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return CSVProfile{}, fmt.Errorf("failed to decode profile %s: %w", name, err)
+	}
+	// the file name wins over a name edited in the file
+	profile.Name = name
+	return profile, nil
+}
+
+// Save writes a profile, a profile with the same name is replaced
+func (ps *CSVProfileStore) Save(profile CSVProfile) error {
+	if err := CheckCSVProfile(profile); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal profile: %w", err)
+	}
+	return writeFileAtomic(ps.path(profile.Name), data)
+}
+
+func (ps *CSVProfileStore) Delete(name string) error {
+	if _, err := ps.GetByName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(ps.path(name)); err != nil {
+		return fmt.Errorf("failed to delete profile %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// FileAuditRepository keeps the audit log in memory and appends every entry
+// to a file as one JSON line, the file is never rewritten
+type FileAuditRepository struct {
+	*AuditRepositoryImpl
+	file *os.File
+	size int64
+}
+
+func NewFileAuditRepository(path string) (*FileAuditRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create folder for %s: %w", path, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+
+	ar := &FileAuditRepository{
+		AuditRepositoryImpl: NewAuditRepository(),
+		file:                file,
+	}
+
+	if err := ar.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return ar, nil
+}
+
+func (ar *FileAuditRepository) Close() error {
+	return ar.file.Close()
+}
+
+// load reads every entry and leaves the file positioned at its end.
+// A last line without a newline was torn by a crash while it was appended,
+// it is cut off so the next entry starts on a line of its own.
+func (ar *FileAuditRepository) load() error {
+	data, err := os.ReadFile(ar.file.Name())
+	if err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end == -1 {
+			break
+		}
+		line := data[offset : offset+end]
+		offset += end + 1
+
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var entry domain.AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("failed to decode audit log entry: %w", err)
+		}
+		ar.entries = append(ar.entries, entry)
+		ar.nextID = max(ar.nextID, entry.ID+1)
+	}
+
+	if offset < len(data) {
+		if err := ar.file.Truncate(int64(offset)); err != nil {
+			return fmt.Errorf("failed to cut torn audit log entry: %w", err)
+		}
+	}
+
+	if _, err := ar.file.Seek(int64(offset), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek audit log: %w", err)
+	}
+	ar.size = int64(offset)
+
+	return nil
+}
+
+func (ar *FileAuditRepository) Append(entry domain.AuditEntry) error {
+	entry.ID = ar.nextID
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit log entry: %w", err)
+	}
+
+	line = append(line, '\n')
+	if _, err := ar.file.Write(line); err != nil {
+		ar.discardTail()
+		return fmt.Errorf("failed to append to audit log: %w", err)
+	}
+	if err := ar.file.Sync(); err != nil {
+		ar.discardTail()
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	ar.size += int64(len(line))
+
+	return ar.AuditRepositoryImpl.Append(entry)
+}
+
+// discardTail drops whatever part of a failed append made it to the file
+func (ar *FileAuditRepository) discardTail() {
+	if err := ar.file.Truncate(ar.size); err == nil {
+		ar.file.Seek(ar.size, io.SeekStart)
+	}
+}
@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// FileContactRepository keeps the contacts in memory like ContactRepositoryImpl
+// but writes the whole store to a JSON file after every change,
+// so the contacts survive a restart of the application.
+// The store is read once, so a lock file next to it keeps other processes out.
+type FileContactRepository struct {
+	*ContactRepositoryImpl
+	path string
+	lock *os.File
+}
+
+// fileStore is the layout of the data file on disk
+type fileStore struct {
+	NextID   int
+	Contacts []domain.Contact
+}
+
+func NewFileContactRepository(path string) (*FileContactRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create folder for %s: %w", path, err)
+	}
+
+	// the data file itself is replaced on every write, so the lock is taken on a file next to it
+	lock, err := lockStore(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	fr := &FileContactRepository{
+		ContactRepositoryImpl: NewContactRepository(),
+		path:                  path,
+		lock:                  lock,
+	}
+
+	if err := fr.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return fr, nil
+}
+
+// Close releases the lock on the store, every change is already written
+func (fr *FileContactRepository) Close() error {
+	return fr.lock.Close()
+}
+
+func (fr *FileContactRepository) load() error {
+	data, err := os.ReadFile(fr.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// first run, start with an empty store
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read data file %s: %w", fr.path, err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	var store fileStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("failed to decode data file %s: %w", fr.path, err)
+	}
+
+	fr.contacts = store.Contacts
+	if fr.contacts == nil {
+		fr.contacts = []domain.Contact{}
+	}
+
+	// never hand out an ID that is already used,
+	// even if the file was edited by hand
+	fr.nextID = max(store.NextID, 1)
+	for _, ctc := range fr.contacts {
+		fr.nextID = max(fr.nextID, ctc.ID+1)
+	}
+
+	return nil
+}
+
+// flush writes the store into a temporary file next to the data file
+// and then renames it over the data file. The rename is atomic,
+// so a crash leaves either the old or the new file but never a half-written one.
+func (fr *FileContactRepository) flush() error {
+	data, err := json.MarshalIndent(fileStore{
+		NextID:   fr.nextID,
+		Contacts: fr.contacts,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal contacts: %w", err)
+	}
+
+	return writeFileAtomic(fr.path, data)
+}
+
+// mutate runs fn against the in-memory store and persists the result.
+// If the store can not be written the in-memory state is rolled back,
+// so memory and disk never disagree.
+func (fr *FileContactRepository) mutate(fn func() error) error {
+	prevContacts := slices.Clone(fr.contacts)
+	prevNextID := fr.nextID
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if err := fr.flush(); err != nil {
+		fr.contacts = prevContacts
+		fr.nextID = prevNextID
+		return err
+	}
+
+	return nil
+}
+
+func (fr *FileContactRepository) Save(contact domain.Contact) error {
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.Save(contact)
+	})
+}
+
+func (fr *FileContactRepository) SaveAll(contacts []domain.Contact) error {
+	// one write for the whole batch instead of one per contact
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.SaveAll(contacts)
+	})
+}
+
+func (fr *FileContactRepository) Update(contact domain.Contact) error {
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.Update(contact)
+	})
+}
+
+func (fr *FileContactRepository) ImportAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	var saved []domain.Contact
+	err := fr.mutate(func() error {
+		var err error
+		saved, err = fr.ContactRepositoryImpl.ImportAll(created, updated)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (fr *FileContactRepository) Delete(id int) error {
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.Delete(id)
+	})
+}
+
+func (fr *FileContactRepository) Restore(id int) error {
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.Restore(id)
+	})
+}
+
+func (fr *FileContactRepository) Purge(id int) error {
+	return fr.mutate(func() error {
+		return fr.ContactRepositoryImpl.Purge(id)
+	})
+}
+
+// writeFileAtomic replaces path with data using a temporary file and a rename
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileStream(path, func(w io.Writer) error {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write temporary file: %w", err)
+		}
+		return nil
+	})
+}
+
+// writeFileStream replaces path with what write writes, like writeFileAtomic but
+// without holding the whole file in memory. When write fails path is left as it was
+func writeFileStream(path string, write func(w io.Writer) error) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", dir, err)
+	}
+
+	// the temporary file must live in the same folder,
+	// a rename across file systems is not atomic
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpName := tmp.Name()
+	// no-op once the rename succeeded
+	defer os.Remove(tmpName)
+
+	bw := bufio.NewWriter(tmp)
+	if err := write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	// make sure the bytes are on disk before the rename makes them visible
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return fmt.Errorf("failed to set permissions on temporary file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to replace file %s: %w", path, err)
+	}
+
+	return nil
+}
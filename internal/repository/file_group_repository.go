@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// FileGroupRepository keeps the groups in memory and writes them to a JSON file
+// after every change, the same way FileContactRepository does for contacts
+type FileGroupRepository struct {
+	*GroupRepositoryImpl
+	path string
+}
+
+// groupFileStore is the layout of the groups file on disk
+type groupFileStore struct {
+	NextID int
+	Groups []domain.Group
+}
+
+func NewFileGroupRepository(path string) (*FileGroupRepository, error) {
+	fr := &FileGroupRepository{
+		GroupRepositoryImpl: NewGroupRepository(),
+		path:                path,
+	}
+
+	if err := fr.load(); err != nil {
+		return nil, err
+	}
+
+	return fr, nil
+}
+
+func (fr *FileGroupRepository) load() error {
+	data, err := os.ReadFile(fr.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// first run, start without groups
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read groups file %s: %w", fr.path, err)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	var store groupFileStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("failed to decode groups file %s: %w", fr.path, err)
+	}
+
+	fr.groups = store.Groups
+	if fr.groups == nil {
+		fr.groups = []domain.Group{}
+	}
+
+	fr.nextID = max(store.NextID, 1)
+	for _, grp := range fr.groups {
+		fr.nextID = max(fr.nextID, grp.ID+1)
+	}
+
+	return nil
+}
+
+func (fr *FileGroupRepository) flush() error {
+	data, err := json.MarshalIndent(groupFileStore{
+		NextID: fr.nextID,
+		Groups: fr.groups,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal groups: %w", err)
+	}
+
+	return writeFileAtomic(fr.path, data)
+}
+
+// mutate runs fn and persists the result, rolling back memory when the write fails
+func (fr *FileGroupRepository) mutate(fn func() error) error {
+	prevGroups := slices.Clone(fr.groups)
+	prevNextID := fr.nextID
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	if err := fr.flush(); err != nil {
+		fr.groups = prevGroups
+		fr.nextID = prevNextID
+		return err
+	}
+
+	return nil
+}
+
+func (fr *FileGroupRepository) Save(group domain.Group) error {
+	return fr.mutate(func() error {
+		return fr.GroupRepositoryImpl.Save(group)
+	})
+}
+
+func (fr *FileGroupRepository) Update(group domain.Group) error {
+	return fr.mutate(func() error {
+		return fr.GroupRepositoryImpl.Update(group)
+	})
+}
+
+func (fr *FileGroupRepository) Delete(id int) error {
+	return fr.mutate(func() error {
+		return fr.GroupRepositoryImpl.Delete(id)
+	})
+}
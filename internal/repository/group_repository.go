@@ -0,0 +1,13 @@
+package repository
+
+import "github.com/Dwipasca/contact-management/internal/domain"
+
+type GroupRepository interface {
+	GetAll() ([]domain.Group, error)
+	GetByID(id int) (domain.Group, error)
+	GetByName(name string) (domain.Group, error)
+
+	Save(group domain.Group) error
+	Update(group domain.Group) error
+	Delete(id int) error
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+type GroupRepositoryImpl struct {
+	groups []domain.Group
+	nextID int
+}
+
+func NewGroupRepository() *GroupRepositoryImpl {
+	return &GroupRepositoryImpl{
+		groups: []domain.Group{},
+		nextID: 1,
+	}
+}
+
+func (gr *GroupRepositoryImpl) GetAll() ([]domain.Group, error) {
+	return gr.groups, nil
+}
+
+func (gr *GroupRepositoryImpl) GetByID(id int) (domain.Group, error) {
+	for _, grp := range gr.groups {
+		if grp.ID == id {
+			return grp, nil
+		}
+	}
+
+	return domain.Group{}, nil
+}
+
+// GetByName finds a group by its name, ignoring case
+func (gr *GroupRepositoryImpl) GetByName(name string) (domain.Group, error) {
+	for _, grp := range gr.groups {
+		if strings.EqualFold(grp.Name, name) {
+			return grp, nil
+		}
+	}
+
+	return domain.Group{}, nil
+}
+
+func (gr *GroupRepositoryImpl) Save(group domain.Group) error {
+	group.ID = gr.nextID
+	gr.groups = append(gr.groups, group)
+	gr.nextID++
+	return nil
+}
+
+func (gr *GroupRepositoryImpl) findIndexByID(id int) int {
+	for idx, grp := range gr.groups {
+		if grp.ID == id {
+			return idx
+		}
+	}
+	return -1 // not found
+}
+
+func (gr *GroupRepositoryImpl) Update(updated domain.Group) error {
+	idx := gr.findIndexByID(updated.ID)
+	if idx == -1 {
+		return errors.New("group is not found")
+	}
+	// the caller keeps its slices, later changes to them must not leak in here
+	updated.MemberIDs = slices.Clone(updated.MemberIDs)
+	updated.SubgroupIDs = slices.Clone(updated.SubgroupIDs)
+	gr.groups[idx] = updated
+	return nil
+}
+
+func (gr *GroupRepositoryImpl) Delete(id int) error {
+	idx := gr.findIndexByID(id)
+	if idx == -1 {
+		return errors.New("group is not found")
+	}
+	gr.groups = append(gr.groups[:idx], gr.groups[idx+1:]...)
+	return nil
+}
@@ -0,0 +1,480 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+const (
+	journalFileName  = "journal.log"
+	snapshotFileName = "snapshot.json"
+	lockFileName     = "lock"
+
+	// DefaultCompactThreshold is the journal size after which
+	// the journal is folded into a new snapshot
+	DefaultCompactThreshold = 4 << 20 // 4 MiB
+
+	// every record starts with the payload length and a CRC32 of the payload
+	journalHeaderSize = 8
+	// a bigger length can only come from a corrupted header
+	maxJournalRecordSize = 16 << 20
+	// every record is encoded as a JSON object starting with its Seq
+	journalRecordPrefix = `{"Seq":`
+)
+
+type journalOp string
+
+const (
+	journalOpSave   journalOp = "save"
+	journalOpUpdate journalOp = "update"
+	journalOpDelete journalOp = "delete"
+	// an import is one record, so a crash never replays half of it
+	journalOpImport journalOp = "import"
+)
+
+// journalRecord is one mutation appended to the journal
+type journalRecord struct {
+	Seq     uint64
+	Op      journalOp
+	Contact domain.Contact
+	ID      int
+	// Contacts are the new and changed contacts of an import
+	Contacts []domain.Contact `json:",omitempty"`
+}
+
+// journalSnapshot is the state of the store up to and including record Seq
+type journalSnapshot struct {
+	Seq      uint64
+	NextID   int
+	Contacts []domain.Contact
+}
+
+// JournalContactRepository keeps the contacts in memory and appends every change
+// as a record to a write-ahead journal instead of rewriting the whole store.
+// On startup the journal is replayed on top of the latest snapshot,
+// and once the journal grows past the threshold it is compacted into a new snapshot.
+// A lock file in the folder keeps other processes out while the store is open.
+type JournalContactRepository struct {
+	*ContactRepositoryImpl
+	dir              string
+	lock             *os.File
+	journal          *os.File
+	journalSize      int64
+	seq              uint64
+	compactThreshold int64
+}
+
+func NewJournalContactRepository(dir string, compactThreshold int64) (*JournalContactRepository, error) {
+	if compactThreshold <= 0 {
+		compactThreshold = DefaultCompactThreshold
+	}
+
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create journal folder %s: %w", dir, err)
+	}
+
+	lock, err := lockStore(filepath.Join(dir, lockFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	jr := &JournalContactRepository{
+		ContactRepositoryImpl: NewContactRepository(),
+		dir:                   dir,
+		lock:                  lock,
+		compactThreshold:      compactThreshold,
+	}
+
+	if err := jr.loadSnapshot(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	if err := jr.openJournal(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	return jr, nil
+}
+
+// Close closes the journal file and releases the lock, every record is already synced to disk
+func (jr *JournalContactRepository) Close() error {
+	err := jr.journal.Close()
+	if lockErr := jr.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+func (jr *JournalContactRepository) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(jr.dir, snapshotFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snap journalSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	jr.seq = snap.Seq
+	jr.nextID = max(snap.NextID, 1)
+	if snap.Contacts != nil {
+		jr.contacts = snap.Contacts
+	}
+
+	return nil
+}
+
+// openJournal replays the journal and opens it for appending.
+// A torn record can only be the last one written before a crash,
+// so the journal is truncated right before it.
+func (jr *JournalContactRepository) openJournal() error {
+	path := filepath.Join(jr.dir, journalFileName)
+	// every write goes to the end of the file, whatever the offset of this file handle
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	validSize, err := jr.replay(file)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat journal: %w", err)
+	}
+
+	if info.Size() != validSize {
+		if err := file.Truncate(validSize); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to truncate torn journal record: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+
+	jr.journal = file
+	jr.journalSize = validSize
+	return nil
+}
+
+// replay applies every intact record to the in-memory store
+// and returns the offset right after the last intact record.
+// A damaged record is only taken for a torn tail when no intact record follows it,
+// otherwise truncating would drop the records after it and replay fails instead.
+func (jr *JournalContactRepository) replay(file *os.File) (int64, error) {
+	reader := bufio.NewReader(file)
+	var offset int64
+
+	for {
+		payload, err := readJournalRecord(reader)
+		if errors.Is(err, io.EOF) {
+			return offset, nil
+		}
+
+		var rec journalRecord
+		if err == nil {
+			err = json.Unmarshal(payload, &rec)
+		}
+		if err != nil {
+			rest, readErr := io.ReadAll(io.NewSectionReader(file, offset, math.MaxInt64-offset))
+			if readErr != nil {
+				return 0, fmt.Errorf("failed to read journal: %w", readErr)
+			}
+			if !recordFollows(rest) {
+				// torn by a crash, nothing after offset is kept
+				return offset, nil
+			}
+			return 0, fmt.Errorf("journal %s is corrupted at offset %d and intact records follow, fix or remove it by hand: %w",
+				filepath.Join(jr.dir, journalFileName), offset, err)
+		}
+
+		// records up to the snapshot are already part of it,
+		// they are left behind when a crash hits between snapshot and truncate
+		if rec.Seq > jr.seq {
+			jr.applyRecord(rec)
+			jr.seq = rec.Seq
+		}
+
+		offset += int64(journalHeaderSize + len(payload))
+	}
+}
+
+// recordFollows reports whether an intact record starts anywhere in rest after its first byte.
+// A crash only tears the last record, so a damaged record with an intact one after it is corruption.
+func recordFollows(rest []byte) bool {
+	for i := 1; i+journalHeaderSize <= len(rest); i++ {
+		payload := rest[i+journalHeaderSize:]
+		// every record is a JSON object that starts with its Seq,
+		// which skips the checksum for almost every offset
+		if !bytes.HasPrefix(payload, []byte(journalRecordPrefix)) {
+			continue
+		}
+		length := binary.LittleEndian.Uint32(rest[i : i+4])
+		if int64(length) > int64(len(payload)) {
+			continue
+		}
+		if crc32.ChecksumIEEE(payload[:length]) == binary.LittleEndian.Uint32(rest[i+4:i+8]) {
+			return true
+		}
+	}
+	return false
+}
+
+func readJournalRecord(reader io.Reader) ([]byte, error) {
+	header := make([]byte, journalHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		// io.EOF means a clean end, io.ErrUnexpectedEOF a torn header
+		return nil, err
+	}
+
+	length := binary.LittleEndian.Uint32(header[0:4])
+	checksum := binary.LittleEndian.Uint32(header[4:8])
+	if length > maxJournalRecordSize {
+		return nil, errors.New("journal record is too large")
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, errors.New("journal record checksum mismatch")
+	}
+
+	return payload, nil
+}
+
+func (jr *JournalContactRepository) applyRecord(rec journalRecord) {
+	switch rec.Op {
+	case journalOpSave:
+		jr.contacts = append(jr.contacts, rec.Contact)
+		jr.nextID = max(jr.nextID, rec.Contact.ID+1)
+	case journalOpUpdate:
+		if idx := jr.findIndexByID(rec.Contact.ID); idx != -1 {
+			jr.contacts[idx] = rec.Contact
+		}
+	case journalOpDelete:
+		if idx := jr.findIndexByID(rec.ID); idx != -1 {
+			jr.contacts = append(jr.contacts[:idx], jr.contacts[idx+1:]...)
+		}
+	case journalOpImport:
+		for _, ctc := range rec.Contacts {
+			if idx := jr.findIndexByID(ctc.ID); idx != -1 {
+				jr.contacts[idx] = ctc
+				continue
+			}
+			jr.contacts = append(jr.contacts, ctc)
+			jr.nextID = max(jr.nextID, ctc.ID+1)
+		}
+	}
+}
+
+// append writes the records to the journal in a single write, syncs it
+// and only then applies them to the in-memory store
+func (jr *JournalContactRepository) append(records ...journalRecord) error {
+	var buf bytes.Buffer
+	seq := jr.seq
+
+	for i := range records {
+		seq++
+		records[i].Seq = seq
+
+		payload, err := json.Marshal(records[i])
+		if err != nil {
+			return fmt.Errorf("failed to encode journal record: %w", err)
+		}
+		// replay would take a bigger record for a corrupted one and drop it
+		if len(payload) > maxJournalRecordSize {
+			return fmt.Errorf("journal record of %d bytes is larger than %d bytes", len(payload), maxJournalRecordSize)
+		}
+
+		header := make([]byte, journalHeaderSize)
+		binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+		binary.LittleEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+		buf.Write(header)
+		buf.Write(payload)
+	}
+
+	if _, err := jr.journal.Write(buf.Bytes()); err != nil {
+		jr.discardTail()
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+
+	if err := jr.journal.Sync(); err != nil {
+		jr.discardTail()
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	jr.journalSize += int64(buf.Len())
+	jr.seq = seq
+	for _, rec := range records {
+		jr.applyRecord(rec)
+	}
+
+	if jr.journalSize >= jr.compactThreshold {
+		if err := jr.Compact(); err != nil {
+			// the change itself is safe in the journal, so it is not reported as failed.
+			// The journal stays past the threshold and the next change compacts again
+			log.Printf("journal compaction failed, retrying on the next change: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// discardTail drops whatever part of a failed batch made it to the file.
+// If this fails too, the next startup still truncates the torn record.
+func (jr *JournalContactRepository) discardTail() {
+	jr.journal.Truncate(jr.journalSize)
+}
+
+// Compact writes the current state to a new snapshot and empties the journal
+func (jr *JournalContactRepository) Compact() error {
+	data, err := json.Marshal(journalSnapshot{
+		Seq:      jr.seq,
+		NextID:   jr.nextID,
+		Contacts: jr.contacts,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(jr.dir, snapshotFileName), data); err != nil {
+		return err
+	}
+
+	// a crash before the truncate is harmless,
+	// replay skips records that are already in the snapshot
+	if err := jr.journal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	if err := jr.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+
+	jr.journalSize = 0
+	return nil
+}
+
+func (jr *JournalContactRepository) Save(contact domain.Contact) error {
+	contact.ID = jr.nextID
+	contact.MarkCreated(time.Now().UTC())
+	return jr.append(journalRecord{Op: journalOpSave, Contact: contact})
+}
+
+func (jr *JournalContactRepository) SaveAll(contacts []domain.Contact) error {
+	records := make([]journalRecord, 0, len(contacts))
+	now := time.Now().UTC()
+	for i, ctc := range contacts {
+		ctc.ID = jr.nextID + i
+		ctc.MarkCreated(now)
+		records = append(records, journalRecord{Op: journalOpSave, Contact: ctc})
+	}
+	return jr.append(records...)
+}
+
+func (jr *JournalContactRepository) Update(updated domain.Contact) error {
+	idx := jr.findIndexIn(updated.ID, false)
+	if idx == -1 {
+		return errors.New("contact is not found")
+	}
+	// the record carries the new version and timestamp, replay does not stamp again
+	if err := updated.MarkUpdated(jr.contacts[idx], time.Now().UTC()); err != nil {
+		return err
+	}
+	return jr.append(journalRecord{Op: journalOpUpdate, Contact: updated})
+}
+
+func (jr *JournalContactRepository) ImportAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	nextID := jr.nextID
+	for _, ctc := range created {
+		if ctc.ID != 0 && jr.findIndexByID(ctc.ID) != -1 {
+			return nil, fmt.Errorf("failed to save contact %s: %w", ctc.Name, domain.ErrIDAlreadyExist)
+		}
+		nextID = max(nextID, ctc.ID+1)
+	}
+
+	now := time.Now().UTC()
+	saved := make([]domain.Contact, len(created))
+	rec := journalRecord{Op: journalOpImport}
+	for i, ctc := range created {
+		if ctc.ID == 0 {
+			ctc.ID = nextID
+			nextID++
+		}
+		ctc.MarkCreated(now)
+		saved[i] = ctc
+		rec.Contacts = append(rec.Contacts, ctc)
+	}
+	for _, ctc := range updated {
+		idx := jr.findIndexIn(ctc.ID, false)
+		if idx == -1 {
+			return nil, fmt.Errorf("failed to update contact %d: contact is not found", ctc.ID)
+		}
+		if err := ctc.MarkUpdated(jr.contacts[idx], now); err != nil {
+			return nil, fmt.Errorf("failed to update contact %d: %w", ctc.ID, err)
+		}
+		rec.Contacts = append(rec.Contacts, ctc)
+	}
+
+	if err := jr.append(rec); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// Delete records the contact with its deletion time as an update,
+// a delete record purges the contact like it did before the trash existed
+func (jr *JournalContactRepository) Delete(id int) error {
+	idx := jr.findIndexIn(id, false)
+	if idx == -1 {
+		return errors.New("contact is not found")
+	}
+	trashed := jr.contacts[idx]
+	trashed.DeletedAt = time.Now().UTC()
+	return jr.append(journalRecord{Op: journalOpUpdate, Contact: trashed})
+}
+
+func (jr *JournalContactRepository) Restore(id int) error {
+	idx := jr.findIndexIn(id, true)
+	if idx == -1 {
+		return errors.New("contact is not found in the trash")
+	}
+	restored := jr.contacts[idx]
+	restored.DeletedAt = time.Time{}
+	return jr.append(journalRecord{Op: journalOpUpdate, Contact: restored})
+}
+
+func (jr *JournalContactRepository) Purge(id int) error {
+	if jr.findIndexIn(id, true) == -1 {
+		return errors.New("contact is not found in the trash")
+	}
+	return jr.append(journalRecord{Op: journalOpDelete, ID: id})
+}
@@ -0,0 +1,317 @@
+package repository
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// newTestJournal opens a journal in a new folder and saves n contacts, one record each
+func newTestJournal(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	jr, err := NewJournalContactRepository(dir, 0)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	for i := range n {
+		ctc := domain.Contact{Name: fmt.Sprintf("Contact %d", i), Email: fmt.Sprintf("contact%d@mail.com", i)}
+		if err := jr.Save(ctc); err != nil {
+			t.Fatalf("save contact %d: %v", i, err)
+		}
+	}
+	if err := jr.Close(); err != nil {
+		t.Fatalf("close journal: %v", err)
+	}
+
+	return dir
+}
+
+// recordOffsets returns the offset of every record in the journal file
+func recordOffsets(t *testing.T, data []byte) []int {
+	t.Helper()
+	var offsets []int
+	for offset := 0; offset < len(data); {
+		offsets = append(offsets, offset)
+		offset += journalHeaderSize + int(binary.LittleEndian.Uint32(data[offset:]))
+	}
+	return offsets
+}
+
+func TestJournalTruncatesTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the journal of 3 records as a crash could
+		damage func(data []byte, offsets []int) []byte
+		want   int
+	}{
+		{
+			name: "short header",
+			damage: func(data []byte, offsets []int) []byte {
+				return append(data, 0x10, 0x00, 0x00)
+			},
+			want: 3,
+		},
+		{
+			name: "short payload",
+			damage: func(data []byte, offsets []int) []byte {
+				return data[:len(data)-5]
+			},
+			want: 2,
+		},
+		{
+			name: "last record with a bad checksum",
+			damage: func(data []byte, offsets []int) []byte {
+				data[offsets[2]+journalHeaderSize+3] ^= 0xff
+				return data
+			},
+			want: 2,
+		},
+		{
+			name: "last record zeroed",
+			damage: func(data []byte, offsets []int) []byte {
+				clear(data[offsets[2]+journalHeaderSize:])
+				return data
+			},
+			want: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestJournal(t, 3)
+			path := filepath.Join(dir, journalFileName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets := recordOffsets(t, data)
+			// the journal is cut right after the last intact record
+			wantSize := int64(len(data))
+			if tt.want < len(offsets) {
+				wantSize = int64(offsets[tt.want])
+			}
+			if err := os.WriteFile(path, tt.damage(data, offsets), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			jr, err := NewJournalContactRepository(dir, 0)
+			if err != nil {
+				t.Fatalf("reopen journal: %v", err)
+			}
+			defer jr.Close()
+
+			contacts, _ := jr.GetAll()
+			if len(contacts) != tt.want {
+				t.Errorf("got %d contacts, want %d", len(contacts), tt.want)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != wantSize {
+				t.Errorf("journal is %d bytes after the truncate, want %d", info.Size(), wantSize)
+			}
+
+			// the journal keeps working after the truncate
+			if err := jr.Save(domain.Contact{Name: "After", Email: "after@mail.com"}); err != nil {
+				t.Fatalf("save after truncate: %v", err)
+			}
+		})
+	}
+}
+
+func TestJournalCompacts(t *testing.T) {
+	dir := t.TempDir()
+	// a record is about 100 bytes, the journal is folded every few changes
+	jr, err := NewJournalContactRepository(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := jr.Save(domain.Contact{Name: fmt.Sprintf("Contact %d", i), Email: fmt.Sprintf("contact%d@mail.com", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renamed, err := jr.GetByID(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed.Name = "Renamed"
+	if err := jr.Update(renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := jr.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := jr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("no snapshot after compaction: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(dir, journalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() >= 250 {
+		t.Errorf("journal is %d bytes, want it folded below the threshold", info.Size())
+	}
+
+	jr, err = NewJournalContactRepository(dir, 250)
+	if err != nil {
+		t.Fatalf("reopen journal: %v", err)
+	}
+	defer jr.Close()
+
+	contacts, _ := jr.GetAll()
+	if len(contacts) != 4 {
+		t.Fatalf("got %d contacts after reopen, want 4", len(contacts))
+	}
+	if got, _ := jr.GetByID(2); got.Name != "Renamed" {
+		t.Errorf("contact 2 is %q, want the update kept", got.Name)
+	}
+	// ids are not handed out twice after a compaction
+	if err := jr.Save(domain.Contact{Name: "Next", Email: "next@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := jr.GetByEmail("next@mail.com"); next.ID != 6 {
+		t.Errorf("next contact got id %d, want 6", next.ID)
+	}
+}
+
+func TestJournalSkipsRecordsInSnapshot(t *testing.T) {
+	dir := newTestJournal(t, 3)
+	path := filepath.Join(dir, journalFileName)
+	journal, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash between writing the snapshot and truncating the journal
+	jr, err := NewJournalContactRepository(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jr.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	jr.Close()
+	if err := os.WriteFile(path, journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jr, err = NewJournalContactRepository(dir, 0)
+	if err != nil {
+		t.Fatalf("reopen journal: %v", err)
+	}
+	defer jr.Close()
+	if contacts, _ := jr.GetAll(); len(contacts) != 3 {
+		t.Errorf("got %d contacts, want the 3 of the snapshot once", len(contacts))
+	}
+}
+
+func TestJournalCompactionFailureKeepsChange(t *testing.T) {
+	dir := t.TempDir()
+	jr, err := NewJournalContactRepository(dir, 250)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jr.Close()
+
+	// a folder in the way of the snapshot makes every compaction fail
+	snapshot := filepath.Join(dir, snapshotFileName)
+	if err := os.Mkdir(snapshot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5 {
+		if err := jr.Save(domain.Contact{Name: fmt.Sprintf("Contact %d", i), Email: fmt.Sprintf("contact%d@mail.com", i)}); err != nil {
+			t.Fatalf("save contact %d: %v, want the saved change reported as saved", i, err)
+		}
+	}
+	if jr.journalSize < 250 {
+		t.Fatalf("journal is %d bytes, want it past the threshold", jr.journalSize)
+	}
+
+	// the next change compacts once the snapshot can be written
+	if err := os.Remove(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if err := jr.Save(domain.Contact{Name: "Next", Email: "next@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if jr.journalSize != 0 {
+		t.Errorf("journal is %d bytes, want it compacted", jr.journalSize)
+	}
+	if contacts, _ := jr.GetAll(); len(contacts) != 6 {
+		t.Errorf("got %d contacts, want 6", len(contacts))
+	}
+}
+
+func TestJournalRejectsCorruptedRecordFollowedByRecords(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the first record of a journal of 5 records
+		damage func(data []byte)
+	}{
+		{"payload byte flipped", func(data []byte) { data[journalHeaderSize+3] ^= 0xff }},
+		{"checksum byte flipped", func(data []byte) { data[4] ^= 0xff }},
+		{"length past the end of the file", func(data []byte) { data[2] = 0x01 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := newTestJournal(t, 5)
+			path := filepath.Join(dir, journalFileName)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.damage(data)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := NewJournalContactRepository(dir, 0); err == nil {
+				t.Fatal("reopening a journal with a corrupted first record succeeded")
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(data)) {
+				t.Errorf("journal was truncated to %d bytes, want %d", info.Size(), len(data))
+			}
+		})
+	}
+}
+
+func TestJournalIsLocked(t *testing.T) {
+	dir := t.TempDir()
+	jr, err := NewJournalContactRepository(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewJournalContactRepository(dir, 0); !errors.Is(err, ErrStoreLocked) {
+		t.Fatalf("opening a journal that is open got %v, want ErrStoreLocked", err)
+	}
+
+	if err := jr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	jr, err = NewJournalContactRepository(dir, 0)
+	if err != nil {
+		t.Fatalf("open after close: %v", err)
+	}
+	jr.Close()
+}
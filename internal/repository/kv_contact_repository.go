@@ -0,0 +1,562 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/kvstore"
+)
+
+// key layout inside the store, ids are big endian so they sort numerically
+//
+//	contact/<id>                 -> contact as JSON
+//	email/<lowercase email>\0<id> -> empty, secondary index, one per email of the contact
+//	name/<lowercase token>\0<id>  -> empty, secondary index, one per word of the name
+//	city/<lowercase locality>\0<id> -> empty, secondary index, one per address
+//	country/<country code>\0<id>   -> empty, secondary index, one per address
+//	trash/<id>                   -> empty, the contacts in the trash
+//	meta/next_id                 -> next id
+const (
+	kvContactPrefix = "contact/"
+	kvEmailPrefix   = "email/"
+	kvNamePrefix    = "name/"
+	kvCityPrefix    = "city/"
+	kvCountryPrefix = "country/"
+	kvTrashPrefix   = "trash/"
+	kvNextIDKey     = "meta/next_id"
+
+	// longer tokens are cut, the exact name is compared after the lookup anyway
+	kvMaxTokenSize = 128
+
+	// kvEachPage is the number of contacts Each reads per transaction
+	kvEachPage = 500
+)
+
+// KVContactRepository stores the contacts in an embedded B+tree key-value store.
+// Contacts are kept by ID with secondary indexes on email and on the words of the name,
+// so lookups do not scan the whole address book.
+// A lock file next to the store keeps other processes out while it is open.
+type KVContactRepository struct {
+	db   *kvstore.DB
+	lock *os.File
+}
+
+func NewKVContactRepository(path string) (*KVContactRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create folder for %s: %w", path, err)
+	}
+
+	// the store keeps free pages and its meta page in memory, a second process would overwrite them
+	lock, err := lockStore(path + ".lock")
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := kvstore.Open(path)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open key-value store: %w", err)
+	}
+
+	return &KVContactRepository{db: db, lock: lock}, nil
+}
+
+func (kr *KVContactRepository) Close() error {
+	err := kr.db.Close()
+	if lockErr := kr.lock.Close(); err == nil {
+		err = lockErr
+	}
+	return err
+}
+
+func kvIDBytes(id int) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(id))
+	return buf
+}
+
+func kvContactKey(id int) []byte {
+	return append([]byte(kvContactPrefix), kvIDBytes(id)...)
+}
+
+func kvEmailPrefixFor(email string) []byte {
+	return []byte(kvEmailPrefix + strings.ToLower(email) + "\x00")
+}
+
+func kvNamePrefixFor(token string) []byte {
+	return []byte(kvNamePrefix + token + "\x00")
+}
+
+func kvCityPrefixFor(locality string) []byte {
+	return []byte(kvCityPrefix + strings.ToLower(locality) + "\x00")
+}
+
+func kvCountryPrefixFor(countryCode string) []byte {
+	return []byte(kvCountryPrefix + strings.ToUpper(countryCode) + "\x00")
+}
+
+// nameTokens splits a name into the lowercase words used by the name index
+func nameTokens(name string) []string {
+	var tokens []string
+	seen := map[string]bool{}
+	for _, token := range strings.Fields(strings.ToLower(name)) {
+		if len(token) > kvMaxTokenSize {
+			token = token[:kvMaxTokenSize]
+		}
+		if !seen[token] {
+			seen[token] = true
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
+
+// indexKeys returns every secondary index entry of a contact
+func indexKeys(contact domain.Contact) [][]byte {
+	id := kvIDBytes(contact.ID)
+	var keys [][]byte
+	for _, em := range contact.EmailList() {
+		keys = append(keys, append(kvEmailPrefixFor(em.Address), id...))
+	}
+	for _, token := range nameTokens(contact.Name) {
+		keys = append(keys, append(kvNamePrefixFor(token), id...))
+	}
+	for _, addr := range contact.Addresses {
+		if addr.Locality != "" {
+			keys = append(keys, append(kvCityPrefixFor(addr.Locality), id...))
+		}
+		if addr.CountryCode != "" {
+			keys = append(keys, append(kvCountryPrefixFor(addr.CountryCode), id...))
+		}
+	}
+	if contact.InTrash() {
+		keys = append(keys, append([]byte(kvTrashPrefix), id...))
+	}
+	return keys
+}
+
+func kvGet(tx *kvstore.Tx, id int) (domain.Contact, bool, error) {
+	data, err := tx.Get(kvContactKey(id))
+	if err != nil {
+		return domain.Contact{}, false, err
+	}
+	if data == nil {
+		return domain.Contact{}, false, nil
+	}
+
+	var contact domain.Contact
+	if err := json.Unmarshal(data, &contact); err != nil {
+		return domain.Contact{}, false, fmt.Errorf("failed to decode contact %d: %w", id, err)
+	}
+	return contact, true, nil
+}
+
+func kvPut(tx *kvstore.Tx, contact domain.Contact) error {
+	data, err := json.Marshal(contact)
+	if err != nil {
+		return fmt.Errorf("failed to encode contact %d: %w", contact.ID, err)
+	}
+	if err := tx.Put(kvContactKey(contact.ID), data); err != nil {
+		return err
+	}
+	for _, key := range indexKeys(contact) {
+		if err := tx.Put(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func kvRemove(tx *kvstore.Tx, contact domain.Contact) error {
+	for _, key := range indexKeys(contact) {
+		if err := tx.Delete(key); err != nil {
+			return err
+		}
+	}
+	return tx.Delete(kvContactKey(contact.ID))
+}
+
+// kvIndexIDs returns the ids stored under an index prefix
+func kvIndexIDs(tx *kvstore.Tx, prefix []byte) ([]int, error) {
+	var ids []int
+	err := tx.ScanPrefix(prefix, func(key, _ []byte) bool {
+		ids = append(ids, int(binary.BigEndian.Uint64(key[len(key)-8:])))
+		return true
+	})
+	return ids, err
+}
+
+func (kr *KVContactRepository) GetAll() ([]domain.Contact, error) {
+	contacts := []domain.Contact{}
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		var decodeErr error
+		err := tx.ScanPrefix([]byte(kvContactPrefix), func(_, value []byte) bool {
+			var contact domain.Contact
+			if decodeErr = json.Unmarshal(value, &contact); decodeErr != nil {
+				return false
+			}
+			if !contact.InTrash() {
+				contacts = append(contacts, contact)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		return decodeErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contacts: %w", err)
+	}
+
+	return contacts, nil
+}
+
+// Each reads kvEachPage contacts per read transaction, so an export neither holds
+// every contact in memory nor keeps the writers of the store waiting until it is done
+func (kr *KVContactRepository) Each(fn func(domain.Contact) error) error {
+	start := kvContactKey(0)
+	for {
+		var page []domain.Contact
+		err := kr.db.View(func(tx *kvstore.Tx) error {
+			var decodeErr error
+			err := tx.Scan(start, func(key, value []byte) bool {
+				if !bytes.HasPrefix(key, []byte(kvContactPrefix)) || len(page) == kvEachPage {
+					return false
+				}
+				var contact domain.Contact
+				if decodeErr = json.Unmarshal(value, &contact); decodeErr != nil {
+					return false
+				}
+				page = append(page, contact)
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			return decodeErr
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read contacts: %w", err)
+		}
+		if len(page) == 0 {
+			return nil
+		}
+
+		for _, contact := range page {
+			if contact.InTrash() {
+				continue
+			}
+			if err := fn(contact); err != nil {
+				return err
+			}
+		}
+		start = kvContactKey(page[len(page)-1].ID + 1)
+	}
+}
+
+func (kr *KVContactRepository) GetByID(id int) (domain.Contact, error) {
+	var contact domain.Contact
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		var err error
+		contact, _, err = kvGet(tx, id)
+		return err
+	})
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if contact.InTrash() {
+		return domain.Contact{}, nil
+	}
+
+	return contact, nil
+}
+
+func (kr *KVContactRepository) GetByName(name string) ([]domain.Contact, error) {
+	tokens := nameTokens(name)
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	var result []domain.Contact
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		// every contact with this name has its first word in the index,
+		// the exact name is compared on the few candidates
+		ids, err := kvIndexIDs(tx, kvNamePrefixFor(tokens[0]))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			contact, found, err := kvGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if found && contact.Name == name && !contact.InTrash() {
+				result = append(result, contact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (kr *KVContactRepository) GetByEmail(email string) (domain.Contact, error) {
+	var result domain.Contact
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		ids, err := kvIndexIDs(tx, kvEmailPrefixFor(email))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			contact, found, err := kvGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if found && contact.HasEmail(email) {
+				result = contact
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Contact{}, err
+	}
+
+	return result, nil
+}
+
+func (kr *KVContactRepository) GetByLocation(locality, countryCode string) ([]domain.Contact, error) {
+	var result []domain.Contact
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		var ids []int
+		if locality != "" {
+			cityIDs, err := kvIndexIDs(tx, kvCityPrefixFor(locality))
+			if err != nil {
+				return err
+			}
+			ids = append(ids, cityIDs...)
+		}
+		if countryCode != "" {
+			countryIDs, err := kvIndexIDs(tx, kvCountryPrefixFor(countryCode))
+			if err != nil {
+				return err
+			}
+			ids = append(ids, countryIDs...)
+		}
+
+		// a contact shows up once per matching address
+		slices.Sort(ids)
+		for _, id := range slices.Compact(ids) {
+			contact, found, err := kvGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if found && !contact.InTrash() {
+				result = append(result, contact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (kr *KVContactRepository) nextID(tx *kvstore.Tx) (int, error) {
+	data, err := tx.Get([]byte(kvNextIDKey))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 8 {
+		return 1, nil
+	}
+	return int(binary.BigEndian.Uint64(data)), nil
+}
+
+func (kr *KVContactRepository) Save(contact domain.Contact) error {
+	return kr.SaveAll([]domain.Contact{contact})
+}
+
+func (kr *KVContactRepository) SaveAll(contacts []domain.Contact) error {
+	// a single transaction, either the whole batch is stored or nothing
+	return kr.db.Update(func(tx *kvstore.Tx) error {
+		nextID, err := kr.nextID(tx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		for _, ctc := range contacts {
+			ctc.ID = nextID
+			ctc.MarkCreated(now)
+			if err := kvPut(tx, ctc); err != nil {
+				return fmt.Errorf("failed to save contact %s: %w", ctc.Name, err)
+			}
+			nextID++
+		}
+
+		return tx.Put([]byte(kvNextIDKey), kvIDBytes(nextID))
+	})
+}
+
+func (kr *KVContactRepository) Update(updated domain.Contact) error {
+	return kr.db.Update(func(tx *kvstore.Tx) error {
+		return kvUpdate(tx, updated, time.Now().UTC())
+	})
+}
+
+func kvUpdate(tx *kvstore.Tx, updated domain.Contact, now time.Time) error {
+	prev, found, err := kvGet(tx, updated.ID)
+	if err != nil {
+		return err
+	}
+	if !found || prev.InTrash() {
+		return errors.New("contact is not found")
+	}
+	if err := updated.MarkUpdated(prev, now); err != nil {
+		return err
+	}
+
+	// drop the index entries of the old values before writing the new ones
+	if err := kvRemove(tx, prev); err != nil {
+		return err
+	}
+	return kvPut(tx, updated)
+}
+
+func (kr *KVContactRepository) ImportAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	saved := make([]domain.Contact, len(created))
+	// a single transaction like SaveAll
+	err := kr.db.Update(func(tx *kvstore.Tx) error {
+		nextID, err := kr.nextID(tx)
+		if err != nil {
+			return err
+		}
+		for _, ctc := range created {
+			if ctc.ID == 0 {
+				continue
+			}
+			if _, found, err := kvGet(tx, ctc.ID); err != nil {
+				return err
+			} else if found {
+				return fmt.Errorf("failed to save contact %s: %w", ctc.Name, domain.ErrIDAlreadyExist)
+			}
+			nextID = max(nextID, ctc.ID+1)
+		}
+
+		now := time.Now().UTC()
+		for i, ctc := range created {
+			if ctc.ID == 0 {
+				ctc.ID = nextID
+				nextID++
+			}
+			ctc.MarkCreated(now)
+			if err := kvPut(tx, ctc); err != nil {
+				return fmt.Errorf("failed to save contact %s: %w", ctc.Name, err)
+			}
+			saved[i] = ctc
+		}
+		for _, ctc := range updated {
+			if err := kvUpdate(tx, ctc, now); err != nil {
+				return fmt.Errorf("failed to update contact %d: %w", ctc.ID, err)
+			}
+		}
+
+		return tx.Put([]byte(kvNextIDKey), kvIDBytes(nextID))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (kr *KVContactRepository) Delete(id int) error {
+	return kr.db.Update(func(tx *kvstore.Tx) error {
+		prev, found, err := kvGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if !found || prev.InTrash() {
+			return errors.New("contact is not found")
+		}
+		// the other index entries stay, the emails remain taken while in the trash
+		trashed := prev
+		trashed.DeletedAt = time.Now().UTC()
+		return kvPut(tx, trashed)
+	})
+}
+
+func (kr *KVContactRepository) GetTrash() ([]domain.Contact, error) {
+	var result []domain.Contact
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		ids, err := kvIndexIDs(tx, []byte(kvTrashPrefix))
+		if err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			contact, found, err := kvGet(tx, id)
+			if err != nil {
+				return err
+			}
+			if found {
+				result = append(result, contact)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (kr *KVContactRepository) Restore(id int) error {
+	return kr.db.Update(func(tx *kvstore.Tx) error {
+		prev, found, err := kvGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if !found || !prev.InTrash() {
+			return errors.New("contact is not found in the trash")
+		}
+		if err := kvRemove(tx, prev); err != nil {
+			return err
+		}
+		restored := prev
+		restored.DeletedAt = time.Time{}
+		return kvPut(tx, restored)
+	})
+}
+
+func (kr *KVContactRepository) Purge(id int) error {
+	return kr.db.Update(func(tx *kvstore.Tx) error {
+		prev, found, err := kvGet(tx, id)
+		if err != nil {
+			return err
+		}
+		if !found || !prev.InTrash() {
+			return errors.New("contact is not found in the trash")
+		}
+		return kvRemove(tx, prev)
+	})
+}
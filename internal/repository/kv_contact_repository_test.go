@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/kvstore"
+)
+
+func newTestKVRepository(t *testing.T) *KVContactRepository {
+	t.Helper()
+	kr, err := NewKVContactRepository(filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kr.Close() })
+	return kr
+}
+
+// indexIDs returns the ids the index has under prefix
+func indexIDs(t *testing.T, kr *KVContactRepository, prefix []byte) []int {
+	t.Helper()
+	var ids []int
+	err := kr.db.View(func(tx *kvstore.Tx) error {
+		var err error
+		ids, err = kvIndexIDs(tx, prefix)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestKVIndexes(t *testing.T) {
+	kr := newTestKVRepository(t)
+	for _, ctc := range []domain.Contact{
+		{Name: "Ann Lee", Email: "ann@mail.com"},
+		{Name: "Ann Smith", Email: "Ann.Smith@Mail.com"},
+		{Name: "Bob Lee", Email: "bob@mail.com"},
+	} {
+		if err := kr.Save(ctc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the email is indexed in lowercase, every word of the name on its own
+	tests := []struct {
+		name   string
+		prefix []byte
+		want   []int
+	}{
+		{"email in another case", kvEmailPrefixFor("ANN.SMITH@MAIL.COM"), []int{2}},
+		{"first name", kvNamePrefixFor("ann"), []int{1, 2}},
+		{"last name", kvNamePrefixFor("lee"), []int{1, 3}},
+		{"unknown word", kvNamePrefixFor("cid"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := indexIDs(t, kr, tt.prefix); !slices.Equal(got, tt.want) {
+				t.Errorf("index %q has %v, want %v", tt.prefix, got, tt.want)
+			}
+		})
+	}
+
+	// a lookup by name only reads the contacts the index returns and compares the whole name
+	found, err := kr.GetByName("Ann Smith")
+	if err != nil || len(found) != 1 || found[0].ID != 2 {
+		t.Errorf("GetByName(Ann Smith) got %+v (%v), want contact 2", found, err)
+	}
+	if found, _ := kr.GetByName("Ann"); len(found) != 0 {
+		t.Errorf("GetByName(Ann) got %+v, want no contact with that whole name", found)
+	}
+	if bob, err := kr.GetByEmail("bob@mail.com"); err != nil || bob.ID != 3 {
+		t.Errorf("GetByEmail(bob@mail.com) got %+v (%v), want contact 3", bob, err)
+	}
+
+	// an update moves the index entries of the old name to the new one
+	bob, _ := kr.GetByID(3)
+	bob.Name = "Bob Stone"
+	if err := kr.Update(bob); err != nil {
+		t.Fatal(err)
+	}
+	if got := indexIDs(t, kr, kvNamePrefixFor("lee")); !slices.Equal(got, []int{1}) {
+		t.Errorf("index lee has %v after the rename, want [1]", got)
+	}
+	if got := indexIDs(t, kr, kvNamePrefixFor("stone")); !slices.Equal(got, []int{3}) {
+		t.Errorf("index stone has %v after the rename, want [3]", got)
+	}
+}
+
+func TestKVIsLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contacts.db")
+	kr, err := NewKVContactRepository(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKVContactRepository(path); !errors.Is(err, ErrStoreLocked) {
+		t.Fatalf("opening a store that is open got %v, want ErrStoreLocked", err)
+	}
+
+	if err := kr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	kr, err = NewKVContactRepository(path)
+	if err != nil {
+		t.Fatalf("open after close: %v", err)
+	}
+	kr.Close()
+}
@@ -0,0 +1,304 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/ldif"
+)
+
+// DefaultDNTemplate files the contacts under one organizational unit, the uid keeps the dn
+// unique and stable when the name changes
+const DefaultDNTemplate = "uid={uid},ou=contacts,dc=example,dc=com"
+
+var ErrInvalidDNTemplate = errors.New("invalid DN template, use attributes like uid={uid},ou=contacts,dc=example,dc=com with {uid}, {id}, {cn} or {mail}")
+
+var dnPlaceholderRegex = regexp.MustCompile(`\{[^}]*\}`)
+
+// dnPlaceholders are what a DN template can hold, each is replaced with a value of the contact
+var dnPlaceholders = map[string]func(ctc domain.Contact) string{
+	"{uid}": func(ctc domain.Contact) string { return VCardUID(ctc) },
+	"{id}":  func(ctc domain.Contact) string { return strconv.Itoa(ctc.ID) },
+	"{cn}":  func(ctc domain.Contact) string { return ctc.Name },
+	"{mail}": func(ctc domain.Contact) string {
+		if emails := ctc.EmailList(); len(emails) > 0 {
+			return emails[0].Address
+		}
+		return ""
+	},
+}
+
+// CheckDNTemplate makes sure the template gives every contact a dn: it is a list of
+// attribute=value parts and its first part, the RDN, holds a placeholder
+func CheckDNTemplate(template string) error {
+	rdn, _, _ := strings.Cut(template, ",")
+	if !strings.Contains(rdn, "=") || !dnPlaceholderRegex.MatchString(rdn) {
+		return ErrInvalidDNTemplate
+	}
+	for _, placeholder := range dnPlaceholderRegex.FindAllString(template, -1) {
+		if _, ok := dnPlaceholders[placeholder]; !ok {
+			return fmt.Errorf("%w, unknown placeholder %s", ErrInvalidDNTemplate, placeholder)
+		}
+	}
+	return nil
+}
+
+// ContactDN fills the template with the values of the contact, escaped as RFC 4514 requires
+func ContactDN(template string, ctc domain.Contact) string {
+	return dnPlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return escapeDNValue(dnPlaceholders[placeholder](ctc))
+	})
+}
+
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(value)-1 && r == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ContactToLDIF writes a contact as an inetOrgPerson entry (RFC 2798). Emails have no labels
+// in a directory, a phone goes to mobile, homePhone or telephoneNumber by its label and
+// an address to homePostalAddress or postalAddress, the first one also to street, l, st and postalCode
+func ContactToLDIF(ctc domain.Contact, dnTemplate string) ldif.Record {
+	rec := ldif.Record{DN: ContactDN(dnTemplate, ctc)}
+	for _, class := range []string{"top", "person", "organizationalPerson", "inetOrgPerson"} {
+		rec.Add("objectClass", class)
+	}
+	rec.Add("uid", VCardUID(ctc))
+	rec.Add("cn", ctc.Name)
+
+	// person requires a surname, the last word of the name like the N of a vCard
+	name := splitName(ctc.Name)
+	rec.Add("sn", name[0])
+	rec.Add("givenName", name[1])
+
+	for _, em := range ctc.EmailList() {
+		rec.Add("mail", em.Address)
+	}
+
+	for _, ph := range ctc.PhoneList() {
+		switch strings.ToLower(ph.Label) {
+		case "mobile", "cell":
+			rec.Add("mobile", ph.Number)
+		case "home":
+			rec.Add("homePhone", ph.Number)
+		default:
+			rec.Add("telephoneNumber", ph.Number)
+		}
+	}
+
+	for i, addr := range ctc.Addresses {
+		if i == 0 {
+			rec.Add("street", addr.Street)
+			rec.Add("l", addr.Locality)
+			rec.Add("st", addr.Region)
+			rec.Add("postalCode", addr.PostalCode)
+		}
+		if strings.EqualFold(addr.Label, "home") {
+			rec.Add("homePostalAddress", formatPostalAddress(addr))
+		} else {
+			rec.Add("postalAddress", formatPostalAddress(addr))
+		}
+	}
+
+	for _, tag := range ctc.Tags {
+		rec.Add("businessCategory", tag)
+	}
+	return rec
+}
+
+// postalAddressEscaper escapes a line of a postal address, RFC 4517 section 3.3.28
+var postalAddressEscaper = strings.NewReplacer(`\`, `\5C`, "$", `\24`)
+
+var postalAddressUnescaper = strings.NewReplacer(`\5C`, `\`, `\5c`, `\`, `\24`, "$")
+
+// formatPostalAddress writes the lines street, locality, region, postal code and
+// country separated by "$", an empty line is left out
+func formatPostalAddress(addr domain.Address) string {
+	country := ""
+	if addr.CountryCode != "" {
+		country = domain.CountryName(addr.CountryCode)
+	}
+
+	var lines []string
+	for _, line := range []string{addr.Street, addr.Locality, addr.Region, addr.PostalCode, country} {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, postalAddressEscaper.Replace(line))
+		}
+	}
+	return strings.Join(lines, "$")
+}
+
+// parsePostalAddress reads the lines of formatPostalAddress back. As empty lines are left
+// out the lines after the street are recognized: a country on the last line, a postal code
+// with digits, else the locality and then the region
+func parsePostalAddress(label, value string) domain.Address {
+	addr := domain.Address{Label: label}
+	lines := strings.Split(value, "$")
+	for i, line := range lines {
+		line = strings.TrimSpace(postalAddressUnescaper.Replace(line))
+		switch {
+		case line == "":
+		case i == 0:
+			addr.Street = line
+		// only the last line, a region like Georgia has the name of a country too
+		case i > 1 && i == len(lines)-1 && domain.CountryCodeFor(line) != "":
+			addr.CountryCode = domain.CountryCodeFor(line)
+		case addr.PostalCode == "" && strings.ContainsFunc(line, unicode.IsDigit):
+			addr.PostalCode = line
+		case addr.Locality == "":
+			addr.Locality = line
+		case addr.Region == "":
+			addr.Region = line
+		default:
+			addr.Street += ", " + line
+		}
+	}
+	return addr
+}
+
+// ContactFromLDIF reads an entry into a contact without an ID, ok is false for
+// an entry that is not a person, e.g. the organizational unit the contacts are in
+func ContactFromLDIF(rec ldif.Record) (ctc domain.Contact, ok bool) {
+	if classes := rec.Values("objectClass"); len(classes) > 0 {
+		person := false
+		for _, class := range classes {
+			person = person || strings.Contains(strings.ToLower(class), "person")
+		}
+		if !person {
+			return domain.Contact{}, false
+		}
+	}
+
+	ctc.Name = strings.TrimSpace(rec.Value("cn"))
+	if ctc.Name == "" {
+		ctc.Name = strings.TrimSpace(rec.Value("displayName"))
+	}
+	if ctc.Name == "" {
+		ctc.Name = strings.Join(strings.Fields(rec.Value("givenName")+" "+rec.Value("sn")), " ")
+	}
+
+	for i, mail := range rec.Values("mail") {
+		ctc.Emails = append(ctc.Emails, domain.Email{Address: strings.TrimSpace(mail), Primary: i == 0})
+	}
+
+	for _, a := range rec.Attrs {
+		label := ""
+		switch strings.ToLower(a.Name) {
+		case "mobile":
+			label = "mobile"
+		case "homephone":
+			label = "home"
+		case "telephonenumber":
+			label = "work"
+		default:
+			continue
+		}
+		ctc.Phones = append(ctc.Phones, domain.Phone{Label: label, Number: strings.TrimSpace(a.Value), Primary: len(ctc.Phones) == 0})
+	}
+
+	for _, a := range rec.Attrs {
+		switch strings.ToLower(a.Name) {
+		case "homepostaladdress":
+			ctc.Addresses = append(ctc.Addresses, parsePostalAddress("home", a.Value))
+		case "postaladdress":
+			ctc.Addresses = append(ctc.Addresses, parsePostalAddress("work", a.Value))
+		}
+	}
+	if len(ctc.Addresses) == 0 {
+		addr := domain.Address{
+			Label:      "work",
+			Street:     strings.TrimSpace(rec.Value("street")),
+			Locality:   strings.TrimSpace(rec.Value("l")),
+			Region:     strings.TrimSpace(rec.Value("st")),
+			PostalCode: strings.TrimSpace(rec.Value("postalCode")),
+		}
+		if !addr.IsEmpty() {
+			ctc.Addresses = append(ctc.Addresses, addr)
+		}
+	}
+
+	var tags []string
+	for _, category := range rec.Values("businessCategory") {
+		if tag := sanitizeTag(category); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	ctc.Tags = domain.NormalizeTags(tags)
+
+	ctc.Normalize()
+	return ctc, true
+}
+
+// WriteContactsLDIF writes the contacts of source to an .ldif file, their dn is made
+// from dnTemplate. The file only replaces filename once it is complete
+func WriteContactsLDIF(filename, dnTemplate string, source ContactSource) error {
+	if err := CheckDNTemplate(dnTemplate); err != nil {
+		return err
+	}
+
+	err := writeFileStream(filename, func(w io.Writer) error {
+		lw := ldif.NewWriter(w)
+		err := source(func(ctc domain.Contact) error {
+			lw.Write(ContactToLDIF(ctc, dnTemplate))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return lw.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ReadLDIFRows reads the entries of an .ldif file and calls fn with the contact of every
+// person in the order of the file, see ReadJSONRows. An entry is read at a time, so the
+// file does not have to fit in memory. The text of a row is the dn of its entry
+func ReadLDIFRows(filename string, fn func(ImportRow) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	lr := ldif.NewReader(file)
+	for {
+		rec, err := lr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode LDIF: %w", err)
+		}
+
+		ctc, ok := ContactFromLDIF(rec)
+		if !ok {
+			continue
+		}
+		if err := fn(ImportRow{Line: lr.Line(), Text: "dn: " + rec.DN, Contact: ctc, End: lr.Offset()}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package repository
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockStore only opens the lock file on this platform, it does not keep other processes out
+func lockStore(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	return file, nil
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockStore takes an exclusive lock on the lock file at path, creating it if needed.
+// It fails right away with ErrStoreLocked when another process holds the lock,
+// the lock is released when the returned file is closed or the process exits.
+func lockStore(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrStoreLocked, path)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	return file, nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// SQLAuditRepository stores the audit log in the same database as the contacts,
+// its table is created by the migrations of SQLContactRepository.
+// The changes and the snapshot are kept as JSON, they are only read back whole.
+type SQLAuditRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// Audit returns the audit repository sharing the database of the contacts
+func (sr *SQLContactRepository) Audit() *SQLAuditRepository {
+	return &SQLAuditRepository{db: sr.db, dialect: sr.dialect}
+}
+
+const sqlAuditColumns = `id, contact_id, actor, at, op, changes, snapshot, reverted_to`
+
+func (ar *SQLAuditRepository) Append(entry domain.AuditEntry) error {
+	ctx := context.Background()
+
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+	snapshot, err := json.Marshal(entry.Snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+
+	tx, err := ar.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE audit_sequence SET next_id = next_id + 1`); err != nil {
+		return fmt.Errorf("failed to reserve audit id: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT next_id - 1 FROM audit_sequence`).Scan(&entry.ID); err != nil {
+		return fmt.Errorf("failed to reserve audit id: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, ar.dialect.rebind(`INSERT INTO contact_audit (`+sqlAuditColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		entry.ID, entry.ContactID, entry.Actor, formatSQLTime(entry.At), string(entry.Op),
+		string(changes), string(snapshot), entry.RevertedTo); err != nil {
+		return fmt.Errorf("failed to append to audit log: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (ar *SQLAuditRepository) query(query string, args ...any) ([]domain.AuditEntry, error) {
+	rows, err := ar.db.QueryContext(context.Background(), ar.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []domain.AuditEntry
+	for rows.Next() {
+		var entry domain.AuditEntry
+		var at, op, changes, snapshot string
+		if err := rows.Scan(&entry.ID, &entry.ContactID, &entry.Actor, &at, &op, &changes, &snapshot, &entry.RevertedTo); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entry.Op = domain.AuditOp(op)
+		if entry.At, err = parseSQLTime(at); err != nil {
+			return nil, fmt.Errorf("audit entry %d has an invalid time: %w", entry.ID, err)
+		}
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode changes of audit entry %d: %w", entry.ID, err)
+		}
+		if err := json.Unmarshal([]byte(snapshot), &entry.Snapshot); err != nil {
+			return nil, fmt.Errorf("failed to decode snapshot of audit entry %d: %w", entry.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (ar *SQLAuditRepository) GetByID(id int) (domain.AuditEntry, error) {
+	entries, err := ar.query(`SELECT `+sqlAuditColumns+` FROM contact_audit WHERE id = ?`, id)
+	if err != nil || len(entries) == 0 {
+		return domain.AuditEntry{}, err
+	}
+	return entries[0], nil
+}
+
+func (ar *SQLAuditRepository) GetByContact(contactID int) ([]domain.AuditEntry, error) {
+	return ar.query(`SELECT `+sqlAuditColumns+` FROM contact_audit WHERE contact_id = ? ORDER BY id`, contactID)
+}
@@ -0,0 +1,667 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// sqlDialect hides the few differences between databases that matter here
+type sqlDialect struct {
+	// numbered placeholders ($1, $2) instead of ?
+	numbered bool
+}
+
+func dialectFor(driverName string) sqlDialect {
+	switch driverName {
+	case "postgres", "pgx":
+		return sqlDialect{numbered: true}
+	default:
+		return sqlDialect{}
+	}
+}
+
+// rebind turns the ? placeholders of a query into the style of the database
+func (d sqlDialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// SQLContactRepository stores the contacts in a relational database through database/sql.
+// The schema is migrated when the repository is created,
+// and the database itself guarantees that an email is only used once.
+type SQLContactRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// NewSQLContactRepository migrates the schema of db to schemaVersion,
+// use LatestSchemaVersion to apply every migration.
+// The driver must be registered by importing it, see cmd/main.go.
+func NewSQLContactRepository(db *sql.DB, driverName string, schemaVersion int) (*SQLContactRepository, error) {
+	sr := &SQLContactRepository{
+		db:      db,
+		dialect: dialectFor(driverName),
+	}
+
+	if err := migrate(context.Background(), db, sr.dialect, schemaVersion); err != nil {
+		return nil, err
+	}
+
+	return sr, nil
+}
+
+func (sr *SQLContactRepository) Close() error {
+	return sr.db.Close()
+}
+
+// sqlUniqueConstraints are the unique constraints of the schema a violation is reported for,
+// with the names the drivers use for them: PostgreSQL and MySQL name the index, SQLite its columns
+var sqlUniqueConstraints = []struct {
+	names []string
+	err   error
+}{
+	{[]string{"contacts_email_unique", "contact_emails_address_unique", "contacts.email", "contact_emails.address"}, domain.ErrEmailAlreadyExist},
+	{[]string{"contacts_pkey", "contacts.primary", "contacts.id"}, domain.ErrIDAlreadyExist},
+}
+
+// mapSQLError turns driver errors into the sentinels the service understands.
+// Drivers do not share error types, so a unique violation is recognised
+// by its SQLSTATE when the driver exposes it and by its message otherwise,
+// and the constraint it broke by its name in the message.
+// A violation of any other constraint is returned as it is.
+func mapSQLError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := strings.ToLower(err.Error())
+	var state interface{ SQLState() string }
+	unique := errors.As(err, &state) && state.SQLState() == "23505" ||
+		strings.Contains(msg, "unique constraint") ||
+		strings.Contains(msg, "duplicate key") ||
+		strings.Contains(msg, "duplicate entry")
+	if !unique {
+		return err
+	}
+
+	for _, constraint := range sqlUniqueConstraints {
+		for _, name := range constraint.names {
+			if strings.Contains(msg, name) {
+				return fmt.Errorf("%w: %v", constraint.err, err)
+			}
+		}
+	}
+
+	return err
+}
+
+const (
+	sqlContactColumns = `id, name, email, phone, created_at, updated_at, version, deleted_at, uid, card_name`
+	// the same columns when the query joins another table
+	sqlContactColumnsJoined = `c.id, c.name, c.email, c.phone, c.created_at, c.updated_at, c.version, c.deleted_at, c.uid, c.card_name`
+
+	// bigger reads load the emails and phones of every contact
+	// instead of listing the ids in the query
+	sqlMaxIDList = 500
+)
+
+func scanContacts(rows *sql.Rows) ([]domain.Contact, error) {
+	defer rows.Close()
+
+	contacts := []domain.Contact{}
+	for rows.Next() {
+		var ctc domain.Contact
+		var createdAt, updatedAt, deletedAt string
+		if err := rows.Scan(&ctc.ID, &ctc.Name, &ctc.Email, &ctc.Phone, &createdAt, &updatedAt, &ctc.Version, &deletedAt, &ctc.UID, &ctc.CardName); err != nil {
+			return nil, fmt.Errorf("failed to scan contact: %w", err)
+		}
+		var err error
+		if ctc.CreatedAt, err = parseSQLTime(createdAt); err != nil {
+			return nil, fmt.Errorf("contact %d has an invalid created_at: %w", ctc.ID, err)
+		}
+		if ctc.UpdatedAt, err = parseSQLTime(updatedAt); err != nil {
+			return nil, fmt.Errorf("contact %d has an invalid updated_at: %w", ctc.ID, err)
+		}
+		if ctc.DeletedAt, err = parseSQLTime(deletedAt); err != nil {
+			return nil, fmt.Errorf("contact %d has an invalid deleted_at: %w", ctc.ID, err)
+		}
+		contacts = append(contacts, ctc)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read contacts: %w", err)
+	}
+
+	return contacts, nil
+}
+
+// formatSQLTime stores a timestamp as RFC 3339 text
+func formatSQLTime(t time.Time) string {
+	if t.IsZero() {
+		// zero is stored as ''
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseSQLTime(text string) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, text)
+}
+
+func (sr *SQLContactRepository) query(query string, args ...any) ([]domain.Contact, error) {
+	rows, err := sr.db.QueryContext(context.Background(), sr.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query contacts: %w", err)
+	}
+
+	contacts, err := scanContacts(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sr.attachDetails(contacts); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// attachDetails loads the labelled emails, phones, addresses and tags of the contacts
+func (sr *SQLContactRepository) attachDetails(contacts []domain.Contact) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*domain.Contact, len(contacts))
+	for i := range contacts {
+		byID[contacts[i].ID] = &contacts[i]
+	}
+
+	filter := ""
+	var args []any
+	if len(contacts) <= sqlMaxIDList {
+		filter = ` WHERE contact_id IN (?` + strings.Repeat(`, ?`, len(contacts)-1) + `)`
+		for _, ctc := range contacts {
+			args = append(args, ctc.ID)
+		}
+	}
+
+	ctx := context.Background()
+	rows, err := sr.db.QueryContext(ctx, sr.dialect.rebind(
+		`SELECT contact_id, label, address, is_primary FROM contact_emails`+filter+` ORDER BY contact_id, position`), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query emails: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, primary int
+		var em domain.Email
+		if err := rows.Scan(&id, &em.Label, &em.Address, &primary); err != nil {
+			return fmt.Errorf("failed to scan email: %w", err)
+		}
+		em.Primary = primary == 1
+		if ctc, ok := byID[id]; ok {
+			ctc.Emails = append(ctc.Emails, em)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read emails: %w", err)
+	}
+
+	phoneRows, err := sr.db.QueryContext(ctx, sr.dialect.rebind(
+		`SELECT contact_id, label, number, is_primary FROM contact_phones`+filter+` ORDER BY contact_id, position`), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query phones: %w", err)
+	}
+	defer phoneRows.Close()
+	for phoneRows.Next() {
+		var id, primary int
+		var ph domain.Phone
+		if err := phoneRows.Scan(&id, &ph.Label, &ph.Number, &primary); err != nil {
+			return fmt.Errorf("failed to scan phone: %w", err)
+		}
+		ph.Primary = primary == 1
+		if ctc, ok := byID[id]; ok {
+			ctc.Phones = append(ctc.Phones, ph)
+		}
+	}
+	if err := phoneRows.Err(); err != nil {
+		return fmt.Errorf("failed to read phones: %w", err)
+	}
+
+	addressRows, err := sr.db.QueryContext(ctx, sr.dialect.rebind(
+		`SELECT contact_id, label, street, locality, region, postal_code, country_code
+		FROM contact_addresses`+filter+` ORDER BY contact_id, position`), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query addresses: %w", err)
+	}
+	defer addressRows.Close()
+	for addressRows.Next() {
+		var id int
+		var addr domain.Address
+		if err := addressRows.Scan(&id, &addr.Label, &addr.Street, &addr.Locality, &addr.Region, &addr.PostalCode, &addr.CountryCode); err != nil {
+			return fmt.Errorf("failed to scan address: %w", err)
+		}
+		if ctc, ok := byID[id]; ok {
+			ctc.Addresses = append(ctc.Addresses, addr)
+		}
+	}
+	if err := addressRows.Err(); err != nil {
+		return fmt.Errorf("failed to read addresses: %w", err)
+	}
+
+	tagRows, err := sr.db.QueryContext(ctx, sr.dialect.rebind(
+		`SELECT contact_id, tag FROM contact_tags`+filter+` ORDER BY contact_id, tag`), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		var id int
+		var tag string
+		if err := tagRows.Scan(&id, &tag); err != nil {
+			return fmt.Errorf("failed to scan tag: %w", err)
+		}
+		if ctc, ok := byID[id]; ok {
+			ctc.Tags = append(ctc.Tags, tag)
+		}
+	}
+	if err := tagRows.Err(); err != nil {
+		return fmt.Errorf("failed to read tags: %w", err)
+	}
+
+	return nil
+}
+
+// queryOne returns an empty contact when nothing matches, like the other repositories
+func (sr *SQLContactRepository) queryOne(query string, args ...any) (domain.Contact, error) {
+	contacts, err := sr.query(query, args...)
+	if err != nil {
+		return domain.Contact{}, err
+	}
+	if len(contacts) == 0 {
+		return domain.Contact{}, nil
+	}
+	return contacts[0], nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// insertDetails writes the labelled emails, phones, addresses and tags of a contact
+func (sr *SQLContactRepository) insertDetails(ctx context.Context, tx *sql.Tx, ctc domain.Contact) error {
+	insertEmail := sr.dialect.rebind(`INSERT INTO contact_emails (contact_id, position, label, address, is_primary) VALUES (?, ?, ?, ?, ?)`)
+	for pos, em := range ctc.EmailList() {
+		if _, err := tx.ExecContext(ctx, insertEmail, ctc.ID, pos, em.Label, em.Address, boolToInt(em.Primary)); err != nil {
+			return mapSQLError(err)
+		}
+	}
+
+	insertPhone := sr.dialect.rebind(`INSERT INTO contact_phones (contact_id, position, label, number, is_primary) VALUES (?, ?, ?, ?, ?)`)
+	for pos, ph := range ctc.PhoneList() {
+		if _, err := tx.ExecContext(ctx, insertPhone, ctc.ID, pos, ph.Label, ph.Number, boolToInt(ph.Primary)); err != nil {
+			return mapSQLError(err)
+		}
+	}
+
+	insertAddress := sr.dialect.rebind(`INSERT INTO contact_addresses
+		(contact_id, position, label, street, locality, region, postal_code, country_code)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	for pos, addr := range ctc.Addresses {
+		if _, err := tx.ExecContext(ctx, insertAddress, ctc.ID, pos, addr.Label,
+			addr.Street, addr.Locality, addr.Region, addr.PostalCode, addr.CountryCode); err != nil {
+			return err
+		}
+	}
+
+	insertTag := sr.dialect.rebind(`INSERT INTO contact_tags (contact_id, tag) VALUES (?, ?)`)
+	for _, tag := range ctc.Tags {
+		if _, err := tx.ExecContext(ctx, insertTag, ctc.ID, tag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sr *SQLContactRepository) deleteDetails(ctx context.Context, tx *sql.Tx, id int) error {
+	if _, err := tx.ExecContext(ctx, sr.dialect.rebind(`DELETE FROM contact_emails WHERE contact_id = ?`), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sr.dialect.rebind(`DELETE FROM contact_phones WHERE contact_id = ?`), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, sr.dialect.rebind(`DELETE FROM contact_addresses WHERE contact_id = ?`), id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, sr.dialect.rebind(`DELETE FROM contact_tags WHERE contact_id = ?`), id)
+	return err
+}
+
+func (sr *SQLContactRepository) GetAll() ([]domain.Contact, error) {
+	return sr.query(`SELECT ` + sqlContactColumns + ` FROM contacts WHERE deleted_at = '' ORDER BY id`)
+}
+
+// Each reads sqlMaxIDList contacts per query, the details of a page are loaded with one IN list
+func (sr *SQLContactRepository) Each(fn func(domain.Contact) error) error {
+	lastID := 0
+	for {
+		page, err := sr.query(`SELECT `+sqlContactColumns+` FROM contacts WHERE id > ? AND deleted_at = '' ORDER BY id LIMIT `+strconv.Itoa(sqlMaxIDList), lastID)
+		if err != nil {
+			return err
+		}
+		for _, contact := range page {
+			if err := fn(contact); err != nil {
+				return err
+			}
+		}
+		if len(page) < sqlMaxIDList {
+			return nil
+		}
+		lastID = page[len(page)-1].ID
+	}
+}
+
+func (sr *SQLContactRepository) GetByID(id int) (domain.Contact, error) {
+	return sr.queryOne(`SELECT `+sqlContactColumns+` FROM contacts WHERE id = ? AND deleted_at = ''`, id)
+}
+
+func (sr *SQLContactRepository) GetByName(name string) ([]domain.Contact, error) {
+	contacts, err := sr.query(`SELECT `+sqlContactColumns+` FROM contacts WHERE name = ? AND deleted_at = '' ORDER BY id`, name)
+	if err != nil || len(contacts) == 0 {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+// GetByEmail also finds contacts in the trash, see ContactRepository
+func (sr *SQLContactRepository) GetByEmail(email string) (domain.Contact, error) {
+	return sr.queryOne(`SELECT `+sqlContactColumnsJoined+` FROM contacts c
+		JOIN contact_emails e ON e.contact_id = c.id WHERE e.address = ?`, email)
+}
+
+func (sr *SQLContactRepository) GetByLocation(locality, countryCode string) ([]domain.Contact, error) {
+	// an empty argument must not match the empty columns of other addresses
+	contacts, err := sr.query(`SELECT `+sqlContactColumns+` FROM contacts WHERE id IN (
+		SELECT contact_id FROM contact_addresses
+		WHERE (? <> '' AND LOWER(locality) = LOWER(?)) OR (? <> '' AND country_code = UPPER(?))
+	) AND deleted_at = '' ORDER BY id`, locality, locality, countryCode, countryCode)
+	if err != nil || len(contacts) == 0 {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (sr *SQLContactRepository) Save(contact domain.Contact) error {
+	return sr.SaveAll([]domain.Contact{contact})
+}
+
+func (sr *SQLContactRepository) SaveAll(contacts []domain.Contact) error {
+	ctx := context.Background()
+
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	// SaveAll hands out new ids like Save, whatever id the contacts had
+	fresh := make([]domain.Contact, len(contacts))
+	for i, ctc := range contacts {
+		ctc.ID = 0
+		fresh[i] = ctc
+	}
+	if _, err := sr.insertContacts(ctx, tx, fresh); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit contacts: %w", mapSQLError(err))
+	}
+
+	return nil
+}
+
+// insertContacts inserts the contacts, the ones without an ID get the next ones of the sequence
+func (sr *SQLContactRepository) insertContacts(ctx context.Context, tx *sql.Tx, contacts []domain.Contact) ([]domain.Contact, error) {
+	keptMax, missing := 0, 0
+	for _, ctc := range contacts {
+		if ctc.ID == 0 {
+			missing++
+			continue
+		}
+		var used int
+		if err := tx.QueryRowContext(ctx, sr.dialect.rebind(`SELECT COUNT(*) FROM contacts WHERE id = ?`), ctc.ID).Scan(&used); err != nil {
+			return nil, fmt.Errorf("failed to check contact id %d: %w", ctc.ID, err)
+		}
+		if used > 0 {
+			return nil, fmt.Errorf("failed to save contact %s: %w", ctc.Name, domain.ErrIDAlreadyExist)
+		}
+		keptMax = max(keptMax, ctc.ID)
+	}
+
+	// move the sequence past the kept ids first, so the reserved ones never clash with them
+	if keptMax > 0 {
+		if _, err := tx.ExecContext(ctx, sr.dialect.rebind(`UPDATE contact_sequence SET next_id = ? WHERE next_id <= ?`), keptMax+1, keptMax); err != nil {
+			return nil, fmt.Errorf("failed to reserve contact ids: %w", err)
+		}
+	}
+
+	// reserve the ids of the whole batch at once,
+	// the update locks the row so concurrent writers never get the same ids
+	if _, err := tx.ExecContext(ctx, sr.dialect.rebind(`UPDATE contact_sequence SET next_id = next_id + ?`), missing); err != nil {
+		return nil, fmt.Errorf("failed to reserve contact ids: %w", err)
+	}
+	var nextID int
+	if err := tx.QueryRowContext(ctx, `SELECT next_id FROM contact_sequence`).Scan(&nextID); err != nil {
+		return nil, fmt.Errorf("failed to reserve contact ids: %w", err)
+	}
+	nextID -= missing
+
+	insert := sr.dialect.rebind(`INSERT INTO contacts (` + sqlContactColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	now := time.Now().UTC()
+	saved := make([]domain.Contact, len(contacts))
+	for i, ctc := range contacts {
+		if ctc.ID == 0 {
+			ctc.ID = nextID
+			nextID++
+		}
+		ctc.MarkCreated(now)
+		if _, err := tx.ExecContext(ctx, insert, ctc.ID, ctc.Name, ctc.Email, ctc.Phone,
+			formatSQLTime(ctc.CreatedAt), formatSQLTime(ctc.UpdatedAt), ctc.Version, "", ctc.UID, ctc.CardName); err != nil {
+			return nil, fmt.Errorf("failed to save contact %s: %w", ctc.Name, mapSQLError(err))
+		}
+		if err := sr.insertDetails(ctx, tx, ctc); err != nil {
+			return nil, fmt.Errorf("failed to save contact %s: %w", ctc.Name, err)
+		}
+		saved[i] = ctc
+	}
+
+	return saved, nil
+}
+
+func (sr *SQLContactRepository) Update(updated domain.Contact) error {
+	ctx := context.Background()
+
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	if err := sr.updateContact(ctx, tx, updated); err != nil {
+		return err
+	}
+
+	return mapSQLError(tx.Commit())
+}
+
+func (sr *SQLContactRepository) updateContact(ctx context.Context, tx *sql.Tx, updated domain.Contact) error {
+	var stored domain.Contact
+	var createdAt string
+	err := tx.QueryRowContext(ctx, sr.dialect.rebind(`SELECT created_at, version FROM contacts WHERE id = ? AND deleted_at = ''`), updated.ID).
+		Scan(&createdAt, &stored.Version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("contact is not found")
+	}
+	if err != nil {
+		return err
+	}
+	if stored.CreatedAt, err = parseSQLTime(createdAt); err != nil {
+		return fmt.Errorf("contact %d has an invalid created_at: %w", updated.ID, err)
+	}
+	if err := updated.MarkUpdated(stored, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	// the version in the WHERE clause catches a writer that committed since the read above
+	result, err := tx.ExecContext(ctx,
+		sr.dialect.rebind(`UPDATE contacts SET name = ?, email = ?, phone = ?, updated_at = ?, version = ?, uid = ?, card_name = ? WHERE id = ? AND version = ?`),
+		updated.Name, updated.Email, updated.Phone, formatSQLTime(updated.UpdatedAt), updated.Version, updated.UID, updated.CardName, updated.ID, stored.Version)
+	if err != nil {
+		return mapSQLError(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return domain.ErrConflict
+	}
+
+	// the lists are small, replacing them is simpler than diffing them
+	if err := sr.deleteDetails(ctx, tx, updated.ID); err != nil {
+		return err
+	}
+	return sr.insertDetails(ctx, tx, updated)
+}
+
+func (sr *SQLContactRepository) ImportAll(created, updated []domain.Contact) ([]domain.Contact, error) {
+	ctx := context.Background()
+
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	saved, err := sr.insertContacts(ctx, tx, created)
+	if err != nil {
+		return nil, err
+	}
+	for _, ctc := range updated {
+		if err := sr.updateContact(ctx, tx, ctc); err != nil {
+			return nil, fmt.Errorf("failed to update contact %d: %w", ctc.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit contacts: %w", mapSQLError(err))
+	}
+	return saved, nil
+}
+
+func (sr *SQLContactRepository) Delete(id int) error {
+	result, err := sr.db.ExecContext(context.Background(),
+		sr.dialect.rebind(`UPDATE contacts SET deleted_at = ? WHERE id = ? AND deleted_at = ''`),
+		formatSQLTime(time.Now().UTC()), id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+func (sr *SQLContactRepository) GetTrash() ([]domain.Contact, error) {
+	contacts, err := sr.query(`SELECT ` + sqlContactColumns + ` FROM contacts WHERE deleted_at <> '' ORDER BY deleted_at, id`)
+	if err != nil || len(contacts) == 0 {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func (sr *SQLContactRepository) Restore(id int) error {
+	result, err := sr.db.ExecContext(context.Background(),
+		sr.dialect.rebind(`UPDATE contacts SET deleted_at = '' WHERE id = ? AND deleted_at <> ''`), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errors.New("contact is not found in the trash")
+	}
+	return nil
+}
+
+func (sr *SQLContactRepository) Purge(id int) error {
+	ctx := context.Background()
+
+	tx, err := sr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	var trashed int
+	err = tx.QueryRowContext(ctx, sr.dialect.rebind(`SELECT COUNT(*) FROM contacts WHERE id = ? AND deleted_at <> ''`), id).Scan(&trashed)
+	if err != nil {
+		return err
+	}
+	if trashed == 0 {
+		return errors.New("contact is not found in the trash")
+	}
+
+	if err := sr.deleteDetails(ctx, tx, id); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, sr.dialect.rebind(`DELETE FROM contacts WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func checkAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return errors.New("contact is not found")
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// migrationLog is what migrating up, or down, through migs runs on the database
+func migrationLog(migs []sqlMigration, up bool, bookkeeping func(version int) string) []string {
+	var log []string
+	for _, mig := range migs {
+		statements := mig.Up
+		if !up {
+			statements = mig.Down
+		}
+		log = append(log, "BEGIN")
+		log = append(log, statements...)
+		log = append(log, bookkeeping(mig.Version), "COMMIT")
+	}
+	return log
+}
+
+func TestSQLMigrateUp(t *testing.T) {
+	db, rec := openRecordDB(t)
+	rec.rows["MAX(version)"] = [][]driver.Value{{nil}}
+
+	// PostgreSQL numbers its placeholders
+	if _, err := NewSQLContactRepository(db, "postgres", LatestSchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`,
+		`SELECT MAX(version) FROM schema_migrations`,
+	}
+	want = append(want, migrationLog(sqlMigrations, true, func(version int) string {
+		return fmt.Sprintf("INSERT INTO schema_migrations (version) VALUES ($1) [%d]", version)
+	})...)
+	if got := rec.statements(); !slices.Equal(got, want) {
+		t.Errorf("migrating up ran\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSQLMigrateDown(t *testing.T) {
+	db, rec := openRecordDB(t)
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+	rec.rows["MAX(version)"] = [][]driver.Value{{int64(latest)}}
+
+	if _, err := NewSQLContactRepository(db, "sqlite", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// every migration after 1, the last one first
+	down := slices.Clone(sqlMigrations[1:])
+	slices.Reverse(down)
+	want := []string{
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`,
+		`SELECT MAX(version) FROM schema_migrations`,
+	}
+	want = append(want, migrationLog(down, false, func(version int) string {
+		return fmt.Sprintf("DELETE FROM schema_migrations WHERE version = ? [%d]", version)
+	})...)
+	if got := rec.statements(); !slices.Equal(got, want) {
+		t.Errorf("migrating down ran\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSQLFailedMigrationRollsBack(t *testing.T) {
+	db, rec := openRecordDB(t)
+	rec.rows["MAX(version)"] = [][]driver.Value{{nil}}
+	failing := sqlMigrations[1].Up[0]
+	rec.fail[failing] = errors.New("syntax error")
+
+	_, err := NewSQLContactRepository(db, "sqlite", LatestSchemaVersion)
+	if err == nil || !strings.Contains(err.Error(), "migration 2") {
+		t.Fatalf("got %v, want migration 2 to fail", err)
+	}
+
+	// migration 1 stays, migration 2 is rolled back with its bookkeeping and nothing runs after it
+	got := rec.statements()
+	want := migrationLog(sqlMigrations[:1], true, func(version int) string {
+		return fmt.Sprintf("INSERT INTO schema_migrations (version) VALUES (?) [%d]", version)
+	})
+	want = append(want, "BEGIN", failing, "ROLLBACK")
+	if !slices.Equal(got[2:], want) {
+		t.Errorf("the failed migration ran\n%s\nwant\n%s", strings.Join(got[2:], "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSQLUnknownSchemaVersion(t *testing.T) {
+	db, rec := openRecordDB(t)
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+
+	if _, err := NewSQLContactRepository(db, "sqlite", latest+1); err == nil {
+		t.Error("migrating to an unknown version succeeded")
+	}
+	if got := rec.statements(); len(got) != 0 {
+		t.Errorf("an unknown version ran %v", got)
+	}
+}
+
+func TestMapSQLError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"postgres email", &fakeError{"23505", `duplicate key value violates unique constraint "contacts_email_unique"`}, domain.ErrEmailAlreadyExist},
+		{"postgres other email", &fakeError{"23505", `duplicate key value violates unique constraint "contact_emails_address_unique"`}, domain.ErrEmailAlreadyExist},
+		{"postgres primary key", &fakeError{"23505", `duplicate key value violates unique constraint "contacts_pkey"`}, domain.ErrIDAlreadyExist},
+		{"sqlite email", errors.New("UNIQUE constraint failed: contact_emails.address"), domain.ErrEmailAlreadyExist},
+		{"sqlite primary key", errors.New("UNIQUE constraint failed: contacts.id"), domain.ErrIDAlreadyExist},
+		{"mysql email", errors.New("Error 1062: Duplicate entry 'a@b.c' for key 'contacts.contacts_email_unique'"), domain.ErrEmailAlreadyExist},
+		{"mysql primary key", errors.New("Error 1062: Duplicate entry '7' for key 'contacts.PRIMARY'"), domain.ErrIDAlreadyExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mapSQLError(tt.err); !errors.Is(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a unique violation of another constraint is not an email or id clash
+	for _, err := range []error{
+		&fakeError{"23505", `duplicate key value violates unique constraint "contact_emails_pkey"`},
+		errors.New("UNIQUE constraint failed: contact_groups.name"),
+		errors.New("connection refused"),
+	} {
+		got := mapSQLError(err)
+		if errors.Is(got, domain.ErrEmailAlreadyExist) || errors.Is(got, domain.ErrIDAlreadyExist) {
+			t.Errorf("%v was mapped to %v", err, got)
+		}
+	}
+}
+
+func TestSQLSaveMapsUniqueViolation(t *testing.T) {
+	db, rec := openRecordDB(t)
+	rec.rows["MAX(version)"] = [][]driver.Value{{nil}}
+	sr, err := NewSQLContactRepository(db, "postgres", LatestSchemaVersion)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.statements()
+
+	rec.rows["next_id"] = [][]driver.Value{{int64(2)}}
+	rec.fail["INSERT INTO contacts"] = &fakeError{"23505", `duplicate key value violates unique constraint "contacts_email_unique"`}
+	err = sr.Save(domain.Contact{Name: "Ann", Email: "ann@mail.com"})
+	if !errors.Is(err, domain.ErrEmailAlreadyExist) {
+		t.Fatalf("got %v, want ErrEmailAlreadyExist", err)
+	}
+
+	// the ids reserved for the contact are given back with the rest of the transaction
+	got := rec.statements()
+	if got[0] != "BEGIN" || got[len(got)-1] != "ROLLBACK" || slices.Contains(got, "COMMIT") {
+		t.Errorf("the failed save ran\n%s\nwant one transaction that is rolled back", strings.Join(got, "\n"))
+	}
+}
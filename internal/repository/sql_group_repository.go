@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// SQLGroupRepository stores the groups in the same database as the contacts,
+// its tables are created by the migrations of SQLContactRepository
+type SQLGroupRepository struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// Groups returns the group repository sharing the database of the contacts
+func (sr *SQLContactRepository) Groups() *SQLGroupRepository {
+	return &SQLGroupRepository{db: sr.db, dialect: sr.dialect}
+}
+
+func (gr *SQLGroupRepository) query(query string, args ...any) ([]domain.Group, error) {
+	ctx := context.Background()
+
+	rows, err := gr.db.QueryContext(ctx, gr.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	defer rows.Close()
+
+	groups := []domain.Group{}
+	byID := map[int]int{}
+	for rows.Next() {
+		var grp domain.Group
+		if err := rows.Scan(&grp.ID, &grp.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %w", err)
+		}
+		byID[grp.ID] = len(groups)
+		groups = append(groups, grp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read groups: %w", err)
+	}
+
+	if len(groups) == 0 {
+		return groups, nil
+	}
+
+	// there are few groups, loading every membership is cheaper than listing the ids
+	memberRows, err := gr.db.QueryContext(ctx, `SELECT group_id, contact_id FROM group_members ORDER BY group_id, position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group members: %w", err)
+	}
+	defer memberRows.Close()
+	for memberRows.Next() {
+		var groupID, contactID int
+		if err := memberRows.Scan(&groupID, &contactID); err != nil {
+			return nil, fmt.Errorf("failed to scan group member: %w", err)
+		}
+		if idx, ok := byID[groupID]; ok {
+			groups[idx].MemberIDs = append(groups[idx].MemberIDs, contactID)
+		}
+	}
+	if err := memberRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read group members: %w", err)
+	}
+
+	subgroupRows, err := gr.db.QueryContext(ctx, `SELECT group_id, subgroup_id FROM group_subgroups ORDER BY group_id, position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query nested groups: %w", err)
+	}
+	defer subgroupRows.Close()
+	for subgroupRows.Next() {
+		var groupID, subgroupID int
+		if err := subgroupRows.Scan(&groupID, &subgroupID); err != nil {
+			return nil, fmt.Errorf("failed to scan nested group: %w", err)
+		}
+		if idx, ok := byID[groupID]; ok {
+			groups[idx].SubgroupIDs = append(groups[idx].SubgroupIDs, subgroupID)
+		}
+	}
+	if err := subgroupRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read nested groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// queryOne returns an empty group when nothing matches
+func (gr *SQLGroupRepository) queryOne(query string, args ...any) (domain.Group, error) {
+	groups, err := gr.query(query, args...)
+	if err != nil {
+		return domain.Group{}, err
+	}
+	if len(groups) == 0 {
+		return domain.Group{}, nil
+	}
+	return groups[0], nil
+}
+
+func (gr *SQLGroupRepository) GetAll() ([]domain.Group, error) {
+	return gr.query(`SELECT id, name FROM contact_groups ORDER BY id`)
+}
+
+func (gr *SQLGroupRepository) GetByID(id int) (domain.Group, error) {
+	return gr.queryOne(`SELECT id, name FROM contact_groups WHERE id = ?`, id)
+}
+
+func (gr *SQLGroupRepository) GetByName(name string) (domain.Group, error) {
+	return gr.queryOne(`SELECT id, name FROM contact_groups WHERE LOWER(name) = LOWER(?)`, name)
+}
+
+func (gr *SQLGroupRepository) Save(group domain.Group) error {
+	ctx := context.Background()
+
+	tx, err := gr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `UPDATE group_sequence SET next_id = next_id + 1`); err != nil {
+		return fmt.Errorf("failed to reserve group id: %w", err)
+	}
+	if err := tx.QueryRowContext(ctx, `SELECT next_id - 1 FROM group_sequence`).Scan(&group.ID); err != nil {
+		return fmt.Errorf("failed to reserve group id: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, gr.dialect.rebind(`INSERT INTO contact_groups (id, name) VALUES (?, ?)`), group.ID, group.Name); err != nil {
+		return fmt.Errorf("failed to save group %s: %w", group.Name, err)
+	}
+	if err := gr.insertMembers(ctx, tx, group); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (gr *SQLGroupRepository) Update(updated domain.Group) error {
+	ctx := context.Background()
+
+	tx, err := gr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, gr.dialect.rebind(`UPDATE contact_groups SET name = ? WHERE id = ?`), updated.Name, updated.ID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errors.New("group is not found")
+	}
+
+	// the lists are small, replacing them keeps the positions simple
+	if err := gr.deleteMembers(ctx, tx, updated.ID); err != nil {
+		return err
+	}
+	if err := gr.insertMembers(ctx, tx, updated); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (gr *SQLGroupRepository) Delete(id int) error {
+	ctx := context.Background()
+
+	tx, err := gr.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	if err := gr.deleteMembers(ctx, tx, id); err != nil {
+		return err
+	}
+	// the group also disappears from the groups it was nested in
+	if _, err := tx.ExecContext(ctx, gr.dialect.rebind(`DELETE FROM group_subgroups WHERE subgroup_id = ?`), id); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, gr.dialect.rebind(`DELETE FROM contact_groups WHERE id = ?`), id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return errors.New("group is not found")
+	}
+
+	return tx.Commit()
+}
+
+func (gr *SQLGroupRepository) insertMembers(ctx context.Context, tx *sql.Tx, group domain.Group) error {
+	insertMember := gr.dialect.rebind(`INSERT INTO group_members (group_id, position, contact_id) VALUES (?, ?, ?)`)
+	for pos, contactID := range group.MemberIDs {
+		if _, err := tx.ExecContext(ctx, insertMember, group.ID, pos, contactID); err != nil {
+			return fmt.Errorf("failed to add contact %d to group %s: %w", contactID, group.Name, err)
+		}
+	}
+
+	insertSubgroup := gr.dialect.rebind(`INSERT INTO group_subgroups (group_id, position, subgroup_id) VALUES (?, ?, ?)`)
+	for pos, subgroupID := range group.SubgroupIDs {
+		if _, err := tx.ExecContext(ctx, insertSubgroup, group.ID, pos, subgroupID); err != nil {
+			return fmt.Errorf("failed to nest group %d in group %s: %w", subgroupID, group.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (gr *SQLGroupRepository) deleteMembers(ctx context.Context, tx *sql.Tx, id int) error {
+	if _, err := tx.ExecContext(ctx, gr.dialect.rebind(`DELETE FROM group_members WHERE group_id = ?`), id); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, gr.dialect.rebind(`DELETE FROM group_subgroups WHERE group_id = ?`), id)
+	return err
+}
@@ -0,0 +1,298 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// sqlMigration is one versioned change of the schema,
+// Down must undo exactly what Up did
+type sqlMigration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// LatestSchemaVersion asks the migrations to go all the way up
+const LatestSchemaVersion = -1
+
+// sqlMigrations must only ever be appended to, a released migration never changes
+var sqlMigrations = []sqlMigration{
+	{
+		Version: 1,
+		Name:    "create contacts",
+		Up: []string{
+			`CREATE TABLE contacts (
+				id INTEGER PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				email VARCHAR(255) NOT NULL,
+				phone VARCHAR(64) NOT NULL DEFAULT ''
+			)`,
+			// ids are handed out from this table instead of an auto increment column,
+			// every database spells auto increment differently
+			`CREATE TABLE contact_sequence (next_id INTEGER NOT NULL)`,
+			`INSERT INTO contact_sequence (next_id) VALUES (1)`,
+		},
+		Down: []string{
+			`DROP TABLE contact_sequence`,
+			`DROP TABLE contacts`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "unique email and name index",
+		Up: []string{
+			`CREATE UNIQUE INDEX contacts_email_unique ON contacts (email)`,
+			`CREATE INDEX contacts_name_idx ON contacts (name)`,
+		},
+		Down: []string{
+			`DROP INDEX contacts_name_idx`,
+			`DROP INDEX contacts_email_unique`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "labelled emails and phones",
+		Up: []string{
+			// contacts.email and contacts.phone stay as the primary values
+			`CREATE TABLE contact_emails (
+				contact_id INTEGER NOT NULL REFERENCES contacts (id),
+				position INTEGER NOT NULL,
+				label VARCHAR(64) NOT NULL DEFAULT '',
+				address VARCHAR(255) NOT NULL,
+				is_primary INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (contact_id, position)
+			)`,
+			// no email may belong to two contacts
+			`CREATE UNIQUE INDEX contact_emails_address_unique ON contact_emails (address)`,
+			`CREATE TABLE contact_phones (
+				contact_id INTEGER NOT NULL REFERENCES contacts (id),
+				position INTEGER NOT NULL,
+				label VARCHAR(64) NOT NULL DEFAULT '',
+				number VARCHAR(64) NOT NULL,
+				is_primary INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (contact_id, position)
+			)`,
+			`INSERT INTO contact_emails (contact_id, position, label, address, is_primary)
+				SELECT id, 0, '', email, 1 FROM contacts`,
+			`INSERT INTO contact_phones (contact_id, position, label, number, is_primary)
+				SELECT id, 0, '', phone, 1 FROM contacts WHERE phone <> ''`,
+		},
+		Down: []string{
+			`DROP TABLE contact_phones`,
+			`DROP TABLE contact_emails`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "postal addresses",
+		Up: []string{
+			`CREATE TABLE contact_addresses (
+				contact_id INTEGER NOT NULL REFERENCES contacts (id),
+				position INTEGER NOT NULL,
+				label VARCHAR(64) NOT NULL DEFAULT '',
+				street VARCHAR(255) NOT NULL DEFAULT '',
+				locality VARCHAR(128) NOT NULL DEFAULT '',
+				region VARCHAR(128) NOT NULL DEFAULT '',
+				postal_code VARCHAR(32) NOT NULL DEFAULT '',
+				country_code VARCHAR(2) NOT NULL DEFAULT '',
+				PRIMARY KEY (contact_id, position)
+			)`,
+			`CREATE INDEX contact_addresses_locality_idx ON contact_addresses (locality)`,
+			`CREATE INDEX contact_addresses_country_idx ON contact_addresses (country_code)`,
+		},
+		Down: []string{
+			`DROP TABLE contact_addresses`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "tags",
+		Up: []string{
+			`CREATE TABLE contact_tags (
+				contact_id INTEGER NOT NULL REFERENCES contacts (id),
+				tag VARCHAR(64) NOT NULL,
+				PRIMARY KEY (contact_id, tag)
+			)`,
+			`CREATE INDEX contact_tags_tag_idx ON contact_tags (tag)`,
+		},
+		Down: []string{
+			`DROP TABLE contact_tags`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "groups",
+		Up: []string{
+			`CREATE TABLE contact_groups (
+				id INTEGER PRIMARY KEY,
+				name VARCHAR(255) NOT NULL
+			)`,
+			`CREATE UNIQUE INDEX contact_groups_name_unique ON contact_groups (name)`,
+			`CREATE TABLE group_sequence (next_id INTEGER NOT NULL)`,
+			`INSERT INTO group_sequence (next_id) VALUES (1)`,
+			// position keeps the order the members were added in
+			`CREATE TABLE group_members (
+				group_id INTEGER NOT NULL REFERENCES contact_groups (id),
+				position INTEGER NOT NULL,
+				contact_id INTEGER NOT NULL REFERENCES contacts (id),
+				PRIMARY KEY (group_id, position)
+			)`,
+			`CREATE INDEX group_members_contact_idx ON group_members (contact_id)`,
+			`CREATE TABLE group_subgroups (
+				group_id INTEGER NOT NULL REFERENCES contact_groups (id),
+				position INTEGER NOT NULL,
+				subgroup_id INTEGER NOT NULL REFERENCES contact_groups (id),
+				PRIMARY KEY (group_id, position)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE group_subgroups`,
+			`DROP TABLE group_members`,
+			`DROP TABLE group_sequence`,
+			`DROP TABLE contact_groups`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "timestamps and version",
+		Up: []string{
+			// RFC 3339 text in UTC, every database stores and sorts it the same way
+			`ALTER TABLE contacts ADD COLUMN created_at VARCHAR(40) NOT NULL DEFAULT ''`,
+			`ALTER TABLE contacts ADD COLUMN updated_at VARCHAR(40) NOT NULL DEFAULT ''`,
+			`ALTER TABLE contacts ADD COLUMN version INTEGER NOT NULL DEFAULT 0`,
+		},
+		Down: []string{
+			`ALTER TABLE contacts DROP COLUMN version`,
+			`ALTER TABLE contacts DROP COLUMN updated_at`,
+			`ALTER TABLE contacts DROP COLUMN created_at`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "trash",
+		Up: []string{
+			// '' while the contact is not in the trash
+			`ALTER TABLE contacts ADD COLUMN deleted_at VARCHAR(40) NOT NULL DEFAULT ''`,
+			`CREATE INDEX contacts_deleted_at_idx ON contacts (deleted_at)`,
+		},
+		Down: []string{
+			`DROP INDEX contacts_deleted_at_idx`,
+			`ALTER TABLE contacts DROP COLUMN deleted_at`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "audit log",
+		Up: []string{
+			// no foreign key on contact_id, the history outlives a purged contact
+			`CREATE TABLE contact_audit (
+				id INTEGER PRIMARY KEY,
+				contact_id INTEGER NOT NULL,
+				actor VARCHAR(255) NOT NULL DEFAULT '',
+				at VARCHAR(40) NOT NULL,
+				op VARCHAR(16) NOT NULL,
+				changes TEXT NOT NULL,
+				snapshot TEXT NOT NULL,
+				reverted_to INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX contact_audit_contact_idx ON contact_audit (contact_id)`,
+			`CREATE TABLE audit_sequence (next_id INTEGER NOT NULL)`,
+			`INSERT INTO audit_sequence (next_id) VALUES (1)`,
+		},
+		Down: []string{
+			`DROP TABLE audit_sequence`,
+			`DROP TABLE contact_audit`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "carddav card names",
+		Up: []string{
+			// '' for a contact that was not saved by a CardDAV client
+			`ALTER TABLE contacts ADD COLUMN uid VARCHAR(255) NOT NULL DEFAULT ''`,
+			`ALTER TABLE contacts ADD COLUMN card_name VARCHAR(255) NOT NULL DEFAULT ''`,
+		},
+		Down: []string{
+			`ALTER TABLE contacts DROP COLUMN card_name`,
+			`ALTER TABLE contacts DROP COLUMN uid`,
+		},
+	},
+}
+
+// migrate moves the schema to target, running up or down migrations as needed.
+// Every migration runs in its own transaction together with the version bookkeeping.
+func migrate(ctx context.Context, db *sql.DB, dialect sqlDialect, target int) error {
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+	if target == LatestSchemaVersion {
+		target = latest
+	}
+	if target < 0 || target > latest {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, latest)
+	}
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	current, err := schemaVersion(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range sqlMigrations {
+		if mig.Version > current && mig.Version <= target {
+			if err := runMigration(ctx, db, mig.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %d (%s) up failed: %w", mig.Version, mig.Name, err)
+			}
+		}
+	}
+
+	for i := len(sqlMigrations) - 1; i >= 0; i-- {
+		mig := sqlMigrations[i]
+		if mig.Version <= current && mig.Version > target {
+			if err := runMigration(ctx, db, mig.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, dialect.rebind(`DELETE FROM schema_migrations WHERE version = ?`), mig.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migration %d (%s) down failed: %w", mig.Version, mig.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func schemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+func runMigration(ctx context.Context, db *sql.DB, statements []string, record func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// no-op after a commit
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := record(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
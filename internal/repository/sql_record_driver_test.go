@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordDriver is a database/sql driver that runs nothing. It records every statement
+// and transaction, fails the statements a test asks for and answers queries with canned rows,
+// so a test can check what the storage sends to the database. What the database does with it
+// is tested against SQLite, see sql_sqlite_test.go
+type recordDriver struct {
+	mu  sync.Mutex
+	log []string
+	// fail makes a statement that contains the key fail with the error
+	fail map[string]error
+	// rows are the rows of a query that contains the key
+	rows map[string][][]driver.Value
+}
+
+// openRecordDB opens a database on a new recordDriver
+func openRecordDB(t *testing.T) (*sql.DB, *recordDriver) {
+	t.Helper()
+	rec := &recordDriver{fail: map[string]error{}, rows: map[string][][]driver.Value{}}
+	db := sql.OpenDB(rec)
+	t.Cleanup(func() { db.Close() })
+	return db, rec
+}
+
+// statements returns what was run so far and starts a new log
+func (rec *recordDriver) statements() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	log := rec.log
+	rec.log = nil
+	return log
+}
+
+func (rec *recordDriver) run(query string, args []driver.Value) ([][]driver.Value, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	entry := query
+	if len(args) > 0 {
+		entry += fmt.Sprint(" ", args)
+	}
+	rec.log = append(rec.log, entry)
+
+	for key, err := range rec.fail {
+		if strings.Contains(query, key) {
+			return nil, err
+		}
+	}
+	for key, rows := range rec.rows {
+		if strings.Contains(query, key) {
+			return rows, nil
+		}
+	}
+	return nil, nil
+}
+
+func (rec *recordDriver) record(entry string) error {
+	_, err := rec.run(entry, nil)
+	return err
+}
+
+func (rec *recordDriver) Connect(context.Context) (driver.Conn, error) { return recordConn{rec}, nil }
+func (rec *recordDriver) Driver() driver.Driver                        { return nil }
+
+type recordConn struct{ rec *recordDriver }
+
+func (c recordConn) Prepare(query string) (driver.Stmt, error) { return recordStmt{c.rec, query}, nil }
+func (c recordConn) Close() error                              { return nil }
+func (c recordConn) Begin() (driver.Tx, error)                 { return c, c.rec.record("BEGIN") }
+func (c recordConn) Commit() error                             { return c.rec.record("COMMIT") }
+func (c recordConn) Rollback() error                           { return c.rec.record("ROLLBACK") }
+
+type recordStmt struct {
+	rec   *recordDriver
+	query string
+}
+
+func (s recordStmt) Close() error  { return nil }
+func (s recordStmt) NumInput() int { return -1 }
+
+func (s recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if _, err := s.rec.run(s.query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, err := s.rec.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &recordRows{rows: rows}, nil
+}
+
+type recordRows struct{ rows [][]driver.Value }
+
+func (r *recordRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *recordRows) Close() error { return nil }
+
+func (r *recordRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeError is a driver error that tells its SQLSTATE like the PostgreSQL drivers do
+type fakeError struct {
+	state string
+	msg   string
+}
+
+func (e *fakeError) Error() string    { return e.msg }
+func (e *fakeError) SQLState() string { return e.state }
@@ -0,0 +1,175 @@
+//go:build sqlite
+
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	_ "modernc.org/sqlite"
+)
+
+// the tests in this file run the sql storage against SQLite, an embedded database
+// with real constraints and transactions: go test -tags sqlite ./internal/repository
+
+func newTestSQLRepository(t *testing.T) *SQLContactRepository {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "contacts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sr, err := NewSQLContactRepository(db, "sqlite", LatestSchemaVersion)
+	if err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+	return sr
+}
+
+// sqliteTables returns the names of the tables, sorted
+func sqliteTables(t *testing.T, db *sql.DB) []string {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table' ORDER BY name`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, name)
+	}
+	return tables
+}
+
+func TestSQLMigrationsUpAndDown(t *testing.T) {
+	sr := newTestSQLRepository(t)
+	latest := sqlMigrations[len(sqlMigrations)-1].Version
+
+	var version int
+	if err := sr.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil || version != latest {
+		t.Fatalf("schema version is %d (%v), want %d", version, err, latest)
+	}
+
+	ann := domain.Contact{Name: "Ann", Email: "ann@mail.com", Phone: "0811"}
+	if err := sr.Save(ann); err != nil {
+		t.Fatal(err)
+	}
+
+	// down past the labelled emails and back up, migration 3 copies the email back from contacts.email
+	if _, err := NewSQLContactRepository(sr.db, "sqlite", 2); err != nil {
+		t.Fatalf("migrate down to 2: %v", err)
+	}
+	if tables := sqliteTables(t, sr.db); slices.Contains(tables, "contact_emails") || !slices.Contains(tables, "contacts") {
+		t.Errorf("tables at version 2 are %v", tables)
+	}
+	if _, err := NewSQLContactRepository(sr.db, "sqlite", LatestSchemaVersion); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+	got, err := sr.GetByEmail("ann@mail.com")
+	if err != nil || got.Name != "Ann" || len(got.Phones) != 1 {
+		t.Errorf("after down and up got %+v (%v), want Ann with her phone", got, err)
+	}
+
+	// all the way down leaves only the bookkeeping
+	if _, err := NewSQLContactRepository(sr.db, "sqlite", 0); err != nil {
+		t.Fatalf("migrate down to 0: %v", err)
+	}
+	if tables := sqliteTables(t, sr.db); !slices.Equal(tables, []string{"schema_migrations"}) {
+		t.Errorf("tables at version 0 are %v, want only schema_migrations", tables)
+	}
+}
+
+func TestSQLUniqueEmail(t *testing.T) {
+	sr := newTestSQLRepository(t)
+
+	ann := domain.Contact{Name: "Ann", Emails: []domain.Email{
+		{Address: "ann@mail.com", Primary: true},
+		{Label: "work", Address: "ann@work.com"},
+	}, Email: "ann@mail.com"}
+	if err := sr.Save(ann); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		contact domain.Contact
+	}{
+		{"primary email", domain.Contact{Name: "Bob", Email: "ann@mail.com"}},
+		{"other email", domain.Contact{Name: "Bob", Email: "ann@work.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := sr.Save(tt.contact); !errors.Is(err, domain.ErrEmailAlreadyExist) {
+				t.Errorf("got %v, want ErrEmailAlreadyExist", err)
+			}
+		})
+	}
+}
+
+func TestSQLImportAllRollsBack(t *testing.T) {
+	sr := newTestSQLRepository(t)
+	if err := sr.Save(domain.Contact{Name: "Ann", Email: "ann@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	ann, _ := sr.GetByEmail("ann@mail.com")
+
+	tests := []struct {
+		name             string
+		created, updated []domain.Contact
+		want             error
+	}{
+		{
+			name:    "email taken by the store",
+			created: []domain.Contact{{Name: "Bob", Email: "bob@mail.com"}, {Name: "Ann 2", Email: "ann@mail.com"}},
+			want:    domain.ErrEmailAlreadyExist,
+		},
+		{
+			name:    "email twice in the import",
+			created: []domain.Contact{{Name: "Bob", Email: "bob@mail.com"}, {Name: "Bob 2", Email: "bob@mail.com"}},
+			want:    domain.ErrEmailAlreadyExist,
+		},
+		{
+			name:    "kept id taken",
+			created: []domain.Contact{{Name: "Bob", Email: "bob@mail.com"}, {ID: ann.ID, Name: "Cid", Email: "cid@mail.com"}},
+			want:    domain.ErrIDAlreadyExist,
+		},
+		{
+			name:    "stale update",
+			created: []domain.Contact{{Name: "Bob", Email: "bob@mail.com"}},
+			updated: []domain.Contact{{ID: ann.ID, Name: "Ann B", Email: "ann@mail.com", Version: ann.Version + 1}},
+			want:    domain.ErrConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := sr.ImportAll(tt.created, tt.updated); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+
+			contacts, err := sr.GetAll()
+			if err != nil || len(contacts) != 1 || contacts[0].Name != "Ann" {
+				t.Errorf("after the failed import the store has %+v (%v), want only Ann", contacts, err)
+			}
+			if bob, _ := sr.GetByEmail("bob@mail.com"); bob.ID != 0 {
+				t.Errorf("bob was saved with id %d", bob.ID)
+			}
+		})
+	}
+
+	// the ids reserved by the failed imports were rolled back too
+	saved, err := sr.ImportAll([]domain.Contact{{Name: "Bob", Email: "bob@mail.com"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved[0].ID != ann.ID+1 {
+		t.Errorf("bob got id %d, want %d", saved[0].ID, ann.ID+1)
+	}
+}
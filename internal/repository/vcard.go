@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/vcard"
+)
+
+// VCardUID is the UID a contact is written with, it stays the same across edits.
+// A contact saved by a CardDAV client keeps the UID the client gave it
+func VCardUID(ctc domain.Contact) string {
+	if ctc.UID != "" {
+		return ctc.UID
+	}
+	return fmt.Sprintf("contact-%d", ctc.ID)
+}
+
+// VCardVersions are the versions ContactToVCard writes
+var VCardVersions = []string{"3.0", "4.0"}
+
+// ContactToVCard writes a contact as a vCard of version 3.0 or 4.0
+func ContactToVCard(ctc domain.Contact, version string) vcard.Card {
+	card := vcard.Card{
+		{Name: "PRODID", Value: "-//contact-management//EN"},
+		{Name: "UID", Value: VCardUID(ctc)},
+		{Name: "FN", Value: ctc.Name},
+		vcard.NewStructured("N", splitName(ctc.Name)...),
+	}
+
+	for _, em := range ctc.EmailList() {
+		p := vcard.Property{Name: "EMAIL", Value: em.Address}
+		if version == "3.0" {
+			p.Params = map[string][]string{"TYPE": {"INTERNET"}}
+		}
+		card = addLabelled(card, p, version, em.Label, em.Primary)
+	}
+
+	for _, ph := range ctc.PhoneList() {
+		p := vcard.Property{Name: "TEL", Value: ph.Number}
+		label := ph.Label
+		if strings.EqualFold(label, "mobile") {
+			// the vCard name of a mobile phone
+			label = "cell"
+		}
+		card = addLabelled(card, p, version, label, ph.Primary)
+	}
+
+	for _, addr := range ctc.Addresses {
+		country := ""
+		if addr.CountryCode != "" {
+			country = domain.CountryName(addr.CountryCode)
+		}
+		// post office box, extended address, street, locality, region, postal code, country
+		p := vcard.NewStructured("ADR", "", "", addr.Street, addr.Locality, addr.Region, addr.PostalCode, country)
+		card = addLabelled(card, p, version, addr.Label, false)
+	}
+
+	if len(ctc.Tags) > 0 {
+		card = append(card, vcard.NewList("CATEGORIES", ctc.Tags...))
+	}
+	if !ctc.UpdatedAt.IsZero() {
+		card = append(card, vcard.Property{Name: "REV", Value: ctc.UpdatedAt.UTC().Format("20060102T150405Z")})
+	}
+	return card
+}
+
+// vcardLabels are the labels a TYPE can carry, any other label is written the way
+// Apple does, as an X-ABLABEL in the group of the property, e.g. item1.EMAIL
+var vcardLabels = map[string]bool{"home": true, "work": true, "cell": true, "voice": true, "fax": true, "pager": true, "text": true, "video": true}
+
+// addLabelled adds p with its label to the card. The primary one is marked
+// with TYPE=PREF in vCard 3.0 and with PREF=1 in 4.0, which writes types in lower case
+func addLabelled(card vcard.Card, p vcard.Property, version, label string, primary bool) vcard.Card {
+	if p.Params == nil {
+		p.Params = map[string][]string{}
+	}
+
+	var custom *vcard.Property
+	label = strings.ToLower(strings.TrimSpace(label))
+	switch {
+	case label == "":
+	case vcardLabels[label] && version == "3.0":
+		p.Params["TYPE"] = append(p.Params["TYPE"], strings.ToUpper(label))
+	case vcardLabels[label]:
+		p.Params["TYPE"] = append(p.Params["TYPE"], label)
+	default:
+		p.Group = fmt.Sprintf("item%d", len(card.All("X-ABLABEL"))+1)
+		custom = &vcard.Property{Group: p.Group, Name: "X-ABLABEL", Value: label}
+	}
+
+	if primary && version == "3.0" {
+		p.Params["TYPE"] = append(p.Params["TYPE"], "PREF")
+	} else if primary {
+		p.Params["PREF"] = []string{"1"}
+	}
+	if len(p.Params["TYPE"]) == 0 {
+		delete(p.Params, "TYPE")
+	}
+
+	card = append(card, p)
+	if custom != nil {
+		card = append(card, *custom)
+	}
+	return card
+}
+
+// splitName fills the N property from a full name: the last word is the family name
+func splitName(name string) []string {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return []string{name, "", "", "", ""}
+	}
+	return []string{words[len(words)-1], strings.Join(words[:len(words)-1], " "), "", "", ""}
+}
+
+// ContactFromVCard reads a vCard into a contact without an ID,
+// the contact still has to be validated before it is saved
+func ContactFromVCard(card vcard.Card) domain.Contact {
+	ctc := domain.Contact{Name: strings.TrimSpace(card.Value("FN"))}
+	if ctc.Name == "" {
+		// N is family;given;additional;prefix;suffix
+		if n, ok := card.Get("N"); ok {
+			parts := n.Components()
+			for len(parts) < 5 {
+				parts = append(parts, "")
+			}
+			ctc.Name = strings.Join(strings.Fields(strings.Join([]string{parts[3], parts[1], parts[2], parts[0], parts[4]}, " ")), " ")
+		}
+	}
+
+	if ctc.Name == "" {
+		// a card of a company may only have its organization
+		if org, ok := card.Get("ORG"); ok {
+			ctc.Name = strings.TrimSpace(org.Components()[0])
+		}
+	}
+
+	for _, p := range card.All("EMAIL") {
+		if addr := strings.TrimSpace(p.Value); addr != "" {
+			ctc.Emails = append(ctc.Emails, domain.Email{Label: vcardLabel(card, p), Address: addr, Primary: isPreferred(p)})
+		}
+	}
+
+	for _, p := range card.All("TEL") {
+		number := strings.TrimPrefix(strings.TrimSpace(p.Value), "tel:")
+		if number == "" {
+			continue
+		}
+		label := vcardLabel(card, p)
+		if label == "cell" {
+			label = "mobile"
+		}
+		ctc.Phones = append(ctc.Phones, domain.Phone{Label: label, Number: number, Primary: isPreferred(p)})
+	}
+
+	for _, p := range card.All("ADR") {
+		parts := p.Components()
+		for len(parts) < 7 {
+			parts = append(parts, "")
+		}
+		street := strings.TrimSpace(strings.Join(strings.Fields(parts[1]+" "+parts[2]), " "))
+		if parts[0] != "" {
+			street = strings.TrimSpace(parts[0] + " " + street)
+		}
+		addr := domain.Address{
+			Label:       vcardLabel(card, p),
+			Street:      street,
+			Locality:    strings.TrimSpace(parts[3]),
+			Region:      strings.TrimSpace(parts[4]),
+			PostalCode:  strings.TrimSpace(parts[5]),
+			CountryCode: domain.CountryCodeFor(parts[6]),
+		}
+		if !addr.IsEmpty() {
+			ctc.Addresses = append(ctc.Addresses, addr)
+		}
+	}
+
+	var tags []string
+	for _, p := range card.All("CATEGORIES") {
+		for _, item := range p.ListItems() {
+			if tag := sanitizeTag(item); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	ctc.Tags = domain.NormalizeTags(tags)
+
+	ctc.Normalize()
+	return ctc
+}
+
+// vcardTypes are the TYPE values that are not a label
+var vcardTypes = map[string]bool{"pref": true, "internet": true, "x400": true, "voice": true, "msg": true, "postal": true, "parcel": true, "dom": true, "intl": true}
+
+// vcardLabel is the first TYPE of p that is a label like work or home. Apple writes
+// a custom label as an X-ABLABEL of the same group, e.g. item1.EMAIL and item1.X-ABLABEL
+func vcardLabel(card vcard.Card, p vcard.Property) string {
+	if p.Group != "" {
+		for _, other := range card.All("X-ABLABEL") {
+			if other.Group == p.Group {
+				// the built in labels look like _$!<Mobile>!$_
+				label := strings.TrimSuffix(strings.TrimPrefix(other.Value, "_$!<"), ">!$_")
+				return strings.ToLower(strings.TrimSpace(label))
+			}
+		}
+	}
+	for _, t := range p.Params["TYPE"] {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" && !vcardTypes[t] {
+			return t
+		}
+	}
+	return ""
+}
+
+// isPreferred reports whether p is marked as the preferred one,
+// vCard 3.0 uses TYPE=PREF and 4.0 a PREF parameter from 1 to 100
+func isPreferred(p vcard.Property) bool {
+	return p.HasType("pref") || p.Param("PREF") == "1"
+}
+
+// sanitizeTag turns a category like "VIP Customers" into a valid tag like vip-customers
+func sanitizeTag(category string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(category)) {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteRune('-')
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		}
+	}
+	tag := strings.Trim(b.String(), "-._")
+	if tag == "and" || tag == "or" || tag == "not" {
+		return ""
+	}
+	return tag
+}
+
+// WriteContactsVCard writes the contacts of source to a .vcf file of the given version,
+// one card per contact. The file only replaces filename once it is complete
+func WriteContactsVCard(filename, version string, source ContactSource) error {
+	err := writeFileStream(filename, func(w io.Writer) error {
+		vw := vcard.NewWriter(w, version)
+		err := source(func(ctc domain.Contact) error {
+			vw.Write(ContactToVCard(ctc, version))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return vw.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %w", filename, err)
+	}
+	return nil
+}
+
+// ReadVCardRows reads the cards of a .vcf file of version 2.1, 3.0 or 4.0 and calls fn with
+// the contact of each in the order of the file, see ReadJSONRows. A card is read at a time,
+// so the file does not have to fit in memory
+func ReadVCardRows(filename string, fn func(ImportRow) error) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	vr := vcard.NewReader(file)
+	for {
+		card, err := vr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode vCard: %w", err)
+		}
+
+		row := ImportRow{Line: vr.Line(), Text: cardText(card), Contact: ContactFromVCard(card), End: vr.Offset()}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+}
+
+// cardText is a card on one line for the import report, a PHOTO or another binary value is left out
+func cardText(card vcard.Card) string {
+	var props []string
+	for _, p := range card {
+		if encoding := strings.ToUpper(p.Param("ENCODING")); p.Name == "VERSION" || encoding == "B" || encoding == "BASE64" || strings.HasPrefix(p.Value, "data:") {
+			continue
+		}
+		props = append(props, p.Name+":"+p.Value)
+	}
+	return strings.Join(props, "; ")
+}
@@ -0,0 +1,159 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+var (
+	ErrNoHistory         = errors.New("no history found")
+	ErrRevisionNotFound  = errors.New("revision not found for this contact")
+	ErrRevertNeedsActive = errors.New("the contact is in the trash, restore it before reverting")
+)
+
+// SetActor names who the following changes are recorded for in the audit log
+func (cs *ContactService) SetActor(actor string) {
+	cs.actor = actor
+}
+
+// Actor is who the changes are currently recorded for
+func (cs *ContactService) Actor() string {
+	return cs.actor
+}
+
+// record appends an audit entry for a change that is already saved and
+// remembers it for undo. before and after are the contact around the change,
+// an empty contact stands for "did not exist" so a create or a delete diffs every field.
+func (cs *ContactService) record(op domain.AuditOp, before, after domain.Contact, revertedTo int) error {
+	cs.track(op, before, after)
+
+	if cs.audit == nil {
+		return nil
+	}
+
+	entry := domain.AuditEntry{
+		ContactID:  after.ID,
+		Actor:      cs.actor,
+		At:         time.Now().UTC(),
+		Op:         op,
+		Changes:    domain.DiffContacts(before, after),
+		Snapshot:   after,
+		RevertedTo: revertedTo,
+	}
+	if op == domain.AuditDelete || op == domain.AuditPurge {
+		entry.ContactID = before.ID
+		entry.Snapshot = before
+	}
+
+	if err := cs.audit.Append(entry); err != nil {
+		// the change itself is saved
+		return fmt.Errorf("change saved but audit log failed: %w", err)
+	}
+
+	return nil
+}
+
+// contactIDs returns the ids of every contact, in and out of the trash
+func (cs *ContactService) contactIDs() (map[int]bool, error) {
+	ids := map[int]bool{}
+	contacts, err := cs.repo.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve contacts: %w", err)
+	}
+	trash, err := cs.repo.GetTrash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trash: %w", err)
+	}
+
+	for _, ctc := range append(contacts, trash...) {
+		ids[ctc.ID] = true
+	}
+	return ids, nil
+}
+
+// GetHistory returns the audit entries of a contact, oldest first.
+// The history of a purged contact is kept.
+func (cs *ContactService) GetHistory(contactID int) ([]domain.AuditEntry, error) {
+	if cs.audit == nil {
+		return nil, ErrNoHistory
+	}
+
+	entries, err := cs.audit.GetByContact(contactID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve history: %w", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, ErrNoHistory
+	}
+
+	return entries, nil
+}
+
+// RevertContact puts the contact back the way it was after the change recorded
+// as revision. The revert is a new change itself, so it can be reverted as well.
+func (cs *ContactService) RevertContact(contactID, revision int) error {
+	if cs.audit == nil {
+		return ErrRevisionNotFound
+	}
+
+	entry, err := cs.audit.GetByID(revision)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve revision: %w", err)
+	}
+	if entry.ID == 0 || entry.ContactID != contactID {
+		return ErrRevisionNotFound
+	}
+
+	current, err := cs.repo.GetByID(contactID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve contact: %w", err)
+	}
+	if current.ID == 0 {
+		if _, err := cs.findInTrash(contactID); err == nil {
+			return ErrRevertNeedsActive
+		}
+		return ErrNoContacts
+	}
+
+	target := entry.Snapshot
+	target.ID = current.ID
+	target.Version = current.Version
+	target.DeletedAt = time.Time{}
+
+	if err := cs.validateContact(&target); err != nil {
+		return err
+	}
+
+	return cs.step(fmt.Sprintf("revert contact %d to revision %d", contactID, revision), func() error {
+		if err := cs.repo.Update(target); err != nil {
+			return fmt.Errorf("revert failed: %w", err)
+		}
+
+		after, err := cs.repo.GetByID(contactID)
+		if err != nil {
+			return fmt.Errorf("change saved but audit log failed: %w", err)
+		}
+
+		return cs.record(domain.AuditRevert, current, after, revision)
+	})
+}
+
+// findInTrash returns the contact with id from the trash
+func (cs *ContactService) findInTrash(id int) (domain.Contact, error) {
+	trash, err := cs.repo.GetTrash()
+	if err != nil {
+		return domain.Contact{}, fmt.Errorf("failed to retrieve trash: %w", err)
+	}
+
+	for _, ctc := range trash {
+		if ctc.ID == id {
+			return ctc, nil
+		}
+	}
+
+	return domain.Contact{}, ErrNoContacts
+}
@@ -0,0 +1,606 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/repository"
+)
+
+// ImportOutcome is what an import does with a row of a file
+type ImportOutcome string
+
+const (
+	// ImportCreate adds the row as a new contact
+	ImportCreate ImportOutcome = "create"
+	// ImportUpdate replaces the contact that has the email of the row
+	ImportUpdate ImportOutcome = "update"
+	// ImportSkip leaves out a row that is the same as the contact with its email
+	ImportSkip ImportOutcome = "skip"
+	// ImportFailed leaves out a row that can not be read or is not valid, see ImportRowReport.Err
+	ImportFailed ImportOutcome = "error"
+)
+
+// ImportConflict is what an import does with a row whose email belongs to a stored contact
+type ImportConflict string
+
+const (
+	// ConflictSkip leaves the stored contact as it is
+	ConflictSkip ImportConflict = "skip"
+	// ConflictOverwrite replaces every field of the stored contact with the row
+	ConflictOverwrite ImportConflict = "overwrite"
+	// ConflictMerge keeps the stored contact and adds what the row has: a name
+	// that is not empty replaces the stored one and the emails, phones,
+	// addresses and tags it does not have yet are added
+	ConflictMerge ImportConflict = "merge"
+	// ConflictDuplicate creates a new contact from the row, an email belongs to one
+	// contact only so it keeps the emails no stored contact has
+	ConflictDuplicate ImportConflict = "duplicate"
+)
+
+// ImportConflicts are the conflict strategies in the order they are offered
+var ImportConflicts = []ImportConflict{ConflictSkip, ConflictOverwrite, ConflictMerge, ConflictDuplicate}
+
+// IDPolicy tells which ID a contact created by an import gets
+type IDPolicy string
+
+const (
+	// IDReassign gives every new contact the next free ID like adding it does
+	IDReassign IDPolicy = "reassign"
+	// IDPreserve keeps the ID of the row, a row with an ID another contact has fails
+	IDPreserve IDPolicy = "preserve"
+)
+
+// IDPolicies are the id policies in the order they are offered
+var IDPolicies = []IDPolicy{IDReassign, IDPreserve}
+
+// DefaultImportBatchSize is the batch size the menu, the import command and the
+// REST API import with unless one is given, so a large file is never held at once
+const DefaultImportBatchSize = 1000
+
+// ImportOptions change how a file is imported
+type ImportOptions struct {
+	// DryRun checks every row and reports its outcome without saving anything
+	DryRun bool
+	// Profile is the saved mapping profile a .csv file is read with,
+	// empty detects the columns from the header
+	Profile string
+	// Conflict is ConflictOverwrite when empty
+	Conflict ImportConflict
+	// IDs is IDReassign when empty
+	IDs IDPolicy
+	// BatchSize saves the rows every BatchSize rows, each batch in one transaction.
+	// A batch that fails, or an import that is cancelled, keeps the batches saved before it.
+	// The rows of a batch are checked against each other and the store, so a row with the
+	// email of a row in an earlier batch meets the contact that row saved. A batched import
+	// can not be undone. 0 saves the whole file in one transaction, so nothing is saved when it fails.
+	// A dry run checks the rows the same way, a batch at a time
+	BatchSize int
+	// OnRow is called with the outcome of every row once its batch is saved, or checked on a
+	// dry run. When it is set the report only counts the rows, so with a BatchSize the memory
+	// an import needs does not grow with the file
+	OnRow func(ImportRowReport)
+	// OnProgress is called while the file is read, see Progress
+	OnProgress func(Progress)
+}
+
+// check fills in the defaults and makes sure the options are known
+func (opts *ImportOptions) check() error {
+	if opts.Conflict == "" {
+		opts.Conflict = ConflictOverwrite
+	}
+	if opts.IDs == "" {
+		opts.IDs = IDReassign
+	}
+	opts.BatchSize = max(opts.BatchSize, 0)
+	if !slices.Contains(ImportConflicts, opts.Conflict) {
+		return ErrUnsupportedConflictStrategy
+	}
+	if !slices.Contains(IDPolicies, opts.IDs) {
+		return ErrUnsupportedIDPolicy
+	}
+	return nil
+}
+
+// ImportRowReport is the outcome of one row of an imported file
+type ImportRowReport struct {
+	Line    int
+	Row     string
+	Outcome ImportOutcome
+	// Contact is the contact the row creates or updates, or the stored one it skips
+	Contact domain.Contact
+	Err     error
+}
+
+// ImportReport tells what an import did, or would do on a dry run, with every row of a file
+type ImportReport struct {
+	File     string
+	DryRun   bool
+	Conflict ImportConflict
+	IDs      IDPolicy
+	// Rows are left empty when ImportOptions.OnRow is set
+	Rows []ImportRowReport
+	// Dialect, Profile and Unmapped are only set for a .csv file, see repository.CSVImport
+	Dialect  string
+	Profile  string
+	Unmapped []string
+	counts   map[ImportOutcome]int
+}
+
+// Count returns the number of rows with the outcome
+func (r ImportReport) Count(outcome ImportOutcome) int {
+	return r.counts[outcome]
+}
+
+// Contacts returns the contacts the import created or updated, see Rows
+func (r ImportReport) Contacts() []domain.Contact {
+	var contacts []domain.Contact
+	for _, row := range r.Rows {
+		if row.Outcome == ImportCreate || row.Outcome == ImportUpdate {
+			contacts = append(contacts, row.Contact)
+		}
+	}
+	return contacts
+}
+
+// ImportFromJSON imports a file of the JSON export in the data folder, see importer.
+// Every contact is validated like a new one, see planRow
+func (cs *ContactService) ImportFromJSON(ctx context.Context, filename string, opts ImportOptions) (ImportReport, error) {
+	return cs.importDataFile(ctx, filename, opts, "JSON", []string{".json"}, repository.ReadJSONRows)
+}
+
+// ImportFromVCard imports every card of a .vcf file in the data folder like the rows of a JSON file
+func (cs *ContactService) ImportFromVCard(ctx context.Context, filename string, opts ImportOptions) (ImportReport, error) {
+	return cs.importDataFile(ctx, filename, opts, "vCard", []string{".vcf", ".vcard"}, repository.ReadVCardRows)
+}
+
+// ImportFromLDIF imports every person of an .ldif file in the data folder like the rows of a JSON file
+func (cs *ContactService) ImportFromLDIF(ctx context.Context, filename string, opts ImportOptions) (ImportReport, error) {
+	return cs.importDataFile(ctx, filename, opts, "LDIF", []string{".ldif"}, repository.ReadLDIFRows)
+}
+
+// importDataFile imports a file of the data folder whose rows are read by read, see importer
+func (cs *ContactService) importDataFile(ctx context.Context, filename string, opts ImportOptions, format string, exts []string, read func(string, func(repository.ImportRow) error) error) (ImportReport, error) {
+	if err := opts.check(); err != nil {
+		return ImportReport{}, err
+	}
+	filename = strings.TrimSpace(filename)
+
+	if filename == "" || !slices.Contains(exts, strings.ToLower(filepath.Ext(filename))) || strings.Contains(filename, "..") {
+		return ImportReport{}, ErrInvalidImportFilename
+	}
+	path := filepath.Join("data", filename)
+
+	imp, err := cs.newImporter(ctx, ImportReport{File: filename}, opts, path)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	err = cs.step("import "+filename, func() error {
+		if err := read(path, imp.add); err != nil {
+			return imp.readError(fmt.Errorf("failed to import %s contacts: %w", format, err))
+		}
+		return imp.save()
+	})
+	return imp.finish(err)
+}
+
+// ImportFromCSV imports a .csv file with the columns of the saved profile named in opts,
+// or of the dialect told by the header row when it names none, see importer
+func (cs *ContactService) ImportFromCSV(ctx context.Context, filename string, opts ImportOptions) (ImportReport, error) {
+	if err := opts.check(); err != nil {
+		return ImportReport{}, err
+	}
+
+	var profile *CSVProfile
+	if name := strings.TrimSpace(opts.Profile); name != "" {
+		p, err := cs.profiles.GetByName(name)
+		if err != nil {
+			return ImportReport{}, err
+		}
+		profile = &p
+	}
+
+	imp, err := cs.newImporter(ctx, ImportReport{File: filename}, opts, filename)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	err = cs.step("import "+filename, func() error {
+		imported, err := repository.ReadCSVRows(filename, profile, imp.add)
+		if err != nil {
+			return imp.readError(err)
+		}
+		imp.report.Dialect, imp.report.Profile, imp.report.Unmapped = imported.Dialect, imported.Profile, imported.Unmapped
+		return imp.save()
+	})
+	return imp.finish(err)
+}
+
+// importPlan holds what the rows read since the last save claim
+type importPlan struct {
+	opts ImportOptions
+	// ids are the ids of every stored contact, in and out of the trash, they are only loaded to preserve ids
+	ids map[int]bool
+	// emails, contacts and kept are the line that claimed an email, a stored contact or the id of a new contact.
+	// A saved batch is in the store, so they start over, see reset
+	emails   map[string]int
+	contacts map[int]int
+	kept     map[int]int
+	// stored are the contacts as they were before the rows of the batch that update them
+	stored map[int]domain.Contact
+}
+
+// importer imports the rows of a file while it is read. It decides the outcome of every row
+// and, unless it is a dry run, saves the created and updated contacts of a batch of rows
+// with one ImportAll, see ImportOptions.BatchSize. A row is validated like a new contact:
+// a row whose email belongs to a stored contact is handled by the conflict strategy, and a
+// row with an email or a contact of an earlier row of the batch fails. Only the rows of the
+// batch that is read are held, unless the report keeps every row
+type importer struct {
+	cs       *ContactService
+	ctx      context.Context
+	report   ImportReport
+	plan     importPlan
+	progress *progressTracker
+	// batch are the rows read since the last save, a dry run reports them right away
+	// so batchRows counts the rows planned since the plan started over
+	batch     []ImportRowReport
+	batchRows int
+	// line is the line of the last row read, saved the contacts created or updated
+	// so far by the rows up to line savedTo
+	line    int
+	saved   int
+	savedTo int
+	// saveErr is set when a batch could not be saved
+	saveErr error
+}
+
+// newImporter starts an import of the file at path, its size tells the progress
+func (cs *ContactService) newImporter(ctx context.Context, report ImportReport, opts ImportOptions, path string) (*importer, error) {
+	report.DryRun, report.Conflict, report.IDs = opts.DryRun, opts.Conflict, opts.IDs
+	report.counts = map[ImportOutcome]int{}
+
+	plan := importPlan{
+		opts:     opts,
+		emails:   map[string]int{},
+		contacts: map[int]int{},
+		kept:     map[int]int{},
+		stored:   map[int]domain.Contact{},
+	}
+	if opts.IDs == IDPreserve {
+		ids, err := cs.contactIDs()
+		if err != nil {
+			return nil, err
+		}
+		plan.ids = ids
+	}
+
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+
+	return &importer{
+		cs:       cs,
+		ctx:      ctx,
+		report:   report,
+		plan:     plan,
+		progress: newProgressTracker(opts.OnProgress, size),
+	}, nil
+}
+
+// add plans a row and saves the batch once it is full, a cancelled import stops at the next row
+func (imp *importer) add(row repository.ImportRow) error {
+	if err := imp.ctx.Err(); err != nil {
+		return err
+	}
+
+	r := ImportRowReport{Line: row.Line, Row: row.Text, Contact: row.Contact, Err: row.Err}
+	if r.Err == nil {
+		r.Outcome, r.Err = imp.cs.planRow(&imp.plan, row.Line, &r.Contact)
+	}
+	if r.Err != nil {
+		r.Outcome = ImportFailed
+	}
+	imp.batch = append(imp.batch, r)
+	imp.batchRows++
+	imp.line = row.Line
+	imp.progress.add(row.End)
+
+	// a dry run saves nothing, its rows are reported right away
+	if imp.report.DryRun || imp.batchFull() {
+		return imp.save()
+	}
+	return nil
+}
+
+func (imp *importer) batchFull() bool {
+	return imp.plan.opts.BatchSize > 0 && imp.batchRows >= imp.plan.opts.BatchSize
+}
+
+// save saves the created and updated contacts of the batch in one change and reports its rows
+func (imp *importer) save() error {
+	var created, updated []domain.Contact
+	for _, r := range imp.batch {
+		switch r.Outcome {
+		case ImportCreate:
+			created = append(created, r.Contact)
+		case ImportUpdate:
+			updated = append(updated, r.Contact)
+		}
+	}
+
+	if !imp.report.DryRun && len(created)+len(updated) > 0 {
+		saved, err := imp.cs.repo.ImportAll(created, updated)
+		if err != nil {
+			imp.saveErr = fmt.Errorf("failed to import contacts, %s: %w", imp.savedSoFar("nothing was saved"), err)
+			return imp.saveErr
+		}
+		imp.saved += len(saved) + len(updated)
+		imp.savedTo = imp.batch[len(imp.batch)-1].Line
+
+		// undo would hold every contact of the file, a batched import is not undone
+		if imp.plan.opts.BatchSize > 0 {
+			imp.cs.untracked = true
+			defer func() { imp.cs.untracked = false }()
+		}
+		// saved is in the order of the rows that create a contact
+		for i, r := range imp.batch {
+			switch r.Outcome {
+			case ImportCreate:
+				imp.batch[i].Contact, saved = saved[0], saved[1:]
+				if err := imp.cs.record(domain.AuditImport, domain.Contact{}, imp.batch[i].Contact, 0); err != nil {
+					return err
+				}
+			case ImportUpdate:
+				if err := imp.cs.recordUpdate(imp.plan.stored[r.Contact.ID]); err != nil {
+					return err
+				}
+				imp.batch[i].Contact, _ = imp.cs.repo.GetByID(r.Contact.ID)
+			}
+		}
+	}
+
+	for _, r := range imp.batch {
+		imp.report.counts[r.Outcome]++
+		if imp.plan.opts.OnRow != nil {
+			imp.plan.opts.OnRow(r)
+		} else {
+			imp.report.Rows = append(imp.report.Rows, r)
+		}
+	}
+	imp.batch = imp.batch[:0]
+	// a dry run checks the rows of a batch against each other like the import does,
+	// so it starts over after every batch as well and does not hold the whole file either
+	if imp.report.DryRun && !imp.batchFull() {
+		clear(imp.plan.stored)
+		return nil
+	}
+	imp.plan.reset()
+	imp.batchRows = 0
+	return nil
+}
+
+// savedSoFar tells how many contacts the batches saved before, or none when there were none
+func (imp *importer) savedSoFar(none string) string {
+	if imp.saved == 0 {
+		return none
+	}
+	return fmt.Sprintf("the %d contacts of the rows up to line %d were saved", imp.saved, imp.savedTo)
+}
+
+// readError is the error of reading the file, a failed save or a cancel is told by finish
+func (imp *importer) readError(err error) error {
+	if imp.saveErr != nil || imp.ctx.Err() != nil {
+		return err
+	}
+	if imp.saved > 0 {
+		return fmt.Errorf("%w, %s", err, imp.savedSoFar(""))
+	}
+	return err
+}
+
+// finish reports the progress a last time and tells what a cancel left saved
+func (imp *importer) finish(err error) (ImportReport, error) {
+	imp.progress.finish()
+	// the undone actions can not be redone over a batched import, it is not undone itself
+	if imp.plan.opts.BatchSize > 0 && imp.saved > 0 {
+		imp.cs.redo = nil
+	}
+
+	switch {
+	case err == nil:
+		return imp.report, nil
+	case imp.saveErr != nil:
+		return imp.report, imp.saveErr
+	case imp.ctx.Err() != nil && errors.Is(err, imp.ctx.Err()):
+		if imp.report.DryRun {
+			return imp.report, fmt.Errorf("import %w after line %d", ErrCancelled, imp.line)
+		}
+		return imp.report, fmt.Errorf("import %w after line %d, %s", ErrCancelled, imp.line, imp.savedSoFar("nothing was saved"))
+	default:
+		return imp.report, err
+	}
+}
+
+// planRow validates the contact of the row on line and tells what importing it does.
+// A row that updates a stored contact takes its id and version, a skipped row becomes the stored contact
+func (cs *ContactService) planRow(plan *importPlan, line int, ctc *domain.Contact) (ImportOutcome, error) {
+	incomingID, uid, cardName := ctc.ID, ctc.UID, ctc.CardName
+	// the version and trash state of another store mean nothing here,
+	// the CardDAV card only belongs to the contact under the id it was synced with
+	ctc.ID, ctc.Version, ctc.DeletedAt = 0, 0, time.Time{}
+	ctc.UID, ctc.CardName = "", ""
+	ctc.Normalize()
+
+	for _, em := range ctc.Emails {
+		if other, ok := plan.emails[em.Address]; ok {
+			return "", fmt.Errorf("%w, line %d has %s as well", ErrEmailAlreadyExist, other, em.Address)
+		}
+	}
+
+	stored, err := cs.storedContact(*ctc)
+	if err != nil {
+		return "", err
+	}
+
+	if stored.ID != 0 {
+		switch plan.opts.Conflict {
+		case ConflictSkip:
+			plan.claim(line, *ctc)
+			*ctc = stored
+			return ImportSkip, nil
+		case ConflictDuplicate:
+			if err := cs.dropStoredEmails(ctc); err != nil {
+				return "", err
+			}
+			stored = domain.Contact{}
+		default:
+			// only one row may change a stored contact
+			if other, ok := plan.contacts[stored.ID]; ok {
+				return "", fmt.Errorf("%w, line %d imports contact %d as well", ErrEmailAlreadyExist, other, stored.ID)
+			}
+			if plan.opts.Conflict == ConflictMerge {
+				*ctc = mergeContacts(stored, *ctc)
+			} else {
+				ctc.ID, ctc.Version = stored.ID, stored.Version
+			}
+			ctc.UID, ctc.CardName = stored.UID, stored.CardName
+		}
+	}
+
+	// a new contact, its id is checked before validating as the email check skips the contact's own id
+	if stored.ID == 0 && plan.opts.IDs == IDPreserve && incomingID > 0 {
+		if plan.ids[incomingID] {
+			return "", fmt.Errorf("%w, contact %d is stored already", ErrIDAlreadyExist, incomingID)
+		}
+		if other, ok := plan.kept[incomingID]; ok {
+			return "", fmt.Errorf("%w, line %d keeps id %d as well", ErrIDAlreadyExist, other, incomingID)
+		}
+		ctc.ID = incomingID
+		ctc.UID, ctc.CardName = uid, cardName
+	}
+
+	if err := cs.validateContact(ctc); err != nil {
+		return "", err
+	}
+
+	plan.claim(line, *ctc)
+	if stored.ID == 0 {
+		if ctc.ID != 0 {
+			plan.kept[ctc.ID] = line
+		}
+		return ImportCreate, nil
+	}
+	plan.contacts[stored.ID] = line
+	plan.stored[stored.ID] = stored
+	if sameContent(*ctc, stored) {
+		*ctc = stored
+		return ImportSkip, nil
+	}
+	return ImportUpdate, nil
+}
+
+// reset starts over after a batch is saved, the store has what its rows claimed
+func (plan *importPlan) reset() {
+	for id := range plan.kept {
+		plan.ids[id] = true
+	}
+	clear(plan.emails)
+	clear(plan.contacts)
+	clear(plan.kept)
+	clear(plan.stored)
+}
+
+// claim takes the emails of a row, a later row of the batch with one of them fails
+func (plan *importPlan) claim(line int, ctc domain.Contact) {
+	for _, em := range ctc.Emails {
+		plan.emails[em.Address] = line
+	}
+}
+
+// storedContact returns the contact out of the trash that has one of the emails of ctc,
+// the first email finds it first. The ID is 0 when there is none
+func (cs *ContactService) storedContact(ctc domain.Contact) (domain.Contact, error) {
+	for _, em := range ctc.Emails {
+		existing, err := cs.repo.GetByEmail(em.Address)
+		if err != nil {
+			return domain.Contact{}, fmt.Errorf("failed to check existing email: %w", err)
+		}
+		if existing.ID != 0 && !existing.InTrash() {
+			return existing, nil
+		}
+	}
+	return domain.Contact{}, nil
+}
+
+// dropStoredEmails leaves out the emails of ctc another contact has, in or out of the trash
+func (cs *ContactService) dropStoredEmails(ctc *domain.Contact) error {
+	var own []domain.Email
+	for _, em := range ctc.Emails {
+		existing, err := cs.repo.GetByEmail(em.Address)
+		if err != nil {
+			return fmt.Errorf("failed to check existing email: %w", err)
+		}
+		if existing.ID == 0 {
+			own = append(own, em)
+		}
+	}
+	if len(own) == 0 {
+		return fmt.Errorf("%w, a duplicate needs an email no other contact has", ErrEmailAlreadyExist)
+	}
+	ctc.Emails, ctc.Email = own, ""
+	ctc.Normalize()
+	return nil
+}
+
+// mergeContacts adds what row has to stored, the primary email and phone of stored stay primary
+func mergeContacts(stored, row domain.Contact) domain.Contact {
+	merged := stored
+	merged.Emails = slices.Clone(stored.EmailList())
+	merged.Phones = slices.Clone(stored.PhoneList())
+	merged.Addresses = slices.Clone(stored.Addresses)
+	merged.Tags = slices.Clone(stored.Tags)
+
+	if row.Name != "" {
+		merged.Name = row.Name
+	}
+	for _, em := range row.EmailList() {
+		if !merged.HasEmail(em.Address) {
+			em.Primary = false
+			merged.Emails = append(merged.Emails, em)
+		}
+	}
+	for _, ph := range row.PhoneList() {
+		if !slices.ContainsFunc(merged.Phones, func(p domain.Phone) bool { return p.Number == ph.Number }) {
+			ph.Primary = false
+			merged.Phones = append(merged.Phones, ph)
+		}
+	}
+	for _, addr := range row.Addresses {
+		if !slices.Contains(merged.Addresses, addr) {
+			merged.Addresses = append(merged.Addresses, addr)
+		}
+	}
+	merged.Tags = append(merged.Tags, row.Tags...)
+
+	merged.Normalize()
+	return merged
+}
+
+// sameContent reports whether two contacts have the same name, emails, phones, addresses and tags
+func sameContent(a, b domain.Contact) bool {
+	return a.Name == b.Name &&
+		slices.Equal(a.EmailList(), b.EmailList()) &&
+		slices.Equal(a.PhoneList(), b.PhoneList()) &&
+		slices.Equal(a.Addresses, b.Addresses) &&
+		slices.Equal(a.Tags, b.Tags)
+}
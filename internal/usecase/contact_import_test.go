@@ -0,0 +1,143 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/repository"
+)
+
+func newTestService(t *testing.T) *ContactService {
+	t.Helper()
+	return NewContactService(repository.NewContactRepository(), repository.NewGroupRepository(), repository.NewAuditRepository(),
+		repository.NewCSVProfileStore(t.TempDir()))
+}
+
+// contactIDs returns the ids of every contact of the service
+func contactIDs(t *testing.T, cs *ContactService) []int {
+	t.Helper()
+	contacts, err := cs.GetAllContacts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0, len(contacts))
+	for _, ctc := range contacts {
+		ids = append(ids, ctc.ID)
+	}
+	return ids
+}
+
+func TestImportIDPolicy(t *testing.T) {
+	// contacts 1 and 3 are exported, 2 is in the trash
+	source := newTestService(t)
+	for _, ctc := range []domain.Contact{
+		{Name: "Ann", Email: "ann@mail.com"},
+		{Name: "Bob", Email: "bob@mail.com"},
+		{Name: "Cid", Email: "cid@mail.com"},
+	} {
+		if err := source.CreateContact(ctc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := source.DeleteContact(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// exports are written to the data folder, a JSON import reads from it and a CSV import takes the path
+	t.Chdir(t.TempDir())
+	jsonFile, csvFile := "contacts.json", filepath.Join("data", "contacts.csv")
+	if err := source.ExportToJSON(context.Background(), jsonFile, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.ExportToCSV(context.Background(), "contacts.csv", repository.CSVNative, "", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type importFunc func(cs *ContactService, ctx context.Context, filename string, opts ImportOptions) (ImportReport, error)
+	tests := []struct {
+		name     string
+		file     string
+		importer importFunc
+		ids      IDPolicy
+		want     []int
+	}{
+		{"json preserve", jsonFile, (*ContactService).ImportFromJSON, IDPreserve, []int{1, 3}},
+		{"csv preserve", csvFile, (*ContactService).ImportFromCSV, IDPreserve, []int{1, 3}},
+		{"json reassign", jsonFile, (*ContactService).ImportFromJSON, IDReassign, []int{1, 2}},
+		{"csv reassign", csvFile, (*ContactService).ImportFromCSV, IDReassign, []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newTestService(t)
+			if _, err := tt.importer(cs, context.Background(), tt.file, ImportOptions{IDs: tt.ids}); err != nil {
+				t.Fatal(err)
+			}
+			if got := contactIDs(t, cs); !slices.Equal(got, tt.want) {
+				t.Errorf("imported contacts have ids %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// a row with the id of a stored contact fails with the preserve policy
+	cs := newTestService(t)
+	if err := cs.CreateContact(domain.Contact{Name: "Dan", Email: "dan@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+	report, err := cs.ImportFromCSV(context.Background(), csvFile, ImportOptions{IDs: IDPreserve, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows[0].Outcome != ImportFailed {
+		t.Errorf("row with the id of a stored contact got %s, want %s", report.Rows[0].Outcome, ImportFailed)
+	}
+}
+
+func TestImportDryRunChecksBatches(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rows := `[
+		{"Name": "Ann", "Emails": [{"Address": "ann@mail.com"}]},
+		{"Name": "Bob", "Emails": [{"Address": "bob@mail.com"}]},
+		{"Name": "Ann B", "Emails": [{"Address": "ann@mail.com"}]}
+	]`
+	if err := os.MkdirAll("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("data", "contacts.json"), []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the third row has the email of the first one, a dry run only remembers the rows of its batch
+	tests := []struct {
+		name      string
+		batchSize int
+		want      []ImportOutcome
+	}{
+		{"whole file", 0, []ImportOutcome{ImportCreate, ImportCreate, ImportFailed}},
+		{"same batch", 3, []ImportOutcome{ImportCreate, ImportCreate, ImportFailed}},
+		{"earlier batch", 2, []ImportOutcome{ImportCreate, ImportCreate, ImportCreate}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := newTestService(t)
+			report, err := cs.ImportFromJSON(context.Background(), "contacts.json", ImportOptions{DryRun: true, BatchSize: tt.batchSize})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []ImportOutcome
+			for _, r := range report.Rows {
+				got = append(got, r.Outcome)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("dry run got %v, want %v", got, tt.want)
+			}
+			if _, err := cs.GetAllContacts(); !errors.Is(err, ErrNoContacts) {
+				t.Errorf("a dry run saved contacts: %v", err)
+			}
+		})
+	}
+}
@@ -1,12 +1,15 @@
 package usecase
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/Dwipasca/contact-management/internal/domain"
 	"github.com/Dwipasca/contact-management/internal/repository"
@@ -14,25 +17,59 @@ import (
 
 type ContactService struct {
 	repo repository.ContactRepository
+	// groups lose a contact when it is purged, nil when groups are not used
+	groups repository.GroupRepository
+	// audit records every change for the history, nil turns it off
+	audit repository.AuditRepository
+	actor string
+	// profiles holds the saved CSV mapping profiles
+	profiles *repository.CSVProfileStore
+	// undo and redo hold the actions of this session, see step
+	undo, redo []undoStep
+	pending    []undoChange
+	inStep     bool
+	replaying  bool
+	// untracked leaves the changes out of undo, see importer.save
+	untracked bool
 }
 
-func NewContactService(repo repository.ContactRepository) *ContactService {
+func NewContactService(repo repository.ContactRepository, groups repository.GroupRepository, audit repository.AuditRepository, profiles *repository.CSVProfileStore) *ContactService {
 	return &ContactService{
-		repo: repo,
+		repo:     repo,
+		groups:   groups,
+		audit:    audit,
+		profiles: profiles,
 	}
 }
 
 var (
-	ErrNoContacts		 = errors.New("no contacts found")
-	ErrNameRequired      = errors.New("name is required")
-	ErrEmailRequired     = errors.New("email is required")
-	ErrInvalidEmail      = errors.New("invalid email format")
-	ErrEmailAlreadyExist = errors.New("email already exists")
-	ErrInvalidExportFilename = errors.New("invalid export filename")
-	ErrInvalidImportFilename = errors.New("invalid import filename")
+	ErrNoContacts                  = errors.New("no contacts found")
+	ErrNameRequired                = errors.New("name is required")
+	ErrEmailRequired               = errors.New("email is required")
+	ErrInvalidEmail                = errors.New("invalid email format")
+	ErrEmailAlreadyExist           = domain.ErrEmailAlreadyExist
+	ErrConflict                    = domain.ErrConflict
+	ErrInvalidExportFilename       = errors.New("invalid export filename")
+	ErrInvalidImportFilename       = errors.New("invalid import filename")
+	ErrInvalidCountryCode          = errors.New("invalid country code, use two letters like ID or US")
+	ErrInvalidTag                  = errors.New("invalid tag, use letters, digits, '.', '_' or '-'")
+	ErrInvalidTagExpression        = errors.New("invalid tag expression")
+	ErrTrashEmpty                  = errors.New("the trash is empty")
+	ErrUnsupportedVCardVersion     = errors.New("unsupported vCard version, use 3.0 or 4.0")
+	ErrInvalidDNTemplate           = repository.ErrInvalidDNTemplate
+	ErrUnsupportedCSVDialect       = errors.New("unsupported CSV dialect, use native, google or outlook")
+	ErrUnrecognizedCSVHeader       = repository.ErrUnrecognizedCSVHeader
+	ErrInvalidCSVProfile           = repository.ErrInvalidCSVProfile
+	ErrCSVProfileNotFound          = repository.ErrCSVProfileNotFound
+	ErrIDAlreadyExist              = domain.ErrIDAlreadyExist
+	ErrUnsupportedConflictStrategy = errors.New("unsupported conflict strategy, use skip, overwrite, merge or duplicate")
+	ErrUnsupportedIDPolicy         = errors.New("unsupported id policy, use reassign or preserve")
+	ErrCancelled                   = errors.New("cancelled")
 )
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+var countryCodeRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+var tagRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
 
 func (cs *ContactService) GetAllContacts() ([]domain.Contact, error) {
 	contacts, err := cs.repo.GetAll()
@@ -79,7 +116,8 @@ func (cs *ContactService) SearchByEmail(email string) (domain.Contact, error){
 		return domain.Contact{}, err // file corrupt or something
 	}
 
-	if contact.ID == 0 {
+	// the repository finds contacts in the trash by email, a search must not
+	if contact.ID == 0 || contact.InTrash() {
 		return domain.Contact{}, ErrNoContacts
 	}
 
@@ -87,54 +125,212 @@ func (cs *ContactService) SearchByEmail(email string) (domain.Contact, error){
 
 }
 
-func (cs *ContactService) AddContact(name, email, phone string) error {
-	name = strings.TrimSpace(name)
-	email = strings.TrimSpace(email)
+// SearchByLocation finds the contacts with an address in a city or a country,
+// the country can be given by its code or by its name
+func (cs *ContactService) SearchByLocation(location string) ([]domain.Contact, error) {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		return nil, ErrNoContacts
+	}
 
-	if name == "" {
-		return ErrNameRequired
+	contacts, err := cs.repo.GetByLocation(location, domain.CountryCodeFor(location))
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve contacts: %w", err)
 	}
 
-	if !emailRegex.MatchString(email) {
-		return ErrInvalidEmail
+	if len(contacts) == 0 {
+		return nil, ErrNoContacts
 	}
 
-	if email == "" {
-		return ErrEmailRequired
+	return contacts, nil
+}
+
+// SearchByTags finds the contacts matching a tag expression
+// like "customer AND NOT churned", see parseTagExpression
+func (cs *ContactService) SearchByTags(expr string) ([]domain.Contact, error) {
+	matches, err := parseTagExpression(expr)
+	if err != nil {
+		return nil, err
 	}
 
-	// check if email is already exists or not
-	existing, err := cs.repo.GetByEmail(email)
+	contacts, err := cs.repo.GetAll()
 	if err != nil {
-		return fmt.Errorf("failed to check existing email: %w", err)
+		return nil, fmt.Errorf("failed to retrieve contacts: %w", err)
+	}
+
+	var result []domain.Contact
+	for _, ctc := range contacts {
+		if matches(ctc) {
+			result = append(result, ctc)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, ErrNoContacts
+	}
+
+	return result, nil
+}
+
+// validateTags rejects tags that would not survive a tag expression,
+// tags must already be normalized
+func validateTags(tags []string) error {
+	for _, tag := range tags {
+		if !tagRegex.MatchString(tag) || tag == "and" || tag == "or" || tag == "not" {
+			return fmt.Errorf("%w: %q", ErrInvalidTag, tag)
+		}
+	}
+	return nil
+}
+
+// AddTags adds the tags to every contact in ids
+func (cs *ContactService) AddTags(ids []int, tags []string) error {
+	tags = domain.NormalizeTags(tags)
+	if err := validateTags(tags); err != nil {
+		return err
+	}
+
+	return cs.changeTags(ids, func(ctc *domain.Contact) {
+		ctc.Tags = domain.NormalizeTags(append(ctc.Tags, tags...))
+	})
+}
+
+// RemoveTags removes the tags from every contact in ids
+func (cs *ContactService) RemoveTags(ids []int, tags []string) error {
+	tags = domain.NormalizeTags(tags)
+
+	return cs.changeTags(ids, func(ctc *domain.Contact) {
+		ctc.Tags = slices.DeleteFunc(ctc.Tags, func(tag string) bool {
+			return slices.Contains(tags, tag)
+		})
+	})
+}
+
+// changeTags applies change to every contact in ids, all contacts are looked up
+// before the first one is changed so a wrong id does not leave half of them changed
+func (cs *ContactService) changeTags(ids []int, change func(ctc *domain.Contact)) error {
+	var contacts []domain.Contact
+	for _, id := range ids {
+		ctc, err := cs.SearchByID(id)
+		if err != nil {
+			return fmt.Errorf("contact %d: %w", id, err)
+		}
+		contacts = append(contacts, ctc)
+	}
+
+	return cs.step(fmt.Sprintf("change tags of %d contacts", len(contacts)), func() error {
+		for _, before := range contacts {
+			ctc := before
+			// the slice may be shared with the copy kept by an in-memory repository
+			ctc.Tags = slices.Clone(ctc.Tags)
+			change(&ctc)
+			if err := cs.repo.Update(ctc); err != nil {
+				return fmt.Errorf("failed to update tags of contact %d: %w", ctc.ID, err)
+			}
+			if err := cs.recordUpdate(before); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// AddContact adds a contact, email and phone are lists
+// written as "work:a@mail.com, home:b@mail.com" with the primary one first
+func (cs *ContactService) AddContact(name, email, phone string) error {
+	return cs.CreateContact(domain.Contact{
+		Name:   name,
+		Emails: domain.ParseEmails(email),
+		Phones: domain.ParsePhones(phone),
+	})
+}
+
+func (cs *ContactService) CreateContact(newContact domain.Contact) error {
+	newContact.ID = 0
+	if err := cs.validateContact(&newContact); err != nil {
+		return err
 	}
 
-	if existing.ID != 0 {
-		return ErrEmailAlreadyExist
+	return cs.step("add contact "+newContact.Name, func() error {
+		if err := cs.repo.Save(newContact); err != nil {
+			return fmt.Errorf("failed to save contact: %w", err)
+		}
+
+		// Save does not return the id, the primary email finds the contact as emails are unique
+		saved, err := cs.repo.GetByEmail(newContact.Email)
+		if err != nil {
+			return fmt.Errorf("change saved but audit log failed: %w", err)
+		}
+
+		return cs.record(domain.AuditCreate, domain.Contact{}, saved, 0)
+	})
+}
+
+// validateContact normalizes the contact and checks it before it is saved.
+// The emails must not belong to any other contact than the one being saved.
+func (cs *ContactService) validateContact(ctc *domain.Contact) error {
+	ctc.Name = strings.TrimSpace(ctc.Name)
+	ctc.Normalize()
+
+	if ctc.Name == "" {
+		return ErrNameRequired
 	}
 
-	newContact := domain.Contact{
-		Name: name,
-		Email: email,
-		Phone: phone,
+	if len(ctc.Emails) == 0 {
+		return ErrEmailRequired
 	}
 
+	for _, addr := range ctc.Addresses {
+		if addr.CountryCode != "" && !countryCodeRegex.MatchString(addr.CountryCode) {
+			return ErrInvalidCountryCode
+		}
+	}
 
-	if err := cs.repo.Save(newContact); err != nil {
-		return fmt.Errorf("failed to save contact: %w", err)
+	if err := validateTags(ctc.Tags); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, em := range ctc.Emails {
+		if !emailRegex.MatchString(em.Address) {
+			return ErrInvalidEmail
+		}
+
+		if seen[em.Address] {
+			return ErrEmailAlreadyExist
+		}
+		seen[em.Address] = true
+
+		// check if email is already exists or not
+		existing, err := cs.repo.GetByEmail(em.Address)
+		if err != nil {
+			return fmt.Errorf("failed to check existing email: %w", err)
+		}
+
+		if existing.ID != 0 && existing.ID != ctc.ID {
+			if existing.InTrash() {
+				return fmt.Errorf("%w, it belongs to contact %d in the trash", ErrEmailAlreadyExist, existing.ID)
+			}
+			return ErrEmailAlreadyExist
+		}
 	}
 
 	return nil
 }
 
+// AddMultipleContact adds every valid contact, they are undone together
 func (cs *ContactService) AddMultipleContact(newContacts []domain.Contact) error {
 	var failed []string
 
-	for _, ctc := range newContacts{
-		if err := cs.AddContact(ctc.Name, ctc.Email, ctc.Phone); err != nil {
-			failed = append(failed, fmt.Sprintf("%s (%s): %v", ctc.Name, ctc.Email, err))
+	cs.step(fmt.Sprintf("add %d contacts", len(newContacts)), func() error {
+		for _, ctc := range newContacts {
+			if err := cs.CreateContact(ctc); err != nil {
+				failed = append(failed, fmt.Sprintf("%s (%s): %v", ctc.Name, domain.FormatEmails(ctc.EmailList()), err))
+			}
 		}
-	}
+		return nil
+	})
 
 	if len(failed) > 0 {
 		return fmt.Errorf("some contacts failed to add:\n%s", strings.Join(failed, "\n"))
@@ -143,59 +339,171 @@ func (cs *ContactService) AddMultipleContact(newContacts []domain.Contact) error
 	return nil
 }
 
-func (cs *ContactService) EditContact(id int, name, email, phone string) error {
-	prevContact, err := cs.repo.GetByID(id)
+// EditContact replaces the contact, email and phone are lists like in AddContact.
+// version is the version the contact was read at, see UpdateContact.
+func (cs *ContactService) EditContact(id, version int, name, email, phone string) error {
+	return cs.UpdateContact(domain.Contact{
+		ID:      id,
+		Version: version,
+		Name:    name,
+		Emails:  domain.ParseEmails(email),
+		Phones:  domain.ParsePhones(phone),
+	})
+}
+
+// UpdateContact replaces the stored contact. It fails with ErrConflict when
+// updated.Version is not the stored version, someone else changed the contact meanwhile.
+func (cs *ContactService) UpdateContact(updated domain.Contact) error {
+	if err := cs.validateContact(&updated); err != nil {
+		return err
+	}
+
+	before, err := cs.repo.GetByID(updated.ID)
 	if err != nil {
-		return fmt.Errorf("fetching contact failed: %w", err)
+		return fmt.Errorf("update failed: %w", err)
+	}
+	// an edit outside CardDAV does not know the card, it stays where the client saved it
+	if updated.UID == "" && updated.CardName == "" {
+		updated.UID, updated.CardName = before.UID, before.CardName
 	}
-	
-	name = strings.TrimSpace(name)
-	email = strings.TrimSpace(email)
 
-	if name == "" {
-		return ErrNameRequired
+	return cs.step("edit contact "+updated.Name, func() error {
+		if err := cs.repo.Update(updated); err != nil {
+			return fmt.Errorf("update failed: %w", err)
+		}
+
+		return cs.recordUpdate(before)
+	})
+}
+
+// recordUpdate records the update of a contact, before is the contact as it was read
+func (cs *ContactService) recordUpdate(before domain.Contact) error {
+	after, err := cs.repo.GetByID(before.ID)
+	if err != nil {
+		return fmt.Errorf("change saved but audit log failed: %w", err)
 	}
 
-	if !emailRegex.MatchString(email) {
-		return ErrInvalidEmail
+	return cs.record(domain.AuditUpdate, before, after, 0)
+}
+
+// DeleteContact moves the contact to the trash, it stays in its groups until it is purged
+func (cs *ContactService) DeleteContact(id int) error {
+
+	before, err := cs.repo.GetByID(id)
+	if err != nil {
+		return fmt.Errorf("delete failed: %w", err)
 	}
 
-	if email != prevContact.Email {
-		existing, err := cs.repo.GetByEmail(email)
-		if err == nil && existing.ID != id {
-			return ErrEmailAlreadyExist
+	return cs.step("delete contact "+before.Name, func() error {
+		if err := cs.repo.Delete(id); err != nil {
+			return fmt.Errorf("delete failed: %w", err)
 		}
+
+		return cs.record(domain.AuditDelete, before, domain.Contact{}, 0)
+	})
+}
+
+// GetTrash returns the contacts in the trash, the most recently deleted last
+func (cs *ContactService) GetTrash() ([]domain.Contact, error) {
+	contacts, err := cs.repo.GetTrash()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve trash: %w", err)
 	}
 
-	updated := domain.Contact{
-		ID: id,
-		Name: name,
-		Email: email,
-		Phone: phone,
+	if len(contacts) == 0 {
+		return nil, ErrTrashEmpty
 	}
 
-	if err := cs.repo.Update(updated); err != nil {
-		return fmt.Errorf("update failed: %w", err)
+	slices.SortStableFunc(contacts, func(a, b domain.Contact) int {
+		return a.DeletedAt.Compare(b.DeletedAt)
+	})
+
+	return contacts, nil
+}
+
+// RestoreContact takes a contact out of the trash under its original ID
+func (cs *ContactService) RestoreContact(id int) error {
+	return cs.step(fmt.Sprintf("restore contact %d", id), func() error {
+		if err := cs.repo.Restore(id); err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+
+		restored, err := cs.repo.GetByID(id)
+		if err != nil {
+			return fmt.Errorf("change saved but audit log failed: %w", err)
+		}
+
+		// the fields did not change, the entry only marks the contact as back
+		return cs.record(domain.AuditRestore, restored, restored, 0)
+	})
+}
+
+// PurgeContact removes a contact in the trash for good, together with its group memberships
+func (cs *ContactService) PurgeContact(id int) error {
+	before, err := cs.findInTrash(id)
+	if err != nil {
+		return fmt.Errorf("purge failed: %w", err)
 	}
 
-	return nil
+	// the memberships go first, a database may refuse to delete a contact still in a group
+	if cs.groups != nil {
+		if err := removeContactFromGroups(cs.groups, id); err != nil {
+			return fmt.Errorf("purge failed: %w", err)
+		}
+	}
+
+	if err := cs.repo.Purge(id); err != nil {
+		return fmt.Errorf("purge failed: %w", err)
+	}
+
+	return cs.record(domain.AuditPurge, before, domain.Contact{}, 0)
 }
 
-func (cs *ContactService) DeleteContact(id int) error {
+// EmptyTrash purges every contact in the trash and returns how many were purged
+func (cs *ContactService) EmptyTrash() (int, error) {
+	return cs.purgeTrash(func(domain.Contact) bool { return true })
+}
 
-	if err := cs.repo.Delete(id); err != nil {
-		return fmt.Errorf("delete failed: %w", err)
+// PurgeExpiredTrash purges the contacts that are in the trash for longer than retention,
+// a retention of zero or less keeps them forever
+func (cs *ContactService) PurgeExpiredTrash(retention time.Duration) (int, error) {
+	if retention <= 0 {
+		return 0, nil
 	}
 
-	return nil
+	cutoff := time.Now().Add(-retention)
+	return cs.purgeTrash(func(ctc domain.Contact) bool {
+		return ctc.DeletedAt.Before(cutoff)
+	})
 }
 
-func (cs *ContactService) ExportToJSON(filename string) error {
-	
+func (cs *ContactService) purgeTrash(expired func(ctc domain.Contact) bool) (int, error) {
+	contacts, err := cs.repo.GetTrash()
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve trash: %w", err)
+	}
+
+	purged := 0
+	for _, ctc := range contacts {
+		if !expired(ctc) {
+			continue
+		}
+		if err := cs.PurgeContact(ctc.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// ExportToJSON exports the contacts matching tagFilter, or every contact when it is empty
+func (cs *ContactService) ExportToJSON(ctx context.Context, filename, tagFilter string, onProgress func(Progress)) error {
+
 	if strings.TrimSpace(filename) == "" || strings.Contains(filename, "..") {
 		return ErrInvalidExportFilename
 	}
-
+	
 	// create the "data" folder if it does not exist
 	// os.ModePerm = 0777 (read/write/execute permissions for all users)
 	if err := os.MkdirAll("data", os.ModePerm); err != nil {
@@ -206,19 +514,27 @@ func (cs *ContactService) ExportToJSON(filename string) error {
 	// ex: data/contacts.json
 	filePath := filepath.Join("data", filename)
 
-	if err := cs.repo.ExportToJSON(filePath); err != nil {
-		return fmt.Errorf("failed to export contacts to JSON: %w", err)
-	}
-
-	return nil
+	return cs.exportContacts(ctx, tagFilter, onProgress, "JSON", func(source repository.ContactSource) error {
+		return repository.WriteContactsJSON(filePath, source)
+	})
 }
 
-func (cs *ContactService) ExportToCSV(filename string) error {
+// ExportToCSV exports the contacts matching tagFilter, or every contact when it is empty,
+// in the columns of this app, of Google Contacts or of Outlook, an empty dialect is native
+func (cs *ContactService) ExportToCSV(ctx context.Context, filename, dialect, tagFilter string, onProgress func(Progress)) error {
 
 	if strings.TrimSpace(filename) == "" || strings.Contains(filename, "..") {
 		return ErrInvalidExportFilename
 	}
-	
+
+	dialect = strings.ToLower(strings.TrimSpace(dialect))
+	if dialect == "" {
+		dialect = repository.CSVNative
+	}
+	if !slices.Contains(repository.CSVDialects, dialect) {
+		return ErrUnsupportedCSVDialect
+	}
+
 	// create the "data" folder if it does not exist
 	// os.ModePerm = 0777 (read/write/execute permissions for all users)
 	if err := os.MkdirAll("data", os.ModePerm); err != nil {
@@ -229,31 +545,107 @@ func (cs *ContactService) ExportToCSV(filename string) error {
 	// ex: data/contacts.json
 	filePath := filepath.Join("data", filename)
 
-	if err := cs.repo.ExportToCSV(filePath); err != nil {
-		return fmt.Errorf("failed to export contacts to CSV: %w", err)
+	return cs.exportContacts(ctx, tagFilter, onProgress, "CSV", func(source repository.ContactSource) error {
+		return repository.WriteContactsCSV(filePath, dialect, source)
+	})
+}
+
+// ExportToVCard exports the contacts matching tagFilter, or every contact when it is empty,
+// as a .vcf file of version 3.0 or 4.0
+func (cs *ContactService) ExportToVCard(ctx context.Context, filename, version, tagFilter string, onProgress func(Progress)) error {
+
+	if strings.TrimSpace(filename) == "" || strings.Contains(filename, "..") {
+		return ErrInvalidExportFilename
 	}
 
-	return nil
+	if !slices.Contains(repository.VCardVersions, version) {
+		return ErrUnsupportedVCardVersion
+	}
+
+	if err := os.MkdirAll("data", os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create data folder: %w", err)
+	}
+
+	filePath := filepath.Join("data", filename)
+
+	return cs.exportContacts(ctx, tagFilter, onProgress, "vCard", func(source repository.ContactSource) error {
+		return repository.WriteContactsVCard(filePath, version, source)
+	})
 }
 
-func (cs *ContactService) ImportFromJSON(filename string) ([]domain.Contact,error) {
-	filename = strings.TrimSpace(filename)
+// DefaultDNTemplate is the dn of a contact in an LDIF export when none is given
+const DefaultDNTemplate = repository.DefaultDNTemplate
+
+// ExportToLDIF exports the contacts matching tagFilter, or every contact when it is empty,
+// as an .ldif file, the dn of a contact is made from dnTemplate, see repository.ContactDN
+func (cs *ContactService) ExportToLDIF(ctx context.Context, filename, dnTemplate, tagFilter string, onProgress func(Progress)) error {
 
-	if filename == "" || !strings.HasSuffix(filename, ".json") || strings.Contains(filename, "..") {
-		return nil, ErrInvalidImportFilename
+	if strings.TrimSpace(filename) == "" || strings.Contains(filename, "..") {
+		return ErrInvalidExportFilename
 	}
 
-	filePath := filepath.Join("data", filename)
+	dnTemplate = strings.TrimSpace(dnTemplate)
+	if dnTemplate == "" {
+		dnTemplate = DefaultDNTemplate
+	}
+	if err := repository.CheckDNTemplate(dnTemplate); err != nil {
+		return err
+	}
 
-	contacts, err := cs.repo.ImportFromJSON(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to import JSON contacts: %w", err)
+	if err := os.MkdirAll("data", os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create data folder: %w", err)
 	}
 
-	return contacts, nil
-}
+	filePath := filepath.Join("data", filename)
 
-func (cs *ContactService) ImportFromCSV(filename string) ([]domain.Contact,error) {
-	return cs.repo.ImportFromCSV(filename)
+	return cs.exportContacts(ctx, tagFilter, onProgress, "LDIF", func(source repository.ContactSource) error {
+		return repository.WriteContactsLDIF(filePath, dnTemplate, source)
+	})
 }
 
+// exportContacts writes the contacts matching tagFilter, or every contact when it is empty,
+// with write. They are streamed from the store, so an export does not hold them in memory.
+// When ctx is cancelled the export stops and the file is left as it was
+func (cs *ContactService) exportContacts(ctx context.Context, tagFilter string, onProgress func(Progress), format string, write func(repository.ContactSource) error) error {
+	matches := func(domain.Contact) bool { return true }
+	filtered := strings.TrimSpace(tagFilter) != ""
+	if filtered {
+		var err error
+		if matches, err = parseTagExpression(tagFilter); err != nil {
+			return err
+		}
+	}
+
+	progress := newProgressTracker(onProgress, 0)
+	source := func(fn func(domain.Contact) error) error {
+		// a dialect that needs the contacts twice reads them again
+		progress.restart()
+		err := cs.repo.Each(func(ctc domain.Contact) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !matches(ctc) {
+				return nil
+			}
+			progress.add(0)
+			return fn(ctc)
+		})
+		if err == nil && filtered && progress.p.Rows == 0 {
+			return ErrNoContacts
+		}
+		return err
+	}
+
+	err := write(source)
+	progress.finish()
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrNoContacts):
+		return ErrNoContacts
+	case ctx.Err() != nil && errors.Is(err, ctx.Err()):
+		return fmt.Errorf("export %w, the file was left as it was", ErrCancelled)
+	default:
+		return fmt.Errorf("failed to export contacts to %s: %w", format, err)
+	}
+}
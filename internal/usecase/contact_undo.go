@@ -0,0 +1,203 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+var (
+	ErrNothingToUndo = errors.New("nothing to undo")
+	ErrNothingToRedo = errors.New("nothing to redo")
+	ErrUndoConflict  = errors.New("the contact was changed since, undo and redo would overwrite that change")
+)
+
+// undoStep is one action of the user, e.g. an import, undone and redone as a whole
+type undoStep struct {
+	label   string
+	changes []undoChange
+}
+
+// undoChange is one contact changed by a step.
+// An added contact is undone by removing it for good, so its emails can be used again,
+// and redone by saving it again under the same ID. A deleted contact is undone by
+// restoring it from the trash.
+type undoChange struct {
+	op     domain.AuditOp
+	before domain.Contact
+	after  domain.Contact
+}
+
+// step runs fn as one undoable action. Every change recorded while fn runs,
+// also in nested steps, becomes part of it, even when fn fails halfway:
+// what was saved can be undone.
+func (cs *ContactService) step(label string, fn func() error) error {
+	if cs.inStep || cs.replaying {
+		return fn()
+	}
+
+	cs.inStep = true
+	err := fn()
+	cs.inStep = false
+
+	if len(cs.pending) > 0 {
+		cs.undo = append(cs.undo, undoStep{label: label, changes: cs.pending})
+		cs.redo = nil
+		cs.pending = nil
+	}
+
+	return err
+}
+
+// track remembers a change for the step that is running, purges can not be undone
+func (cs *ContactService) track(op domain.AuditOp, before, after domain.Contact) {
+	if cs.replaying || cs.untracked || op == domain.AuditPurge {
+		return
+	}
+	cs.pending = append(cs.pending, undoChange{op: op, before: before, after: after})
+}
+
+// Undo reverses the last action and returns its description
+func (cs *ContactService) Undo() (string, error) {
+	if len(cs.undo) == 0 {
+		return "", ErrNothingToUndo
+	}
+
+	step := cs.undo[len(cs.undo)-1]
+	cs.undo = cs.undo[:len(cs.undo)-1]
+
+	// the last change first, a contact changed twice goes back through both
+	for i := len(step.changes) - 1; i >= 0; i-- {
+		if err := cs.replay(&step.changes[i], true); err != nil {
+			// the step is dropped, its changes are partly undone and can not be replayed
+			return step.label, fmt.Errorf("undo of %q stopped: %w", step.label, err)
+		}
+	}
+
+	cs.redo = append(cs.redo, step)
+	return step.label, nil
+}
+
+// Redo applies the last undone action again and returns its description
+func (cs *ContactService) Redo() (string, error) {
+	if len(cs.redo) == 0 {
+		return "", ErrNothingToRedo
+	}
+
+	step := cs.redo[len(cs.redo)-1]
+	cs.redo = cs.redo[:len(cs.redo)-1]
+
+	for i := range step.changes {
+		if err := cs.replay(&step.changes[i], false); err != nil {
+			return step.label, fmt.Errorf("redo of %q stopped: %w", step.label, err)
+		}
+	}
+
+	cs.undo = append(cs.undo, step)
+	return step.label, nil
+}
+
+// replay undoes or redoes one change through the regular operations,
+// so the audit log shows the undo too. An update is only replayed while
+// the contact is still at the version the change left it at.
+func (cs *ContactService) replay(change *undoChange, undo bool) error {
+	cs.replaying = true
+	defer func() { cs.replaying = false }()
+
+	switch change.op {
+	case domain.AuditCreate, domain.AuditImport:
+		if undo {
+			return cs.unsave(change.after)
+		}
+		return cs.resave(change)
+
+	case domain.AuditDelete:
+		if undo {
+			return cs.RestoreContact(change.before.ID)
+		}
+		return cs.DeleteContact(change.before.ID)
+
+	case domain.AuditRestore:
+		if undo {
+			return cs.DeleteContact(change.after.ID)
+		}
+		return cs.RestoreContact(change.after.ID)
+
+	case domain.AuditUpdate, domain.AuditRevert:
+		from, to := change.after, change.before
+		if !undo {
+			from, to = change.before, change.after
+		}
+
+		current, err := cs.repo.GetByID(to.ID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve contact: %w", err)
+		}
+		if current.ID == 0 {
+			return fmt.Errorf("contact %d: %w", to.ID, ErrNoContacts)
+		}
+		if current.Version != from.Version {
+			return fmt.Errorf("contact %d: %w", to.ID, ErrUndoConflict)
+		}
+
+		to.Version = current.Version
+		if err := cs.UpdateContact(to); err != nil {
+			return err
+		}
+
+		// the next replay in the other direction starts from the version written now
+		stored, err := cs.repo.GetByID(to.ID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve contact: %w", err)
+		}
+		if undo {
+			change.before = stored
+		} else {
+			change.after = stored
+		}
+	}
+
+	return nil
+}
+
+// unsave removes an added contact for good, unless it was changed since it was added
+func (cs *ContactService) unsave(added domain.Contact) error {
+	current, err := cs.repo.GetByID(added.ID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve contact: %w", err)
+	}
+	if current.ID == 0 {
+		// deleted since, it only has to leave the trash
+		if _, err := cs.findInTrash(added.ID); err != nil {
+			return fmt.Errorf("contact %d: %w", added.ID, ErrNoContacts)
+		}
+		return cs.PurgeContact(added.ID)
+	}
+	// the version moves on when a later edit is undone, so the fields are compared
+	if len(domain.DiffContacts(added, current)) > 0 {
+		return fmt.Errorf("contact %d: %w", added.ID, ErrUndoConflict)
+	}
+
+	if err := cs.DeleteContact(added.ID); err != nil {
+		return err
+	}
+	return cs.PurgeContact(added.ID)
+}
+
+// resave saves an added contact again under its ID, which is never handed out twice
+func (cs *ContactService) resave(change *undoChange) error {
+	ctc := change.after
+	if err := cs.validateContact(&ctc); err != nil {
+		return fmt.Errorf("contact %d: %w", ctc.ID, err)
+	}
+
+	saved, err := cs.repo.ImportAll([]domain.Contact{ctc}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to save contact: %w", err)
+	}
+
+	// the next undo compares against the contact as it is stored now
+	change.after = saved[0]
+	return cs.record(change.op, domain.Contact{}, saved[0], 0)
+}
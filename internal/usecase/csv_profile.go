@@ -0,0 +1,51 @@
+package usecase
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/repository"
+)
+
+type (
+	// CSVProfile maps the columns of a .csv file onto a contact, see repository.CSVProfile
+	CSVProfile = repository.CSVProfile
+	CSVField   = repository.CSVField
+)
+
+// CSVProfileFields are the fields of a contact a column can be mapped to
+var CSVProfileFields = repository.CSVProfileFields
+
+// CSVTransforms returns the names of the transforms a profile can apply, sorted
+func CSVTransforms() []string {
+	var names []string
+	for name := range repository.CSVTransforms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// CSVProfiles returns the saved mapping profiles sorted by name
+func (cs *ContactService) CSVProfiles() ([]CSVProfile, error) {
+	return cs.profiles.GetAll()
+}
+
+func (cs *ContactService) GetCSVProfile(name string) (CSVProfile, error) {
+	return cs.profiles.GetByName(strings.TrimSpace(name))
+}
+
+// SaveCSVProfile saves a mapping profile by its name, replacing the one with the same name
+func (cs *ContactService) SaveCSVProfile(profile CSVProfile) error {
+	profile.Name = strings.TrimSpace(profile.Name)
+	return cs.profiles.Save(profile)
+}
+
+func (cs *ContactService) DeleteCSVProfile(name string) error {
+	return cs.profiles.Delete(strings.TrimSpace(name))
+}
+
+// CSVHeader returns the first row of a .csv file, the columns a new profile can map
+func (cs *ContactService) CSVHeader(filename string) ([]string, error) {
+	return repository.ReadCSVHeader(filename)
+}
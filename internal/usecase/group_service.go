@@ -0,0 +1,317 @@
+package usecase
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+	"github.com/Dwipasca/contact-management/internal/repository"
+)
+
+// GroupService manages the distribution lists, the contacts themselves
+// are only read, they are changed through ContactService
+type GroupService struct {
+	groups   repository.GroupRepository
+	contacts repository.ContactRepository
+}
+
+func NewGroupService(groups repository.GroupRepository, contacts repository.ContactRepository) *GroupService {
+	return &GroupService{
+		groups:   groups,
+		contacts: contacts,
+	}
+}
+
+var (
+	ErrNoGroups          = errors.New("no groups found")
+	ErrGroupNameRequired = errors.New("group name is required")
+	ErrGroupNameExist    = errors.New("group name already exists")
+	ErrGroupCycle        = errors.New("a group can not contain itself, directly or through another group")
+)
+
+func (gs *GroupService) GetAllGroups() ([]domain.Group, error) {
+	groups, err := gs.groups.GetAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve groups: %w", err)
+	}
+
+	if len(groups) == 0 {
+		return nil, ErrNoGroups
+	}
+
+	return groups, nil
+}
+
+func (gs *GroupService) GetGroup(id int) (domain.Group, error) {
+	group, err := gs.groups.GetByID(id)
+	if err != nil {
+		return domain.Group{}, fmt.Errorf("failed to retrieve group: %w", err)
+	}
+
+	if group.ID == 0 {
+		return domain.Group{}, fmt.Errorf("group %d: %w", id, ErrNoGroups)
+	}
+
+	return group, nil
+}
+
+func (gs *GroupService) CreateGroup(name string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ErrGroupNameRequired
+	}
+
+	existing, err := gs.groups.GetByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to check existing group: %w", err)
+	}
+	if existing.ID != 0 {
+		return ErrGroupNameExist
+	}
+
+	if err := gs.groups.Save(domain.Group{Name: name}); err != nil {
+		return fmt.Errorf("failed to save group: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteGroup deletes the group and takes it out of the groups it was nested in,
+// the contacts of the group are kept
+func (gs *GroupService) DeleteGroup(id int) error {
+	if _, err := gs.GetGroup(id); err != nil {
+		return err
+	}
+
+	if err := gs.updateEach(func(grp *domain.Group) bool {
+		before := len(grp.SubgroupIDs)
+		grp.SubgroupIDs = slices.DeleteFunc(slices.Clone(grp.SubgroupIDs), func(sub int) bool { return sub == id })
+		return len(grp.SubgroupIDs) != before
+	}); err != nil {
+		return err
+	}
+
+	if err := gs.groups.Delete(id); err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+
+	return nil
+}
+
+// AddMembers appends the contacts to the group in the given order,
+// contacts already in the group keep their place
+func (gs *GroupService) AddMembers(groupID int, contactIDs []int) error {
+	group, err := gs.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	// check every contact before the group changes
+	for _, id := range contactIDs {
+		ctc, err := gs.contacts.GetByID(id)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve contact %d: %w", id, err)
+		}
+		if ctc.ID == 0 {
+			return fmt.Errorf("contact %d: %w", id, ErrNoContacts)
+		}
+	}
+
+	group.MemberIDs = slices.Clone(group.MemberIDs)
+	for _, id := range contactIDs {
+		if !slices.Contains(group.MemberIDs, id) {
+			group.MemberIDs = append(group.MemberIDs, id)
+		}
+	}
+
+	if err := gs.groups.Update(group); err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	return nil
+}
+
+func (gs *GroupService) RemoveMembers(groupID int, contactIDs []int) error {
+	group, err := gs.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	group.MemberIDs = slices.DeleteFunc(slices.Clone(group.MemberIDs), func(id int) bool {
+		return slices.Contains(contactIDs, id)
+	})
+
+	if err := gs.groups.Update(group); err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	return nil
+}
+
+// AddSubgroup nests subgroupID in groupID. It is refused when groupID can
+// already be reached from subgroupID, the expansion would never end.
+func (gs *GroupService) AddSubgroup(groupID, subgroupID int) error {
+	group, err := gs.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+	if _, err := gs.GetGroup(subgroupID); err != nil {
+		return err
+	}
+
+	if slices.Contains(group.SubgroupIDs, subgroupID) {
+		return nil
+	}
+
+	reachable, err := gs.reachable(subgroupID, groupID)
+	if err != nil {
+		return err
+	}
+	if reachable {
+		return ErrGroupCycle
+	}
+
+	group.SubgroupIDs = append(slices.Clone(group.SubgroupIDs), subgroupID)
+	if err := gs.groups.Update(group); err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	return nil
+}
+
+func (gs *GroupService) RemoveSubgroup(groupID, subgroupID int) error {
+	group, err := gs.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	group.SubgroupIDs = slices.DeleteFunc(slices.Clone(group.SubgroupIDs), func(id int) bool {
+		return id == subgroupID
+	})
+
+	if err := gs.groups.Update(group); err != nil {
+		return fmt.Errorf("failed to update group: %w", err)
+	}
+
+	return nil
+}
+
+// reachable reports whether target is the group from or is nested in it at any depth
+func (gs *GroupService) reachable(from, target int) (bool, error) {
+	visited := map[int]bool{}
+	queue := []int{from}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == target {
+			return true, nil
+		}
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		group, err := gs.groups.GetByID(id)
+		if err != nil {
+			return false, fmt.Errorf("failed to retrieve group: %w", err)
+		}
+		queue = append(queue, group.SubgroupIDs...)
+	}
+	return false, nil
+}
+
+// ExpandGroup lists the recipients of a group as "Name <email>": its own members first
+// in their order, then the members of the nested groups. A contact or an email reached
+// twice is only listed once, and contacts without an email are left out.
+func (gs *GroupService) ExpandGroup(id int) ([]string, error) {
+	if _, err := gs.GetGroup(id); err != nil {
+		return nil, err
+	}
+
+	var recipients []string
+	seenGroups := map[int]bool{}
+	seenContacts := map[int]bool{}
+	seenEmails := map[string]bool{}
+
+	var expand func(groupID int) error
+	expand = func(groupID int) error {
+		// cycles are refused when nesting, this only guards against a hand-edited store
+		if seenGroups[groupID] {
+			return nil
+		}
+		seenGroups[groupID] = true
+
+		group, err := gs.groups.GetByID(groupID)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve group: %w", err)
+		}
+
+		for _, contactID := range group.MemberIDs {
+			if seenContacts[contactID] {
+				continue
+			}
+			seenContacts[contactID] = true
+
+			ctc, err := gs.contacts.GetByID(contactID)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve contact %d: %w", contactID, err)
+			}
+
+			email := strings.ToLower(ctc.Email)
+			if ctc.ID == 0 || email == "" || seenEmails[email] {
+				continue
+			}
+			seenEmails[email] = true
+
+			recipients = append(recipients, ctc.Recipient())
+		}
+
+		for _, subgroupID := range group.SubgroupIDs {
+			if err := expand(subgroupID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := expand(id); err != nil {
+		return nil, err
+	}
+
+	return recipients, nil
+}
+
+// removeContactFromGroups takes a contact out of every group it is a member of
+func removeContactFromGroups(groups repository.GroupRepository, contactID int) error {
+	gs := &GroupService{groups: groups}
+	return gs.updateEach(func(grp *domain.Group) bool {
+		if !slices.Contains(grp.MemberIDs, contactID) {
+			return false
+		}
+		grp.MemberIDs = slices.DeleteFunc(slices.Clone(grp.MemberIDs), func(id int) bool { return id == contactID })
+		return true
+	})
+}
+
+// updateEach saves every group that change reports as changed
+func (gs *GroupService) updateEach(change func(grp *domain.Group) bool) error {
+	groups, err := gs.groups.GetAll()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve groups: %w", err)
+	}
+
+	// the repository may hand out its own slice, it changes with every update
+	for _, grp := range slices.Clone(groups) {
+		if !change(&grp) {
+			continue
+		}
+		if err := gs.groups.Update(grp); err != nil {
+			return fmt.Errorf("failed to update group %s: %w", grp.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package usecase
+
+import "time"
+
+// progressInterval is how often an import or export reports its progress at most
+const progressInterval = 200 * time.Millisecond
+
+// Progress tells how far an import or an export got
+type Progress struct {
+	// Rows is the number of rows read or contacts written so far
+	Rows int
+	// Done and Total are the bytes of the file read so far and its size,
+	// Total is 0 when it is not known, e.g. for an export
+	Done, Total int64
+	Elapsed     time.Duration
+	// Finished is set on the last report
+	Finished bool
+}
+
+// Rate returns the rows per second so far
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Rows) / p.Elapsed.Seconds()
+}
+
+// ETA estimates the time left from the share of the file done so far, it is 0 when that is not known
+func (p Progress) ETA() time.Duration {
+	if p.Total <= 0 || p.Done <= 0 || p.Done >= p.Total {
+		return 0
+	}
+	return time.Duration(float64(p.Elapsed) * float64(p.Total-p.Done) / float64(p.Done))
+}
+
+// progressTracker calls fn with the progress every progressInterval and once at the end,
+// a nil fn is not called
+type progressTracker struct {
+	fn          func(Progress)
+	start, last time.Time
+	p           Progress
+}
+
+func newProgressTracker(fn func(Progress), total int64) *progressTracker {
+	now := time.Now()
+	return &progressTracker{fn: fn, start: now, last: now, p: Progress{Total: total}}
+}
+
+// add counts a row, done is how much of the file was read with it
+func (pt *progressTracker) add(done int64) {
+	pt.p.Rows++
+	pt.p.Done = max(pt.p.Done, done)
+	if pt.fn == nil {
+		return
+	}
+	if now := time.Now(); now.Sub(pt.last) >= progressInterval {
+		pt.last = now
+		pt.p.Elapsed = now.Sub(pt.start)
+		pt.fn(pt.p)
+	}
+}
+
+// restart starts counting the rows again for another pass over the same contacts
+func (pt *progressTracker) restart() {
+	pt.p.Rows, pt.p.Done = 0, 0
+}
+
+// finish reports the progress a last time
+func (pt *progressTracker) finish() {
+	if pt.fn == nil {
+		return
+	}
+	pt.p.Elapsed = time.Since(pt.start)
+	pt.p.Finished = true
+	pt.fn(pt.p)
+}
@@ -0,0 +1,132 @@
+package usecase
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// tagMatcher reports whether a contact matches a parsed tag expression
+type tagMatcher func(ctc domain.Contact) bool
+
+// parseTagExpression parses expressions like "customer AND NOT (churned OR lead)".
+// NOT binds tighter than AND, and AND tighter than OR, the keywords are case-insensitive.
+func parseTagExpression(expr string) (tagMatcher, error) {
+	p := &tagParser{tokens: tokenizeTagExpression(expr)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("%w: expression is empty", ErrInvalidTagExpression)
+	}
+
+	matcher, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidTagExpression, p.tokens[p.pos])
+	}
+
+	return matcher, nil
+}
+
+func tokenizeTagExpression(expr string) []string {
+	expr = strings.ReplaceAll(expr, "(", " ( ")
+	expr = strings.ReplaceAll(expr, ")", " ) ")
+	return strings.Fields(expr)
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) isKeyword(keyword string) bool {
+	return strings.EqualFold(p.peek(), keyword)
+}
+
+func (p *tagParser) parseOr() (tagMatcher, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ctc domain.Contact) bool { return l(ctc) || right(ctc) }
+	}
+
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagMatcher, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(ctc domain.Contact) bool { return l(ctc) && right(ctc) }
+	}
+
+	return left, nil
+}
+
+func (p *tagParser) parseNot() (tagMatcher, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctc domain.Contact) bool { return !inner(ctc) }, nil
+	}
+
+	return p.parseTag()
+}
+
+func (p *tagParser) parseTag() (tagMatcher, error) {
+	token := p.peek()
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("%w: expression ends too early", ErrInvalidTagExpression)
+
+	case token == "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%w: missing )", ErrInvalidTagExpression)
+		}
+		p.pos++
+		return inner, nil
+
+	case token == ")" || p.isKeyword("AND") || p.isKeyword("OR"):
+		return nil, fmt.Errorf("%w: unexpected %q", ErrInvalidTagExpression, token)
+	}
+
+	p.pos++
+	tag := domain.NormalizeTag(token)
+	return func(ctc domain.Contact) bool { return ctc.HasTag(tag) }, nil
+}
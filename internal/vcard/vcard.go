@@ -0,0 +1,572 @@
+// Package vcard reads and writes vCards (RFC 2426 for version 3.0, RFC 6350 for 4.0).
+// Cards of version 2.1 are read as well, with their quoted-printable values and charsets.
+//
+// A Card is the list of its properties in the order they were read. The package
+// knows the text format only, lines are unfolded and values unescaped on reading
+// and folded and escaped on writing, what a property means is up to the caller.
+package vcard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoCard       = errors.New("vcard: no BEGIN:VCARD found")
+	ErrUnterminated = errors.New("vcard: card is missing END:VCARD")
+)
+
+// Property is one content line, e.g. EMAIL;TYPE=work:bob@mail.com
+type Property struct {
+	// Group is the optional prefix of the name, e.g. item1 in item1.EMAIL
+	Group string
+	// Name is upper case, e.g. EMAIL
+	Name string
+	// Params are keyed by the upper case parameter name, TYPE=work,home gives two values
+	Params map[string][]string
+	// Value is unescaped for text properties, a structured value like N or ADR
+	// and a list like CATEGORIES stay escaped, see Components and ListItems
+	Value string
+}
+
+// Card is one vCard without its BEGIN and END lines
+type Card []Property
+
+// Get returns the first property named name
+func (c Card) Get(name string) (Property, bool) {
+	for _, p := range c {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Property{}, false
+}
+
+// Value returns the value of the first property named name, or ""
+func (c Card) Value(name string) string {
+	p, _ := c.Get(name)
+	return p.Value
+}
+
+// All returns every property named name
+func (c Card) All(name string) []Property {
+	var props []Property
+	for _, p := range c {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Version is the VERSION of the card, e.g. 3.0 or 4.0
+func (c Card) Version() string {
+	return c.Value("VERSION")
+}
+
+// HasType reports whether the TYPE parameter has value, ignoring case
+func (p Property) HasType(value string) bool {
+	return slices.ContainsFunc(p.Params["TYPE"], func(t string) bool {
+		return strings.EqualFold(t, value)
+	})
+}
+
+// Param returns the first value of the parameter named name, or ""
+func (p Property) Param(name string) string {
+	if values := p.Params[strings.ToUpper(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Components splits a structured value like N or ADR at its ";" and unescapes each part
+func (p Property) Components() []string {
+	parts := splitEscaped(p.Value, ';')
+	for i, part := range parts {
+		parts[i] = unescapeText(part)
+	}
+	return parts
+}
+
+// structured are the properties whose value is a list of components, written with ";"
+var structured = map[string]bool{"N": true, "ADR": true, "ORG": true, "GENDER": true, "CLIENTPIDMAP": true}
+
+// lists are the properties whose value is a list written with ","
+var lists = map[string]bool{"CATEGORIES": true, "NICKNAME": true}
+
+// NewStructured returns a property whose value is made of components, e.g. ADR
+func NewStructured(name string, components ...string) Property {
+	escaped := make([]string, len(components))
+	for i, c := range components {
+		escaped[i] = escapeText(c)
+	}
+	return Property{Name: name, Value: strings.Join(escaped, ";")}
+}
+
+// ReadAll reads every card of r
+func ReadAll(r io.Reader) ([]Card, error) {
+	vr := NewReader(r)
+	var cards []Card
+	for {
+		card, err := vr.Read()
+		if err == io.EOF {
+			return cards, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+}
+
+// Reader reads the cards of r one at a time, so a file of many cards does not have to fit in memory
+type Reader struct {
+	r *bufio.Reader
+	// next is the line read ahead to see whether the line before it goes on
+	next    *line
+	num     int
+	read    int64
+	cards   int
+	cardNum int
+	cardEnd int64
+}
+
+// line is one line of r, num is its number and end the offset just after it
+type line struct {
+	num  int
+	text string
+	end  int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Read returns the next card, or io.EOF after the last one. It returns ErrNoCard when r has no card at all
+func (vr *Reader) Read() (Card, error) {
+	var card Card
+	inCard := false
+	for {
+		l, err := vr.unfolded()
+		if err == io.EOF {
+			if inCard {
+				return nil, ErrUnterminated
+			}
+			if vr.cards == 0 {
+				return nil, ErrNoCard
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("vcard: %w", err)
+		}
+		if strings.TrimSpace(l.text) == "" {
+			continue
+		}
+		p, err := parseLine(l.text)
+		if err != nil {
+			return nil, fmt.Errorf("vcard: line %d: %w", l.num, err)
+		}
+
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VCARD"):
+			if inCard {
+				return nil, fmt.Errorf("vcard: line %d: %w", l.num, ErrUnterminated)
+			}
+			inCard, card, vr.cardNum = true, nil, l.num
+		case p.Name == "END" && strings.EqualFold(p.Value, "VCARD"):
+			if !inCard {
+				return nil, fmt.Errorf("vcard: line %d: END:VCARD without BEGIN:VCARD", l.num)
+			}
+			vr.cards++
+			vr.cardEnd = l.end
+			return card, nil
+		case inCard:
+			card = append(card, p)
+		default:
+			return nil, fmt.Errorf("vcard: line %d: %w", l.num, ErrNoCard)
+		}
+	}
+}
+
+// Line is the line the card returned last begins on, the line of its BEGIN:VCARD
+func (vr *Reader) Line() int {
+	return vr.cardNum
+}
+
+// Offset is the offset in r just after the card returned last
+func (vr *Reader) Offset() int64 {
+	return vr.cardEnd
+}
+
+// unfolded returns the next line with its continuation lines, which start with a space or a tab.
+// A vCard 2.1 quoted-printable value that ends with "=" goes on in the next line without a space
+func (vr *Reader) unfolded() (line, error) {
+	l, err := vr.physical()
+	if err != nil {
+		return line{}, err
+	}
+	for {
+		softBreak := strings.HasSuffix(l.text, "=") && isQuotedPrintable(l.text)
+		next, err := vr.physical()
+		if err == io.EOF {
+			return l, nil
+		}
+		if err != nil {
+			return line{}, err
+		}
+		switch {
+		case softBreak:
+			l.text = strings.TrimSuffix(l.text, "=") + next.text
+		case strings.HasPrefix(next.text, " ") || strings.HasPrefix(next.text, "\t"):
+			l.text += next.text[1:]
+		default:
+			vr.next = &next
+			return l, nil
+		}
+		l.end = next.end
+	}
+}
+
+// physical returns the next line of r without its line break
+func (vr *Reader) physical() (line, error) {
+	if vr.next != nil {
+		l := *vr.next
+		vr.next = nil
+		return l, nil
+	}
+
+	text, err := vr.r.ReadString('\n')
+	if err != nil && (err != io.EOF || text == "") {
+		return line{}, err
+	}
+	vr.num++
+	vr.read += int64(len(text))
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+	if vr.num == 1 {
+		text = strings.TrimPrefix(text, "\uFEFF")
+	}
+	return line{num: vr.num, text: text, end: vr.read}, nil
+}
+
+// parseLine reads group.NAME;PARAM=a,b;PARAM2="x:y":value
+func parseLine(line string) (Property, error) {
+	// the name and the parameters end at the first ":" outside quotes
+	colon := -1
+	quoted := false
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		} else if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return Property{}, fmt.Errorf("missing ':' in %q", line)
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := splitQuoted(head, ';')
+
+	p := Property{Name: strings.ToUpper(parts[0])}
+	if group, name, found := strings.Cut(p.Name, "."); found {
+		p.Group, p.Name = strings.ToLower(group), name
+	}
+	if p.Name == "" {
+		return Property{}, fmt.Errorf("missing property name in %q", line)
+	}
+
+	for _, param := range parts[1:] {
+		key, values, found := strings.Cut(param, "=")
+		key = strings.ToUpper(strings.TrimSpace(key))
+		if p.Params == nil {
+			p.Params = map[string][]string{}
+		}
+		if !found {
+			// vCard 2.1 wrote a bare type like TEL;CELL: and a bare encoding like QUOTED-PRINTABLE
+			if encodings[key] {
+				p.Params["ENCODING"] = append(p.Params["ENCODING"], key)
+			} else {
+				p.Params["TYPE"] = append(p.Params["TYPE"], key)
+			}
+			continue
+		}
+		for _, v := range splitQuoted(values, ',') {
+			v = unquoteParam(v)
+			if key == "TYPE" {
+				// vCard 4.0 may quote a list of types, TYPE="work,voice"
+				for _, t := range strings.Split(v, ",") {
+					p.Params[key] = append(p.Params[key], strings.TrimSpace(t))
+				}
+				continue
+			}
+			p.Params[key] = append(p.Params[key], v)
+		}
+	}
+
+	value, err := decodeValue(p, value)
+	if err != nil {
+		return Property{}, fmt.Errorf("%s: %w", p.Name, err)
+	}
+
+	if structured[p.Name] || lists[p.Name] {
+		p.Value = value
+	} else {
+		p.Value = unescapeText(value)
+	}
+	return p, nil
+}
+
+// encodings are the bare ENCODING values of vCard 2.1
+var encodings = map[string]bool{"QUOTED-PRINTABLE": true, "BASE64": true, "8BIT": true, "7BIT": true}
+
+// isQuotedPrintable reports whether the content line has a quoted-printable value
+func isQuotedPrintable(line string) bool {
+	head, _, _ := strings.Cut(line, ":")
+	return strings.Contains(strings.ToUpper(head), "QUOTED-PRINTABLE")
+}
+
+// decodeValue decodes a quoted-printable value and converts a value in another CHARSET
+// to UTF-8, the ENCODING and CHARSET parameters are removed as they no longer apply.
+// A base64 value, e.g. a PHOTO, is returned as it is
+func decodeValue(p Property, value string) (string, error) {
+	encoding := strings.ToUpper(p.Param("ENCODING"))
+	if encoding == "B" || encoding == "BASE64" {
+		return value, nil
+	}
+
+	if encoding == "QUOTED-PRINTABLE" {
+		data, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value)))
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted-printable value: %w", err)
+		}
+		value = string(data)
+	}
+
+	value, err := toUTF8(value, p.Param("CHARSET"))
+	if err != nil {
+		return "", err
+	}
+	delete(p.Params, "ENCODING")
+	delete(p.Params, "CHARSET")
+	return value, nil
+}
+
+// toUTF8 converts a value written in charset, the charsets of old phones and Outlook are known
+func toUTF8(value, charset string) (string, error) {
+	switch strings.ToUpper(charset) {
+	case "", "UTF-8", "UTF8", "US-ASCII", "ASCII":
+		return value, nil
+	case "ISO-8859-1", "LATIN1", "ISO8859-1":
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes), nil
+	case "WINDOWS-1252", "CP1252":
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+			if r, ok := windows1252[value[i]]; ok {
+				runes[i] = r
+			}
+		}
+		return string(runes), nil
+	default:
+		return "", fmt.Errorf("unsupported charset %s", charset)
+	}
+}
+
+// windows1252 are the characters where Windows-1252 is not ISO-8859-1
+var windows1252 = map[byte]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+	0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+	0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›',
+	0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+// ListItems splits a list value like CATEGORIES at its ","
+func (p Property) ListItems() []string {
+	var items []string
+	for _, item := range splitEscaped(p.Value, ',') {
+		if item = strings.TrimSpace(unescapeText(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// NewList returns a property whose value is a list, e.g. CATEGORIES
+func NewList(name string, items ...string) Property {
+	escaped := make([]string, len(items))
+	for i, item := range items {
+		escaped[i] = escapeText(item)
+	}
+	return Property{Name: name, Value: strings.Join(escaped, ",")}
+}
+
+// splitQuoted splits s at sep outside double quotes
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	var b strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case r == sep && !quoted:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return append(parts, b.String())
+}
+
+// splitEscaped splits s at sep when it is not escaped with a backslash
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func unquoteParam(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	// RFC 6868 escapes a quote and a line break in a parameter with ^
+	return strings.NewReplacer("^'", `"`, "^n", "\n", "^^", "^").Replace(v)
+}
+
+var textUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\:`, ":", `\\`, `\`)
+
+func unescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", "", ",", `\,`, ";", `\;`)
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// maxLineOctets is where lines are folded, RFC 6350 section 3.2
+const maxLineOctets = 75
+
+// Write writes cards with CRLF line endings, version is written as the VERSION
+// right after BEGIN:VCARD and a VERSION property of the card is skipped
+func Write(w io.Writer, version string, cards ...Card) error {
+	vw := NewWriter(w, version)
+	for _, card := range cards {
+		vw.Write(card)
+	}
+	return vw.Flush()
+}
+
+// Writer writes cards one at a time like Write, so a long list of cards
+// does not have to be built first
+type Writer struct {
+	bw      *bufio.Writer
+	version string
+}
+
+func NewWriter(w io.Writer, version string) *Writer {
+	return &Writer{bw: bufio.NewWriter(w), version: version}
+}
+
+// Write buffers a card, an error of the underlying writer is returned by Flush
+func (vw *Writer) Write(card Card) {
+	writeLine(vw.bw, "BEGIN:VCARD")
+	writeLine(vw.bw, "VERSION:"+vw.version)
+	for _, p := range card {
+		if p.Name == "VERSION" {
+			continue
+		}
+		writeLine(vw.bw, formatLine(p))
+	}
+	writeLine(vw.bw, "END:VCARD")
+}
+
+func (vw *Writer) Flush() error {
+	if err := vw.bw.Flush(); err != nil {
+		return fmt.Errorf("vcard: %w", err)
+	}
+	return nil
+}
+
+// formatLine writes a property, a text value is escaped,
+// structured and list values are expected to be escaped already
+func formatLine(p Property) string {
+	var b strings.Builder
+	if p.Group != "" {
+		b.WriteString(p.Group + ".")
+	}
+	b.WriteString(p.Name)
+
+	keys := make([]string, 0, len(p.Params))
+	for key := range p.Params {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		values := make([]string, len(p.Params[key]))
+		for i, v := range p.Params[key] {
+			values[i] = quoteParam(v)
+		}
+		b.WriteString(";" + key + "=" + strings.Join(values, ","))
+	}
+
+	b.WriteString(":")
+	if structured[p.Name] || lists[p.Name] {
+		b.WriteString(p.Value)
+	} else {
+		b.WriteString(escapeText(p.Value))
+	}
+	return b.String()
+}
+
+func quoteParam(v string) string {
+	v = strings.NewReplacer("^", "^^", "\n", "^n", `"`, "^'").Replace(v)
+	if strings.ContainsAny(v, ",;:") {
+		return `"` + v + `"`
+	}
+	return v
+}
+
+// writeLine folds line at maxLineOctets without splitting a UTF-8 character
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// a continuation line starts with a space, which counts
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
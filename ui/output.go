@@ -0,0 +1,292 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// Output is the format the commands print their results and errors in
+type Output string
+
+const (
+	// OutputText is the format of the menu, meant to be read by people
+	OutputText Output = "text"
+	// OutputTable prints one aligned row per contact, see PrintTable
+	OutputTable Output = "table"
+	// OutputJSON prints one JSON document, contacts in the format of the JSON export
+	OutputJSON Output = "json"
+	// OutputNDJSON prints one JSON object per line
+	OutputNDJSON Output = "ndjson"
+	// OutputTSV prints a header and one tab separated row per contact
+	OutputTSV Output = "tsv"
+)
+
+var Outputs = []Output{OutputText, OutputTable, OutputJSON, OutputNDJSON, OutputTSV}
+
+// ParseOutput checks the name of an output format
+func ParseOutput(name string) (Output, error) {
+	for _, out := range Outputs {
+		if string(out) == strings.ToLower(strings.TrimSpace(name)) {
+			return out, nil
+		}
+	}
+	return "", fmt.Errorf("unknown output %q, please use text, table, json, ndjson or tsv", name)
+}
+
+// Structured reports whether the output is meant to be read by other programs
+func (o Output) Structured() bool {
+	return o == OutputJSON || o == OutputNDJSON || o == OutputTSV
+}
+
+// Result is what a command reports when it does not print contacts
+type Result struct {
+	Command string `json:"command"`
+	ID      int    `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Count is only set by the commands that handle several contacts, 0 is a valid count
+	Count   *int   `json:"count,omitempty"`
+	File    string `json:"file,omitempty"`
+	Message string `json:"message"`
+}
+
+// ErrorInfo is an error of a command, Code is the exit code of the process
+// and Name the stable name of the sentinel error behind it
+type ErrorInfo struct {
+	Code    int    `json:"code"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+var contactColumns = []string{"id", "name", "emails", "phones", "addresses", "tags", "version", "created_at", "updated_at", "deleted_at"}
+
+// PrintContactsAs prints contacts on stdout in the given output,
+// columns are the columns of the table output, nil shows the DefaultColumns
+func PrintContactsAs(out Output, contacts []domain.Contact, columns []Column) error {
+	switch out {
+	case OutputTable:
+		if columns == nil {
+			var err error
+			if columns, err = ParseColumns(DefaultColumns); err != nil {
+				return err
+			}
+		}
+		PrintTable(columns, contacts, TerminalWidth())
+		return nil
+	case OutputJSON:
+		if contacts == nil {
+			contacts = []domain.Contact{}
+		}
+		return printJSON(contacts, true)
+	case OutputNDJSON:
+		for _, ctc := range contacts {
+			if err := printJSON(ctc, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputTSV:
+		printTSV(contactColumns)
+		for _, ctc := range contacts {
+			printTSV([]string{
+				strconv.Itoa(ctc.ID),
+				ctc.Name,
+				domain.FormatEmails(ctc.EmailList()),
+				domain.FormatPhones(ctc.PhoneList()),
+				domain.FormatAddresses(ctc.Addresses),
+				domain.FormatTags(ctc.Tags),
+				strconv.Itoa(ctc.Version),
+				formatTSVTime(ctc.CreatedAt),
+				formatTSVTime(ctc.UpdatedAt),
+				formatTSVTime(ctc.DeletedAt),
+			})
+		}
+		return nil
+	default:
+		PrintContacts(contacts...)
+		return nil
+	}
+}
+
+// PrintResult prints the result of a command on stdout in the given output
+func PrintResult(out Output, r Result) error {
+	switch out {
+	case OutputJSON, OutputNDJSON:
+		return printJSON(r, false)
+	case OutputTSV:
+		var id, version, count string
+		if r.ID != 0 {
+			id = strconv.Itoa(r.ID)
+		}
+		if r.Version != 0 {
+			version = strconv.Itoa(r.Version)
+		}
+		if r.Count != nil {
+			count = strconv.Itoa(*r.Count)
+		}
+		printTSV([]string{"command", "id", "version", "count", "file", "message"})
+		printTSV([]string{r.Command, id, version, count, r.File, r.Message})
+		return nil
+	default:
+		fmt.Println(r.Message)
+		return nil
+	}
+}
+
+// ImportRow is the outcome of one row of an imported file, Outcome is create,
+// update, skip or error and ID the contact it created, updated or skipped
+type ImportRow struct {
+	Line    int    `json:"line"`
+	Outcome string `json:"outcome"`
+	ID      int    `json:"id,omitempty"`
+	Row     string `json:"row"`
+	Error   string `json:"error,omitempty"`
+}
+
+// PrintImportRows prints the outcome of every row of an import in the given output,
+// the text and table outputs shorten a row to the width of the terminal
+func PrintImportRows(out Output, rows []ImportRow) error {
+	printer := NewImportRowPrinter(out)
+	for _, row := range rows {
+		if err := printer.Print(row); err != nil {
+			return err
+		}
+	}
+	return printer.Close()
+}
+
+// ImportRowPrinter prints the rows of an import one at a time while the file is read,
+// in the layout of PrintImportRows. Close ends the list
+type ImportRowPrinter struct {
+	out   Output
+	width int
+	rows  int
+}
+
+// NewImportRowPrinter prints the header of the text, table and TSV outputs
+func NewImportRowPrinter(out Output) *ImportRowPrinter {
+	switch out {
+	case OutputJSON, OutputNDJSON:
+	case OutputTSV:
+		printTSV([]string{"line", "outcome", "id", "row", "error"})
+	default:
+		fmt.Println(PadRight("LINE", 6) + PadRight("OUTCOME", 9) + "ROW")
+	}
+	return &ImportRowPrinter{out: out, width: TerminalWidth()}
+}
+
+// Print prints one row
+func (p *ImportRowPrinter) Print(row ImportRow) error {
+	p.rows++
+	switch p.out {
+	case OutputJSON:
+		// the same layout as printJSON of the whole list
+		data, err := json.MarshalIndent(row, "  ", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal output: %w", err)
+		}
+		sep := ",\n  "
+		if p.rows == 1 {
+			sep = "[\n  "
+		}
+		fmt.Print(sep + string(data))
+		return nil
+	case OutputNDJSON:
+		return printJSON(row, false)
+	case OutputTSV:
+		id := ""
+		if row.ID != 0 {
+			id = strconv.Itoa(row.ID)
+		}
+		printTSV([]string{strconv.Itoa(row.Line), row.Outcome, id, row.Row, row.Error})
+		return nil
+	default:
+		fmt.Println(PadRight(strconv.Itoa(row.Line), 6) + PadRight(row.Outcome, 9) + Truncate(tableCell(row.Row), max(p.width-15, 10)))
+		if row.Error != "" {
+			fmt.Println(strings.Repeat(" ", 15) + Truncate(tableCell(row.Error), max(p.width-15, 10)))
+		}
+		return nil
+	}
+}
+
+// Close ends the JSON list
+func (p *ImportRowPrinter) Close() error {
+	if p.out != OutputJSON {
+		return nil
+	}
+	if p.rows == 0 {
+		fmt.Println("[]")
+	} else {
+		fmt.Println("\n]")
+	}
+	return nil
+}
+
+// PrintError prints the error of a command on stderr in the given output,
+// a structured error is one JSON object {"error": {...}} or one TSV row starting with "error"
+func PrintError(out Output, e ErrorInfo) {
+	switch out {
+	case OutputJSON, OutputNDJSON:
+		data, err := json.Marshal(struct {
+			Error ErrorInfo `json:"error"`
+		}{e})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERROR:", e.Message)
+			return
+		}
+		fmt.Fprintln(os.Stderr, string(data))
+	case OutputTSV:
+		fmt.Fprintln(os.Stderr, "error\t"+strconv.Itoa(e.Code)+"\t"+escapeTSV(e.Name)+"\t"+escapeTSV(e.Message))
+	default:
+		fmt.Fprintln(os.Stderr, "ERROR:", e.Message)
+	}
+}
+
+var tableCellReplacer = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+// tableCell keeps a value in its column, tabs and line breaks would start a new cell or row
+func tableCell(value string) string {
+	return tableCellReplacer.Replace(value)
+}
+
+func printJSON(v any, indent bool) error {
+	var data []byte
+	var err error
+	if indent {
+		data, err = json.MarshalIndent(v, "", "  ")
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal output: %w", err)
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func printTSV(fields []string) {
+	escaped := make([]string, len(fields))
+	for i, field := range fields {
+		escaped[i] = escapeTSV(field)
+	}
+	fmt.Println(strings.Join(escaped, "\t"))
+}
+
+var tsvEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n", "\r", "\\r")
+
+// escapeTSV keeps a value on one field, tabs and line breaks are written as \t, \n and \r
+func escapeTSV(value string) string {
+	return tsvEscaper.Replace(value)
+}
+
+func formatTSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
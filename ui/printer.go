@@ -2,7 +2,9 @@ package ui
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Dwipasca/contact-management/internal/domain"
 )
@@ -16,6 +18,12 @@ var Menus = []string{
 	"Search Contact",
 	"Export Contacts",
 	"Import Contacts",
+	"Manage Tags",
+	"Groups",
+	"Trash",
+	"History",
+	"Undo Last Action",
+	"Redo",
 }
 
 func PrintMenu() {
@@ -31,11 +39,87 @@ func PrintContacts(contacts ...domain.Contact) {
 	for _, ctc := range contacts {
 		fmt.Println("ID: ", ctc.ID)
 		fmt.Println("Name: ", ctc.Name)
-		fmt.Println("Email: ", ctc.Email)
-		fmt.Println("Phone: ", ctc.Phone)
+		for _, em := range ctc.EmailList() {
+			fmt.Println("Email: ", em.Address+describeLabel(em.Label, em.Primary))
+		}
+		for _, ph := range ctc.PhoneList() {
+			fmt.Println("Phone: ", ph.Number+describeLabel(ph.Label, ph.Primary))
+		}
+		for _, addr := range ctc.Addresses {
+			fmt.Println("Address: ", addr.Format()+describeLabel(addr.Label, false))
+		}
+		if len(ctc.Tags) > 0 {
+			fmt.Println("Tags: ", domain.FormatTags(ctc.Tags))
+		}
+		if ctc.InTrash() {
+			fmt.Println("Deleted: ", ctc.DeletedAt.Local().Format(time.DateTime))
+		}
 	}
 }
 
+func PrintGroups(groups ...domain.Group) {
+	fmt.Println("\n-- Group List --")
+	for _, grp := range groups {
+		fmt.Println("ID: ", grp.ID)
+		fmt.Println("Name: ", grp.Name)
+		fmt.Println("Members: ", formatIDs(grp.MemberIDs))
+		if len(grp.SubgroupIDs) > 0 {
+			fmt.Println("Nested groups: ", formatIDs(grp.SubgroupIDs))
+		}
+	}
+}
+
+func formatIDs(ids []int) string {
+	if len(ids) == 0 {
+		return "-"
+	}
+	items := make([]string, 0, len(ids))
+	for _, id := range ids {
+		items = append(items, strconv.Itoa(id))
+	}
+	return strings.Join(items, ", ")
+}
+
+// PrintHistory prints the audit entries of a contact as a timeline
+func PrintHistory(entries ...domain.AuditEntry) {
+	fmt.Println("\n-- History --")
+	for _, entry := range entries {
+		op := string(entry.Op)
+		if entry.RevertedTo != 0 {
+			op += fmt.Sprintf(" to revision %d", entry.RevertedTo)
+		}
+		actor := entry.Actor
+		if actor == "" {
+			actor = "unknown"
+		}
+		fmt.Printf("Revision %d: %s %s by %s\n", entry.ID, entry.At.Local().Format(time.DateTime), op, actor)
+		for _, change := range entry.Changes {
+			fmt.Printf("    %s: %q -> %q\n", change.Field, change.Before, change.After)
+		}
+	}
+}
+
+// describeLabel renders the label of an email or phone, e.g. " (work, primary)"
+func describeLabel(label string, primary bool) string {
+	var parts []string
+	if label != "" {
+		parts = append(parts, label)
+	}
+	if primary {
+		parts = append(parts, "primary")
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(parts, ", ") + ")"
+}
+
+// PrintListHint explains how several labelled emails or phones are typed
+func PrintListHint() {
+	fmt.Println("(several emails or phones are separated by commas and can have a label,")
+	fmt.Println(" e.g. work:bob@mail.com, home:bob@home.com - the first one is the primary)")
+}
+
 func SetTitle(text string) {
 	fmt.Println()
 	fmt.Println("=================")
@@ -0,0 +1,24 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+)
+
+// IsTerminal reports whether f is a terminal rather than a file or a pipe
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// PrintProgress replaces the progress line on stderr with line,
+// it is only meant for a terminal, see IsTerminal
+func PrintProgress(line string) {
+	// \033[K clears what is left of a longer previous line
+	fmt.Fprint(os.Stderr, "\r"+Truncate(line, max(TerminalWidth()-1, 10))+"\033[K")
+}
+
+// EndProgress moves past the progress line, so what is printed next starts on its own line
+func EndProgress() {
+	fmt.Fprintln(os.Stderr)
+}
@@ -0,0 +1,228 @@
+package ui
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dwipasca/contact-management/internal/domain"
+)
+
+// Column is one column of the contact table
+type Column struct {
+	// Name chooses the column, e.g. in "id, name, email"
+	Name  string
+	Title string
+	Value func(ctc domain.Contact) string
+	// compare orders the contacts when the table is sorted by this column,
+	// nil compares the values case-insensitively
+	compare func(a, b domain.Contact) int
+}
+
+// ContactColumns are the columns a contact table can show
+var ContactColumns = []Column{
+	{Name: "id", Title: "ID", Value: func(ctc domain.Contact) string { return strconv.Itoa(ctc.ID) },
+		compare: func(a, b domain.Contact) int { return cmp.Compare(a.ID, b.ID) }},
+	{Name: "name", Title: "Name", Value: func(ctc domain.Contact) string { return ctc.Name }},
+	{Name: "email", Title: "Email", Value: func(ctc domain.Contact) string {
+		if emails := ctc.EmailList(); len(emails) > 0 {
+			return emails[0].Address
+		}
+		return ""
+	}},
+	{Name: "phone", Title: "Phone", Value: func(ctc domain.Contact) string {
+		if phones := ctc.PhoneList(); len(phones) > 0 {
+			return phones[0].Number
+		}
+		return ""
+	}},
+	{Name: "city", Title: "City", Value: func(ctc domain.Contact) string {
+		if len(ctc.Addresses) > 0 {
+			return ctc.Addresses[0].Locality
+		}
+		return ""
+	}},
+	{Name: "country", Title: "Country", Value: func(ctc domain.Contact) string {
+		if len(ctc.Addresses) > 0 {
+			return ctc.Addresses[0].CountryCode
+		}
+		return ""
+	}},
+	{Name: "tags", Title: "Tags", Value: func(ctc domain.Contact) string { return domain.FormatTags(ctc.Tags) }},
+	{Name: "version", Title: "Version", Value: func(ctc domain.Contact) string { return strconv.Itoa(ctc.Version) },
+		compare: func(a, b domain.Contact) int { return cmp.Compare(a.Version, b.Version) }},
+	{Name: "created", Title: "Created", Value: func(ctc domain.Contact) string { return formatTableTime(ctc.CreatedAt) },
+		compare: func(a, b domain.Contact) int { return a.CreatedAt.Compare(b.CreatedAt) }},
+	{Name: "updated", Title: "Updated", Value: func(ctc domain.Contact) string { return formatTableTime(ctc.UpdatedAt) },
+		compare: func(a, b domain.Contact) int { return a.UpdatedAt.Compare(b.UpdatedAt) }},
+}
+
+// DefaultColumns is the list of columns shown when none are chosen
+const DefaultColumns = "id, name, email, phone, tags"
+
+// ColumnNames lists the names of ContactColumns, e.g. for a prompt
+func ColumnNames() string {
+	names := make([]string, len(ContactColumns))
+	for i, col := range ContactColumns {
+		names[i] = col.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// ParseColumns reads a list of column names like "id, name, email",
+// an empty list gives the DefaultColumns
+func ParseColumns(text string) ([]Column, error) {
+	if strings.Trim(text, ", ") == "" {
+		text = DefaultColumns
+	}
+
+	var columns []Column
+	for _, name := range strings.Split(text, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		col, ok := findColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, please use %s", name, ColumnNames())
+		}
+		columns = append(columns, col)
+	}
+	return columns, nil
+}
+
+func findColumn(name string) (Column, bool) {
+	for _, col := range ContactColumns {
+		if col.Name == name {
+			return col, true
+		}
+	}
+	return Column{}, false
+}
+
+// SortContacts sorts contacts by the column named key, "-name" sorts descending.
+// contacts with the same value keep the order of their IDs
+func SortContacts(contacts []domain.Contact, key string) error {
+	key = strings.ToLower(strings.TrimSpace(key))
+	if key == "" {
+		key = "id"
+	}
+	desc := strings.HasPrefix(key, "-")
+	key = strings.TrimPrefix(key, "-")
+
+	col, ok := findColumn(key)
+	if !ok {
+		return fmt.Errorf("unknown sort column %q, please use %s", key, ColumnNames())
+	}
+
+	compare := col.compare
+	if compare == nil {
+		compare = func(a, b domain.Contact) int {
+			return strings.Compare(strings.ToLower(col.Value(a)), strings.ToLower(col.Value(b)))
+		}
+	}
+
+	slices.SortStableFunc(contacts, func(a, b domain.Contact) int {
+		c := compare(a, b)
+		if desc {
+			c = -c
+		}
+		if c == 0 {
+			return cmp.Compare(a.ID, b.ID)
+		}
+		return c
+	})
+	return nil
+}
+
+// TerminalWidth is the width of the terminal on stdout, $COLUMNS when it can not be asked
+// and 80 when that is not set either
+func TerminalWidth() int {
+	if cols := terminalColumns(); cols > 0 {
+		return cols
+	}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 0 {
+		return cols
+	}
+	return 80
+}
+
+// tableGap is the space between two columns
+const tableGap = "  "
+
+// minColumnWidth is the width a column is not shrunk below, enough for a few letters and "…"
+const minColumnWidth = 5
+
+// PrintTable prints contacts as a table that fits in width cells,
+// the widest columns are shrunk first and their values end with "…"
+func PrintTable(columns []Column, contacts []domain.Contact, width int) {
+	cells := make([][]string, len(contacts))
+	widths := make([]int, len(columns))
+	for i, col := range columns {
+		widths[i] = StringWidth(col.Title)
+	}
+	for r, ctc := range contacts {
+		cells[r] = make([]string, len(columns))
+		for i, col := range columns {
+			cells[r][i] = tableCell(col.Value(ctc))
+			widths[i] = max(widths[i], StringWidth(cells[r][i]))
+		}
+	}
+
+	fitWidths(widths, width-len(tableGap)*(len(columns)-1))
+
+	titles := make([]string, len(columns))
+	for i, col := range columns {
+		titles[i] = strings.ToUpper(col.Title)
+	}
+	printTableRow(titles, widths)
+	for _, row := range cells {
+		printTableRow(row, widths)
+	}
+}
+
+// fitWidths shrinks the widest column by one cell until all of them fit in available,
+// a table whose columns are all at their minimum stays wider than available
+func fitWidths(widths []int, available int) {
+	total := 0
+	for _, w := range widths {
+		total += w
+	}
+
+	for total > available {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= minColumnWidth {
+			return
+		}
+		widths[widest]--
+		total--
+	}
+}
+
+func printTableRow(cells []string, widths []int) {
+	var b strings.Builder
+	for i, cell := range cells {
+		if i > 0 {
+			b.WriteString(tableGap)
+		}
+		b.WriteString(PadRight(Truncate(cell, widths[i]), widths[i]))
+	}
+	// a row whose last cells are empty would end with the padding
+	fmt.Println(strings.TrimRight(b.String(), " "))
+}
+
+func formatTableTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format(time.DateTime)
+}
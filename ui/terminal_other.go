@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package ui
+
+// terminalColumns is not known on this platform, TerminalWidth falls back to $COLUMNS
+func terminalColumns() int {
+	return 0
+}
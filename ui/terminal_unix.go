@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package ui
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// terminalColumns asks the terminal on stdout for its width, 0 when stdout is not a terminal
+func terminalColumns() int {
+	var size struct {
+		rows, cols, xpixel, ypixel uint16
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0
+	}
+	return int(size.cols)
+}
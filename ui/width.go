@@ -0,0 +1,134 @@
+package ui
+
+import (
+	"strings"
+	"unicode"
+)
+
+// wideRanges are the East Asian wide and fullwidth characters and the emoji,
+// a terminal shows them two cells wide
+var wideRanges = []struct{ lo, hi rune }{
+	{0x1100, 0x115F}, // hangul jamo
+	{0x231A, 0x231B}, // watch, hourglass
+	{0x2329, 0x232A}, // angle brackets
+	{0x23E9, 0x23EC},
+	{0x23F0, 0x23F0},
+	{0x23F3, 0x23F3},
+	{0x25FD, 0x25FE},
+	{0x2614, 0x2615},
+	{0x2648, 0x2653},
+	{0x26A1, 0x26A1},
+	{0x26AA, 0x26AB},
+	{0x26BD, 0x26BE},
+	{0x26C4, 0x26C5},
+	{0x26D4, 0x26D4},
+	{0x26EA, 0x26EA},
+	{0x26F2, 0x26F5},
+	{0x26FA, 0x26FD},
+	{0x2705, 0x2705},
+	{0x270A, 0x270B},
+	{0x2728, 0x2728},
+	{0x274C, 0x274C},
+	{0x2753, 0x2755},
+	{0x2757, 0x2757},
+	{0x2795, 0x2797},
+	{0x27B0, 0x27B0},
+	{0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C},
+	{0x2B50, 0x2B50},
+	{0x2B55, 0x2B55},
+	{0x2E80, 0x303E}, // cjk radicals, punctuation
+	{0x3041, 0x33FF}, // hiragana, katakana, cjk compatibility
+	{0x3400, 0x4DBF}, // cjk extension a
+	{0x4E00, 0x9FFF}, // cjk unified ideographs
+	{0xA000, 0xA4CF}, // yi
+	{0xA960, 0xA97F}, // hangul jamo extended a
+	{0xAC00, 0xD7A3}, // hangul syllables
+	{0xF900, 0xFAFF}, // cjk compatibility ideographs
+	{0xFE10, 0xFE19}, // vertical forms
+	{0xFE30, 0xFE6F}, // cjk compatibility forms, small forms
+	{0xFF00, 0xFF60}, // fullwidth forms
+	{0xFFE0, 0xFFE6},
+	{0x16FE0, 0x16FE4},
+	{0x17000, 0x18AFF}, // tangut
+	{0x1B000, 0x1B16F}, // kana supplement
+	{0x1F004, 0x1F004},
+	{0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E},
+	{0x1F191, 0x1F19A},
+	{0x1F200, 0x1F251},
+	{0x1F300, 0x1F64F}, // pictographs, emoticons
+	{0x1F680, 0x1F6FF}, // transport and map symbols
+	{0x1F7E0, 0x1F7EB},
+	{0x1F90C, 0x1F9FF}, // supplemental symbols and pictographs
+	{0x1FA70, 0x1FAFF},
+	{0x20000, 0x2FFFD}, // cjk extension b and later
+	{0x30000, 0x3FFFD},
+}
+
+// RuneWidth is the number of terminal cells r takes: 0 for combining marks
+// and other invisible characters, 2 for wide characters and 1 for the rest
+func RuneWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return 0
+	// marks combine with the previous character, Cf holds the zero width space and joiner
+	case unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Me, r) || unicode.Is(unicode.Cf, r):
+		return 0
+	case r >= 0xFE00 && r <= 0xFE0F: // variation selectors
+		return 0
+	case r < 0x1100:
+		return 1
+	}
+
+	for _, wr := range wideRanges {
+		if r < wr.lo {
+			break
+		}
+		if r <= wr.hi {
+			return 2
+		}
+	}
+	return 1
+}
+
+// StringWidth is the number of terminal cells s takes
+func StringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += RuneWidth(r)
+	}
+	return width
+}
+
+// Truncate shortens s to at most width cells, a shortened value ends with "…"
+func Truncate(s string, width int) string {
+	if StringWidth(s) <= width {
+		return s
+	}
+	if width <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	used := 0
+	for _, r := range s {
+		w := RuneWidth(r)
+		// keep one cell for the ellipsis
+		if used+w > width-1 {
+			break
+		}
+		b.WriteRune(r)
+		used += w
+	}
+	b.WriteString("…")
+	return b.String()
+}
+
+// PadRight fills s with spaces up to width cells
+func PadRight(s string, width int) string {
+	if pad := width - StringWidth(s); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}